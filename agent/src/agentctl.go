@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"agent/client"
+	"agent/config"
+)
+
+// runAgentctl sends one control command to the running agent and prints its
+// response. It returns the process exit status.
+func runAgentctl(args []string) int {
+	fs := flag.NewFlagSet("agentctl", flag.ContinueOnError)
+	cfgFile := fs.String("config", config.DefaultConfigFile, "Configuration file of the running agent")
+	data := fs.String("data", "", "Data directory of the running agent (overrides config)")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s agentctl [-config file] [-data dir] <status|ioc-stats|blocks|scan-now|log-level|help> [args]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return 2
+	}
+
+	// Only read the configuration; do not create a default file as the agent would
+	cfg := config.NewDefaultConfig()
+	if _, err := os.Stat(*cfgFile); err == nil {
+		loaded, err := config.LoadConfig(*cfgFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+			return 1
+		}
+		cfg = loaded
+	} else if err := cfg.ApplyEnv(); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to apply environment: %v\n", err)
+		return 1
+	}
+	if *data != "" {
+		cfg.DataDir = *data
+	}
+
+	response, err := client.ControlRequest(cfg, strings.Join(fs.Args(), " "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(response), &result); err != nil {
+		fmt.Println(response)
+		return 1
+	}
+	pretty, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(pretty))
+	if _, failed := result["error"]; failed {
+		return 1
+	}
+	return 0
+}
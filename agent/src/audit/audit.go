@@ -0,0 +1,322 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"agent/persist"
+)
+
+// KeyName names the audit log's HMAC key in the agent's key store
+const KeyName = "audit"
+
+// KeySuffix is appended to an audit log's path to form the key file of
+// earlier agents, which kept the key next to the log
+const KeySuffix = ".key"
+
+// ErrChainBroken is returned when an audit log entry was edited, removed or
+// inserted after it was written
+var ErrChainBroken = errors.New("audit log chain verification failed")
+
+// ErrTornEntry is reported by Tampered when the last line of the audit log
+// was cut short, for example by a crash in the middle of a write
+var ErrTornEntry = errors.New("audit log ends with a partial entry")
+
+// ErrKeyMissing is reported by Tampered when a non-empty audit log was found
+// while its HMAC key was not, so its chain can no longer be verified
+var ErrKeyMissing = errors.New("audit log key is missing")
+
+// Entry is one line of the audit log
+type Entry struct {
+	Seq        uint64            `json:"seq"`
+	Time       time.Time         `json:"time"`
+	CommandID  string            `json:"command_id"`
+	Type       string            `json:"type"`
+	Params     map[string]string `json:"params,omitempty"`
+	Success    bool              `json:"success"`
+	Message    string            `json:"message,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+	MAC        string            `json:"mac,omitempty"` // HMAC-SHA256 of the previous MAC and this entry
+}
+
+// Logger appends HMAC-chained entries to an audit log. Each entry's MAC
+// covers the MAC of the entry before it, so editing or deleting a line breaks
+// the chain for every line after it.
+type Logger struct {
+	mu       sync.Mutex
+	path     string
+	key      []byte
+	lastMAC  string
+	seq      uint64
+	tampered error // What Open found wrong with the log, see Tampered
+}
+
+// KeyPath returns the path of the key file an earlier agent kept next to an
+// audit log
+func KeyPath(path string) string {
+	return path + KeySuffix
+}
+
+// Open opens the audit log at path, creating it if needed, and continues the
+// chain from the last entry. The HMAC key is kept in keys, outside the data
+// directory, so whoever can edit the log cannot recompute its MACs. A key
+// file left next to the log by an earlier agent is moved into keys. A
+// partial last entry is cut off and a log whose key is missing is moved
+// aside so a new chain can start; both are reported by Tampered.
+func Open(path string, keys *persist.KeyStore) (*Logger, error) {
+	l := &Logger{path: path}
+
+	key, legacy, err := loadKey(path, keys)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		// The old entries cannot be verified without their key, keep them for
+		// investigation and start over
+		if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+			orphan := fmt.Sprintf("%s.%s.nokey", path, time.Now().UTC().Format("20060102T150405Z"))
+			if err := os.Rename(path, orphan); err != nil {
+				return nil, fmt.Errorf("%w: %s, and failed to move the log aside: %v", ErrKeyMissing, path, err)
+			}
+			l.tampered = fmt.Errorf("%w: %s was moved to %s and a new chain started", ErrKeyMissing, path, orphan)
+		}
+		if key, _, err = keys.Key(KeyName); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case legacy:
+		if err := keys.Store(KeyName, key); err != nil {
+			return nil, err
+		}
+		if err := os.Remove(KeyPath(path)); err != nil {
+			return nil, fmt.Errorf("failed to remove old audit key: %v", err)
+		}
+	}
+	l.key = key
+
+	last, torn, err := lastEntry(path)
+	if err != nil {
+		return nil, err
+	}
+	if torn != nil {
+		l.tampered = torn
+	}
+	if last != nil {
+		l.lastMAC = last.MAC
+		l.seq = last.Seq
+	}
+
+	return l, nil
+}
+
+// Tampered returns what Open found wrong with the log and repaired, wrapping
+// ErrTornEntry or ErrKeyMissing, or nil if it was intact
+func (l *Logger) Tampered() error {
+	return l.tampered
+}
+
+// Head returns the sequence number and MAC of the last entry, 0 and empty
+// before the first one. The agent reports them to the server, so a log cut
+// back to an earlier entry, which still verifies, is noticed there.
+func (l *Logger) Head() (seq uint64, mac string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.seq, l.lastMAC
+}
+
+// Log appends an entry to the audit log. Seq and MAC are filled in.
+func (l *Logger) Log(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	entry.Seq = l.seq + 1
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Time = entry.Time.UTC()
+	mac, err := computeMAC(l.key, l.lastMAC, entry)
+	if err != nil {
+		return err
+	}
+	entry.MAC = mac
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %v", err)
+	}
+
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %v", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync audit log: %v", err)
+	}
+
+	l.seq = entry.Seq
+	l.lastMAC = mac
+	return nil
+}
+
+// VerifyAuditChain checks every entry of the audit log at path against the
+// HMAC chain and returns the number of valid entries. The first edited,
+// removed or inserted entry is reported with ErrChainBroken. The key is
+// read from keys, or from next to the log if the agent has not moved it yet.
+func VerifyAuditChain(path string, keys *persist.KeyStore) (int, error) {
+	key, _, err := loadKey(path, keys)
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	count := 0
+	prevMAC := ""
+	var prevSeq uint64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return count, fmt.Errorf("%w: line %d is not a valid entry: %v", ErrChainBroken, lineNum, err)
+		}
+		if entry.Seq != prevSeq+1 {
+			return count, fmt.Errorf("%w: line %d has sequence %d, expected %d", ErrChainBroken, lineNum, entry.Seq, prevSeq+1)
+		}
+
+		expected, err := computeMAC(key, prevMAC, entry)
+		if err != nil {
+			return count, err
+		}
+		if !hmac.Equal([]byte(expected), []byte(entry.MAC)) {
+			return count, fmt.Errorf("%w: line %d (sequence %d) has an invalid MAC", ErrChainBroken, lineNum, entry.Seq)
+		}
+
+		prevMAC = entry.MAC
+		prevSeq = entry.Seq
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return count, fmt.Errorf("failed to read audit log: %v", err)
+	}
+
+	return count, nil
+}
+
+// computeMAC returns the hex HMAC-SHA256 of the previous MAC followed by the
+// JSON encoding of the entry without its own MAC
+func computeMAC(key []byte, prevMAC string, entry Entry) (string, error) {
+	entry.MAC = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode audit entry: %v", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prevMAC))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// loadKey returns the audit log's HMAC key from keys, or else from the key
+// file next to the log, which legacy reports. The error wraps
+// os.ErrNotExist when there is neither.
+func loadKey(path string, keys *persist.KeyStore) (key []byte, legacy bool, err error) {
+	key, err = keys.Load(KeyName)
+	if !errors.Is(err, os.ErrNotExist) {
+		return key, false, err
+	}
+
+	data, err := os.ReadFile(KeyPath(path))
+	if os.IsNotExist(err) {
+		return nil, false, fmt.Errorf("no audit key in %s: %w", keys.Dir(), os.ErrNotExist)
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read audit key: %v", err)
+	}
+	key, err = hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) == 0 {
+		return nil, false, fmt.Errorf("invalid audit key in %s", KeyPath(path))
+	}
+	return key, true, nil
+}
+
+// lastEntry returns the last entry of the audit log, or nil if it is empty.
+// A last line that is not a complete entry is truncated so appending can
+// continue, and described by the torn error.
+func lastEntry(path string) (last *Entry, torn error, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open audit log: %v", err)
+	}
+	defer file.Close()
+
+	// Find the last non-empty line and where it starts
+	var line []byte
+	var offset, lineStart int64
+	reader := bufio.NewReaderSize(file, 64*1024)
+	for {
+		chunk, readErr := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(chunk)) > 0 {
+			line = chunk
+			lineStart = offset
+		}
+		offset += int64(len(chunk))
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return nil, nil, fmt.Errorf("failed to read audit log: %v", readErr)
+		}
+	}
+	if line == nil {
+		return nil, nil, nil
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(bytes.TrimSpace(line), &entry); err != nil {
+		if err := file.Truncate(lineStart); err != nil {
+			return nil, nil, fmt.Errorf("failed to remove partial audit entry: %v", err)
+		}
+		torn = fmt.Errorf("%w: removed %d bytes at offset %d", ErrTornEntry, offset-lineStart, lineStart)
+
+		// The chain continues from the entry before the torn one
+		prev, _, err := lastEntry(path)
+		return prev, torn, err
+	}
+
+	// A complete entry whose newline was not written only needs the newline
+	if !bytes.HasSuffix(line, []byte("\n")) {
+		if _, err := file.WriteAt([]byte("\n"), offset); err != nil {
+			return nil, nil, fmt.Errorf("failed to complete last audit entry: %v", err)
+		}
+	}
+	return &entry, nil, nil
+}
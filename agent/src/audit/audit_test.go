@@ -0,0 +1,143 @@
+package audit
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent/persist"
+)
+
+// openTestLog opens an audit log in a temporary data directory with its key
+// store in another one
+func openTestLog(t *testing.T) (*Logger, string, *persist.KeyStore) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	keys := persist.NewKeyStore(t.TempDir())
+	l, err := Open(path, keys)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	return l, path, keys
+}
+
+func TestAuditKeyOutsideDataDir(t *testing.T) {
+	l, path, keys := openTestLog(t)
+	for _, id := range []string{"cmd-1", "cmd-2"} {
+		if err := l.Log(Entry{CommandID: id, Type: "BLOCK_IP", Success: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := os.Stat(KeyPath(path)); !os.IsNotExist(err) {
+		t.Errorf("key file next to the log: %v", err)
+	}
+	if _, err := keys.Load(KeyName); err != nil {
+		t.Errorf("key store has no audit key: %v", err)
+	}
+	if count, err := VerifyAuditChain(path, keys); err != nil || count != 2 {
+		t.Errorf("VerifyAuditChain = %d, %v; want 2 entries", count, err)
+	}
+
+	// Another key cannot verify the chain
+	other := persist.NewKeyStore(t.TempDir())
+	if _, _, err := other.Key(KeyName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyAuditChain(path, other); !errors.Is(err, ErrChainBroken) {
+		t.Errorf("VerifyAuditChain with another key = %v, want ErrChainBroken", err)
+	}
+}
+
+func TestAuditLegacyKeyMoved(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "audit.log")
+	key := []byte(strings.Repeat("k", 32))
+
+	// A log written by an earlier agent, with its key next to it
+	if err := os.WriteFile(KeyPath(path), []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys := persist.NewKeyStore(t.TempDir())
+	old := &Logger{path: path, key: key}
+	if err := old.Log(Entry{CommandID: "cmd-1", Type: "SCAN"}); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := VerifyAuditChain(path, keys); err != nil || count != 1 {
+		t.Fatalf("VerifyAuditChain before migration = %d, %v; want 1 entry", count, err)
+	}
+
+	l, err := Open(path, keys)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := l.Tampered(); err != nil {
+		t.Errorf("Tampered = %v, want nil", err)
+	}
+	if _, err := os.Stat(KeyPath(path)); !os.IsNotExist(err) {
+		t.Errorf("old key file not removed: %v", err)
+	}
+	if stored, err := keys.Load(KeyName); err != nil || string(stored) != string(key) {
+		t.Errorf("key store holds %x, %v; want the old key", stored, err)
+	}
+
+	// The chain continues with the moved key
+	if err := l.Log(Entry{CommandID: "cmd-2", Type: "SCAN"}); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := VerifyAuditChain(path, keys); err != nil || count != 2 {
+		t.Errorf("VerifyAuditChain after migration = %d, %v; want 2 entries", count, err)
+	}
+}
+
+func TestAuditKeyMissing(t *testing.T) {
+	l, path, _ := openTestLog(t)
+	if err := l.Log(Entry{CommandID: "cmd-1", Type: "SCAN"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The log is kept but its key is gone
+	reopened, err := Open(path, persist.NewKeyStore(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := reopened.Tampered(); !errors.Is(err, ErrKeyMissing) {
+		t.Errorf("Tampered = %v, want ErrKeyMissing", err)
+	}
+	if seq, mac := reopened.Head(); seq != 0 || mac != "" {
+		t.Errorf("Head = %d, %q; want a new chain", seq, mac)
+	}
+	orphans, _ := filepath.Glob(path + ".*.nokey")
+	if len(orphans) != 1 {
+		t.Errorf("moved logs = %v, want one", orphans)
+	}
+}
+
+func TestAuditHead(t *testing.T) {
+	l, path, keys := openTestLog(t)
+	if seq, mac := l.Head(); seq != 0 || mac != "" {
+		t.Errorf("Head of an empty log = %d, %q", seq, mac)
+	}
+
+	for _, id := range []string{"cmd-1", "cmd-2", "cmd-3"} {
+		if err := l.Log(Entry{CommandID: id, Type: "SCAN"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	seq, mac := l.Head()
+	if seq != 3 || mac == "" {
+		t.Fatalf("Head = %d, %q; want sequence 3", seq, mac)
+	}
+
+	// Reopening continues from the same head
+	reopened, err := Open(path, keys)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if gotSeq, gotMAC := reopened.Head(); gotSeq != seq || gotMAC != mac {
+		t.Errorf("Head after reopening = %d, %q; want %d, %q", gotSeq, gotMAC, seq, mac)
+	}
+}
@@ -1,56 +1,217 @@
 package blocker
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"agent/config"
+	"agent/persist"
 )
 
-// Blocker handles blocking of malicious IPs and URLs
+// Source says who created a block. Only blocks created by the scanner
+// expire after block_ttl_hours.
+type Source int
+
+const (
+	SourceCommand Source = iota // BLOCK_IP or BLOCK_URL from the server
+	SourceScanner               // An IOC found by the scanner
+)
+
+// Blocker handles blocking of malicious IPs and URLs. It is safe for
+// concurrent use; firewall and hosts file changes run outside the lock.
 type Blocker struct {
+	mu          sync.RWMutex // Guards the block maps and the pending save
 	config      *config.Config
 	blockedIPs  map[string]bool
 	blockedURLs map[string]bool
+	ipBlockedAt  map[string]time.Time
+	urlBlockedAt map[string]time.Time
+	scannerIPs  map[string]bool // Blocks created by the scanner, the only ones that expire
+	scannerURLs map[string]bool
+	suppressedIPs  map[string]time.Time // Unblocked by a command, the scanner leaves them alone
+	suppressedURLs map[string]time.Time
 	storagePath string
+	integrity   *persist.Integrity // Signs and verifies the checksum of blocked_items.json
+	tampered    bool
+	corrupt     error // Why the block list could not be loaded, see Corrupt
+	savedURLMethod string // url_block_method the saved URL blocks were created with
+	runner      commandRunner   // Starts the firewall tools
+	firewall    firewallBackend // OS-specific firewall used for IP blocks
+	urls        urlBlocker      // Hosts file or DNS policy used for URL blocks
 	
-	// Performance optimization: batch save operations
-	pendingSave bool
-	saveTimer   *time.Timer
+	// Changes are collected for block_save_delay_ms and written together
+	saveMu      sync.Mutex  // Serializes writes of blocked_items.json
+	pendingSave bool        // The block list changed since it was last written
+	saveTimer   *time.Timer // Writes the pending changes, nil if none are pending
 }
 
 // BlockedItems represents the structure for persisting blocked items
 type BlockedItems struct {
 	BlockedIPs  map[string]bool `json:"blocked_ips"`
 	BlockedURLs map[string]bool `json:"blocked_urls"`
+	
+	// When each block was created or last refreshed, used for TTL expiry
+	IPBlockedAt  map[string]time.Time `json:"ip_blocked_at,omitempty"`
+	URLBlockedAt map[string]time.Time `json:"url_blocked_at,omitempty"`
+	
+	// Blocks created by the scanner for an IOC. Other blocks came from
+	// BLOCK_IP and BLOCK_URL commands and never expire.
+	ScannerIPs  map[string]bool `json:"scanner_ips,omitempty"`
+	ScannerURLs map[string]bool `json:"scanner_urls,omitempty"`
+	
+	// IPs and URLs unblocked by UNBLOCK_IP and UNBLOCK_URL commands, and
+	// when. The scanner does not block them again until a BLOCK_IP or
+	// BLOCK_URL command does.
+	SuppressedIPs  map[string]time.Time `json:"suppressed_ips,omitempty"`
+	SuppressedURLs map[string]time.Time `json:"suppressed_urls,omitempty"`
+	
+	// url_block_method the URL blocks were created with, empty for hosts
+	URLBlockMethod string `json:"url_block_method,omitempty"`
 }
 
 // NewBlocker creates a new network blocker with configuration
-func NewBlocker(cfg *config.Config, storagePath string) *Blocker {
+func NewBlocker(cfg *config.Config, storagePath string, integrity *persist.Integrity) *Blocker {
 	b := &Blocker{
 		config:      cfg,
 		blockedIPs:  make(map[string]bool),
 		blockedURLs: make(map[string]bool),
+		ipBlockedAt:  make(map[string]time.Time),
+		urlBlockedAt: make(map[string]time.Time),
+		scannerIPs:  make(map[string]bool),
+		scannerURLs: make(map[string]bool),
+		suppressedIPs:  make(map[string]time.Time),
+		suppressedURLs: make(map[string]time.Time),
 		storagePath: storagePath,
+		integrity:   integrity,
+		runner:      execRunner{},
+		savedURLMethod: config.URLBlockHosts,
 	}
+	b.firewall = newFirewallBackend(b.runner)
+	b.urls = newURLBlocker(cfg, b.runner)
 	
 	// Load previously blocked items
 	b.loadBlockedItems()
 	
+	// Firewall rules on Linux do not survive a reboot, so put back any that
+	// are recorded as blocked but missing
+	b.reapplyMissingIPBlocks()
+	
+	// Drop URL blocks left behind for URLs that are no longer blocked, and
+	// move blocks created with the other url_block_method to this one
+	if removed, err := b.CleanupURLBlocks(); err != nil {
+		log.Printf("WARNING: Failed to clean up %s: %v", b.urls.Name(), err)
+	} else if removed > 0 {
+		log.Printf("Removed %d orphaned %s entries", removed, b.urls.Name())
+	}
+	if b.savedURLMethod != b.urlBlockMethod() {
+		if other := otherURLBlocker(b.urls, cfg, b.runner); other != nil {
+			if removed, err := other.Cleanup(context.Background(), nil); err != nil {
+				log.Printf("WARNING: Failed to remove URL blocks from the %s: %v", other.Name(), err)
+			} else if removed > 0 {
+				log.Printf("Removed %d URL blocks from the %s, URLs are now blocked in the %s", removed, other.Name(), b.urls.Name())
+			}
+		}
+		b.reapplyMissingURLBlocks()
+		b.mu.Lock()
+		b.scheduleSaveLocked()
+		b.mu.Unlock()
+	}
+	
 	return b
 }
 
+// reapplyMissingIPBlocks re-creates firewall rules for recorded IP blocks
+// that no longer have one
+func (b *Blocker) reapplyMissingIPBlocks() {
+	if len(b.blockedIPs) == 0 {
+		return
+	}
+	
+	active, err := b.firewall.List(context.Background())
+	if err != nil {
+		log.Printf("WARNING: Failed to list firewall rules, not checking existing IP blocks: %v", err)
+		return
+	}
+	
+	var missing []string
+	for ip := range b.blockedIPs {
+		if !active[ip] {
+			missing = append(missing, ip)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	
+	restored, err := b.firewall.BlockMany(context.Background(), missing)
+	if err != nil {
+		log.Printf("WARNING: Failed to restore firewall rules for %d of %d blocked IPs: %v",
+			len(missing)-len(restored), len(missing), err)
+	}
+	if len(restored) > 0 {
+		log.Printf("Restored firewall rules for %d blocked IPs", len(restored))
+	}
+}
+
+// urlBlockMethod returns the url_block_method of the URL blocker in use,
+// which is hosts when dns is not supported on this OS
+func (b *Blocker) urlBlockMethod() string {
+	if _, ok := b.urls.(*nrptBlocker); ok {
+		return config.URLBlockDNS
+	}
+	return config.URLBlockHosts
+}
+
+// reapplyMissingURLBlocks re-creates the blocks of recorded URLs whose
+// domain is not blocked, such as after url_block_method was changed
+func (b *Blocker) reapplyMissingURLBlocks() {
+	if len(b.blockedURLs) == 0 {
+		return
+	}
+	
+	active, err := b.urls.List(context.Background())
+	if err != nil {
+		log.Printf("WARNING: Failed to read %s, not checking existing URL blocks: %v", b.urls.Name(), err)
+		return
+	}
+	
+	restored := 0
+	for url := range b.blockedURLs {
+		domain := b.extractDomain(url)
+		if domain == "" || active[domain] {
+			continue
+		}
+		if _, err := b.urls.Add(context.Background(), domain); err != nil {
+			log.Printf("WARNING: Failed to restore block for URL %s: %v", url, err)
+			continue
+		}
+		active[domain] = true
+		restored++
+	}
+	if restored > 0 {
+		log.Printf("Restored %s blocks for %d blocked URLs", b.urls.Name(), restored)
+	}
+}
+
 // loadBlockedItems loads the list of previously blocked IPs and URLs
 func (b *Blocker) loadBlockedItems() {
 	filePath := filepath.Join(b.storagePath, "blocked_items.json")
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		// A leftover checksum means the file was deleted behind our back
+		if err := persist.CheckMissing(filePath); err != nil {
+			log.Printf("SECURITY WARNING: Blocked items tampering detected: %v", err)
+			b.tampered = true
+			return
+		}
 		log.Printf("No existing blocked items file found at %s", filePath)
 		return
 	}
@@ -63,108 +224,307 @@ func (b *Blocker) loadBlockedItems() {
 
 	var savedData BlockedItems
 	if err := json.Unmarshal(data, &savedData); err != nil {
-		log.Printf("Failed to unmarshal blocked items data: %v", err)
+		// A file that does not parse is corrupt rather than tampered with;
+		// fall back to the copy kept from the previous successful save
+		log.Printf("Blocked items file %s is corrupt, trying backup: %v", filePath, err)
+		b.recoverFromBackup(filePath, fmt.Errorf("%w: failed to unmarshal blocked items data: %v", persist.ErrCorrupt, err))
 		return
 	}
 
+	// Refuse to load a file that was modified outside of the agent. Starting
+	// empty makes the scanner re-apply blocks for every IOC on its next pass.
+	hasChecksum, err := b.integrity.VerifyChecksum(filePath, data)
+	if err != nil {
+		log.Printf("SECURITY WARNING: Blocked items tampering detected, refusing to load: %v", err)
+		b.tampered = true
+		return
+	}
+	if !hasChecksum {
+		log.Printf("Blocked items file %s has no checksum yet, recording one now", filePath)
+		if err := b.integrity.WriteChecksum(filePath, data); err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+	}
+
+	// A file that parses can still hold entries that cannot be blocked
+	if err := b.verifyItems(&savedData); err != nil {
+		log.Printf("Blocked items file %s failed verification, trying backup: %v", filePath, err)
+		b.recoverFromBackup(filePath, err)
+		return
+	}
+
+	b.loadItems(&savedData)
+}
+
+// recoverFromBackup loads the backup of a corrupt blocked_items.json and
+// restores the file from it. If the backup is missing or fails verification
+// too, the block list starts empty and Corrupt reports why; the scanner then
+// re-applies blocks for every IOC on its next pass.
+func (b *Blocker) recoverFromBackup(filePath string, cause error) {
+	backup, err := b.integrity.ReadBackup(filePath)
+	var savedData BlockedItems
+	if err == nil {
+		if err = json.Unmarshal(backup, &savedData); err != nil {
+			err = fmt.Errorf("failed to unmarshal blocked items backup data: %v", err)
+		} else {
+			err = b.verifyItems(&savedData)
+		}
+	}
+	if err != nil {
+		log.Printf("WARNING: Blocked items are corrupt and could not be recovered, starting with an empty block list: %v", err)
+		b.corrupt = fmt.Errorf("%w (no usable backup: %v)", cause, err)
+		return
+	}
+
+	log.Printf("Recovered blocked items from %s", persist.BackupPath(filePath))
+	if err := b.integrity.RestoreBackup(filePath, backup, 0644); err != nil {
+		log.Printf("WARNING: failed to restore blocked items file from backup: %v", err)
+	}
+	b.loadItems(&savedData)
+}
+
+// loadItems replaces the block list with a verified blocked_items.json
+func (b *Blocker) loadItems(savedData *BlockedItems) {
 	if savedData.BlockedIPs != nil {
 		b.blockedIPs = savedData.BlockedIPs
 	}
 	if savedData.BlockedURLs != nil {
 		b.blockedURLs = savedData.BlockedURLs
 	}
+	if savedData.IPBlockedAt != nil {
+		b.ipBlockedAt = savedData.IPBlockedAt
+	}
+	if savedData.URLBlockedAt != nil {
+		b.urlBlockedAt = savedData.URLBlockedAt
+	}
+	if savedData.SuppressedIPs != nil {
+		b.suppressedIPs = savedData.SuppressedIPs
+	}
+	if savedData.SuppressedURLs != nil {
+		b.suppressedURLs = savedData.SuppressedURLs
+	}
+	if savedData.URLBlockMethod != "" {
+		b.savedURLMethod = savedData.URLBlockMethod
+	}
+	
+	// Blocks saved before their source was recorded are kept as command
+	// blocks, so an analyst's block is never expired by mistake
+	for ip := range savedData.ScannerIPs {
+		if b.blockedIPs[ip] {
+			b.scannerIPs[ip] = true
+		}
+	}
+	for url := range savedData.ScannerURLs {
+		if b.blockedURLs[url] {
+			b.scannerURLs[url] = true
+		}
+	}
+	
+	// Blocks saved before timestamps were recorded start their TTL now
+	now := time.Now()
+	for ip := range b.blockedIPs {
+		if _, ok := b.ipBlockedAt[ip]; !ok {
+			b.ipBlockedAt[ip] = now
+		}
+	}
+	for url := range b.blockedURLs {
+		if _, ok := b.urlBlockedAt[url]; !ok {
+			b.urlBlockedAt[url] = now
+		}
+	}
 
 	log.Printf("Loaded blocked items: %d IPs, %d URLs", 
 		len(b.blockedIPs), len(b.blockedURLs))
 }
 
-// saveBlockedItems saves the list of blocked IPs and URLs
-func (b *Blocker) saveBlockedItems() {
-	filePath := filepath.Join(b.storagePath, "blocked_items.json")
+// Flush writes blocked_items.json now if changes are waiting for
+// block_save_delay_ms, so they are not lost when the agent stops
+func (b *Blocker) Flush() {
+	b.saveMu.Lock()
+	defer b.saveMu.Unlock()
 	
+	b.mu.Lock()
+	if !b.pendingSave {
+		b.mu.Unlock()
+		return
+	}
+	b.pendingSave = false
+	if b.saveTimer != nil {
+		b.saveTimer.Stop()
+		b.saveTimer = nil
+	}
 	data := BlockedItems{
 		BlockedIPs:  b.blockedIPs,
 		BlockedURLs: b.blockedURLs,
+		IPBlockedAt:  b.ipBlockedAt,
+		URLBlockedAt: b.urlBlockedAt,
+		ScannerIPs:   b.scannerIPs,
+		ScannerURLs:  b.scannerURLs,
+		SuppressedIPs:  b.suppressedIPs,
+		SuppressedURLs: b.suppressedURLs,
+	}
+	if method := b.urlBlockMethod(); method != config.URLBlockHosts {
+		data.URLBlockMethod = method
 	}
 	
 	jsonData, err := json.MarshalIndent(data, "", "  ")
+	ips, urls := len(b.blockedIPs), len(b.blockedURLs)
+	b.mu.Unlock()
 	if err != nil {
 		log.Printf("Failed to marshal blocked items data: %v", err)
 		return
 	}
+	
+	filePath := filepath.Join(b.storagePath, "blocked_items.json")
 
-	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
+	// Write atomically, keeping the previous version as a backup, and record
+	// the checksum so tampering can be detected on next load
+	if err := b.integrity.SaveWithBackup(filePath, jsonData, 0644); err != nil {
 		log.Printf("Failed to write blocked items file: %v", err)
 		return
 	}
 
-	log.Printf("Saved blocked items: %d IPs, %d URLs", 
-		len(b.blockedIPs), len(b.blockedURLs))
+	log.Printf("Saved blocked items: %d IPs, %d URLs", ips, urls)
 }
 
-// saveBlockedItemsDelayed saves blocked items with a delay to batch operations
-func (b *Blocker) saveBlockedItemsDelayed() {
-	// If a save is already pending, reset the timer
-	if b.pendingSave {
-		if b.saveTimer != nil {
-			b.saveTimer.Stop()
+// scheduleSaveLocked writes blocked_items.json block_save_delay_ms after the
+// first change since it was last written. Changes made in the meantime are
+// written with it, so a burst of blocks causes a single write. Caller must
+// hold b.mu.
+func (b *Blocker) scheduleSaveLocked() {
+	b.pendingSave = true
+	if b.saveTimer == nil {
+		b.saveTimer = time.AfterFunc(b.config.GetBlockSaveDelayDuration(), b.Flush)
+	}
+}
+
+// ipKey returns the form an IP or range is recorded under in blockedIPs.
+// Ranges are reduced to their network address ("10.0.0.7/24" is recorded as
+// "10.0.0.0/24") so they match what the firewall lists; single addresses are
+// kept as given.
+func ipKey(ip string) string {
+	if strings.Contains(ip, "/") {
+		if canonical := canonicalIP(ip); canonical != "" {
+			return canonical
 		}
 	}
-	
-	b.pendingSave = true
-	b.saveTimer = time.AfterFunc(2*time.Second, func() {
-		b.saveBlockedItems()
-		b.pendingSave = false
-	})
+	return ip
+}
+
+// setSourceLocked records who created the block of item in scanner, the
+// set of scanner blocks. A command takes over a scanner block, so it no
+// longer expires. Caller must hold b.mu.
+func (b *Blocker) setSourceLocked(scanner map[string]bool, item string, source Source) {
+	if source == SourceScanner {
+		scanner[item] = true
+	} else if scanner[item] {
+		delete(scanner, item)
+		b.scheduleSaveLocked()
+	}
 }
 
-// BlockIP blocks an IP address using Windows Firewall
-func (b *Blocker) BlockIP(ip string) error {
+// BlockIP blocks an IP address or CIDR range using the platform firewall
+// (netsh on Windows, nftables or iptables on Linux). The firewall tool is
+// killed if ctx is cancelled first. The scanner gets ErrSuppressed for an IP
+// an UNBLOCK_IP command unblocked.
+func (b *Blocker) BlockIP(ctx context.Context, ip string, source Source) error {
+	ip = ipKey(ip)
+	
 	// Check if already blocked
-	if b.blockedIPs[ip] {
+	b.mu.Lock()
+	blocked := b.blockedIPs[ip]
+	_, suppressed := b.suppressedIPs[ip]
+	if blocked && source == SourceCommand {
+		b.setSourceLocked(b.scannerIPs, ip, source)
+	}
+	b.mu.Unlock()
+	if suppressed && source == SourceScanner {
+		return ErrSuppressed
+	}
+	if blocked {
 		log.Printf("IP %s is already blocked", ip)
 		return nil
 	}
 	
 	log.Printf("Blocking IP address: %s", ip)
 	
-	// Block outbound traffic
-	outCmd := exec.Command("netsh", "advfirewall", "firewall", "add", "rule",
-		"name=EDR_Block_"+ip+"_Out",
-		"dir=out",
-		"action=block",
-		"remoteip="+ip)
-	
-	if outOutput, err := outCmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to block outbound IP %s: %v, output: %s", ip, err, string(outOutput))
-	}
-
-	// Block inbound traffic
-	inCmd := exec.Command("netsh", "advfirewall", "firewall", "add", "rule",
-		"name=EDR_Block_"+ip+"_In",
-		"dir=in",
-		"action=block",
-		"remoteip="+ip)
-	
-	if inOutput, err := inCmd.CombinedOutput(); err != nil {
-		// Try to clean up the outbound rule if inbound fails
-		cleanupCmd := exec.Command("netsh", "advfirewall", "firewall", "delete", "rule", "name=EDR_Block_"+ip+"_Out")
-		cleanupCmd.Run()
-		return fmt.Errorf("failed to block inbound IP %s: %v, output: %s", ip, err, string(inOutput))
+	if err := b.firewall.Block(ctx, ip); err != nil {
+		return err
 	}
 
 	// Mark as blocked and persist
+	b.mu.Lock()
 	b.blockedIPs[ip] = true
-	b.saveBlockedItemsDelayed()
+	b.ipBlockedAt[ip] = time.Now()
+	b.setSourceLocked(b.scannerIPs, ip, source)
+	b.suppressLocked(b.suppressedIPs, ip, source, false)
+	b.scheduleSaveLocked()
+	b.mu.Unlock()
 	
 	log.Printf("Successfully blocked IP %s (inbound and outbound)", ip)
 	return nil
 }
 
-// BlockURL blocks a URL by adding it to the hosts file
-func (b *Blocker) BlockURL(url string) error {
+// BlockIPs blocks a list of IP addresses with as few firewall rules and tool
+// invocations as the platform firewall allows, for bulk blocking after an
+// IOC update. IPs that are already blocked are skipped, as are IPs the
+// scanner must not block again because of an UNBLOCK_IP command. It returns
+// the IPs newly blocked; the error describes the first IP or batch that failed.
+func (b *Blocker) BlockIPs(ctx context.Context, ips []string, source Source) ([]string, error) {
+	var pending []string
+	seen := make(map[string]bool, len(ips))
+	b.mu.RLock()
+	for _, ip := range ips {
+		ip = ipKey(ip)
+		if _, suppressed := b.suppressedIPs[ip]; suppressed && source == SourceScanner {
+			continue
+		}
+		if !b.blockedIPs[ip] && !seen[ip] {
+			pending = append(pending, ip)
+			seen[ip] = true
+		}
+	}
+	b.mu.RUnlock()
+	if len(pending) == 0 {
+		return nil, nil
+	}
+	
+	log.Printf("Blocking %d IP addresses", len(pending))
+	blocked, err := b.firewall.BlockMany(ctx, pending)
+	
+	now := time.Now()
+	if len(blocked) > 0 {
+		b.mu.Lock()
+		for _, ip := range blocked {
+			b.blockedIPs[ip] = true
+			b.ipBlockedAt[ip] = now
+			b.setSourceLocked(b.scannerIPs, ip, source)
+			b.suppressLocked(b.suppressedIPs, ip, source, false)
+		}
+		b.scheduleSaveLocked()
+		b.mu.Unlock()
+		log.Printf("Successfully blocked %d IPs (inbound and outbound)", len(blocked))
+	}
+	
+	return blocked, err
+}
+
+// BlockURL blocks a URL by blocking its domain in the hosts file or, with
+// url_block_method dns, in the DNS policy. The scanner gets ErrSuppressed for
+// a URL an UNBLOCK_URL command unblocked.
+func (b *Blocker) BlockURL(url string, source Source) error {
 	// Check if already blocked
-	if b.blockedURLs[url] {
+	b.mu.Lock()
+	blocked := b.blockedURLs[url]
+	_, suppressed := b.suppressedURLs[url]
+	if blocked && source == SourceCommand {
+		b.setSourceLocked(b.scannerURLs, url, source)
+	}
+	b.mu.Unlock()
+	if suppressed && source == SourceScanner {
+		return ErrSuppressed
+	}
+	if blocked {
 		log.Printf("URL %s is already blocked", url)
 		return nil
 	}
@@ -177,25 +537,35 @@ func (b *Blocker) BlockURL(url string) error {
 		return fmt.Errorf("failed to extract domain from URL: %s", url)
 	}
 	
-	// Try to block by modifying hosts file
-	blocked, err := b.addDomainToHostsFile(domain)
+	blocked, err := b.urls.Add(context.Background(), domain)
 	if err != nil {
 		return err
 	}
 	
 	// Mark as blocked and persist
+	b.mu.Lock()
 	b.blockedURLs[url] = true
-	b.saveBlockedItemsDelayed()
+	b.urlBlockedAt[url] = time.Now()
+	b.setSourceLocked(b.scannerURLs, url, source)
+	b.suppressLocked(b.suppressedURLs, url, source, false)
+	b.scheduleSaveLocked()
+	b.mu.Unlock()
 	
 	if blocked {
-		log.Printf("Successfully blocked URL %s by adding domain %s to hosts file", url, domain)
+		log.Printf("Successfully blocked URL %s by adding domain %s to %s", url, domain, b.urls.Name())
 	} else {
-		log.Printf("URL %s already blocked - domain %s exists in hosts file", url, domain)
+		log.Printf("URL %s already blocked - domain %s exists in %s", url, domain, b.urls.Name())
 	}
 	
 	return nil
 }
 
+// ExtractDomain returns the domain BlockURL would block for url, or "" if it
+// has none
+func (b *Blocker) ExtractDomain(url string) string {
+	return b.extractDomain(url)
+}
+
 // extractDomain extracts the domain from a URL
 func (b *Blocker) extractDomain(urlStr string) string {
 	// Add http:// prefix if not present (needed for url.Parse)
@@ -214,63 +584,219 @@ func (b *Blocker) extractDomain(urlStr string) string {
 	return parsedURL.Host
 }
 
-// addDomainToHostsFile adds a domain to the hosts file, pointing to the configured redirect IP
-// Returns true if domain was added, false if it was already there
-func (b *Blocker) addDomainToHostsFile(domain string) (bool, error) {
-	hostsPath := b.config.HostsFilePath
+// URLBlockTarget describes where URL blocks are created, "hosts file" or
+// "DNS policy"
+func (b *Blocker) URLBlockTarget() string {
+	return b.urls.Name()
+}
+
+// CleanupURLBlocks removes the blocks for domains no blocked URL maps to any
+// more, such as ones left behind by an interrupted unblock. It returns how
+// many were removed.
+func (b *Blocker) CleanupURLBlocks() (int, error) {
+	needed := make(map[string]bool)
+	b.mu.RLock()
+	for url := range b.blockedURLs {
+		if domain := b.extractDomain(url); domain != "" {
+			needed[domain] = true
+		}
+	}
+	b.mu.RUnlock()
+	return b.urls.Cleanup(context.Background(), needed)
+}
+
+// UnblockIP removes the firewall rules created by BlockIP for an IP address.
+// An IP unblocked by a command is not blocked by the scanner again.
+func (b *Blocker) UnblockIP(ctx context.Context, ip string, source Source) error {
+	ip = ipKey(ip)
+	log.Printf("Unblocking IP address: %s", ip)
 	
-	// Read current hosts file
-	content, err := os.ReadFile(hostsPath)
-	if err != nil {
-		return false, fmt.Errorf("failed to read hosts file: %v", err)
+	// Only treat it as an error if nothing was removed and we did not know about the block
+	if err := b.firewall.Unblock(ctx, ip); err != nil {
+		b.mu.RLock()
+		known := b.blockedIPs[ip]
+		b.mu.RUnlock()
+		if !known {
+			return fmt.Errorf("failed to unblock IP %s: %v", ip, err)
+		}
+		log.Printf("WARNING: Failed to delete firewall rules for %s: %v", ip, err)
+	}
+	
+	// Remove from blocked list and persist
+	b.mu.Lock()
+	delete(b.blockedIPs, ip)
+	delete(b.ipBlockedAt, ip)
+	delete(b.scannerIPs, ip)
+	b.suppressLocked(b.suppressedIPs, ip, source, true)
+	b.scheduleSaveLocked()
+	b.mu.Unlock()
+	
+	log.Printf("Successfully unblocked IP %s", ip)
+	return nil
+}
+
+// UnblockURL removes a URL block by removing its domain from the hosts file
+// or DNS policy. A URL unblocked by a command is not blocked by the scanner
+// again.
+func (b *Blocker) UnblockURL(url string, source Source) error {
+	log.Printf("Unblocking URL: %s", url)
+	
+	// Extract domain from URL
+	domain := b.extractDomain(url)
+	if domain == "" {
+		return fmt.Errorf("failed to extract domain from URL: %s", url)
 	}
 	
-	// Check if domain is already in hosts file
-	lines := strings.Split(string(content), "\n")
-	blockLine := fmt.Sprintf("%s %s", b.config.BlockedIPRedirect, domain)
+	// Keep the domain blocked if another blocked URL still maps to it
+	stillNeeded := false
+	b.mu.RLock()
+	known := b.blockedURLs[url]
+	for blockedURL := range b.blockedURLs {
+		if blockedURL != url && b.extractDomain(blockedURL) == domain {
+			stillNeeded = true
+			break
+		}
+	}
+	b.mu.RUnlock()
 	
-	for _, line := range lines {
-		trimmedLine := strings.TrimSpace(line)
-		if trimmedLine == blockLine || strings.HasSuffix(trimmedLine, " "+domain) {
-			// Domain already blocked
-			return false, nil
+	if stillNeeded {
+		log.Printf("Domain %s is still used by another blocked URL, keeping %s entry", domain, b.urls.Name())
+	} else {
+		removed, err := b.urls.Remove(context.Background(), domain)
+		if err != nil {
+			return err
+		}
+		if !removed && !known {
+			return fmt.Errorf("URL %s is not blocked", url)
 		}
 	}
 	
-	// Add domain to hosts file
-	file, err := os.OpenFile(hostsPath, os.O_APPEND|os.O_WRONLY, 0644)
-	if err != nil {
-		return false, fmt.Errorf("failed to open hosts file for writing: %v", err)
+	// Remove from blocked list and persist
+	b.mu.Lock()
+	delete(b.blockedURLs, url)
+	delete(b.urlBlockedAt, url)
+	delete(b.scannerURLs, url)
+	b.suppressLocked(b.suppressedURLs, url, source, true)
+	b.scheduleSaveLocked()
+	b.mu.Unlock()
+	
+	log.Printf("Successfully unblocked URL %s", url)
+	return nil
+}
+
+// RefreshIP resets the TTL of an existing IP block
+func (b *Blocker) RefreshIP(ip string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.blockedIPs[ip] {
+		b.ipBlockedAt[ip] = time.Now()
+	}
+}
+
+// RefreshURL resets the TTL of an existing URL block
+func (b *Blocker) RefreshURL(url string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.blockedURLs[url] {
+		b.urlBlockedAt[url] = time.Now()
+	}
+}
+
+// ExpireStaleBlocks removes IP and URL blocks created by the scanner that
+// were created or last refreshed more than maxAge ago. Blocks from BLOCK_IP
+// and BLOCK_URL commands are kept until they are unblocked. It returns the number of IPs and URLs
+// unblocked. A non-positive maxAge disables expiry.
+func (b *Blocker) ExpireStaleBlocks(maxAge time.Duration) (int, int) {
+	if maxAge <= 0 {
+		return 0, 0
 	}
-	defer file.Close()
 	
-	// Add newline if file doesn't end with one
-	if !strings.HasSuffix(string(content), "\n") {
-		if _, err := file.WriteString("\n"); err != nil {
-			return false, fmt.Errorf("failed to write newline to hosts file: %v", err)
+	cutoff := time.Now().Add(-maxAge)
+	expiredIPs, expiredURLs := 0, 0
+	
+	// Collect the expired blocks first; unblocking takes the lock itself
+	stale := func(blocked map[string]bool, blockedAt map[string]time.Time) map[string]time.Time {
+		expired := make(map[string]time.Time)
+		for item := range blocked {
+			if at, ok := blockedAt[item]; ok && at.Before(cutoff) {
+				expired[item] = at
+			}
 		}
+		return expired
 	}
+	b.mu.RLock()
+	staleIPs := stale(b.scannerIPs, b.ipBlockedAt)
+	staleURLs := stale(b.scannerURLs, b.urlBlockedAt)
+	b.mu.RUnlock()
 	
-	// Add block entry
-	if _, err := file.WriteString(blockLine + "\n"); err != nil {
-		return false, fmt.Errorf("failed to write to hosts file: %v", err)
+	for ip, blockedAt := range staleIPs {
+		log.Printf("Block for IP %s expired (blocked at %s)", ip, blockedAt.Format(time.RFC3339))
+		if err := b.UnblockIP(context.Background(), ip, SourceScanner); err != nil {
+			log.Printf("Failed to expire block for IP %s: %v", ip, err)
+			continue
+		}
+		expiredIPs++
 	}
 	
-	return true, nil
+	for url, blockedAt := range staleURLs {
+		log.Printf("Block for URL %s expired (blocked at %s)", url, blockedAt.Format(time.RFC3339))
+		if err := b.UnblockURL(url, SourceScanner); err != nil {
+			log.Printf("Failed to expire block for URL %s: %v", url, err)
+			continue
+		}
+		expiredURLs++
+	}
+	
+	// Persist refreshed timestamps even when nothing expired
+	b.mu.Lock()
+	b.scheduleSaveLocked()
+	b.mu.Unlock()
+	
+	return expiredIPs, expiredURLs
+}
+
+// Tampered reports whether the persisted block list failed its integrity check on load
+func (b *Blocker) Tampered() bool {
+	return b.tampered
 }
 
-// IsIPBlocked checks if an IP is already blocked
+// IsIPBlocked checks if an IP or range is already blocked, including an
+// address that falls inside a blocked range
 func (b *Blocker) IsIPBlocked(ip string) bool {
-	return b.blockedIPs[ip]
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	
+	if b.blockedIPs[ipKey(ip)] {
+		return true
+	}
+	
+	// An address is also blocked when it falls inside a blocked range
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for blocked := range b.blockedIPs {
+		if !strings.Contains(blocked, "/") {
+			continue
+		}
+		if _, network, err := net.ParseCIDR(blocked); err == nil && network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
 }
 
 // IsURLBlocked checks if a URL is already blocked
 func (b *Blocker) IsURLBlocked(url string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	return b.blockedURLs[url]
 }
 
 // GetBlockedIPs returns a copy of blocked IPs
 func (b *Blocker) GetBlockedIPs() map[string]bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	result := make(map[string]bool)
 	for ip, blocked := range b.blockedIPs {
 		result[ip] = blocked
@@ -280,6 +806,8 @@ func (b *Blocker) GetBlockedIPs() map[string]bool {
 
 // GetBlockedURLs returns a copy of blocked URLs
 func (b *Blocker) GetBlockedURLs() map[string]bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	result := make(map[string]bool)
 	for url, blocked := range b.blockedURLs {
 		result[url] = blocked
@@ -287,7 +815,21 @@ func (b *Blocker) GetBlockedURLs() map[string]bool {
 	return result
 }
 
+// ActiveFirewallIPs returns the IPs that currently have EDR firewall rules,
+// read from the firewall itself rather than the agent's records
+func (b *Blocker) ActiveFirewallIPs(ctx context.Context) (map[string]bool, error) {
+	return b.firewall.List(ctx)
+}
+
+// URLBlockDomains returns the domains currently blocked in the hosts file or
+// DNS policy, read from there rather than the agent's records
+func (b *Blocker) URLBlockDomains(ctx context.Context) (map[string]bool, error) {
+	return b.urls.List(ctx)
+}
+
 // GetBlockedCount returns the count of blocked IPs and URLs
 func (b *Blocker) GetBlockedCount() (int, int) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
 	return len(b.blockedIPs), len(b.blockedURLs)
 } 
\ No newline at end of file
@@ -0,0 +1,114 @@
+package blocker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"agent/config"
+	"agent/persist"
+)
+
+// newTestBlocker returns a Blocker on nftables driven by a fake runner and
+// saving to a temporary directory
+func newTestBlocker(t *testing.T) *Blocker {
+	t.Helper()
+	noRateLimit(t)
+	integrity, err := persist.NewIntegrity(persist.NewKeyStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+		if argv[1] == "list" && argv[2] == "set" {
+			return []byte("set blocked4 { type ipv4_addr; flags interval; }"), nil
+		}
+		return nil, nil
+	}}
+	b := &Blocker{
+		config:         config.NewDefaultConfig(),
+		blockedIPs:     make(map[string]bool),
+		blockedURLs:    make(map[string]bool),
+		ipBlockedAt:    make(map[string]time.Time),
+		urlBlockedAt:   make(map[string]time.Time),
+		scannerIPs:     make(map[string]bool),
+		scannerURLs:    make(map[string]bool),
+		suppressedIPs:  make(map[string]time.Time),
+		suppressedURLs: make(map[string]time.Time),
+		storagePath:    t.TempDir(),
+		integrity:      integrity,
+		runner:         runner,
+		firewall:       &nftBackend{firewallTools: firewallTools{runner: runner}},
+	}
+	t.Cleanup(b.Flush)
+	return b
+}
+
+func TestIsIPBlocked(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   string
+		key     string   // Form the block is recorded under
+		blocked []string // Addresses IsIPBlocked must report
+		allowed []string // Addresses it must not
+	}{
+		{
+			name:    "single IPv4 address",
+			block:   "192.0.2.7",
+			key:     "192.0.2.7",
+			blocked: []string{"192.0.2.7"},
+			allowed: []string{"192.0.2.8", "192.0.2.70", "192.0.2.0"},
+		},
+		{
+			name:    "IPv4 /24",
+			block:   "10.1.2.77/24",
+			key:     "10.1.2.0/24",
+			blocked: []string{"10.1.2.0", "10.1.2.1", "10.1.2.77", "10.1.2.255"},
+			allowed: []string{"10.1.3.0", "10.1.1.255", "10.1.20.1"},
+		},
+		{
+			name:    "IPv6 /48",
+			block:   "2001:db8:abcd::/48",
+			key:     "2001:db8:abcd::/48",
+			blocked: []string{"2001:db8:abcd::", "2001:db8:abcd::1", "2001:db8:abcd:ffff::1", "2001:0db8:abcd:0000:0000:0000:0000:0042"},
+			allowed: []string{"2001:db8:abce::1", "2001:db8::1", "10.1.2.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newTestBlocker(t)
+			if err := b.BlockIP(context.Background(), tt.block, SourceCommand); err != nil {
+				t.Fatalf("BlockIP(%q): %v", tt.block, err)
+			}
+			if ips := b.GetBlockedIPs(); len(ips) != 1 || !ips[tt.key] {
+				t.Errorf("blocked IPs = %v, want only %s", ips, tt.key)
+			}
+
+			for _, ip := range tt.blocked {
+				if !b.IsIPBlocked(ip) {
+					t.Errorf("IsIPBlocked(%q) = false, want true", ip)
+				}
+			}
+			for _, ip := range tt.allowed {
+				if b.IsIPBlocked(ip) {
+					t.Errorf("IsIPBlocked(%q) = true, want false", ip)
+				}
+			}
+			if b.IsIPBlocked("not-an-ip") {
+				t.Error("IsIPBlocked(\"not-an-ip\") = true, want false")
+			}
+		})
+	}
+}
+
+func TestBlockIPRejectsInvalidRange(t *testing.T) {
+	for _, ip := range []string{"10.0.0.0/33", "2001:db8::/129", "10.0.0/24", "example.com/24"} {
+		b := newTestBlocker(t)
+		if err := b.BlockIP(context.Background(), ip, SourceCommand); err == nil {
+			t.Errorf("BlockIP(%q) succeeded", ip)
+		}
+		if ips := b.GetBlockedIPs(); len(ips) != 0 {
+			t.Errorf("BlockIP(%q) recorded %v", ip, ips)
+		}
+	}
+}
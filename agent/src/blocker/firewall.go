@@ -0,0 +1,785 @@
+package blocker
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxFirewallCommandsPerSecond caps how often firewall tools are started, so
+// blocking a large IOC update does not peg the CPU with short-lived processes
+const maxFirewallCommandsPerSecond = 20
+
+// firewallBackend creates and removes the firewall rules behind IP blocks,
+// each of which is an address or a CIDR range. Block and Unblock cover both
+// inbound and outbound traffic; BlockMany blocks a list of IPs with as few
+// rules as the firewall allows and returns the ones it blocked; List returns
+// the IPs and ranges that currently have rules, in canonical form. Firewall tools
+// they run are killed when ctx is cancelled.
+type firewallBackend interface {
+	Block(ctx context.Context, ip string) error
+	BlockMany(ctx context.Context, ips []string) ([]string, error)
+	Unblock(ctx context.Context, ip string) error
+	List(ctx context.Context) (map[string]bool, error)
+}
+
+// newFirewallBackend picks the firewall implementation for the running OS,
+// which starts firewall tools through runner
+func newFirewallBackend(runner commandRunner) firewallBackend {
+	tools := firewallTools{runner: runner}
+	switch runtime.GOOS {
+	case "windows":
+		return &netshBackend{firewallTools: tools}
+	case "linux":
+		if _, err := exec.LookPath("nft"); err == nil {
+			log.Printf("Using nftables for IP blocking")
+			return &nftBackend{firewallTools: tools}
+		}
+		if _, err := exec.LookPath("iptables"); err == nil {
+			log.Printf("Using iptables for IP blocking")
+			return &iptablesBackend{firewallTools: tools}
+		}
+		log.Printf("WARNING: neither nft nor iptables found, IP blocking is unavailable")
+	}
+	return unsupportedBackend{}
+}
+
+// validateIP rejects values that are not IP addresses or CIDR ranges before
+// they reach a firewall command line. For a range it returns the network
+// address, which tells the caller the address family.
+func validateIP(ip string) (net.IP, error) {
+	if strings.Contains(ip, "/") {
+		_, network, err := net.ParseCIDR(ip)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP range: %s", ip)
+		}
+		return network.IP, nil
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return nil, fmt.Errorf("invalid IP address: %s", ip)
+	}
+	return parsed, nil
+}
+
+// canonicalIP returns the canonical form of an address or CIDR range, as
+// firewall listings print it: ranges are reduced to their network address
+// and single-host prefixes (/32, /128) to the bare address. It returns ""
+// for values that are neither.
+func canonicalIP(value string) string {
+	if !strings.Contains(value, "/") {
+		if parsed := net.ParseIP(value); parsed != nil {
+			return parsed.String()
+		}
+		return ""
+	}
+	_, network, err := net.ParseCIDR(value)
+	if err != nil {
+		return ""
+	}
+	if ones, bits := network.Mask.Size(); ones == bits {
+		return network.IP.String()
+	}
+	return network.String()
+}
+
+// validIPs returns the entries of ips that are IP addresses and an error
+// naming the first one that is not
+func validIPs(ips []string) ([]string, error) {
+	valid := make([]string, 0, len(ips))
+	var firstErr error
+	for _, ip := range ips {
+		if _, err := validateIP(ip); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		valid = append(valid, ip)
+	}
+	return valid, firstErr
+}
+
+// sameIP reports whether two strings are the same IP address or range,
+// whatever their formatting
+func sameIP(a, b string) bool {
+	ca := canonicalIP(a)
+	return ca != "" && ca == canonicalIP(b)
+}
+
+// commandLimiter spaces out firewall tool invocations to a fixed rate
+type commandLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+var firewallLimiter = &commandLimiter{interval: time.Second / maxFirewallCommandsPerSecond}
+
+// wait blocks until the next invocation may start or ctx is cancelled
+func (l *commandLimiter) wait(ctx context.Context) error {
+	l.mu.Lock()
+	start := time.Now()
+	if l.next.After(start) {
+		start = l.next
+	}
+	l.next = start.Add(l.interval)
+	l.mu.Unlock()
+
+	delay := time.Until(start)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// firewallTools is embedded in the backends that shell out to a firewall
+// tool, and starts the tool through runner under the rate limit
+type firewallTools struct {
+	runner commandRunner
+}
+
+// firewallRun runs a firewall tool for its exit status
+func (t firewallTools) firewallRun(ctx context.Context, name string, args ...string) error {
+	if err := firewallLimiter.wait(ctx); err != nil {
+		return err
+	}
+	_, err := t.runner.Run(ctx, name, args...)
+	return err
+}
+
+// firewallOutput runs a firewall tool and returns its output
+func (t firewallTools) firewallOutput(ctx context.Context, name string, args ...string) ([]byte, error) {
+	if err := firewallLimiter.wait(ctx); err != nil {
+		return nil, err
+	}
+	return t.runner.Run(ctx, name, args...)
+}
+
+// runFirewallCommand runs a firewall tool and includes its output in errors
+func (t firewallTools) runFirewallCommand(ctx context.Context, name string, args ...string) error {
+	if err := firewallLimiter.wait(ctx); err != nil {
+		return err
+	}
+	if output, err := t.runner.Run(ctx, name, args...); err != nil {
+		return fmt.Errorf("%s %s: %v, output: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// netshBatchSize is the most addresses put in one batched netsh rule, which
+// keeps the command line well under the Windows limit even for IPv6
+const netshBatchSize = 100
+
+// netshBatchPrefix starts the names of rules holding a batch of addresses
+const netshBatchPrefix = "EDR_Block_Batch_"
+
+// netshBackend uses Windows Firewall rules named EDR_Block_<ip>_In/_Out for
+// single blocks and EDR_Block_Batch_<n>_In/_Out, whose remoteip lists up to
+// netshBatchSize addresses, for blocks applied in bulk
+type netshBackend struct {
+	firewallTools
+	mu        sync.Mutex
+	loaded    bool
+	batches   map[string][]string // Batch rule name without direction -> member addresses
+	nextBatch int
+}
+
+// netshRuleName returns the rule name, without direction, for a single
+// address or range. Slashes are not allowed in rule names, so the prefix
+// length of a range is joined with an underscore: EDR_Block_10.0.0.0_24.
+func netshRuleName(ip string) string {
+	return "EDR_Block_" + strings.ReplaceAll(ip, "/", "_")
+}
+
+// netshAddress converts an address from a RemoteIP listing, which prints
+// hosts as "1.2.3.4/32" and IPv4 ranges with a netmask as
+// "10.0.0.0/255.255.255.0", to canonical form
+func netshAddress(field string) string {
+	field = strings.TrimSpace(field)
+	if i := strings.IndexByte(field, '/'); i >= 0 {
+		if mask := net.ParseIP(field[i+1:]); mask != nil && mask.To4() != nil {
+			ones, _ := net.IPMask(mask.To4()).Size()
+			field = fmt.Sprintf("%s/%d", field[:i], ones)
+		}
+	}
+	return canonicalIP(field)
+}
+
+func (n *netshBackend) blockRule(ctx context.Context, name, remoteIP string) error {
+	// Block outbound traffic
+	if err := n.runFirewallCommand(ctx, "netsh", "advfirewall", "firewall", "add", "rule",
+		"name="+name+"_Out", "dir=out", "action=block", "remoteip="+remoteIP); err != nil {
+		return fmt.Errorf("failed to block outbound traffic: %v", err)
+	}
+
+	// Block inbound traffic
+	if err := n.runFirewallCommand(ctx, "netsh", "advfirewall", "firewall", "add", "rule",
+		"name="+name+"_In", "dir=in", "action=block", "remoteip="+remoteIP); err != nil {
+		// Try to clean up the outbound rule if inbound fails
+		n.runFirewallCommand(ctx, "netsh", "advfirewall", "firewall", "delete", "rule", "name="+name+"_Out")
+		return fmt.Errorf("failed to block inbound traffic: %v", err)
+	}
+
+	return nil
+}
+
+// deleteRule deletes both directions of a rule, even if one is already gone
+func (n *netshBackend) deleteRule(ctx context.Context, name string) error {
+	var failures []string
+	for _, direction := range []string{"In", "Out"} {
+		ruleName := name + "_" + direction
+		if err := n.runFirewallCommand(ctx, "netsh", "advfirewall", "firewall", "delete", "rule", "name="+ruleName); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) == 2 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	for _, failure := range failures {
+		log.Printf("WARNING: Failed to delete firewall rule: %s", failure)
+	}
+	return nil
+}
+
+func (n *netshBackend) Block(ctx context.Context, ip string) error {
+	if _, err := validateIP(ip); err != nil {
+		return err
+	}
+	if err := n.blockRule(ctx, netshRuleName(ip), ip); err != nil {
+		return fmt.Errorf("failed to block IP %s: %v", ip, err)
+	}
+	return nil
+}
+
+// BlockMany blocks addresses netshBatchSize at a time, one pair of rules per
+// batch, instead of spawning netsh twice per address
+func (n *netshBackend) BlockMany(ctx context.Context, ips []string) ([]string, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.loadLocked(ctx); err != nil {
+		return nil, err
+	}
+
+	valid, firstErr := validIPs(ips)
+	var blocked []string
+	for start := 0; start < len(valid); start += netshBatchSize {
+		end := start + netshBatchSize
+		if end > len(valid) {
+			end = len(valid)
+		}
+		chunk := valid[start:end]
+
+		name := n.newBatchNameLocked()
+		if err := n.blockRule(ctx, name, strings.Join(chunk, ",")); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to block %d IPs: %v", len(chunk), err)
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		n.batches[name] = append([]string(nil), chunk...)
+		blocked = append(blocked, chunk...)
+	}
+	return blocked, firstErr
+}
+
+// newBatchNameLocked returns an unused batch rule name (caller must hold the lock)
+func (n *netshBackend) newBatchNameLocked() string {
+	for {
+		n.nextBatch++
+		name := fmt.Sprintf("%s%d", netshBatchPrefix, n.nextBatch)
+		if _, exists := n.batches[name]; !exists {
+			return name
+		}
+	}
+}
+
+func (n *netshBackend) Unblock(ctx context.Context, ip string) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if err := n.loadLocked(ctx); err != nil {
+		return err
+	}
+
+	// An address in a batch is removed by rewriting the batch's remoteip,
+	// or deleting the batch when it was the last member
+	for name, members := range n.batches {
+		remaining := make([]string, 0, len(members))
+		for _, member := range members {
+			if !sameIP(member, ip) {
+				remaining = append(remaining, member)
+			}
+		}
+		if len(remaining) == len(members) {
+			continue
+		}
+
+		if len(remaining) == 0 {
+			if err := n.deleteRule(ctx, name); err != nil {
+				return err
+			}
+			delete(n.batches, name)
+			return nil
+		}
+		for _, direction := range []string{"In", "Out"} {
+			if err := n.runFirewallCommand(ctx, "netsh", "advfirewall", "firewall", "set", "rule",
+				"name="+name+"_"+direction, "new", "remoteip="+strings.Join(remaining, ",")); err != nil {
+				return fmt.Errorf("failed to remove IP %s from firewall rule %s: %v", ip, name, err)
+			}
+		}
+		n.batches[name] = remaining
+		return nil
+	}
+
+	return n.deleteRule(ctx, netshRuleName(ip))
+}
+
+func (n *netshBackend) List(ctx context.Context) (map[string]bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	ips, batches, err := n.listRules(ctx)
+	if err != nil {
+		return nil, err
+	}
+	n.setBatchesLocked(batches)
+	return ips, nil
+}
+
+// loadLocked reads the existing batch rules on first use so Unblock can find
+// addresses blocked by a previous run (caller must hold the lock)
+func (n *netshBackend) loadLocked(ctx context.Context) error {
+	if n.loaded {
+		return nil
+	}
+	_, batches, err := n.listRules(ctx)
+	if err != nil {
+		return err
+	}
+	n.setBatchesLocked(batches)
+	return nil
+}
+
+// setBatchesLocked replaces the cached batch membership (caller must hold the lock)
+func (n *netshBackend) setBatchesLocked(batches map[string][]string) {
+	n.batches = batches
+	n.loaded = true
+	for name := range batches {
+		var number int
+		if _, err := fmt.Sscanf(strings.TrimPrefix(name, netshBatchPrefix), "%d", &number); err == nil && number > n.nextBatch {
+			n.nextBatch = number
+		}
+	}
+}
+
+// listRules returns the addresses with EDR rules and the members of each
+// batch rule, read from the inbound rules. The labels of a listing are
+// translated on non-English Windows, so lines are told apart by their values:
+// a rule's name is the line above the dashes under it, and the addresses of
+// a batch are the one field of its rule that is a list of addresses.
+func (n *netshBackend) listRules(ctx context.Context) (map[string]bool, map[string][]string, error) {
+	output, err := n.firewallOutput(ctx, "netsh", "advfirewall", "firewall", "show", "rule", "name=all")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list firewall rules: %v", err)
+	}
+
+	ips := make(map[string]bool)
+	batches := make(map[string][]string)
+	current, previous := "", ""
+	scanner := bufio.NewScanner(strings.NewReader(string(output)))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		value := netshValue(line)
+		switch {
+		case line != "" && strings.Trim(line, "-") == "":
+			current = previous
+			if !strings.HasPrefix(current, "EDR_Block_") {
+				current = ""
+			} else if !strings.HasPrefix(current, netshBatchPrefix) {
+				name := strings.TrimPrefix(current, "EDR_Block_")
+				name = strings.TrimSuffix(strings.TrimSuffix(name, "_In"), "_Out")
+				ips[strings.ReplaceAll(name, "_", "/")] = true
+			}
+
+		case strings.HasPrefix(current, netshBatchPrefix):
+			// Addresses are printed as "1.2.3.4/32,10.0.0.0/255.255.255.0,2001:db8::/32"
+			members := netshAddresses(value)
+			if members == nil {
+				break
+			}
+			name := strings.TrimSuffix(strings.TrimSuffix(current, "_In"), "_Out")
+			for _, member := range members {
+				ips[member] = true
+			}
+			if strings.HasSuffix(current, "_In") || batches[name] == nil {
+				batches[name] = members
+			}
+		}
+		previous = value
+	}
+	return ips, batches, nil
+}
+
+// netshValue returns the value of a "Label: value" line of a rule listing
+func netshValue(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		return strings.TrimSpace(line[i+1:])
+	}
+	return ""
+}
+
+// netshAddresses returns the addresses of a RemoteIP value in canonical
+// form, or nil if value is not a list of addresses
+func netshAddresses(value string) []string {
+	if value == "" {
+		return nil
+	}
+	var addrs []string
+	for _, field := range strings.Split(value, ",") {
+		addr := netshAddress(field)
+		if addr == "" {
+			return nil
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// nftTable holds the sets and chains created by nftBackend
+const nftTable = "edr_agent"
+
+// nftBackend adds blocked IPs to sets in a dedicated nftables table whose
+// input and output chains drop traffic to and from set members
+type nftBackend struct {
+	firewallTools
+	mu    sync.Mutex
+	ready bool
+}
+
+// ensureTable creates the table on first use; it is left alone if it exists
+func (n *nftBackend) ensureTable(ctx context.Context) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.ready {
+		return nil
+	}
+	if n.firewallRun(ctx, "nft", "list", "table", "inet", nftTable) == nil {
+		// Sets created before ranges were supported cannot hold them.
+		// Recreate the table; the Blocker re-adds missing blocks after
+		// listing them.
+		output, err := n.firewallOutput(ctx, "nft", "list", "set", "inet", nftTable, "blocked4")
+		if err == nil && strings.Contains(string(output), "interval") {
+			n.ready = true
+			return nil
+		}
+		log.Printf("Recreating nftables table %s to support blocking IP ranges", nftTable)
+		if err := n.runFirewallCommand(ctx, "nft", "delete", "table", "inet", nftTable); err != nil {
+			return fmt.Errorf("failed to recreate nftables table: %v", err)
+		}
+	}
+
+	script := `table inet ` + nftTable + ` {
+	set blocked4 { type ipv4_addr; flags interval; }
+	set blocked6 { type ipv6_addr; flags interval; }
+	chain input {
+		type filter hook input priority 0; policy accept;
+		ip saddr @blocked4 drop
+		ip6 saddr @blocked6 drop
+	}
+	chain output {
+		type filter hook output priority 0; policy accept;
+		ip daddr @blocked4 drop
+		ip6 daddr @blocked6 drop
+	}
+}
+`
+	if output, err := n.runner.RunInput(ctx, script, "nft", "-f", "-"); err != nil {
+		return fmt.Errorf("failed to create nftables table: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	n.ready = true
+	return nil
+}
+
+// nftSet returns the set an address belongs in
+func nftSet(ip net.IP) string {
+	if ip.To4() != nil {
+		return "blocked4"
+	}
+	return "blocked6"
+}
+
+func (n *nftBackend) Block(ctx context.Context, ip string) error {
+	parsed, err := validateIP(ip)
+	if err != nil {
+		return err
+	}
+	if err := n.ensureTable(ctx); err != nil {
+		return err
+	}
+	return n.runFirewallCommand(ctx, "nft", "add", "element", "inet", nftTable, nftSet(parsed), "{", ip, "}")
+}
+
+// BlockMany adds addresses to the sets with one nft command per set
+func (n *nftBackend) BlockMany(ctx context.Context, ips []string) ([]string, error) {
+	valid, firstErr := validIPs(ips)
+	if len(valid) == 0 {
+		return nil, firstErr
+	}
+	if err := n.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	bySet := make(map[string][]string)
+	for _, ip := range valid {
+		parsed, _ := validateIP(ip)
+		set := nftSet(parsed)
+		bySet[set] = append(bySet[set], ip)
+	}
+
+	var blocked []string
+	for _, set := range []string{"blocked4", "blocked6"} {
+		members := bySet[set]
+		if len(members) == 0 {
+			continue
+		}
+		if err := n.runFirewallCommand(ctx, "nft", "add", "element", "inet", nftTable, set,
+			"{ "+strings.Join(members, ", ")+" }"); err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to block %d IPs: %v", len(members), err)
+			}
+			continue
+		}
+		blocked = append(blocked, members...)
+	}
+	return blocked, firstErr
+}
+
+func (n *nftBackend) Unblock(ctx context.Context, ip string) error {
+	parsed, err := validateIP(ip)
+	if err != nil {
+		return err
+	}
+	if err := n.ensureTable(ctx); err != nil {
+		return err
+	}
+	return n.runFirewallCommand(ctx, "nft", "delete", "element", "inet", nftTable, nftSet(parsed), "{", ip, "}")
+}
+
+func (n *nftBackend) List(ctx context.Context) (map[string]bool, error) {
+	if err := n.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	ips := make(map[string]bool)
+	for _, set := range []string{"blocked4", "blocked6"} {
+		output, err := n.firewallOutput(ctx, "nft", "list", "set", "inet", nftTable, set)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list nftables set %s: %v", set, err)
+		}
+
+		// Elements are printed as "elements = { 1.2.3.4, 10.0.0.0/24 }", possibly over several lines
+		text := string(output)
+		start := strings.Index(text, "elements = {")
+		if start < 0 {
+			continue
+		}
+		text = text[start+len("elements = {"):]
+		if end := strings.Index(text, "}"); end >= 0 {
+			text = text[:end]
+		}
+		for _, field := range strings.FieldsFunc(text, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n'
+		}) {
+			if addr := canonicalIP(field); addr != "" {
+				ips[addr] = true
+			}
+		}
+	}
+	return ips, nil
+}
+
+// iptablesChain is the chain iptablesBackend adds its DROP rules to
+const iptablesChain = "EDR_BLOCK"
+
+// iptablesBackend adds DROP rules for blocked IPs to a dedicated chain that
+// is jumped to from INPUT and OUTPUT, using ip6tables for IPv6 addresses
+type iptablesBackend struct {
+	firewallTools
+	mu    sync.Mutex
+	ready map[string]bool // Tools whose chain has been set up
+}
+
+// iptablesTool returns the binary that manages rules for an address or the
+// network address of a range
+func iptablesTool(ip net.IP) string {
+	if ip.To4() != nil {
+		return "iptables"
+	}
+	return "ip6tables"
+}
+
+// ensureChain creates the chain and the jumps to it on first use
+func (t *iptablesBackend) ensureChain(ctx context.Context, tool string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.ready[tool] {
+		return nil
+	}
+
+	// Creating the chain fails if it already exists, which is fine
+	t.firewallRun(ctx, tool, "-N", iptablesChain)
+	for _, hook := range []string{"INPUT", "OUTPUT"} {
+		if t.firewallRun(ctx, tool, "-C", hook, "-j", iptablesChain) == nil {
+			continue
+		}
+		if err := t.runFirewallCommand(ctx, tool, "-I", hook, "-j", iptablesChain); err != nil {
+			return fmt.Errorf("failed to set up %s chain: %v", iptablesChain, err)
+		}
+	}
+
+	if t.ready == nil {
+		t.ready = make(map[string]bool)
+	}
+	t.ready[tool] = true
+	return nil
+}
+
+func (t *iptablesBackend) Block(ctx context.Context, ip string) error {
+	parsed, err := validateIP(ip)
+	if err != nil {
+		return err
+	}
+	tool := iptablesTool(parsed)
+	if err := t.ensureChain(ctx, tool); err != nil {
+		return err
+	}
+
+	for _, match := range []string{"-s", "-d"} {
+		if t.firewallRun(ctx, tool, "-C", iptablesChain, match, ip, "-j", "DROP") == nil {
+			continue
+		}
+		if err := t.runFirewallCommand(ctx, tool, "-A", iptablesChain, match, ip, "-j", "DROP"); err != nil {
+			return fmt.Errorf("failed to block IP %s: %v", ip, err)
+		}
+	}
+	return nil
+}
+
+// BlockMany blocks addresses one at a time; iptables has no multi-address
+// rules without ipset
+func (t *iptablesBackend) BlockMany(ctx context.Context, ips []string) ([]string, error) {
+	var blocked []string
+	var firstErr error
+	for _, ip := range ips {
+		if err := t.Block(ctx, ip); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			continue
+		}
+		blocked = append(blocked, ip)
+	}
+	return blocked, firstErr
+}
+
+func (t *iptablesBackend) Unblock(ctx context.Context, ip string) error {
+	parsed, err := validateIP(ip)
+	if err != nil {
+		return err
+	}
+	tool := iptablesTool(parsed)
+
+	// Delete both directions, even if one of them is already gone
+	var failures []string
+	for _, match := range []string{"-s", "-d"} {
+		if err := t.runFirewallCommand(ctx, tool, "-D", iptablesChain, match, ip, "-j", "DROP"); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) == 2 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	for _, failure := range failures {
+		log.Printf("WARNING: Failed to delete firewall rule: %s", failure)
+	}
+	return nil
+}
+
+func (t *iptablesBackend) List(ctx context.Context) (map[string]bool, error) {
+	ips := make(map[string]bool)
+	for _, tool := range []string{"iptables", "ip6tables"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			continue
+		}
+		if err := t.ensureChain(ctx, tool); err != nil {
+			return nil, err
+		}
+
+		output, err := t.firewallOutput(ctx, tool, "-S", iptablesChain)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s chain: %v", iptablesChain, err)
+		}
+
+		// Rules are printed as "-A EDR_BLOCK -s 1.2.3.4/32 -j DROP" or, for a
+		// range, "-A EDR_BLOCK -s 10.0.0.0/24 -j DROP"
+		scanner := bufio.NewScanner(strings.NewReader(string(output)))
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			for i := 0; i+1 < len(fields); i++ {
+				if fields[i] != "-s" && fields[i] != "-d" {
+					continue
+				}
+				if addr := canonicalIP(fields[i+1]); addr != "" {
+					ips[addr] = true
+				}
+			}
+		}
+	}
+	return ips, nil
+}
+
+// unsupportedBackend is used where no firewall tool is available
+type unsupportedBackend struct{}
+
+func (unsupportedBackend) Block(ctx context.Context, ip string) error {
+	return fmt.Errorf("IP blocking is not supported on %s", runtime.GOOS)
+}
+
+func (unsupportedBackend) BlockMany(ctx context.Context, ips []string) ([]string, error) {
+	return nil, fmt.Errorf("IP blocking is not supported on %s", runtime.GOOS)
+}
+
+func (unsupportedBackend) Unblock(ctx context.Context, ip string) error {
+	return fmt.Errorf("IP blocking is not supported on %s", runtime.GOOS)
+}
+
+func (unsupportedBackend) List(ctx context.Context) (map[string]bool, error) {
+	return nil, fmt.Errorf("IP blocking is not supported on %s", runtime.GOOS)
+}
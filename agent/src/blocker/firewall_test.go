@@ -0,0 +1,420 @@
+package blocker
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRunner records the command lines it is asked to run. respond, if set,
+// decides each command's output and error.
+type fakeRunner struct {
+	mu      sync.Mutex
+	calls   [][]string
+	inputs  []string
+	respond func(argv []string) ([]byte, error)
+}
+
+func (f *fakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	argv := append([]string{name}, args...)
+	f.mu.Lock()
+	f.calls = append(f.calls, argv)
+	f.mu.Unlock()
+	if f.respond != nil {
+		return f.respond(argv)
+	}
+	return nil, nil
+}
+
+func (f *fakeRunner) RunInput(ctx context.Context, input string, name string, args ...string) ([]byte, error) {
+	f.mu.Lock()
+	f.inputs = append(f.inputs, input)
+	f.mu.Unlock()
+	return f.Run(ctx, name, args...)
+}
+
+var errFake = errors.New("exit status 1")
+
+// argvs splits each command line on spaces; arguments that contain spaces
+// are written out as slices instead
+func argvs(lines ...string) [][]string {
+	calls := make([][]string, len(lines))
+	for i, line := range lines {
+		calls[i] = strings.Fields(line)
+	}
+	return calls
+}
+
+// assertCalls compares the recorded command lines with want
+func assertCalls(t *testing.T, got, want [][]string) {
+	t.Helper()
+	if reflect.DeepEqual(got, want) {
+		return
+	}
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var g, w string
+		if i < len(got) {
+			g = strings.Join(got[i], "|")
+		}
+		if i < len(want) {
+			w = strings.Join(want[i], "|")
+		}
+		if g != w {
+			t.Errorf("command %d:\n got: %s\nwant: %s", i, g, w)
+		}
+	}
+}
+
+// noRateLimit lifts the firewall command rate limit for the test
+func noRateLimit(t *testing.T) {
+	saved := firewallLimiter
+	firewallLimiter = &commandLimiter{}
+	t.Cleanup(func() { firewallLimiter = saved })
+}
+
+// netshListing is "netsh advfirewall firewall show rule name=all" output
+// with a batch rule holding two addresses and a range
+const netshListing = `
+Rule Name:                            EDR_Block_Batch_3_In
+----------------------------------------------------------------------
+Enabled:                              Yes
+Direction:                            In
+RemoteIP:                             1.2.3.4/32,5.6.7.8/32,10.0.0.0/255.255.255.0
+Action:                               Block
+
+Rule Name:                            EDR_Block_Batch_3_Out
+----------------------------------------------------------------------
+Enabled:                              Yes
+Direction:                            Out
+RemoteIP:                             1.2.3.4/32,5.6.7.8/32,10.0.0.0/255.255.255.0
+Action:                               Block
+`
+
+// netshListingGerman is the same kind of listing from a German Windows, with
+// a single block and another program's rule after the batch
+const netshListingGerman = `
+Regelname:                            EDR_Block_Batch_7_In
+----------------------------------------------------------------------
+Aktiviert:                            Ja
+Richtung:                             Eingehend
+Lokale IP:                            Beliebig
+Remote-IP:                            1.2.3.4/32,2001:db8::/32
+Aktion:                               Blockieren
+
+Regelname:                            EDR_Block_9.9.9.9_In
+----------------------------------------------------------------------
+Aktiviert:                            Ja
+Remote-IP:                            9.9.9.9/32
+
+Regelname:                            Andere Regel
+----------------------------------------------------------------------
+Aktiviert:                            Ja
+Remote-IP:                            8.8.8.8/32
+`
+
+func TestNetshBackend(t *testing.T) {
+	tests := []struct {
+		name    string
+		listing string
+		run     func(ctx context.Context, b *netshBackend) error
+		want    [][]string
+	}{
+		{
+			name: "block address",
+			run:  func(ctx context.Context, b *netshBackend) error { return b.Block(ctx, "1.2.3.4") },
+			want: argvs(
+				"netsh advfirewall firewall add rule name=EDR_Block_1.2.3.4_Out dir=out action=block remoteip=1.2.3.4",
+				"netsh advfirewall firewall add rule name=EDR_Block_1.2.3.4_In dir=in action=block remoteip=1.2.3.4",
+			),
+		},
+		{
+			name: "block range",
+			run:  func(ctx context.Context, b *netshBackend) error { return b.Block(ctx, "2001:db8::/32") },
+			want: argvs(
+				"netsh advfirewall firewall add rule name=EDR_Block_2001:db8::_32_Out dir=out action=block remoteip=2001:db8::/32",
+				"netsh advfirewall firewall add rule name=EDR_Block_2001:db8::_32_In dir=in action=block remoteip=2001:db8::/32",
+			),
+		},
+		{
+			name: "block many",
+			run: func(ctx context.Context, b *netshBackend) error {
+				_, err := b.BlockMany(ctx, []string{"1.2.3.4", "10.0.0.0/24"})
+				return err
+			},
+			want: argvs(
+				"netsh advfirewall firewall show rule name=all",
+				"netsh advfirewall firewall add rule name=EDR_Block_Batch_1_Out dir=out action=block remoteip=1.2.3.4,10.0.0.0/24",
+				"netsh advfirewall firewall add rule name=EDR_Block_Batch_1_In dir=in action=block remoteip=1.2.3.4,10.0.0.0/24",
+			),
+		},
+		{
+			name:    "block many after existing batch",
+			listing: netshListing,
+			run: func(ctx context.Context, b *netshBackend) error {
+				_, err := b.BlockMany(ctx, []string{"9.9.9.9"})
+				return err
+			},
+			want: argvs(
+				"netsh advfirewall firewall show rule name=all",
+				"netsh advfirewall firewall add rule name=EDR_Block_Batch_4_Out dir=out action=block remoteip=9.9.9.9",
+				"netsh advfirewall firewall add rule name=EDR_Block_Batch_4_In dir=in action=block remoteip=9.9.9.9",
+			),
+		},
+		{
+			name: "unblock address",
+			run:  func(ctx context.Context, b *netshBackend) error { return b.Unblock(ctx, "1.2.3.4") },
+			want: argvs(
+				"netsh advfirewall firewall show rule name=all",
+				"netsh advfirewall firewall delete rule name=EDR_Block_1.2.3.4_In",
+				"netsh advfirewall firewall delete rule name=EDR_Block_1.2.3.4_Out",
+			),
+		},
+		{
+			name:    "unblock batch member",
+			listing: netshListing,
+			run:     func(ctx context.Context, b *netshBackend) error { return b.Unblock(ctx, "5.6.7.8") },
+			want: argvs(
+				"netsh advfirewall firewall show rule name=all",
+				"netsh advfirewall firewall set rule name=EDR_Block_Batch_3_In new remoteip=1.2.3.4,10.0.0.0/24",
+				"netsh advfirewall firewall set rule name=EDR_Block_Batch_3_Out new remoteip=1.2.3.4,10.0.0.0/24",
+			),
+		},
+		{
+			name:    "unblock batch member from localized listing",
+			listing: netshListingGerman,
+			run:     func(ctx context.Context, b *netshBackend) error { return b.Unblock(ctx, "2001:db8::/32") },
+			want: argvs(
+				"netsh advfirewall firewall show rule name=all",
+				"netsh advfirewall firewall set rule name=EDR_Block_Batch_7_In new remoteip=1.2.3.4",
+				"netsh advfirewall firewall set rule name=EDR_Block_Batch_7_Out new remoteip=1.2.3.4",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noRateLimit(t)
+			runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+				if len(argv) > 3 && argv[3] == "show" {
+					return []byte(tt.listing), nil
+				}
+				return nil, nil
+			}}
+			b := &netshBackend{firewallTools: firewallTools{runner: runner}}
+			if err := tt.run(context.Background(), b); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertCalls(t, runner.calls, tt.want)
+		})
+	}
+}
+
+func TestNetshListLocalized(t *testing.T) {
+	tests := []struct {
+		name        string
+		listing     string
+		wantIPs     map[string]bool
+		wantBatches map[string][]string
+	}{
+		{
+			name:        "english",
+			listing:     netshListing,
+			wantIPs:     map[string]bool{"1.2.3.4": true, "5.6.7.8": true, "10.0.0.0/24": true},
+			wantBatches: map[string][]string{"EDR_Block_Batch_3": {"1.2.3.4", "5.6.7.8", "10.0.0.0/24"}},
+		},
+		{
+			name:        "german",
+			listing:     netshListingGerman,
+			wantIPs:     map[string]bool{"1.2.3.4": true, "2001:db8::/32": true, "9.9.9.9": true},
+			wantBatches: map[string][]string{"EDR_Block_Batch_7": {"1.2.3.4", "2001:db8::/32"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noRateLimit(t)
+			runner := &fakeRunner{respond: func([]string) ([]byte, error) { return []byte(tt.listing), nil }}
+			b := &netshBackend{firewallTools: firewallTools{runner: runner}}
+			ips, err := b.List(context.Background())
+			if err != nil {
+				t.Fatalf("List: %v", err)
+			}
+			if !reflect.DeepEqual(ips, tt.wantIPs) {
+				t.Errorf("List = %v, want %v", ips, tt.wantIPs)
+			}
+			if !reflect.DeepEqual(b.batches, tt.wantBatches) {
+				t.Errorf("batches = %v, want %v", b.batches, tt.wantBatches)
+			}
+		})
+	}
+}
+
+func TestNftBackend(t *testing.T) {
+	// The table exists and its sets support ranges
+	ready := argvs(
+		"nft list table inet edr_agent",
+		"nft list set inet edr_agent blocked4",
+	)
+
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, b *nftBackend) error
+		want [][]string
+	}{
+		{
+			name: "block IPv4",
+			run:  func(ctx context.Context, b *nftBackend) error { return b.Block(ctx, "1.2.3.4") },
+			want: append(ready, argvs("nft add element inet edr_agent blocked4 { 1.2.3.4 }")...),
+		},
+		{
+			name: "block IPv6 range",
+			run:  func(ctx context.Context, b *nftBackend) error { return b.Block(ctx, "2001:db8::/32") },
+			want: append(ready, argvs("nft add element inet edr_agent blocked6 { 2001:db8::/32 }")...),
+		},
+		{
+			name: "block many",
+			run: func(ctx context.Context, b *nftBackend) error {
+				_, err := b.BlockMany(ctx, []string{"1.2.3.4", "2001:db8::1", "10.0.0.0/24"})
+				return err
+			},
+			want: append(ready,
+				[]string{"nft", "add", "element", "inet", "edr_agent", "blocked4", "{ 1.2.3.4, 10.0.0.0/24 }"},
+				[]string{"nft", "add", "element", "inet", "edr_agent", "blocked6", "{ 2001:db8::1 }"},
+			),
+		},
+		{
+			name: "unblock",
+			run:  func(ctx context.Context, b *nftBackend) error { return b.Unblock(ctx, "1.2.3.4") },
+			want: append(ready, argvs("nft delete element inet edr_agent blocked4 { 1.2.3.4 }")...),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noRateLimit(t)
+			runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+				if argv[1] == "list" && argv[2] == "set" {
+					return []byte("set blocked4 { type ipv4_addr; flags interval; }"), nil
+				}
+				return nil, nil
+			}}
+			b := &nftBackend{firewallTools: firewallTools{runner: runner}}
+			if err := tt.run(context.Background(), b); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertCalls(t, runner.calls, tt.want)
+		})
+	}
+}
+
+func TestNftBackendCreatesTable(t *testing.T) {
+	noRateLimit(t)
+	runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+		if argv[1] == "list" {
+			return nil, errFake
+		}
+		return nil, nil
+	}}
+	b := &nftBackend{firewallTools: firewallTools{runner: runner}}
+	if err := b.Block(context.Background(), "1.2.3.4"); err != nil {
+		t.Fatalf("Block: %v", err)
+	}
+
+	assertCalls(t, runner.calls, argvs(
+		"nft list table inet edr_agent",
+		"nft -f -",
+		"nft add element inet edr_agent blocked4 { 1.2.3.4 }",
+	))
+	if len(runner.inputs) != 1 || !strings.HasPrefix(runner.inputs[0], "table inet edr_agent {") {
+		t.Errorf("nft -f - input = %q, want the edr_agent table", runner.inputs)
+	}
+}
+
+func TestIptablesBackend(t *testing.T) {
+	// Creating the chain and the jumps to it
+	setup := func(tool string) [][]string {
+		return argvs(
+			tool+" -N EDR_BLOCK",
+			tool+" -C INPUT -j EDR_BLOCK",
+			tool+" -I INPUT -j EDR_BLOCK",
+			tool+" -C OUTPUT -j EDR_BLOCK",
+			tool+" -I OUTPUT -j EDR_BLOCK",
+		)
+	}
+
+	tests := []struct {
+		name string
+		run  func(ctx context.Context, b *iptablesBackend) error
+		want [][]string
+	}{
+		{
+			name: "block IPv4",
+			run:  func(ctx context.Context, b *iptablesBackend) error { return b.Block(ctx, "1.2.3.4") },
+			want: append(setup("iptables"), argvs(
+				"iptables -C EDR_BLOCK -s 1.2.3.4 -j DROP",
+				"iptables -A EDR_BLOCK -s 1.2.3.4 -j DROP",
+				"iptables -C EDR_BLOCK -d 1.2.3.4 -j DROP",
+				"iptables -A EDR_BLOCK -d 1.2.3.4 -j DROP",
+			)...),
+		},
+		{
+			name: "block IPv6 range",
+			run:  func(ctx context.Context, b *iptablesBackend) error { return b.Block(ctx, "2001:db8::/32") },
+			want: append(setup("ip6tables"), argvs(
+				"ip6tables -C EDR_BLOCK -s 2001:db8::/32 -j DROP",
+				"ip6tables -A EDR_BLOCK -s 2001:db8::/32 -j DROP",
+				"ip6tables -C EDR_BLOCK -d 2001:db8::/32 -j DROP",
+				"ip6tables -A EDR_BLOCK -d 2001:db8::/32 -j DROP",
+			)...),
+		},
+		{
+			name: "unblock",
+			run:  func(ctx context.Context, b *iptablesBackend) error { return b.Unblock(ctx, "1.2.3.4") },
+			want: argvs(
+				"iptables -D EDR_BLOCK -s 1.2.3.4 -j DROP",
+				"iptables -D EDR_BLOCK -d 1.2.3.4 -j DROP",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			noRateLimit(t)
+			// Nothing exists yet, so every check fails
+			runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+				if argv[1] == "-C" {
+					return nil, errFake
+				}
+				return nil, nil
+			}}
+			b := &iptablesBackend{firewallTools: firewallTools{runner: runner}}
+			if err := tt.run(context.Background(), b); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			assertCalls(t, runner.calls, tt.want)
+		})
+	}
+}
+
+func TestFirewallRejectsInvalidIP(t *testing.T) {
+	noRateLimit(t)
+	runner := &fakeRunner{}
+	backends := map[string]firewallBackend{
+		"netsh":    &netshBackend{firewallTools: firewallTools{runner: runner}},
+		"nft":      &nftBackend{firewallTools: firewallTools{runner: runner}},
+		"iptables": &iptablesBackend{firewallTools: firewallTools{runner: runner}},
+	}
+	for name, b := range backends {
+		for _, ip := range []string{"1.2.3.4; rm -rf /", "not-an-ip", "10.0.0.0/33"} {
+			if err := b.Block(context.Background(), ip); err == nil {
+				t.Errorf("%s: Block(%q) succeeded", name, ip)
+			}
+		}
+	}
+	if len(runner.calls) != 0 {
+		t.Errorf("invalid addresses reached the firewall: %v", runner.calls)
+	}
+}
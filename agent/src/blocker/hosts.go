@@ -0,0 +1,185 @@
+package blocker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"agent/config"
+	"agent/persist"
+)
+
+// hostsBlocker blocks exact domains by redirecting them to
+// blocked_ip_redirect in the hosts file
+type hostsBlocker struct {
+	config *config.Config
+}
+
+func (h *hostsBlocker) Name() string {
+	return "hosts file"
+}
+
+// hostsEntryTag ends the hosts file lines the agent manages; lines without
+// it belong to the user and are left as they are
+const hostsEntryTag = "# EDR"
+
+// hostsEntry returns the hosts file line that blocks domain
+func (h *hostsBlocker) hostsEntry(domain string) string {
+	return fmt.Sprintf("%s %s %s", h.config.Current().BlockedIPRedirect, domain, hostsEntryTag)
+}
+
+// managedHostsDomain returns the domain of an EDR hosts file entry and
+// whether line is one. Tagged entries count whatever address they redirect
+// to, so entries survive a change of blocked_ip_redirect. Untagged
+// "<redirect> <domain>" lines, as written by older agents, are taken over
+// when their domain is in adopt.
+func (h *hostsBlocker) managedHostsDomain(line string, adopt map[string]bool) (string, bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 4 && fields[2]+" "+fields[3] == hostsEntryTag {
+		return fields[1], true
+	}
+	if len(fields) == 2 && fields[0] == h.config.Current().BlockedIPRedirect && adopt[fields[1]] {
+		return fields[1], true
+	}
+	return "", false
+}
+
+// updateHostsFile passes the domains of the EDR entries in the hosts file to
+// edit, then rewrites the file through a temporary file with the user's
+// lines unchanged followed by one entry per domain edit returns. Duplicate
+// entries are merged. The file is not written if nothing changed.
+func (h *hostsBlocker) updateHostsFile(adopt map[string]bool, edit func(domains []string) []string) error {
+	hostsPath := h.config.HostsFilePath
+	
+	content, err := os.ReadFile(hostsPath)
+	if err != nil {
+		return fmt.Errorf("failed to read hosts file: %v", err)
+	}
+	
+	// Windows hosts files usually have CRLF line endings; keep them
+	newline := "\n"
+	if strings.Contains(string(content), "\r\n") {
+		newline = "\r\n"
+	}
+	
+	var out strings.Builder
+	var domains []string
+	seen := make(map[string]bool)
+	if trimmed := strings.TrimRight(string(content), "\r\n"); trimmed != "" {
+		for _, line := range strings.Split(trimmed, "\n") {
+			line = strings.TrimSuffix(line, "\r")
+			if domain, ok := h.managedHostsDomain(line, adopt); ok {
+				if !seen[domain] {
+					seen[domain] = true
+					domains = append(domains, domain)
+				}
+				continue
+			}
+			out.WriteString(line + newline)
+		}
+	}
+	
+	written := make(map[string]bool)
+	for _, domain := range edit(domains) {
+		if !written[domain] {
+			written[domain] = true
+			out.WriteString(h.hostsEntry(domain) + newline)
+		}
+	}
+	
+	if out.String() == string(content) {
+		return nil
+	}
+	if err := persist.WriteFileAtomic(hostsPath, []byte(out.String()), 0644); err != nil {
+		return fmt.Errorf("failed to rewrite hosts file: %v", err)
+	}
+	return nil
+}
+
+// Add adds a domain to the hosts file, pointing to the configured redirect IP
+// Returns true if domain was added, false if it was already there
+func (h *hostsBlocker) Add(ctx context.Context, domain string) (bool, error) {
+	added := false
+	err := h.updateHostsFile(map[string]bool{domain: true}, func(domains []string) []string {
+		for _, d := range domains {
+			if d == domain {
+				return domains
+			}
+		}
+		added = true
+		return append(domains, domain)
+	})
+	if err != nil {
+		return false, err
+	}
+	return added, nil
+}
+
+// Remove removes the block entry for a domain from the hosts file
+// Returns true if an entry was removed, false if none was found
+func (h *hostsBlocker) Remove(ctx context.Context, domain string) (bool, error) {
+	removed := false
+	err := h.updateHostsFile(map[string]bool{domain: true}, func(domains []string) []string {
+		kept := make([]string, 0, len(domains))
+		for _, d := range domains {
+			if d == domain {
+				removed = true
+				continue
+			}
+			kept = append(kept, d)
+		}
+		return kept
+	})
+	if err != nil {
+		return false, err
+	}
+	return removed, nil
+}
+
+// Cleanup removes the EDR hosts file entries for domains not in needed and
+// merges duplicate entries
+func (h *hostsBlocker) Cleanup(ctx context.Context, needed map[string]bool) (int, error) {
+	removed := 0
+	err := h.updateHostsFile(nil, func(domains []string) []string {
+		kept := make([]string, 0, len(domains))
+		for _, domain := range domains {
+			if !needed[domain] {
+				log.Printf("Removing orphaned hosts file entry for %s", domain)
+				removed++
+				continue
+			}
+			kept = append(kept, domain)
+		}
+		return kept
+	})
+	if err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
+
+// List returns the domains the hosts file currently redirects to
+// blocked_ip_redirect
+func (h *hostsBlocker) List(ctx context.Context) (map[string]bool, error) {
+	content, err := os.ReadFile(h.config.HostsFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hosts file: %v", err)
+	}
+	
+	domains := make(map[string]bool)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != h.config.Current().BlockedIPRedirect {
+			continue
+		}
+		for _, domain := range fields[1:] {
+			if strings.HasPrefix(domain, "#") {
+				break
+			}
+			domains[domain] = true
+		}
+	}
+	return domains, nil
+}
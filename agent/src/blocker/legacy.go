@@ -0,0 +1,85 @@
+package blocker
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"agent/persist"
+)
+
+// ImportScannerBlocks moves the blocks recorded in dir/blocked_items.json,
+// where earlier versions kept the scanner's own block list, into this
+// Blocker as scanner blocks and removes the old file. The firewall rules and
+// hosts entries behind them already exist, so only the records move. A file
+// that fails its checksum is ignored; its blocks are created again by the
+// next scan.
+func (b *Blocker) ImportScannerBlocks(dir string) {
+	filePath := filepath.Join(dir, "blocked_items.json")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return
+	}
+	
+	var legacy BlockedItems
+	if err != nil {
+		log.Printf("Failed to read scanner block list %s: %v", filePath, err)
+	} else if _, err := b.integrity.VerifyChecksum(filePath, data); err != nil {
+		log.Printf("WARNING: Not importing scanner block list %s: %v", filePath, err)
+	} else if err := json.Unmarshal(data, &legacy); err != nil {
+		log.Printf("WARNING: Not importing corrupt scanner block list %s: %v", filePath, err)
+	} else if err := b.verifyItems(&legacy); err != nil {
+		log.Printf("WARNING: Not importing scanner block list %s: %v", filePath, err)
+	} else {
+		b.importScannerItems(&legacy)
+		b.Flush()
+	}
+	
+	for _, path := range []string{filePath, persist.ChecksumPath(filePath), persist.BackupPath(filePath), persist.ChecksumPath(persist.BackupPath(filePath))} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove %s: %v", path, err)
+		}
+	}
+}
+
+// importScannerItems adds the blocks in items that are not recorded yet as
+// scanner blocks
+func (b *Blocker) importScannerItems(items *BlockedItems) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	
+	now := time.Now()
+	imported := 0
+	for ip := range items.BlockedIPs {
+		if b.blockedIPs[ip] {
+			continue
+		}
+		b.blockedIPs[ip] = true
+		b.scannerIPs[ip] = true
+		b.ipBlockedAt[ip] = blockedAt(items.IPBlockedAt, ip, now)
+		imported++
+	}
+	for url := range items.BlockedURLs {
+		if b.blockedURLs[url] {
+			continue
+		}
+		b.blockedURLs[url] = true
+		b.scannerURLs[url] = true
+		b.urlBlockedAt[url] = blockedAt(items.URLBlockedAt, url, now)
+		imported++
+	}
+	if imported > 0 {
+		log.Printf("Imported %d blocks from the scanner's block list", imported)
+		b.scheduleSaveLocked()
+	}
+}
+
+// blockedAt returns when item was blocked according to times, or now
+func blockedAt(times map[string]time.Time, item string, now time.Time) time.Time {
+	if at, ok := times[item]; ok {
+		return at
+	}
+	return now
+}
@@ -0,0 +1,130 @@
+package blocker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"agent/config"
+)
+
+// nrptComment marks the Name Resolution Policy Table rules the agent manages
+const nrptComment = "EDR"
+
+// nrptBlocker sinkholes a domain and all of its subdomains with a Windows
+// Name Resolution Policy Table (NRPT) rule that sends their DNS queries to
+// blocked_ip_redirect, where no DNS server answers. Unlike hosts file
+// entries the rule covers names the agent has never seen.
+type nrptBlocker struct {
+	config *config.Config
+	runner commandRunner
+}
+
+func (n *nrptBlocker) Name() string {
+	return "DNS policy"
+}
+
+// validDNSName rejects domains that are not plain DNS names before they are
+// put in a PowerShell command line
+func validDNSName(domain string) error {
+	if domain == "" || len(domain) > 253 || strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
+		return fmt.Errorf("invalid domain name %q", domain)
+	}
+	for _, c := range domain {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return fmt.Errorf("invalid domain name %q", domain)
+		}
+	}
+	return nil
+}
+
+// powershell runs a PowerShell script and includes its output in errors
+func (n *nrptBlocker) powershell(ctx context.Context, script string) ([]byte, error) {
+	output, err := n.runner.Run(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+	if err != nil {
+		return output, fmt.Errorf("powershell: %v, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return output, nil
+}
+
+// Add creates an NRPT rule for domain and its subdomains and flushes the DNS
+// client cache so names already resolved stop working
+func (n *nrptBlocker) Add(ctx context.Context, domain string) (bool, error) {
+	if err := validDNSName(domain); err != nil {
+		return false, err
+	}
+	domains, err := n.List(ctx)
+	if err != nil {
+		return false, err
+	}
+	if domains[domain] {
+		return false, nil
+	}
+
+	script := fmt.Sprintf("Add-DnsClientNrptRule -Namespace '%s','.%s' -NameServers '%s' -Comment '%s'; Clear-DnsClientCache",
+		domain, domain, n.config.Current().BlockedIPRedirect, nrptComment)
+	if _, err := n.powershell(ctx, script); err != nil {
+		return false, fmt.Errorf("failed to add DNS policy rule for %s: %v", domain, err)
+	}
+	return true, nil
+}
+
+// Remove deletes the NRPT rules for domain
+func (n *nrptBlocker) Remove(ctx context.Context, domain string) (bool, error) {
+	if err := validDNSName(domain); err != nil {
+		return false, err
+	}
+	domains, err := n.List(ctx)
+	if err != nil {
+		return false, err
+	}
+	if !domains[domain] {
+		return false, nil
+	}
+
+	script := fmt.Sprintf("Get-DnsClientNrptRule | Where-Object { $_.Comment -eq '%s' -and $_.Namespace -contains '%s' } | "+
+		"ForEach-Object { Remove-DnsClientNrptRule -Name $_.Name -Force }; Clear-DnsClientCache", nrptComment, domain)
+	if _, err := n.powershell(ctx, script); err != nil {
+		return false, fmt.Errorf("failed to remove DNS policy rule for %s: %v", domain, err)
+	}
+	return true, nil
+}
+
+// List returns the domains of the agent's NRPT rules
+func (n *nrptBlocker) List(ctx context.Context) (map[string]bool, error) {
+	script := fmt.Sprintf("Get-DnsClientNrptRule | Where-Object { $_.Comment -eq '%s' } | ForEach-Object { $_.Namespace }", nrptComment)
+	output, err := n.powershell(ctx, script)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list DNS policy rules: %v", err)
+	}
+
+	domains := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		if namespace := strings.TrimSpace(line); namespace != "" && !strings.HasPrefix(namespace, ".") {
+			domains[namespace] = true
+		}
+	}
+	return domains, nil
+}
+
+// Cleanup removes the agent's NRPT rules for domains not in needed
+func (n *nrptBlocker) Cleanup(ctx context.Context, needed map[string]bool) (int, error) {
+	domains, err := n.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for domain := range domains {
+		if needed[domain] {
+			continue
+		}
+		log.Printf("Removing orphaned DNS policy rule for %s", domain)
+		if _, err := n.Remove(ctx, domain); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
@@ -0,0 +1,31 @@
+package blocker
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+)
+
+// commandRunner starts external tools. The firewall backends only shell out
+// through it, so the exact command lines they produce can be checked against
+// a fake.
+type commandRunner interface {
+	// Run runs name with args and returns its combined output
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+	// RunInput is Run with input fed to the tool's standard input
+	RunInput(ctx context.Context, input string, name string, args ...string) ([]byte, error)
+}
+
+// execRunner is the commandRunner used in production; tools are killed when
+// ctx is cancelled
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
+
+func (execRunner) RunInput(ctx context.Context, input string, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = strings.NewReader(input)
+	return cmd.CombinedOutput()
+}
@@ -0,0 +1,69 @@
+package blocker
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrSuppressed is returned when the scanner tries to block an IP or URL
+// that an UNBLOCK_IP or UNBLOCK_URL command unblocked
+var ErrSuppressed = errors.New("unblocked by an UNBLOCK command, not blocking it again")
+
+// suppressLocked updates the suppression list after item was unblocked or
+// blocked. An UNBLOCK command adds item, so the scanner does not block the
+// false positive again on its next pass; a BLOCK command removes it. Blocks
+// the scanner creates or expires leave the list alone. Caller must hold b.mu.
+func (b *Blocker) suppressLocked(suppressed map[string]time.Time, item string, source Source, unblocked bool) {
+	if source != SourceCommand {
+		return
+	}
+	if unblocked {
+		suppressed[item] = time.Now()
+	} else if _, ok := suppressed[item]; ok {
+		delete(suppressed, item)
+	} else {
+		return
+	}
+	b.scheduleSaveLocked()
+}
+
+// IsIPSuppressed reports whether an IP was unblocked by an UNBLOCK_IP
+// command and must not be blocked by the scanner
+func (b *Blocker) IsIPSuppressed(ip string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.suppressedIPs[ipKey(ip)]
+	return ok
+}
+
+// IsURLSuppressed reports whether a URL was unblocked by an UNBLOCK_URL
+// command and must not be blocked by the scanner
+func (b *Blocker) IsURLSuppressed(url string) bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	_, ok := b.suppressedURLs[url]
+	return ok
+}
+
+// GetSuppressedIPs returns the IPs unblocked by UNBLOCK_IP commands
+func (b *Blocker) GetSuppressedIPs() map[string]bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return suppressedSet(b.suppressedIPs)
+}
+
+// GetSuppressedURLs returns the URLs unblocked by UNBLOCK_URL commands
+func (b *Blocker) GetSuppressedURLs() map[string]bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return suppressedSet(b.suppressedURLs)
+}
+
+// suppressedSet copies the keys of a suppression list
+func suppressedSet(suppressed map[string]time.Time) map[string]bool {
+	set := make(map[string]bool, len(suppressed))
+	for item := range suppressed {
+		set[item] = true
+	}
+	return set
+}
@@ -0,0 +1,52 @@
+package blocker
+
+import (
+	"context"
+	"log"
+	"runtime"
+
+	"agent/config"
+)
+
+// urlBlocker makes the domains of blocked URLs unresolvable. Add and Remove
+// report whether the domain's block was created or removed, false if it
+// already was or was not there; List returns the domains blocked right now;
+// Cleanup removes the blocks for domains not in needed and returns how many
+// it removed. Name describes where the blocks live, for log and report
+// messages.
+type urlBlocker interface {
+	Name() string
+	Add(ctx context.Context, domain string) (bool, error)
+	Remove(ctx context.Context, domain string) (bool, error)
+	List(ctx context.Context) (map[string]bool, error)
+	Cleanup(ctx context.Context, needed map[string]bool) (int, error)
+}
+
+// newURLBlocker picks the URL blocking implementation for url_block_method.
+// The dns method is only available on Windows; elsewhere the hosts file is
+// used instead.
+func newURLBlocker(cfg *config.Config, runner commandRunner) urlBlocker {
+	if cfg.URLBlockMethod == config.URLBlockDNS {
+		if runtime.GOOS == "windows" {
+			log.Printf("Using DNS policy (NRPT) for URL blocking")
+			return &nrptBlocker{config: cfg, runner: runner}
+		}
+		log.Printf("WARNING: url_block_method dns is only supported on Windows, using the hosts file")
+	}
+	return &hostsBlocker{config: cfg}
+}
+
+// otherURLBlocker returns the implementation url_block_method does not use,
+// whose blocks are left over from before the method was changed, or nil if
+// there is none on this OS
+func otherURLBlocker(current urlBlocker, cfg *config.Config, runner commandRunner) urlBlocker {
+	switch current.(type) {
+	case *nrptBlocker:
+		return &hostsBlocker{config: cfg}
+	default:
+		if runtime.GOOS == "windows" {
+			return &nrptBlocker{config: cfg, runner: runner}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,60 @@
+package blocker
+
+import (
+	"fmt"
+	"strings"
+
+	"agent/persist"
+)
+
+// maxReportedProblems caps how many bad entries a verification error lists
+const maxReportedProblems = 5
+
+// Verify checks the structure of the block list: the maps exist, blocked IPs
+// are addresses or CIDR ranges and blocked URLs have a domain. It returns an
+// error wrapping persist.ErrCorrupt describing the first problems found.
+func (b *Blocker) Verify() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.verifyItems(&BlockedItems{
+		BlockedIPs:  b.blockedIPs,
+		BlockedURLs: b.blockedURLs,
+	})
+}
+
+// Corrupt returns the error that made the block list start empty because
+// neither blocked_items.json nor its backup could be loaded, or nil
+func (b *Blocker) Corrupt() error {
+	return b.corrupt
+}
+
+// verifyItems checks a blocked_items.json read from disk before it is loaded
+func (b *Blocker) verifyItems(items *BlockedItems) error {
+	var problems []string
+	if items.BlockedIPs == nil {
+		problems = append(problems, "blocked_ips missing")
+	}
+	if items.BlockedURLs == nil {
+		problems = append(problems, "blocked_urls missing")
+	}
+	for ip := range items.BlockedIPs {
+		if _, err := validateIP(ip); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	for url := range items.BlockedURLs {
+		if b.extractDomain(url) == "" {
+			problems = append(problems, fmt.Sprintf("invalid URL: %s", url))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	more := ""
+	if len(problems) > maxReportedProblems {
+		more = fmt.Sprintf(" and %d more", len(problems)-maxReportedProblems)
+		problems = problems[:maxReportedProblems]
+	}
+	return fmt.Errorf("%w: %s%s", persist.ErrCorrupt, strings.Join(problems, "; "), more)
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReconnectBackoffBounds(t *testing.T) {
+	base := time.Second
+	maxDelay := time.Minute
+	tests := []struct {
+		name     string
+		failures int
+		limit    time.Duration
+	}{
+		{"first failure", 1, 2 * time.Second},
+		{"third failure", 3, 8 * time.Second},
+		{"capped", 6, time.Minute},
+		{"capped far beyond", 29, time.Minute},
+		{"shift overflow", 64, time.Minute},
+		{"huge count", 1 << 20, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const samples = 20000
+			var sum time.Duration
+			for i := 0; i < samples; i++ {
+				d := reconnectBackoff(base, maxDelay, tt.failures)
+				if d < 0 || d >= tt.limit {
+					t.Fatalf("reconnectBackoff(%d) = %v, want in [0, %v)", tt.failures, d, tt.limit)
+				}
+				sum += d
+			}
+
+			// Full jitter is uniform over [0, limit), so the mean is about half the limit
+			mean := sum / samples
+			if mean < tt.limit*45/100 || mean > tt.limit*55/100 {
+				t.Errorf("mean delay %v, want about %v", mean, tt.limit/2)
+			}
+		})
+	}
+}
+
+func TestReconnectBackoffZero(t *testing.T) {
+	if d := reconnectBackoff(0, time.Minute, 3); d != 0 {
+		t.Errorf("zero base: got %v, want 0", d)
+	}
+	if d := reconnectBackoff(time.Second, 0, 3); d != 0 {
+		t.Errorf("zero max: got %v, want 0", d)
+	}
+}
+
+func TestStreamRetry(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var r streamRetry
+
+	// Failures to open the stream keep counting
+	if n := r.failed(start); n != 1 {
+		t.Fatalf("first failure = %d, want 1", n)
+	}
+	if n := r.failed(start); n != 2 {
+		t.Fatalf("second failure = %d, want 2", n)
+	}
+
+	// A stream that drops right after it opened is not healthy
+	r.connected(start)
+	if n := r.failed(start.Add(time.Second)); n != 3 {
+		t.Fatalf("drop after 1s = %d, want 3", n)
+	}
+
+	// A stream that stayed up resets the count
+	r.connected(start)
+	if n := r.failed(start.Add(healthyStreamDuration)); n != 1 {
+		t.Fatalf("drop after healthy stream = %d, want 1", n)
+	}
+
+	// The reset only applies to the stream that was healthy
+	if n := r.failed(start.Add(2 * healthyStreamDuration)); n != 2 {
+		t.Fatalf("failure after reset = %d, want 2", n)
+	}
+}
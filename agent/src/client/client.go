@@ -2,28 +2,39 @@ package client
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"math/rand"
 	"os"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/keepalive"
+	"crypto/ed25519"
 	"crypto/tls"
 	"crypto/x509"
 
 	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
 	"github.com/shirou/gopsutil/v3/mem"
 	"github.com/shirou/gopsutil/v3/host"
+	psnet "github.com/shirou/gopsutil/v3/net"
 
 	pb "agent/proto"
 	"agent/config"
+	"agent/ioc"
 	"agent/logging"
+	"agent/persist"
+	"agent/privilege"
 )
 
 // Initialize random number generator on package import
@@ -31,6 +42,55 @@ func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
+// reconnectBackoff returns how long to wait before reconnect attempt number
+// failures. It grows as base * 2^failures up to maxDelay and applies full
+// jitter so agents disconnected by the same outage do not reconnect in lockstep.
+func reconnectBackoff(base, maxDelay time.Duration, failures int) time.Duration {
+	if base <= 0 || maxDelay <= 0 {
+		return 0
+	}
+	
+	delay := maxDelay
+	// Beyond 30 doublings the shift would overflow; the cap applies anyway
+	if failures < 30 {
+		if computed := base << uint(failures); computed > 0 && computed < maxDelay {
+			delay = computed
+		}
+	}
+	
+	return time.Duration(rand.Float64() * float64(delay))
+}
+
+// healthyStreamDuration is how long a command stream must stay up before its
+// drop no longer counts towards the reconnect backoff
+const healthyStreamDuration = time.Minute
+
+// streamRetry counts consecutive command stream failures for
+// reconnectBackoff. Failing to open the stream, failing to send HELLO and a
+// stream that drops soon after it opened all count, so a server that accepts
+// streams and drops them at once is not hammered.
+type streamRetry struct {
+	failures    int
+	connectedAt time.Time // When the current stream was established, zero if none
+}
+
+// connected records that a stream was established at now
+func (r *streamRetry) connected(now time.Time) {
+	r.connectedAt = now
+}
+
+// failed records a failure at now and returns the number of consecutive
+// failures to back off for. A stream that was healthy for
+// healthyStreamDuration resets the count first.
+func (r *streamRetry) failed(now time.Time) int {
+	if !r.connectedAt.IsZero() && now.Sub(r.connectedAt) >= healthyStreamDuration {
+		r.failures = 0
+	}
+	r.connectedAt = time.Time{}
+	r.failures++
+	return r.failures
+}
+
 // Channel for sending status updates through the main stream
 type statusUpdate struct {
 	status  string
@@ -46,11 +106,34 @@ type EDRClient struct {
 	cmdHandler      *CommandHandler
 	agentVersion    string
 	dataDir         string
+	keys            *persist.KeyStore  // Secret keys, kept outside dataDir
+	integrity       *persist.Integrity // Signs the checksum files in dataDir
 	useTLS          bool
 	config          *config.Config
 	statusChan      chan statusUpdate // Channel for sending status updates
+	eventChan       chan *pb.AgentEvent // Channel for sending agent events
+	metricsIntervalChan chan struct{}   // Signals that the metrics interval changed
+	heartbeatIntervalChan chan struct{} // Signals that the heartbeat interval changed
+	updating        atomic.Bool         // A self-update was installed and the service is being restarted
+	selfUpdateOnce  sync.Once           // Completes a self-update on the first HELLO acknowledgement
+	reloadChan      chan struct{}       // Signals that the configuration file was updated by the server
+	commandLimit    *commandLimiter     // Bounds concurrently executing server commands
+	stateMu         sync.Mutex
+	state           ConnectionState // State of the command stream
+	stateCause      string          // Why the stream entered state
+	stateSince      time.Time       // When the stream entered state
+	connMetrics     *connectionMetrics // Stream uptime, reconnects and heartbeat latency
+	clockSkew       *clockSkew         // How far the agent clock is from the server's
+	ioMu            sync.Mutex
+	lastIOSample    *ioSample // Previous I/O counters, used to compute deltas
+	resyncMu        sync.Mutex
+	lastResync      time.Time // When a full IOC resync was last requested
 }
 
+// fullResyncDebounce is the minimum time between full IOC resync requests, so
+// a burst of out-of-order deltas asks the server for one snapshot, not many
+const fullResyncDebounce = 30 * time.Second
+
 // NewEDRClient creates a new EDR client (legacy function)
 func NewEDRClient(serverAddress, agentID string, dataDir string) (*EDRClient, error) {
 	return NewEDRClientWithTLS(serverAddress, agentID, dataDir, false)
@@ -70,16 +153,86 @@ func NewEDRClientWithTLS(serverAddress, agentID string, dataDir string, useTLS b
 
 // NewEDRClientWithConfig creates a new EDR client using a configuration object
 func NewEDRClientWithConfig(cfg *config.Config) (*EDRClient, error) {
+	return NewEDRClientWithContext(context.Background(), cfg)
+}
+
+// NewEDRClientWithContext creates a new EDR client using a configuration
+// object. With dial_blocking the first connection is waited for, and
+// cancelling ctx abandons the wait.
+func NewEDRClientWithContext(ctx context.Context, cfg *config.Config) (*EDRClient, error) {
 	var conn *grpc.ClientConn
 	var err error
 
+	// Checksums of persisted state are keyed with a key outside the data
+	// directory, so rewriting a file and its checksum together is detected
+	keys := persist.DefaultKeyStore()
+	integrity, err := persist.NewIntegrity(keys)
+	if err != nil {
+		return nil, err
+	}
+
+	// IOC updates decide what the agent blocks and deletes, so they must be
+	// signed by the server's key when one is configured
+	var iocSigningKey ed25519.PublicKey
+	if cfg.IOCSigningPubkeyPath != "" {
+		iocSigningKey, err = ioc.LoadSigningKey(cfg.IOCSigningPubkeyPath)
+		if err != nil {
+			return nil, err
+		}
+		logging.Info().
+			Str("key", cfg.IOCSigningPubkeyPath).
+			Msg("IOC updates must be signed by the configured key")
+	} else {
+		logging.Warn().
+			Msg("SECURITY WARNING: ioc_signing_pubkey_path is not set, IOC updates are applied without signature verification. Anyone able to reach the command stream can change what this agent blocks and deletes")
+	}
+
+	// Keepalive pings let a dead server be noticed without waiting for TCP timeouts
+	keepaliveOpt := grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:                cfg.GetKeepaliveTimeDuration(),
+		Timeout:             cfg.GetKeepaliveTimeoutDuration(),
+		PermitWithoutStream: true,
+	})
+	dialOpts := []grpc.DialOption{keepaliveOpt, grpc.WithStatsHandler(iocSizeLogger{})}
+	
+	// IOC updates can be large, so compress the agent's messages when enabled.
+	// The gzip import registers the codec, so the agent always accepts
+	// compressed messages from the server either way.
+	if cfg.GRPCCompression {
+		dialOpts = append(dialOpts, grpc.WithDefaultCallOptions(grpc.UseCompressor(gzip.Name)))
+		logging.Info().Str("compressor", gzip.Name).Msg("gRPC message compression enabled")
+	}
+
+	// Tunnel through proxy_url when set, otherwise gRPC dials directly
+	dialer, err := proxyDialer(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if dialer != nil {
+		dialOpts = append(dialOpts, grpc.WithContextDialer(dialer))
+		logging.Info().
+			Str("proxy", cfg.ProxyURL).
+			Bool("authenticated", cfg.ProxyUsername != "").
+			Msg("Connecting to server through proxy")
+	}
+
 	if cfg.UseTLS {
 		var creds credentials.TransportCredentials
 		
+		// Verify the certificate against tls_server_name rather than the
+		// dial host when connecting by IP or through a load balancer
+		if cfg.TLSServerName != "" {
+			logging.Info().
+				Str("server", cfg.ServerAddress).
+				Str("tls_server_name", cfg.TLSServerName).
+				Msg("Verifying server certificate against configured TLS server name")
+		}
+		
 		if cfg.InsecureSkipVerify {
 			// Skip certificate verification (not recommended for production)
 			creds = credentials.NewTLS(&tls.Config{
 				InsecureSkipVerify: true,
+				ServerName:         cfg.TLSServerName,
 			})
 			logging.Warn().
 				Str("server", cfg.ServerAddress).
@@ -98,7 +251,8 @@ func NewEDRClientWithConfig(cfg *config.Config) (*EDRClient, error) {
 			}
 			
 			creds = credentials.NewTLS(&tls.Config{
-				RootCAs: caCertPool,
+				RootCAs:    caCertPool,
+				ServerName: cfg.TLSServerName,
 			})
 			
 			logging.Info().
@@ -107,20 +261,22 @@ func NewEDRClientWithConfig(cfg *config.Config) (*EDRClient, error) {
 				Msg("Connected to server with TLS using custom CA certificate")
 		} else {
 			// Use system CA certificates for verification
-			creds = credentials.NewTLS(&tls.Config{})
+			creds = credentials.NewTLS(&tls.Config{
+				ServerName: cfg.TLSServerName,
+			})
 			
 			logging.Info().
 				Str("server", cfg.ServerAddress).
 				Msg("Connected to server with TLS using system CA certificates")
 		}
 		
-		conn, err = grpc.Dial(cfg.ServerAddress, grpc.WithTransportCredentials(creds))
+		conn, err = dialServer(ctx, cfg, append(dialOpts, grpc.WithTransportCredentials(creds)))
 		if err != nil {
 			return nil, fmt.Errorf("failed to connect to server with TLS: %v", err)
 		}
 	} else {
 		// Connect without TLS (insecure)
-		conn, err = grpc.Dial(cfg.ServerAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err = dialServer(ctx, cfg, append(dialOpts, grpc.WithTransportCredentials(insecure.NewCredentials())))
 		if err != nil {
 			return nil, fmt.Errorf("failed to connect to server: %v", err)
 		}
@@ -139,13 +295,26 @@ func NewEDRClientWithConfig(cfg *config.Config) (*EDRClient, error) {
 		edrClient:     pb.NewEDRServiceClient(conn),
 		agentVersion:  cfg.AgentVersion,
 		dataDir:       cfg.DataDir,
+		keys:          keys,
+		integrity:     integrity,
 		useTLS:        cfg.UseTLS,
 		config:        cfg,
 		statusChan:    make(chan statusUpdate, 10), // Buffer size for status updates
+		eventChan:     make(chan *pb.AgentEvent, 20), // Buffer size for agent events
+		metricsIntervalChan: make(chan struct{}, 1),
+		heartbeatIntervalChan: make(chan struct{}, 1),
+		reloadChan:    make(chan struct{}, 1),
+		commandLimit:  newCommandLimiter(cfg.MaxConcurrentCommands),
+		state:         StateConnecting,
+		stateCause:    "agent started",
+		stateSince:    time.Now(),
+		connMetrics:   newConnectionMetrics(time.Now()),
+		clockSkew:     &clockSkew{},
 	}
 
 	// Create command handler
 	client.cmdHandler = NewCommandHandler(client)
+	client.cmdHandler.GetIOCManager().SetSigningKey(iocSigningKey, func() string { return client.agentID })
 
 	return client, nil
 }
@@ -161,15 +330,48 @@ func (c *EDRClient) SetAgentVersion(version string) {
 // SetMetricsInterval sets the system metrics update interval in minutes (legacy function)
 func (c *EDRClient) SetMetricsInterval(interval int) {
 	if c.config != nil {
-		c.config.MetricsInterval = interval
+		c.config.Update(func(next *config.Config) { next.MetricsInterval = interval })
 		logging.Info().
 			Int("interval_minutes", interval).
 			Msg("Setting metrics interval")
+		
+		// Let a running command stream rebuild its ping ticker
+		select {
+		case c.metricsIntervalChan <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// SetHeartbeatInterval sets the heartbeat interval in seconds
+func (c *EDRClient) SetHeartbeatInterval(interval int) {
+	if c.config != nil {
+		c.config.Update(func(next *config.Config) { next.HeartbeatInterval = interval })
+		logging.Info().
+			Int("interval_seconds", interval).
+			Msg("Setting heartbeat interval")
+		
+		// Let a running command stream rebuild its heartbeat ticker
+		select {
+		case c.heartbeatIntervalChan <- struct{}{}:
+		default:
+		}
 	}
 }
 
-// Register registers the agent with the server
+// Register registers the agent with the server. Without a configured agent ID
+// it offers one derived from the host's hardware, so a host that lost its
+// config registers as itself again; the server assigns one only if that fails.
 func (c *EDRClient) Register(ctx context.Context) (*AgentInfo, error) {
+	if c.agentID == "" {
+		if id, err := config.DeriveStableAgentID(); err != nil {
+			log.Printf("Warning: failed to derive a stable agent ID, the server will assign one: %v", err)
+		} else {
+			log.Printf("Using agent ID %s derived from this host's hardware identifiers", id)
+			c.agentID = id
+		}
+	}
+	
 	// Gather system information
 	hostname, err := getHostname()
 	if err != nil {
@@ -210,13 +412,17 @@ func (c *EDRClient) Register(ctx context.Context) (*AgentInfo, error) {
 		OsVersion:       osVersion,
 		AgentVersion:    c.agentVersion,
 		RegistrationTime: time.Now().Unix(),
+		Elevated:        privilege.IsElevated(),
+		Mode:            c.config.Current().Mode,
 	}
 
 	// Send registration request
+	sent := time.Now()
 	resp, err := c.edrClient.RegisterAgent(ctx, req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to register with server: %v", err)
 	}
+	c.clockSkew.observe(resp.ServerTime, sent, time.Now())
 
 	// If server assigned a new ID, update our agent ID
 	if resp.AssignedId != "" {
@@ -240,6 +446,78 @@ func (c *EDRClient) Register(ctx context.Context) (*AgentInfo, error) {
 	}, nil
 }
 
+// dialServer opens the connection to the server. gRPC normally connects in
+// the background; with dial_blocking each attempt waits up to
+// connection_timeout for the connection to be ready, and failed attempts are
+// retried with backoff like registration (max_registration_attempts) until
+// ctx is cancelled.
+func dialServer(ctx context.Context, cfg *config.Config, opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	if !cfg.DialBlocking {
+		return grpc.Dial(cfg.ServerAddress, opts...)
+	}
+	
+	opts = append(opts, grpc.WithBlock())
+	for attempt := 1; ; attempt++ {
+		dialCtx, cancel := context.WithTimeout(ctx, cfg.GetConnectionTimeoutDuration())
+		conn, err := grpc.DialContext(dialCtx, cfg.ServerAddress, opts...)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Connected to server after %d attempts", attempt)
+			}
+			return conn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		
+		if cfg.MaxRegistrationAttempts > 0 && attempt >= cfg.MaxRegistrationAttempts {
+			return nil, fmt.Errorf("giving up after %d connection attempts: %v", attempt, err)
+		}
+		
+		backoffTime := reconnectBackoff(cfg.GetReconnectDelayDuration(), cfg.GetMaxReconnectDelayDuration(), attempt)
+		log.Printf("Connection attempt #%d to %s failed: %v", attempt, cfg.ServerAddress, err)
+		log.Printf("Will retry connection in %.1f seconds", backoffTime.Seconds())
+		
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoffTime):
+		}
+	}
+}
+
+// RegisterWithRetry registers with the server, retrying with the command
+// stream's backoff while the server cannot be reached. It gives up after
+// maxAttempts failed attempts (0 = no limit) or when ctx is cancelled.
+func (c *EDRClient) RegisterWithRetry(ctx context.Context, maxAttempts int) (*AgentInfo, error) {
+	for attempt := 1; ; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, c.config.GetConnectionTimeoutDuration())
+		info, err := c.Register(attemptCtx)
+		cancel()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("Registered with server after %d attempts", attempt)
+			}
+			return info, nil
+		}
+		
+		if maxAttempts > 0 && attempt >= maxAttempts {
+			return nil, fmt.Errorf("giving up after %d registration attempts: %v", attempt, err)
+		}
+		
+		backoffTime := reconnectBackoff(c.config.GetReconnectDelayDuration(), c.config.GetMaxReconnectDelayDuration(), attempt)
+		log.Printf("Registration attempt #%d failed: %v", attempt, err)
+		log.Printf("Will retry registration in %.1f seconds", backoffTime.Seconds())
+		
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(backoffTime):
+		}
+	}
+}
+
 // UpdateStatus sends a status update to the server
 func (c *EDRClient) UpdateStatus(ctx context.Context, status string, metrics map[string]float64) error {
 	// Create system metrics - convert from 0-1 to 0-100 percentage scale for the API
@@ -248,6 +526,10 @@ func (c *EDRClient) UpdateStatus(ctx context.Context, status string, metrics map
 		MemoryUsage: metrics["memory_usage"] * 100,
 		Uptime:      int64(metrics["uptime"]),
 	}
+	c.addIOMetrics(sysMetrics)
+	c.addScanMetrics(sysMetrics)
+	c.addAuditHead(sysMetrics)
+	c.addConnectionMetrics(sysMetrics)
 
 	// Create status request
 	req := &pb.StatusRequest{
@@ -258,10 +540,12 @@ func (c *EDRClient) UpdateStatus(ctx context.Context, status string, metrics map
 	}
 
 	// Send status update
+	sent := time.Now()
 	resp, err := c.edrClient.UpdateStatus(ctx, req)
 	if err != nil {
 		return fmt.Errorf("failed to update status: %v", err)
 	}
+	c.clockSkew.observe(resp.ServerTime, sent, time.Now())
 
 	if !resp.Acknowledged {
 		return fmt.Errorf("status update not acknowledged: %s", resp.ServerMessage)
@@ -273,31 +557,32 @@ func (c *EDRClient) UpdateStatus(ctx context.Context, status string, metrics map
 // StartCommandStream starts a bidirectional stream for agent-server communication
 func (c *EDRClient) StartCommandStream(ctx context.Context) {
 	// Track failed connection attempts for backoff strategy
-	consecutiveFailures := 0
-	maxBackoff := c.config.GetMaxReconnectDelayDuration()
+	var retry streamRetry
 	
 	for {
 		select {
 		case <-ctx.Done():
 			log.Println("Command stream stopped due to context cancellation")
+			c.setState(StateDisconnected, "agent shutting down")
 			return
 		default:
-			// Calculate backoff time based on consecutive failures
-			baseDelay := c.config.GetReconnectDelayDuration()
-			backoffTime := time.Duration(math.Min(float64(baseDelay.Seconds()*float64(consecutiveFailures)), float64(maxBackoff.Seconds()))) * time.Second
-			
 			// Open bidirectional stream
 			stream, err := c.edrClient.CommandStream(ctx)
 			if err != nil {
-				consecutiveFailures++
-				log.Printf("Failed to start command stream (attempt #%d): %v", consecutiveFailures, err)
-				log.Printf("Will retry in %v seconds", backoffTime.Seconds())
-				time.Sleep(backoffTime) // Wait with exponential backoff
+				failures := retry.failed(time.Now())
+				
+				// Calculate backoff time based on consecutive failures
+				backoffTime := reconnectBackoff(c.config.GetReconnectDelayDuration(), c.config.GetMaxReconnectDelayDuration(), failures)
+				
+				log.Printf("Failed to start command stream (attempt #%d): %v", failures, err)
+				c.setState(c.retryState(), fmt.Sprintf("failed to start command stream: %v", err))
+				log.Printf("Will retry in %.1f seconds", backoffTime.Seconds())
+				time.Sleep(backoffTime) // Wait with jittered exponential backoff
 				continue
 			}
 			
-			// Reset failure counter on successful connection
-			consecutiveFailures = 0
+			// The failure counter resets once the stream has stayed up
+			retry.connected(time.Now())
 			log.Println("Command stream established")
 			
 			// Send initial HELLO message
@@ -313,13 +598,22 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 				},
 			}
 			
+			helloSent := time.Now()
 			if err := stream.Send(helloMsg); err != nil {
 				log.Printf("Failed to send HELLO message: %v", err)
+				c.setState(c.retryState(), fmt.Sprintf("failed to send HELLO message: %v", err))
 				stream.CloseSend()
-				time.Sleep(c.config.GetReconnectDelayDuration())
+				backoffTime := reconnectBackoff(c.config.GetReconnectDelayDuration(), c.config.GetMaxReconnectDelayDuration(), retry.failed(time.Now()))
+				log.Printf("Will retry in %.1f seconds", backoffTime.Seconds())
+				time.Sleep(backoffTime)
 				continue
 			}
 
+			c.setState(StateConnected, "command stream established")
+			
+			// Replay reports journaled while the server was unreachable
+			c.cmdHandler.journal.connected()
+			
 			// Create a context that can be cancelled to coordinate goroutines
 			streamCtx, cancelStream := context.WithCancel(ctx)
 			defer cancelStream()
@@ -329,6 +623,7 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 			
 			// Add streamWatcher to coordinate stream closure
 			streamClosed := make(chan struct{})
+			closeCause := "command stream cancelled"
 			
 			// Start goroutine to handle incoming messages
 			wg.Add(1)
@@ -341,8 +636,10 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 					if err != nil {
 						if err == io.EOF {
 							log.Println("Command stream closed by server")
+							closeCause = "command stream closed by server"
 						} else {
 							log.Printf("Error receiving message: %v", err)
+							closeCause = fmt.Sprintf("error receiving message: %v", err)
 						}
 						// Cancel the stream context to signal all goroutines to stop
 						cancelStream()
@@ -352,8 +649,16 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 					// Process different message types
 					switch message.MessageType {
 					case pb.MessageType_AGENT_HELLO:
-						// Server acknowledgment of our HELLO
+						// Server acknowledgment of our HELLO, stamped with its clock
 						log.Printf("Server acknowledged connection for agent %s", message.AgentId)
+						c.clockSkew.observe(message.Timestamp, helloSent, time.Now())
+						c.selfUpdateOnce.Do(c.completeSelfUpdate)
+						
+					case pb.MessageType_AGENT_HEARTBEAT:
+						// The server sent one of our heartbeats back
+						if heartbeat := message.GetHeartbeat(); heartbeat != nil {
+							c.connMetrics.heartbeatEchoed(heartbeat.Sequence, time.Now())
+						}
 						
 					case pb.MessageType_SERVER_COMMAND:
 						// Handle command from server
@@ -399,9 +704,19 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 								return
 							}
 							
-							// For all other command types, process normally
-							// Execute command
-							result := c.cmdHandler.HandleCommand(ctx, command)
+							// For all other command types, process normally.
+							// At most max_concurrent_commands execute at once;
+							// the rest wait for a slot or, once the queue is
+							// full, are refused as busy.
+							var result *pb.CommandResult
+							if err := c.commandLimit.acquire(ctx); err == nil {
+								result = c.cmdHandler.handleCommandInSlot(ctx, command, c.commandLimit.release)
+							} else if errors.Is(err, errCommandQueueFull) {
+								result = c.commandLimit.busyResult(command)
+							} else {
+								log.Printf("Command %s not executed: %v", command.CommandId, err)
+								return
+							}
 							
 							// Check if stream is still active before sending
 							select {
@@ -460,19 +775,57 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 								return
 							}
 							
-							// Process the IOC data using the centralized method
-							log.Printf("Processing IOC update to version %d", data.Version)
-							
-							// Update IOCs from protobuf response
-							if err := iocManager.UpdateFromProto(data); err != nil {
-								log.Printf("ERROR: Failed to update IOCs: %v", err)
-								return
+							if data.IsDelta {
+								// Delta updates only apply on top of the version they were computed against
+								// A delta that does not build on the current version
+								// means updates were missed in between
+								if iocManager.NeedsFullSync(data.BaseVersion) {
+									log.Printf("IOC version gap: delta to version %d is based on version %d but current version is %d, requesting full resync",
+										data.Version, data.BaseVersion, currentVersion)
+									c.RequestFullResync(ctx)
+									return
+								}
+								
+								log.Printf("Applying IOC delta from version %d to version %d", data.BaseVersion, data.Version)
+								
+								if err := iocManager.ApplyDelta(data); err != nil {
+									log.Printf("ERROR: Failed to apply IOC delta: %v", err)
+									if errors.Is(err, ioc.ErrDeltaBaseMismatch) || errors.Is(err, ioc.ErrChecksumMismatch) {
+										c.RequestFullResync(ctx)
+									}
+									c.reportInvalidIOCSignature(data, err)
+									return
+								}
+							} else {
+								// Process the IOC data using the centralized method
+								log.Printf("Processing IOC update to version %d", data.Version)
+								
+								// Update IOCs from protobuf response
+								if err := iocManager.UpdateFromProto(data); err != nil {
+									log.Printf("ERROR: Failed to update IOCs: %v", err)
+									if errors.Is(err, ioc.ErrChecksumMismatch) {
+										// The transfer was cut short or corrupted, fetch it again
+										c.RequestFullResync(ctx)
+									}
+									c.reportInvalidIOCSignature(data, err)
+									return
+								}
+								handler.fullUpdateApplied()
 							}
 							
 							log.Printf("Successfully updated IOCs to version %d", data.Version)
 							
 							// Trigger immediate scan
 							scanner := handler.GetScanner()
+							if scanner != nil && len(data.YaraRules) > 0 {
+								if err := scanner.UpdateYaraRules(data.YaraRules); err != nil {
+									log.Printf("ERROR: Failed to reload YARA rules: %v", err)
+								}
+							}
+							if scanner != nil && len(data.FileHashes) > 0 {
+								// A deleted sample may still be running from memory
+								scanner.KillRunningHashMatches()
+							}
 							if scanner != nil {
 								log.Printf("Triggering immediate IOC scan after update")
 								scanner.TriggerScan()
@@ -490,10 +843,15 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 				defer wg.Done()
 				
 				// Use the metrics interval from config for ping signals
-				log.Printf("Creating ping signal ticker with interval of %d minutes", c.config.MetricsInterval)
+				log.Printf("Creating ping signal ticker with interval of %d minutes", c.config.Current().MetricsInterval)
 				pingTicker := time.NewTicker(c.config.GetMetricsIntervalDuration())
 				defer pingTicker.Stop()
 				
+				// Heartbeats are much more frequent than ping signals and
+				// carry no metrics, so the server can detect a hung agent quickly
+				heartbeatTicker := time.NewTicker(c.config.GetHeartbeatIntervalDuration())
+				defer heartbeatTicker.Stop()
+				
 				// Send an initial ping signal immediately
 				sendRunningSignal(c, stream, streamClosed, cancelStream)
 				
@@ -501,8 +859,18 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 					select {
 					case <-pingTicker.C:
 						sendRunningSignal(c, stream, streamClosed, cancelStream)
+					case <-c.metricsIntervalChan:
+						log.Printf("Resetting ping signal ticker to %d minutes", c.config.Current().MetricsInterval)
+						pingTicker.Reset(c.config.GetMetricsIntervalDuration())
+					case <-heartbeatTicker.C:
+						sendHeartbeat(c, stream, streamClosed, cancelStream)
+					case <-c.heartbeatIntervalChan:
+						log.Printf("Resetting heartbeat ticker to %d seconds", c.config.Current().HeartbeatInterval)
+						heartbeatTicker.Reset(c.config.GetHeartbeatIntervalDuration())
 					case statusUpd := <-c.statusChan:
 						sendStatusUpdate(c, stream, streamClosed, cancelStream, statusUpd.status, statusUpd.metrics)
+					case event := <-c.eventChan:
+						sendAgentEvent(c, stream, streamClosed, cancelStream, event)
 					case <-streamCtx.Done():
 						return
 					}
@@ -519,11 +887,14 @@ func (c *EDRClient) StartCommandStream(ctx context.Context) {
 			select {
 			case <-ctx.Done():
 				log.Println("Parent context cancelled, stopping reconnect attempts")
+				c.setState(StateDisconnected, "agent shutting down")
 				return
 			default:
-				// Wait before reconnecting
-				log.Printf("Will attempt to reconnect command stream in %v", c.config.GetReconnectDelayDuration())
-				time.Sleep(c.config.GetReconnectDelayDuration())
+				c.setState(StateReconnecting, closeCause)
+				// Wait before reconnecting, longer if streams keep dropping
+				backoffTime := reconnectBackoff(c.config.GetReconnectDelayDuration(), c.config.GetMaxReconnectDelayDuration(), retry.failed(time.Now()))
+				log.Printf("Will attempt to reconnect command stream in %.1f seconds", backoffTime.Seconds())
+				time.Sleep(backoffTime)
 			}
 		}
 	}
@@ -538,16 +909,22 @@ func sendStatusUpdate(c *EDRClient, stream pb.EDRService_CommandStreamClient, st
 	default:
 		log.Printf("Sending status update: %s", status)
 		
+		sysMetrics := &pb.SystemMetrics{
+			CpuUsage:    metrics["cpu_usage"] * 100,    // Convert from 0-1 to 0-100 scale
+			MemoryUsage: metrics["memory_usage"] * 100, // Convert from 0-1 to 0-100 scale
+			Uptime:      int64(metrics["uptime"]),
+		}
+		c.addIOMetrics(sysMetrics)
+		c.addScanMetrics(sysMetrics)
+		c.addAuditHead(sysMetrics)
+		c.addConnectionMetrics(sysMetrics)
+		
 		// Create status update message
 		statusMsg := &pb.StatusRequest{
-			AgentId:   c.agentID,
-			Timestamp: time.Now().Unix(),
-			Status:    status,
-			SystemMetrics: &pb.SystemMetrics{
-				CpuUsage:    metrics["cpu_usage"] * 100,    // Convert from 0-1 to 0-100 scale
-				MemoryUsage: metrics["memory_usage"] * 100, // Convert from 0-1 to 0-100 scale
-				Uptime:      int64(metrics["uptime"]),
-			},
+			AgentId:       c.agentID,
+			Timestamp:     time.Now().Unix(),
+			Status:        status,
+			SystemMetrics: sysMetrics,
 		}
 		
 		statusUpdateMsg := &pb.CommandMessage{
@@ -569,6 +946,35 @@ func sendStatusUpdate(c *EDRClient, stream pb.EDRService_CommandStreamClient, st
 	}
 }
 
+// Helper function to send agent events
+func sendAgentEvent(c *EDRClient, stream pb.EDRService_CommandStreamClient, streamClosed chan struct{}, cancelStream context.CancelFunc, event *pb.AgentEvent) {
+	// Check if stream is still active before sending event
+	select {
+	case <-streamClosed:
+		return
+	default:
+		// Agent ID may have been assigned by the server after the event was queued
+		event.AgentId = c.agentID
+		
+		eventMsg := &pb.CommandMessage{
+			AgentId:     c.agentID,
+			Timestamp:   time.Now().Unix(),
+			MessageType: pb.MessageType_AGENT_EVENT,
+			Payload: &pb.CommandMessage_Event{
+				Event: event,
+			},
+		}
+		
+		if err := stream.Send(eventMsg); err != nil {
+			log.Printf("Failed to send agent event %s: %v", event.Type.String(), err)
+			cancelStream() // Cancel context to signal all goroutines to stop
+			return
+		}
+		
+		log.Printf("Sent agent event: %s", event.Type.String())
+	}
+}
+
 // Helper function to send running signals
 func sendRunningSignal(c *EDRClient, stream pb.EDRService_CommandStreamClient, streamClosed chan struct{}, cancelStream context.CancelFunc) {
 	// Check if stream is still active before sending signal
@@ -587,15 +993,22 @@ func sendRunningSignal(c *EDRClient, stream pb.EDRService_CommandStreamClient, s
 		log.Printf("Sending ping signal with metrics: CPU: %.2f%%, Memory: %.2f%%, Uptime: %.0fs", 
 			metrics["cpu_usage"]*100, metrics["memory_usage"]*100, metrics["uptime"])
 		
+		sysMetrics := &pb.SystemMetrics{
+			CpuUsage:    metrics["cpu_usage"]*100,  // Convert from 0-1 to 0-100 scale
+			MemoryUsage: metrics["memory_usage"]*100, // Convert from 0-1 to 0-100 scale
+			Uptime:      int64(metrics["uptime"]),
+		}
+		c.addIOMetrics(sysMetrics)
+		c.addScanMetrics(sysMetrics)
+		c.addAuditHead(sysMetrics)
+		c.addConnectionMetrics(sysMetrics)
+		
 		// Create running signal message
 		runningSignal := &pb.AgentRunning{
-			AgentId:   c.agentID,
-			Timestamp: time.Now().Unix(),
-			SystemMetrics: &pb.SystemMetrics{
-				CpuUsage:    metrics["cpu_usage"]*100,  // Convert from 0-1 to 0-100 scale
-				MemoryUsage: metrics["memory_usage"]*100, // Convert from 0-1 to 0-100 scale
-				Uptime:      int64(metrics["uptime"]),
-			},
+			AgentId:       c.agentID,
+			Timestamp:     time.Now().Unix(),
+			SystemMetrics: sysMetrics,
+			Mode:          c.config.Current().Mode,
 		}
 		
 		runningMsg := &pb.CommandMessage{
@@ -615,6 +1028,36 @@ func sendRunningSignal(c *EDRClient, stream pb.EDRService_CommandStreamClient, s
 	}
 }
 
+// Helper function to send heartbeats
+func sendHeartbeat(c *EDRClient, stream pb.EDRService_CommandStreamClient, streamClosed chan struct{}, cancelStream context.CancelFunc) {
+	// Check if stream is still active before sending heartbeat
+	select {
+	case <-streamClosed:
+		return
+	default:
+		sentAt := time.Now()
+		now := sentAt.Unix()
+		heartbeatMsg := &pb.CommandMessage{
+			AgentId:     c.agentID,
+			Timestamp:   now,
+			MessageType: pb.MessageType_AGENT_HEARTBEAT,
+			Payload: &pb.CommandMessage_Heartbeat{
+				Heartbeat: &pb.AgentHeartbeat{
+					AgentId:   c.agentID,
+					Timestamp: now,
+					Sequence:  c.connMetrics.heartbeatSending(sentAt),
+				},
+			},
+		}
+		
+		if err := stream.Send(heartbeatMsg); err != nil {
+			log.Printf("Failed to send heartbeat: %v", err)
+			cancelStream() // Cancel context to signal all goroutines to stop
+			return
+		}
+	}
+}
+
 // Global variable for tracking start time
 var (
 	processStartTime time.Time
@@ -665,6 +1108,143 @@ func getUptime() int64 {
 	return int64(uptime)
 }
 
+// ioSample holds cumulative network and disk counters at a point in time
+type ioSample struct {
+	takenAt   time.Time
+	netSent   uint64
+	netRecv   uint64
+	diskRead  uint64
+	diskWrite uint64
+}
+
+func takeIOSample() *ioSample {
+	sample := &ioSample{takenAt: time.Now()}
+	
+	if counters, err := psnet.IOCounters(false); err != nil || len(counters) == 0 {
+		log.Printf("Warning: failed to get network I/O counters: %v", err)
+	} else {
+		sample.netSent = counters[0].BytesSent
+		sample.netRecv = counters[0].BytesRecv
+	}
+	
+	if counters, err := disk.IOCounters(); err != nil {
+		log.Printf("Warning: failed to get disk I/O counters: %v", err)
+	} else {
+		for name, c := range counters {
+			if !countDiskIO(name) {
+				continue
+			}
+			sample.diskRead += c.ReadBytes
+			sample.diskWrite += c.WriteBytes
+		}
+	}
+	
+	return sample
+}
+
+// counterDelta returns cur-prev, treating a counter that went backwards
+// (interface reset, counter wrap) as no traffic
+func counterDelta(cur, prev uint64) uint64 {
+	if cur < prev {
+		return 0
+	}
+	return cur - prev
+}
+
+// addIOMetrics fills in disk usage and the network and disk I/O since the
+// previous sample. The first sample only records a baseline.
+func (c *EDRClient) addIOMetrics(m *pb.SystemMetrics) {
+	m.DiskUsage = getDiskUsage()
+	
+	sample := takeIOSample()
+	
+	c.ioMu.Lock()
+	prev := c.lastIOSample
+	c.lastIOSample = sample
+	c.ioMu.Unlock()
+	
+	if prev == nil {
+		return
+	}
+	
+	m.NetBytesSent = counterDelta(sample.netSent, prev.netSent)
+	m.NetBytesRecv = counterDelta(sample.netRecv, prev.netRecv)
+	
+	if elapsed := sample.takenAt.Sub(prev.takenAt).Seconds(); elapsed > 0 {
+		m.DiskReadBytesPerSec = float64(counterDelta(sample.diskRead, prev.diskRead)) / elapsed
+		m.DiskWriteBytesPerSec = float64(counterDelta(sample.diskWrite, prev.diskWrite)) / elapsed
+	}
+}
+
+// addScanMetrics fills in the IOC scanner statistics of a metrics sample
+func (c *EDRClient) addScanMetrics(m *pb.SystemMetrics) {
+	if c.cmdHandler == nil || c.cmdHandler.GetScanner() == nil {
+		return
+	}
+	
+	stats := c.cmdHandler.GetScanner().Stats()
+	m.LastScanDurationSeconds = stats.LastScanDuration.Seconds()
+	if !stats.LastScanTime.IsZero() {
+		m.LastScanTime = stats.LastScanTime.Unix()
+	}
+	m.FilesHashed = stats.FilesHashed
+	m.EventsProcessed = stats.EventsProcessed
+	m.MatchesFound = stats.MatchesFound
+}
+
+// addAuditHead fills in the last entry of the command audit log, so the
+// server notices when the log is cut back to an earlier entry
+func (c *EDRClient) addAuditHead(m *pb.SystemMetrics) {
+	if c.cmdHandler == nil || c.cmdHandler.auditLog == nil {
+		return
+	}
+	m.AuditSeq, m.AuditMac = c.cmdHandler.auditLog.Head()
+}
+
+func getDiskUsage() float64 {
+	// Report usage of the drive holding the operating system
+	path := "/"
+	if runtime.GOOS == "windows" {
+		path = os.Getenv("SystemDrive") + "\\"
+		if path == "\\" {
+			path = "C:\\"
+		}
+	}
+	
+	usage, err := disk.Usage(path)
+	if err != nil {
+		log.Printf("Warning: failed to get disk usage for %s: %v", path, err)
+		return 0
+	}
+	
+	return usage.UsedPercent
+}
+
+// UpdateInProgress reports whether a self-update was installed and the
+// service manager is about to restart the agent on the new executable
+func (c *EDRClient) UpdateInProgress() bool {
+	return c.updating.Load()
+}
+
+// ReloadRequested returns a channel that receives a value when UPDATE_CONFIG
+// has saved a new configuration file that should be reloaded
+func (c *EDRClient) ReloadRequested() <-chan struct{} {
+	return c.reloadChan
+}
+
+// requestReload asks the main loop to reload the configuration file
+func (c *EDRClient) requestReload() {
+	select {
+	case c.reloadChan <- struct{}{}:
+	default:
+	}
+}
+
+// StreamConnected reports whether the command stream to the server is up
+func (c *EDRClient) StreamConnected() bool {
+	return c.State() == StateConnected
+}
+
 // GetCommandHandler returns the command handler
 func (c *EDRClient) GetCommandHandler() *CommandHandler {
 	return c.cmdHandler
@@ -673,7 +1253,29 @@ func (c *EDRClient) GetCommandHandler() *CommandHandler {
 // RequestIOCUpdates sends a request to the server to get the latest IOC data
 func (c *EDRClient) RequestIOCUpdates(ctx context.Context) {
 	log.Printf("Requesting IOC updates from server via command stream...")
+	c.requestIOCUpdates(ctx, "initial")
+}
+
+// RequestFullResync asks the server for a complete IOC snapshot, used when an
+// incremental update cannot be applied on top of the local IOC set. Requests
+// within fullResyncDebounce of the previous one are dropped, since the
+// snapshot already asked for will cover them.
+func (c *EDRClient) RequestFullResync(ctx context.Context) {
+	c.resyncMu.Lock()
+	if since := time.Since(c.lastResync); since < fullResyncDebounce {
+		c.resyncMu.Unlock()
+		log.Printf("Full IOC resync already requested %v ago, not requesting again", since.Round(time.Second))
+		return
+	}
+	c.lastResync = time.Now()
+	c.resyncMu.Unlock()
 	
+	log.Printf("Requesting full IOC resync from server...")
+	c.requestIOCUpdates(ctx, "full_sync")
+}
+
+// requestIOCUpdates sends an UPDATE_IOCS request of the given type to the server
+func (c *EDRClient) requestIOCUpdates(ctx context.Context, requestType string) {
 	// Send the message through the SendCommand RPC
 	cmd := &pb.SendCommandRequest{
 		Command: &pb.Command{
@@ -681,7 +1283,7 @@ func (c *EDRClient) RequestIOCUpdates(ctx context.Context) {
 			AgentId:   c.agentID,
 			Timestamp: time.Now().Unix(),
 			Type:      pb.CommandType_UPDATE_IOCS,
-			Params:    map[string]string{"request_type": "initial"},
+			Params:    map[string]string{"request_type": requestType},
 			Priority:  1,
 		},
 	}
@@ -754,6 +1356,42 @@ type AgentInfo struct {
 	ServerMessage string
 }
 
+// SendEvent queues an agent event to be sent through the main command stream.
+// Events raised before the stream is established are sent once it connects.
+func (c *EDRClient) SendEvent(eventType pb.AgentEventType, message string, details map[string]string) {
+	event := &pb.AgentEvent{
+		AgentId:   c.agentID,
+		Timestamp: time.Now().Unix(),
+		Type:      eventType,
+		Message:   message,
+		Details:   details,
+	}
+	
+	select {
+	case c.eventChan <- event:
+		log.Printf("Queued agent event: %s", eventType.String())
+	default:
+		log.Printf("Agent event channel full, dropping event: %s", eventType.String())
+	}
+}
+
+// reportInvalidIOCSignature raises a security event when an IOC update was
+// rejected because it was not signed with the pinned key
+func (c *EDRClient) reportInvalidIOCSignature(data *pb.IOCResponse, err error) {
+	if !errors.Is(err, ioc.ErrInvalidSignature) {
+		return
+	}
+	
+	log.Printf("SECURITY: Rejected IOC update to version %d, its signature did not verify", data.Version)
+	c.SendEvent(pb.AgentEventType_IOC_SIGNATURE_INVALID,
+		"IOC update rejected: signature verification failed",
+		map[string]string{
+			"version": strconv.FormatInt(data.Version, 10),
+			"delta":   strconv.FormatBool(data.IsDelta),
+			"error":   err.Error(),
+		})
+}
+
 // SendStatusUpdate sends a status update through the main command stream
 func (c *EDRClient) SendStatusUpdate(status string, metrics map[string]float64) {
 	select {
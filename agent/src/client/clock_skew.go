@@ -0,0 +1,76 @@
+package client
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// maxClockSkew is how far the agent clock may be from the server's before a
+// warning is logged. Timestamps on reports and command results are taken
+// from the agent clock, so a larger skew misorders them on the server.
+const maxClockSkew = time.Minute
+
+// clockSkew estimates how far the agent clock is ahead of the server's,
+// from the server time in replies to the agent's requests
+type clockSkew struct {
+	mu       sync.Mutex
+	skew     time.Duration
+	measured bool
+	exceeded bool // The last estimate was beyond maxClockSkew
+}
+
+// observe updates the estimate from serverTime, the Unix time in a reply to
+// a request sent at sent and received at received. The server read its clock
+// somewhere in between, so the midpoint is used; the server sends whole
+// seconds, so the estimate is only good to about a second. A zero serverTime,
+// from a server that does not send one, is ignored.
+func (c *clockSkew) observe(serverTime int64, sent, received time.Time) {
+	if serverTime <= 0 {
+		return
+	}
+	midpoint := sent.Add(received.Sub(sent) / 2)
+	skew := midpoint.Sub(time.Unix(serverTime, int64(500*time.Millisecond))).Round(time.Second)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.skew = skew
+	c.measured = true
+	exceeded := skew > maxClockSkew || skew < -maxClockSkew
+	if exceeded {
+		log.Printf("WARNING: agent clock is %v %s the server's; event and report times will be off by as much", absDuration(skew), aheadOrBehind(skew))
+	} else if c.exceeded {
+		log.Printf("Agent clock is back within %v of the server's (skew %v)", maxClockSkew, skew)
+	}
+	c.exceeded = exceeded
+}
+
+// get returns the last estimate, and whether there is one
+func (c *clockSkew) get() (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.skew, c.measured
+}
+
+// absDuration returns the magnitude of d
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
+// aheadOrBehind describes the sign of a skew
+func aheadOrBehind(skew time.Duration) string {
+	if skew < 0 {
+		return "behind"
+	}
+	return "ahead of"
+}
+
+// ClockSkew returns how far the agent clock is ahead of the server's, negative
+// if it is behind, and whether the server has been reached to measure it
+func (c *EDRClient) ClockSkew() (time.Duration, bool) {
+	return c.clockSkew.get()
+}
@@ -0,0 +1,209 @@
+package client
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"agent/persist"
+	pb "agent/proto"
+)
+
+// maxCachedCommands bounds the number of command results kept for de-duplication
+const maxCachedCommands = 1000
+
+// commandCache remembers the results of recently executed commands so a
+// command re-sent by the server (for example after a stream reconnect) returns
+// the original result instead of running again. Entries older than the
+// retention window are dropped; the least recently used entries are evicted
+// once the cache is full. The cache is persisted so it survives a restart.
+type commandCache struct {
+	mu        sync.Mutex
+	saveMu    sync.Mutex // Serializes writes of the cache file, taken before mu
+	path      string
+	retention time.Duration
+	entries   map[string]*commandCacheEntry
+	order     *list.List // Front is most recently used; values are command IDs
+}
+
+// commandCacheEntry is a cached result, or a command that is still running
+type commandCacheEntry struct {
+	Result   cachedCommandResult `json:"result"`
+	StoredAt time.Time           `json:"stored_at"`
+
+	done chan struct{} // Closed once Result is set
+	elem *list.Element
+}
+
+// cachedCommandResult is the persisted form of a CommandResult
+type cachedCommandResult struct {
+	CommandID     string `json:"command_id"`
+	AgentID       string `json:"agent_id"`
+	Success       bool   `json:"success"`
+	Message       string `json:"message"`
+	ExecutionTime int64  `json:"execution_time"`
+	DurationMs    int64  `json:"duration_ms"`
+}
+
+// newCommandCache creates a cache persisted at path and loads previous entries
+func newCommandCache(path string, retention time.Duration) *commandCache {
+	c := &commandCache{
+		path:      path,
+		retention: retention,
+		entries:   make(map[string]*commandCacheEntry),
+		order:     list.New(),
+	}
+	c.load()
+	return c
+}
+
+// start registers a command as running. If the command ID was already seen
+// it returns the existing entry and true.
+func (c *commandCache) start(commandID string) (*commandCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.expireLocked()
+
+	if entry, ok := c.entries[commandID]; ok {
+		c.order.MoveToFront(entry.elem)
+		return entry, true
+	}
+
+	entry := &commandCacheEntry{
+		StoredAt: time.Now(),
+		done:     make(chan struct{}),
+	}
+	entry.elem = c.order.PushFront(commandID)
+	c.entries[commandID] = entry
+
+	// Evict least recently used entries, but never a command still running
+	for back := c.order.Back(); c.order.Len() > maxCachedCommands && back != nil; {
+		prev := back.Prev()
+		id := back.Value.(string)
+		if e := c.entries[id]; e != entry && e.finished() {
+			c.order.Remove(back)
+			delete(c.entries, id)
+		}
+		back = prev
+	}
+
+	return entry, false
+}
+
+// finish stores the result of a command registered with start and persists the cache
+func (c *commandCache) finish(commandID string, result *pb.CommandResult) {
+	// Encoding and writing under saveMu keeps an older snapshot from being
+	// written over a newer one
+	c.saveMu.Lock()
+	defer c.saveMu.Unlock()
+	
+	c.mu.Lock()
+	entry, ok := c.entries[commandID]
+	if ok {
+		entry.Result = cachedCommandResult{
+			CommandID:     result.CommandId,
+			AgentID:       result.AgentId,
+			Success:       result.Success,
+			Message:       result.Message,
+			ExecutionTime: result.ExecutionTime,
+			DurationMs:    result.DurationMs,
+		}
+		entry.StoredAt = time.Now()
+		close(entry.done)
+	}
+	data, err := c.marshalLocked()
+	c.mu.Unlock()
+
+	if err != nil {
+		log.Printf("Failed to encode command cache: %v", err)
+		return
+	}
+	if err := persist.WriteFileAtomic(c.path, data, 0644); err != nil {
+		log.Printf("Failed to save command cache: %v", err)
+	}
+}
+
+// wait returns the cached result, waiting for the original execution if it is still running
+func (e *commandCacheEntry) wait(ctx context.Context) (*pb.CommandResult, error) {
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	return &pb.CommandResult{
+		CommandId:     e.Result.CommandID,
+		AgentId:       e.Result.AgentID,
+		Success:       e.Result.Success,
+		Message:       e.Result.Message,
+		ExecutionTime: e.Result.ExecutionTime,
+		DurationMs:    e.Result.DurationMs,
+	}, nil
+}
+
+func (e *commandCacheEntry) finished() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// expireLocked drops finished entries older than the retention window
+func (c *commandCache) expireLocked() {
+	cutoff := time.Now().Add(-c.retention)
+	for id, entry := range c.entries {
+		if entry.finished() && entry.StoredAt.Before(cutoff) {
+			c.order.Remove(entry.elem)
+			delete(c.entries, id)
+		}
+	}
+}
+
+// marshalLocked encodes finished entries, most recently used first
+func (c *commandCache) marshalLocked() ([]byte, error) {
+	saved := make([]*commandCacheEntry, 0, len(c.entries))
+	for elem := c.order.Front(); elem != nil; elem = elem.Next() {
+		if entry := c.entries[elem.Value.(string)]; entry.finished() {
+			saved = append(saved, entry)
+		}
+	}
+	return json.MarshalIndent(saved, "", "  ")
+}
+
+// load reads previously persisted results, skipping expired ones
+func (c *commandCache) load() {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read command cache: %v", err)
+		}
+		return
+	}
+
+	var saved []*commandCacheEntry
+	if err := json.Unmarshal(data, &saved); err != nil {
+		log.Printf("Failed to parse command cache, starting empty: %v", err)
+		return
+	}
+
+	cutoff := time.Now().Add(-c.retention)
+	for _, entry := range saved {
+		id := entry.Result.CommandID
+		if id == "" || entry.StoredAt.Before(cutoff) || c.entries[id] != nil || c.order.Len() >= maxCachedCommands {
+			continue
+		}
+		entry.done = make(chan struct{})
+		close(entry.done)
+		entry.elem = c.order.PushBack(id)
+		c.entries[id] = entry
+	}
+
+	log.Printf("Loaded %d recent command results for de-duplication", len(c.entries))
+}
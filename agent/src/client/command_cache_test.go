@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"agent/config"
+	pb "agent/proto"
+)
+
+// newCacheTestHandler returns a CommandHandler with only what HandleCommand
+// needs to de-duplicate commands
+func newCacheTestHandler(t *testing.T) *CommandHandler {
+	t.Helper()
+	return &CommandHandler{
+		client:   &EDRClient{config: config.NewDefaultConfig()},
+		commands: newCommandCache(filepath.Join(t.TempDir(), "command_cache.json"), time.Hour),
+	}
+}
+
+func TestHandleCommandReplayedIDExecutesOnce(t *testing.T) {
+	h := newCacheTestHandler(t)
+	path := filepath.Join(t.TempDir(), "sample.exe")
+	if err := os.WriteFile(path, []byte("sample"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &pb.Command{
+		CommandId: "cmd-1",
+		Type:      pb.CommandType_DELETE_FILE,
+		Params:    map[string]string{"path": path},
+	}
+
+	first := h.HandleCommand(context.Background(), cmd)
+	if !first.Success {
+		t.Fatalf("first DELETE_FILE failed: %s", first.Message)
+	}
+
+	// If the replay ran again it would delete the file put back in between
+	if err := os.WriteFile(path, []byte("sample"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := h.HandleCommand(context.Background(), cmd)
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("replayed command was executed again: %v", err)
+	}
+	if second.Success != first.Success || second.Message != first.Message {
+		t.Errorf("replayed result = %v %q, want the original %v %q", second.Success, second.Message, first.Success, first.Message)
+	}
+}
+
+func TestHandleCommandConcurrentReplaysExecuteOnce(t *testing.T) {
+	h := newCacheTestHandler(t)
+	path := filepath.Join(t.TempDir(), "sample.exe")
+	if err := os.WriteFile(path, []byte("sample"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := &pb.Command{
+		CommandId: "cmd-1",
+		Type:      pb.CommandType_DELETE_FILE,
+		Params:    map[string]string{"path": path},
+	}
+
+	// Only one copy can delete the file; a second execution would fail with
+	// file not found
+	const replays = 8
+	results := make([]*pb.CommandResult, replays)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = h.HandleCommand(context.Background(), cmd)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, result := range results {
+		if !result.Success {
+			t.Errorf("copy %d failed, so the command ran more than once: %s", i, result.Message)
+		}
+	}
+}
+
+func TestCommandCacheConcurrentSaves(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "command_cache.json")
+	cache := newCommandCache(path, time.Hour)
+
+	const commands = 50
+	var wg sync.WaitGroup
+	for i := 0; i < commands; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := fmt.Sprintf("cmd-%d", i)
+			cache.start(id)
+			cache.finish(id, &pb.CommandResult{CommandId: id, Success: true})
+		}(i)
+	}
+	wg.Wait()
+
+	// The last write holds every result, and no temporary file is left
+	reloaded := newCommandCache(path, time.Hour)
+	if len(reloaded.entries) != commands {
+		t.Errorf("reloaded %d results, want %d", len(reloaded.entries), commands)
+	}
+	leftovers, _ := filepath.Glob(path + ".*.tmp")
+	if len(leftovers) > 0 {
+		t.Errorf("temporary files left behind: %v", leftovers)
+	}
+}
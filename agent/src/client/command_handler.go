@@ -2,50 +2,356 @@ package client
 
 import (
 	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/shirou/gopsutil/v3/process"
+
 	pb "agent/proto"
+	"agent/audit"
 	"agent/ioc"
 	"agent/blocker"
+	"agent/persist"
+	"agent/notify"
+	"agent/privilege"
+	"agent/logging"
 )
 
+// dryRunPrefix starts the result message of a command run with dry_run
+const dryRunPrefix = "[DRY RUN] "
+
+// handlerStopTimeout is how long a timed-out command's handler is given to
+// return after its context is cancelled, so its real outcome can be reported
+const handlerStopTimeout = 10 * time.Second
+
+// dryRunCommands are the commands that honour the dry_run parameter
+var dryRunCommands = map[pb.CommandType]bool{
+	pb.CommandType_DELETE_FILE:     true,
+	pb.CommandType_KILL_PROCESS:    true,
+	pb.CommandType_BLOCK_IP:        true,
+	pb.CommandType_BLOCK_URL:       true,
+	pb.CommandType_NETWORK_ISOLATE: true,
+}
+
+// elevatedCommands are the commands that change the firewall, the hosts file
+// or other users' processes and fail without elevated privileges
+var elevatedCommands = map[pb.CommandType]bool{
+	pb.CommandType_KILL_PROCESS:      true,
+	pb.CommandType_KILL_PROCESS_TREE: true,
+	pb.CommandType_KILL_BY_HASH:      true,
+	pb.CommandType_SCAN_MEMORY:       true,
+	pb.CommandType_SUSPEND_PROCESS:   true,
+	pb.CommandType_RESUME_PROCESS:    true,
+	pb.CommandType_BLOCK_IP:          true,
+	pb.CommandType_UNBLOCK_IP:        true,
+	pb.CommandType_BLOCK_URL:         true,
+	pb.CommandType_UNBLOCK_URL:       true,
+	pb.CommandType_NETWORK_ISOLATE:   true,
+	pb.CommandType_NETWORK_RESTORE:   true,
+}
+
+// userNotifications are the remediation commands the logged-on user is told
+// about when notify_user is enabled, with the action shown to them
+var userNotifications = map[pb.CommandType]string{
+	pb.CommandType_DELETE_FILE:       notify.FileRemoved,
+	pb.CommandType_KILL_PROCESS:      notify.ProcessStopped,
+	pb.CommandType_KILL_PROCESS_TREE: notify.ProcessStopped,
+	pb.CommandType_KILL_BY_HASH:      notify.ProcessStopped,
+	pb.CommandType_BLOCK_IP:          notify.AddressBlocked,
+	pb.CommandType_BLOCK_URL:         notify.WebsiteBlocked,
+	pb.CommandType_NETWORK_ISOLATE:   notify.NetworkIsolated,
+}
+
+// monitorSkippedCommands are the commands that block, delete, kill or
+// isolate. In monitor mode they are acknowledged without being run.
+var monitorSkippedCommands = map[pb.CommandType]bool{
+	pb.CommandType_DELETE_FILE:       true,
+	pb.CommandType_KILL_PROCESS:      true,
+	pb.CommandType_KILL_PROCESS_TREE: true,
+	pb.CommandType_KILL_BY_HASH:      true,
+	pb.CommandType_SUSPEND_PROCESS:   true,
+	pb.CommandType_BLOCK_IP:          true,
+	pb.CommandType_BLOCK_URL:         true,
+	pb.CommandType_NETWORK_ISOLATE:   true,
+	pb.CommandType_REGISTRY_DELETE:   true,
+}
+
 // CommandHandler handles incoming commands from the server
 type CommandHandler struct {
 	client     *EDRClient
 	iocManager *ioc.Manager
 	scanner    *ioc.Scanner
 	blocker    *blocker.Blocker
+	commands   *commandCache // Recently executed commands, nil if de-duplication is disabled
+	auditLog   *audit.Logger // Tamper-evident record of executed commands, nil if unavailable
+	isolation  *isolationWatchdog // Lifts network isolation if the server stops renewing it
+	journal    *detectionJournal // Every IOC match report and whether the server acknowledged it
+	runner     commandRunner     // Starts tasklist, taskkill and netsh
+	goos       string            // Operating system whose process tools runner starts
+	notifier   *notify.Notifier  // Tells the logged-on user about remediation actions
+	enricher   Enricher          // Adds reputation context to IOC match reports
+	dedup      *reportDeduper    // Holds back repeated reports of the same IOC
+	noBatching int32             // Set once the server turns out not to support ReportIOCMatches
+	
+	dbMu       sync.Mutex
+	dbProblems map[string]string // Why each database file did not load cleanly, by file name
+
+	inflight   sync.WaitGroup // HandleCommand calls still executing
+	drainMu    sync.Mutex     // Guards draining and running
+	draining   bool           // Set on shutdown; new commands are refused
+	running    int            // Number of HandleCommand calls in inflight
 }
 
 // NewCommandHandler creates a new command handler
 func NewCommandHandler(client *EDRClient) *CommandHandler {
 	// Create IOC manager
-	iocManager := ioc.NewManager(filepath.Join(client.dataDir, "iocs"))
+	iocManager := ioc.NewManager(filepath.Join(client.dataDir, "iocs"), client.integrity)
+	
+	// Databases that did not load cleanly; the agent is not protected until
+	// the server has sent a full IOC snapshot again
+	dbProblems := make(map[string]string)
 	
 	// Load existing IOCs
 	if err := iocManager.LoadFromFile(); err != nil {
 		log.Printf("Warning: failed to load IOCs: %v", err)
+		dbProblems["iocs.json"] = err.Error()
+		
+		// A tampered or corrupt database is discarded; starting from version 0
+		// makes the startup IOC request accept a fresh full copy from the server
+		if errors.Is(err, persist.ErrTampered) {
+			log.Printf("IOC database discarded, a fresh copy will be requested from the server")
+			client.SendEvent(pb.AgentEventType_TAMPER_DETECTED,
+				"IOC database failed integrity check and was discarded",
+				map[string]string{"file": "iocs.json", "error": err.Error()})
+		} else if errors.Is(err, persist.ErrCorrupt) {
+			log.Printf("IOC database discarded, a fresh copy will be requested from the server")
+			client.SendEvent(pb.AgentEventType_DATABASE_CORRUPT,
+				"IOC database and its backup are corrupt and were discarded",
+				map[string]string{"file": "iocs.json", "error": err.Error()})
+		}
 	}
 	
 	// Create blocker instance
-	blockerInstance := blocker.NewBlocker(client.config, client.dataDir)
+	blockerInstance := blocker.NewBlocker(client.config, client.dataDir, client.integrity)
+	if blockerInstance.Tampered() {
+		dbProblems["blocked_items.json"] = persist.ErrTampered.Error()
+		client.SendEvent(pb.AgentEventType_TAMPER_DETECTED,
+			"Blocked items database failed integrity check and was discarded",
+			map[string]string{"file": "blocked_items.json"})
+	}
+	if err := blockerInstance.Corrupt(); err != nil {
+		dbProblems["blocked_items.json"] = err.Error()
+		client.SendEvent(pb.AgentEventType_DATABASE_CORRUPT,
+			"Blocked items database and its backup are corrupt and were discarded",
+			map[string]string{"file": "blocked_items.json", "error": err.Error()})
+	}
+	// Earlier versions kept the scanner's blocks in a separate list
+	blockerInstance.ImportScannerBlocks(filepath.Join(client.dataDir, "iocs"))
+	
+	// Remember recent command results so re-sent commands are not executed twice
+	var commands *commandCache
+	if retention := client.config.GetCommandDedupRetentionDuration(); retention > 0 {
+		commands = newCommandCache(filepath.Join(client.dataDir, "command_results.json"), retention)
+	}
+	
+	// Record every executed command in the audit log
+	auditLog, err := audit.Open(filepath.Join(client.dataDir, "audit.log"), client.keys)
+	if err != nil {
+		log.Printf("Warning: failed to open audit log, commands will not be audited: %v", err)
+	} else if err := auditLog.Tampered(); err != nil {
+		log.Printf("SECURITY WARNING: Audit log was damaged: %v", err)
+		client.SendEvent(pb.AgentEventType_TAMPER_DETECTED,
+			"Command audit log was damaged and has been repaired",
+			map[string]string{"file": "audit.log", "error": err.Error()})
+	}
 	
-	return &CommandHandler{
+	h := &CommandHandler{
 		client:     client,
 		iocManager: iocManager,
 		blocker:    blockerInstance,
+		commands:   commands,
+		auditLog:   auditLog,
+		runner:     execRunner{},
+		goos:       runtime.GOOS,
+		notifier:   notify.New(client.config),
+		enricher:   newEnricher(client.config),
+		dedup:      newReportDeduper(),
+		journal:    newDetectionJournal(filepath.Join(client.dataDir, "detections.jsonl"), filepath.Join(client.dataDir, "pending_reports")),
+		dbProblems: dbProblems,
 	}
+	if len(dbProblems) > 0 {
+		log.Printf("WARNING: Agent is not fully protected until the server sends a full IOC update")
+	}
+	
+	if allowed := client.config.Current().AllowedCommands; len(allowed) > 0 {
+		log.Printf("Command allowlist active, only these commands will run: %s", strings.Join(allowed, ", "))
+	}
+	
+	// Pick up an isolation that was active when the agent last stopped
+	h.isolation = newIsolationWatchdog(filepath.Join(client.dataDir, "isolation.json"), h.handleIsolationExpired)
+	h.isolation.resume()
+	
+	return h
 }
 
-// HandleCommand processes a command and returns the result
+// HandleCommand processes a command and returns the result. A command whose ID
+// was already handled within the de-duplication window is not executed again;
+// the original result is returned instead.
 func (h *CommandHandler) HandleCommand(ctx context.Context, cmd *pb.Command) *pb.CommandResult {
+	return h.handleCommandInSlot(ctx, cmd, func() {})
+}
+
+// handleCommandInSlot is HandleCommand for a command holding a slot of
+// max_concurrent_commands. release frees the slot once the command's handler
+// has returned, which for a command that timed out may be after its result.
+func (h *CommandHandler) handleCommandInSlot(ctx context.Context, cmd *pb.Command, release func()) *pb.CommandResult {
+	if !h.beginCommand() {
+		release()
+		log.Printf("Command %s of type %s refused, agent is shutting down", cmd.CommandId, cmd.Type.String())
+		return &pb.CommandResult{
+			CommandId:     cmd.CommandId,
+			AgentId:       cmd.AgentId,
+			ExecutionTime: time.Now().Unix(),
+			Success:       false,
+			Message:       "Error: agent is shutting down, command not accepted",
+		}
+	}
+	
+	result, running := h.runCommand(ctx, cmd)
+	if running == nil {
+		h.endCommand()
+		release()
+		return result
+	}
+	
+	// The handler still counts against Drain and the concurrency limit
+	go func() {
+		<-running
+		log.Printf("Command %s finished after its timeout", cmd.CommandId)
+		h.endCommand()
+		release()
+	}()
+	return result
+}
+
+// runCommand executes a command unless its ID was already handled. running
+// is closed when the handler returns, or nil if it already has.
+func (h *CommandHandler) runCommand(ctx context.Context, cmd *pb.Command) (*pb.CommandResult, <-chan struct{}) {
+	if h.commands == nil || cmd.CommandId == "" {
+		result, running := h.executeCommand(ctx, cmd)
+		h.writeAudit(cmd, result)
+		return result, running
+	}
+	
+	entry, duplicate := h.commands.start(cmd.CommandId)
+	if duplicate {
+		log.Printf("Command %s was already received, returning previous result", cmd.CommandId)
+		result, err := entry.wait(ctx)
+		if err != nil {
+			return &pb.CommandResult{
+				CommandId:     cmd.CommandId,
+				AgentId:       cmd.AgentId,
+				ExecutionTime: time.Now().Unix(),
+				Success:       false,
+				Message:       fmt.Sprintf("Error: duplicate command, original result unavailable: %v", err),
+			}, nil
+		}
+		return result, nil
+	}
+	
+	result, running := h.executeCommand(ctx, cmd)
+	h.commands.finish(cmd.CommandId, result)
+	h.writeAudit(cmd, result)
+	return result, running
+}
+
+// beginCommand registers a command as in flight. It returns false once
+// Drain has been called, in which case the command must not run.
+func (h *CommandHandler) beginCommand() bool {
+	h.drainMu.Lock()
+	defer h.drainMu.Unlock()
+	
+	if h.draining {
+		return false
+	}
+	h.running++
+	h.inflight.Add(1)
+	return true
+}
+
+// endCommand marks a command started by beginCommand as finished
+func (h *CommandHandler) endCommand() {
+	h.drainMu.Lock()
+	h.running--
+	h.drainMu.Unlock()
+	h.inflight.Done()
+}
+
+// Drain stops accepting new commands and waits up to timeout for the ones
+// already executing to finish, so shutdown does not interrupt a half-written
+// hosts file or half-applied firewall rule. It returns the number of
+// commands still running when it gave up, 0 if all of them finished.
+func (h *CommandHandler) Drain(timeout time.Duration) int {
+	h.drainMu.Lock()
+	h.draining = true
+	running := h.running
+	h.drainMu.Unlock()
+	
+	if running == 0 {
+		return 0
+	}
+	log.Printf("Waiting up to %v for %d running commands to finish", timeout, running)
+	
+	done := make(chan struct{})
+	go func() {
+		h.inflight.Wait()
+		close(done)
+	}()
+	
+	select {
+	case <-done:
+		return 0
+	case <-time.After(timeout):
+		h.drainMu.Lock()
+		defer h.drainMu.Unlock()
+		return h.running
+	}
+}
+
+// writeAudit appends an executed command and its result to the audit log
+func (h *CommandHandler) writeAudit(cmd *pb.Command, result *pb.CommandResult) {
+	if h.auditLog == nil {
+		return
+	}
+	
+	err := h.auditLog.Log(audit.Entry{
+		CommandID:  cmd.CommandId,
+		Type:       cmd.Type.String(),
+		Params:     cmd.Params,
+		Success:    result.Success,
+		Message:    result.Message,
+		DurationMs: result.DurationMs,
+	})
+	if err != nil {
+		log.Printf("Failed to write audit entry for command %s: %v", cmd.CommandId, err)
+	}
+}
+
+// executeCommand runs a command and returns the result. If the handler is
+// still running after it timed out, running is closed when it returns.
+func (h *CommandHandler) executeCommand(ctx context.Context, cmd *pb.Command) (*pb.CommandResult, <-chan struct{}) {
 	startTime := time.Now()
 	
 
@@ -58,49 +364,227 @@ func (h *CommandHandler) HandleCommand(ctx context.Context, cmd *pb.Command) *pb
 		Message:       "",
 	}
 
-	var err error
+	log.Printf("Processing command %s of type %s", cmd.CommandId, cmd.Type.String())
+	logging.Debug().
+		Str("command_id", cmd.CommandId).
+		Interface("params", logging.RedactParams(cmd.Params)).
+		Msg("Command parameters")
+
+	// Only command types on the allowed_commands list are run, so a
+	// compromised server cannot use the others on this agent
+	if !h.client.config.CommandAllowed(cmd.Type.String()) {
+		result.DurationMs = time.Since(startTime).Milliseconds()
+		result.Message = fmt.Sprintf("Error: command denied, %s is not in this agent's allowed_commands", cmd.Type.String())
+		log.Printf("SECURITY: Denied command %s of type %s, not in allowed_commands", cmd.CommandId, cmd.Type.String())
+		return result, nil
+	}
+	
+	// A dry run of a command that cannot rehearse must not fall through to
+	// really running it
+	if err := validateDryRun(cmd); err != nil {
+		result.DurationMs = time.Since(startTime).Milliseconds()
+		result.Message = fmt.Sprintf("Error: %v", err)
+		log.Printf("Command %s rejected: %v", cmd.CommandId, err)
+		return result, nil
+	}
+	
+	// Monitor mode detects without remediating, so commands that would
+	// change the endpoint succeed without doing anything. Dry runs still run.
+	if monitorSkippedCommands[cmd.Type] && !isDryRun(cmd.Params) && h.client.config.MonitorOnly() {
+		result.DurationMs = time.Since(startTime).Milliseconds()
+		result.Success = true
+		result.Message = fmt.Sprintf("Skipped (monitor mode): %s was not run", cmd.Type.String())
+		log.Printf("Command %s of type %s skipped, agent is in monitor mode", cmd.CommandId, cmd.Type.String())
+		return result, nil
+	}
+	
+	// Fail fast instead of letting netsh, taskkill or a hosts file write
+	// fail partway through. A dry run changes nothing, so it still runs.
+	if elevatedCommands[cmd.Type] && !isDryRun(cmd.Params) && !privilege.IsElevated() {
+		result.DurationMs = time.Since(startTime).Milliseconds()
+		result.Message = fmt.Sprintf("Error: %s requires elevated privileges, which this agent does not have; %s",
+			cmd.Type.String(), privilege.Requirement())
+		log.Printf("Command %s rejected: %s requires elevated privileges", cmd.CommandId, cmd.Type.String())
+		return result, nil
+	}
+
+	// Run the handler under the command timeout. The tools it started with
+	// exec.CommandContext are killed on timeout; a handler that does not
+	// watch ctx is waited for up to handlerStopTimeout, then reported as
+	// still running.
+	timeout, err := commandTimeout(cmd, h.client.config.GetCommandTimeoutDuration())
+	if err != nil {
+		result.DurationMs = time.Since(startTime).Milliseconds()
+		result.Message = fmt.Sprintf("Error: %v", err)
+		log.Printf("Command %s rejected: %v", cmd.CommandId, err)
+		return result, nil
+	}
+	
+	var cmdCtx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		cmdCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		cmdCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+	
+	type handlerResult struct {
+		message string
+		err     error
+	}
+	done := make(chan handlerResult, 1)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		message, err := h.runHandler(cmdCtx, cmd)
+		done <- handlerResult{message, err}
+	}()
+	
 	var message string
+	var running <-chan struct{}
+	select {
+	case r := <-done:
+		message, err = r.message, r.err
+	case <-cmdCtx.Done():
+		select {
+		case r := <-done:
+			message, err = r.message, r.err
+		case <-time.After(handlerStopTimeout):
+			err = cmdCtx.Err()
+			running = stopped
+		}
+	}
+	if err != nil && errors.Is(cmdCtx.Err(), context.DeadlineExceeded) && !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("command timed out after %v", timeout)
+	}
+	if running != nil {
+		err = fmt.Errorf("%v; it is still running and its outcome is unknown", err)
+	}
 
-	log.Printf("Processing command %s of type %s", cmd.CommandId, cmd.Type.String())
+	// Set result fields
+	result.DurationMs = time.Since(startTime).Milliseconds()
+	
+	if err != nil {
+		result.Success = false
+		result.Message = fmt.Sprintf("Error: %v", err)
+		log.Printf("Command %s failed: %v", cmd.CommandId, err)
+	} else {
+		result.Success = true
+		result.Message = message
+		log.Printf("Command %s completed successfully: %s", cmd.CommandId, message)
+		
+		if action, ok := userNotifications[cmd.Type]; ok && !isDryRun(cmd.Params) {
+			h.notifier.Notify(action)
+		}
+	}
 
-	// Execute command based on type
+	return result, running
+}
+
+// runHandler dispatches a command to the handler for its type
+func (h *CommandHandler) runHandler(ctx context.Context, cmd *pb.Command) (string, error) {
 	switch cmd.Type {
 	case pb.CommandType_DELETE_FILE:
-		message, err = h.handleDeleteFile(cmd.Params)
+		return h.handleDeleteFile(cmd.Params)
 	case pb.CommandType_KILL_PROCESS:
-		message, err = h.handleKillProcess(cmd.Params)
+		return h.handleKillProcess(ctx, cmd.Params)
 	case pb.CommandType_KILL_PROCESS_TREE:
-		message, err = h.handleKillProcessTree(cmd.Params)
+		return h.handleKillProcessTree(ctx, cmd.Params)
+	case pb.CommandType_SUSPEND_PROCESS:
+		return h.handleSuspendProcess(ctx, cmd.Params)
+	case pb.CommandType_RESUME_PROCESS:
+		return h.handleResumeProcess(ctx, cmd.Params)
+	case pb.CommandType_REGISTRY_DELETE:
+		return h.handleRegistryDelete(cmd.Params)
+	case pb.CommandType_REGISTRY_SET:
+		return h.handleRegistrySet(cmd.Params)
+	case pb.CommandType_GET_PROCESS_LIST:
+		return h.handleGetProcessList(ctx, cmd.Params)
+	case pb.CommandType_SELF_UPDATE:
+		return h.handleSelfUpdate(ctx, cmd.Params)
+	case pb.CommandType_COLLECT_FILE:
+		return h.handleCollectFile(ctx, cmd.CommandId, cmd.Params)
+	case pb.CommandType_GET_FILE_INFO:
+		return h.handleGetFileInfo(cmd.Params)
+	case pb.CommandType_LIST_BLOCKS:
+		return h.handleListBlocks(ctx, cmd.Params)
+	case pb.CommandType_COLLECT_EVENT_LOG:
+		return h.handleCollectEventLog(cmd.Params)
+	case pb.CommandType_KILL_BY_HASH:
+		return h.handleKillByHash(ctx, cmd.Params)
+	case pb.CommandType_UPDATE_CONFIG:
+		return h.handleUpdateConfig(cmd.Params)
+	case pb.CommandType_FIM_REBASELINE:
+		return h.handleFIMRebaseline(cmd.Params)
+	case pb.CommandType_SCAN_MEMORY:
+		return h.handleScanMemory(ctx, cmd.Params)
+	case pb.CommandType_GET_NETWORK_CONNECTIONS:
+		return h.handleGetNetworkConnections(ctx, cmd.Params)
+	case pb.CommandType_SET_LOG_LEVEL:
+		return h.handleSetLogLevel(cmd.Params)
 	case pb.CommandType_BLOCK_IP:
-		message, err = h.handleBlockIP(cmd.Params)
+		return h.handleBlockIP(ctx, cmd.Params)
 	case pb.CommandType_BLOCK_URL:
-		message, err = h.handleBlockURL(cmd.Params)
+		return h.handleBlockURL(cmd.Params)
+	case pb.CommandType_UNBLOCK_IP:
+		return h.handleUnblockIP(ctx, cmd.Params)
+	case pb.CommandType_UNBLOCK_URL:
+		return h.handleUnblockURL(cmd.Params)
+	case pb.CommandType_SCAN_PATH:
+		return h.handleScanPath(ctx, cmd.Params)
 	case pb.CommandType_NETWORK_ISOLATE:
-		message, err = h.handleNetworkIsolate(cmd.Params)
+		return h.handleNetworkIsolate(ctx, cmd.Params)
 	case pb.CommandType_NETWORK_RESTORE:
-		message, err = h.handleNetworkRestore(cmd.Params)
+		return h.handleNetworkRestore(ctx, cmd.Params)
+	case pb.CommandType_NETWORK_ISOLATE_RENEW:
+		return h.handleNetworkIsolateRenew(cmd.Params)
 	case pb.CommandType_UPDATE_IOCS:
 		// Updates now come directly through the command stream
-		message = "UPDATE_IOCS command acknowledged. IOC data will be received through the command stream."
+		return "UPDATE_IOCS command acknowledged. IOC data will be received through the command stream.", nil
 	default:
 		log.Printf("ERROR: Unknown command type: %d (%s)", int(cmd.Type), cmd.Type.String())
-		err = fmt.Errorf("unknown command type: %s", cmd.Type.String())
+		return "", fmt.Errorf("unknown command type: %s", cmd.Type.String())
 	}
+}
 
-	// Set result fields
-	result.DurationMs = time.Since(startTime).Milliseconds()
-	
+// commandTimeout returns how long a command may run: its timeout_seconds
+// parameter if set, otherwise the configured command_timeout. 0 means no
+// limit.
+func commandTimeout(cmd *pb.Command, configured time.Duration) (time.Duration, error) {
+	value, ok := cmd.Params["timeout_seconds"]
+	if !ok {
+		return configured, nil
+	}
+	seconds, err := strconv.Atoi(value)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid timeout_seconds parameter: %s", value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
+
+// validateDryRun checks the dry_run parameter is a boolean and is only set
+// to true on commands that support it
+func validateDryRun(cmd *pb.Command) error {
+	value, ok := cmd.Params["dry_run"]
+	if !ok {
+		return nil
+	}
+	dryRun, err := strconv.ParseBool(value)
 	if err != nil {
-		result.Success = false
-		result.Message = fmt.Sprintf("Error: %v", err)
-		log.Printf("Command %s failed: %v", cmd.CommandId, err)
-	} else {
-		result.Success = true
-		result.Message = message
-		log.Printf("Command %s completed successfully: %s", cmd.CommandId, message)
+		return fmt.Errorf("invalid dry_run parameter: %s", value)
 	}
+	if dryRun && !dryRunCommands[cmd.Type] {
+		return fmt.Errorf("%s does not support dry_run", cmd.Type.String())
+	}
+	return nil
+}
 
-	return result
+// isDryRun reports whether the command should only describe what it would
+// do. The parameter has already been checked by validateDryRun.
+func isDryRun(params map[string]string) bool {
+	dryRun, _ := strconv.ParseBool(params["dry_run"])
+	return dryRun
 }
 
 // GetIOCManager returns the IOC manager instance
@@ -111,6 +595,45 @@ func (h *CommandHandler) GetIOCManager() *ioc.Manager {
 // SetScanner sets the IOC scanner instance
 func (h *CommandHandler) SetScanner(scanner *ioc.Scanner) {
 	h.scanner = scanner
+	scanner.SetFileCollector(h.collectFile)
+	scanner.SetReportBatching(h.BatchReports)
+	scanner.SetSensorReporter(h.reportSensorStatus)
+	scanner.SetNotifier(h.notifier)
+	scanner.SetBlockReporter(h.reportIPBlocking)
+	scanner.FIM().SetReporter(h.reportFIMChange)
+}
+
+// reportSensorStatus tells the server when Sysmon telemetry is lost or back
+func (h *CommandHandler) reportSensorStatus(degraded bool, reason string) {
+	details := map[string]string{"sensor": "sysmon"}
+	if degraded {
+		h.client.SendEvent(pb.AgentEventType_SENSOR_DEGRADED, reason, details)
+		return
+	}
+	h.client.SendEvent(pb.AgentEventType_SENSOR_RESTORED, "Sysmon telemetry is available again", details)
+}
+
+// reportIPBlocking tells the server how many IOC IPs were blocked at startup
+// and which ones could not be
+func (h *CommandHandler) reportIPBlocking(blocked, total int, failed []string) {
+	h.client.SendEvent(pb.AgentEventType_IP_BLOCKING_SUMMARY,
+		fmt.Sprintf("%d/%d IPs successfully blocked", blocked, total),
+		map[string]string{
+			"blocked":    strconv.Itoa(blocked),
+			"total":      strconv.Itoa(total),
+			"failed_ips": strings.Join(failed, ","),
+		})
+}
+
+// collectFile uploads a file found by the scanner before it is remediated
+func (h *CommandHandler) collectFile(ctx context.Context, path, reason string) error {
+	_, _, err := h.client.UploadFile(ctx, path, "", reason)
+	return err
+}
+
+// GetBlocker returns the blocker instance
+func (h *CommandHandler) GetBlocker() *blocker.Blocker {
+	return h.blocker
 }
 
 // GetScanner returns the IOC scanner instance
@@ -118,7 +641,8 @@ func (h *CommandHandler) GetScanner() *ioc.Scanner {
 	return h.scanner
 }
 
-// ReportIOCMatch sends an IOC match report to the server
+// ReportIOCMatch records an IOC match in the detection journal and sends the
+// report to the server
 func (h *CommandHandler) ReportIOCMatch(ctx context.Context, iocType pb.IOCType, iocValue string, 
 	matchedValue string, matchContext string, severity string) error {
 	
@@ -129,42 +653,102 @@ func (h *CommandHandler) ReportIOCMatch(ctx context.Context, iocType pb.IOCType,
 	actionSuccess := false
 	actionMessage := ""
 	
-	// Check for specific messages that indicate an action was taken
+	// Check for specific messages that indicate an action was taken. The
+	// process tree is left out, its command lines could contain the markers.
+	actionContext, _, _ := strings.Cut(matchContext, ioc.ProcessTreeHeader)
+	
 	// For IP blocking
-	if iocType == pb.IOCType_IOC_IP && strings.Contains(matchContext, "IP automatically blocked") {
+	if iocType == pb.IOCType_IOC_IP && strings.Contains(actionContext, "IP automatically blocked") {
 		actionTaken = pb.CommandType_BLOCK_IP
 		actionSuccess = true
 		actionMessage = fmt.Sprintf("Successfully blocked IP %s using Windows Firewall", matchedValue)
 	}
 	
 	// For URL blocking
-	if iocType == pb.IOCType_IOC_URL && strings.Contains(matchContext, "URL blocked by adding domain") {
+	if iocType == pb.IOCType_IOC_URL && strings.Contains(actionContext, "URL blocked by adding domain") {
 		actionTaken = pb.CommandType_BLOCK_URL
 		actionSuccess = true
 		actionMessage = fmt.Sprintf("Successfully blocked URL %s", matchedValue)
 	}
 	
-	// For file deletion after hash match
-	if iocType == pb.IOCType_IOC_HASH && strings.Contains(matchContext, "Malicious file") {
-		if strings.Contains(matchContext, "deleted: true") {
+	// For file deletion after hash match, and the processes killed first
+	if iocType == pb.IOCType_IOC_HASH && strings.Contains(actionContext, "Malicious file") {
+		killed := killedProcesses(actionContext)
+		if strings.Contains(actionContext, "deleted: true") {
 			actionTaken = pb.CommandType_DELETE_FILE
 			actionSuccess = true
 			actionMessage = "Successfully deleted malicious file"
+			if killed != "" {
+				actionMessage = fmt.Sprintf("Killed %s and deleted malicious file", killed)
+			}
+		} else if killed != "" {
+			actionTaken = pb.CommandType_KILL_PROCESS
+			actionSuccess = true
+			actionMessage = fmt.Sprintf("Killed %s, malicious file was not deleted", killed)
 		}
 	}
 	
+	// For a running process whose image matched, found after an IOC update
+	if iocType == pb.IOCType_IOC_HASH && strings.HasPrefix(actionContext, "Malicious process: ") {
+		actionTaken = pb.CommandType_KILL_BY_HASH
+		actionSuccess = strings.Contains(actionContext, "killed: true")
+		actionMessage = "Killed process running a malicious image"
+		if !actionSuccess {
+			actionMessage = "Failed to kill process running a malicious image"
+		}
+	}
+	
+	// For a process rule match whose rule kills the child process
+	if iocType == pb.IOCType_IOC_BEHAVIOR && strings.Contains(actionContext, "killed: ") {
+		actionTaken = pb.CommandType_KILL_PROCESS
+		actionSuccess = strings.Contains(actionContext, "killed: true")
+		actionMessage = "Killed process started by a suspicious parent"
+		if !actionSuccess {
+			actionMessage = "Failed to kill process started by a suspicious parent"
+		}
+	}
+
+	// A match that took no action, of an IOC already reported within
+	// report_dedup_window, is only counted. Remediations are always reported.
+	var suppressed int32
+	if actionTaken == pb.CommandType_UNKNOWN {
+		window := h.client.config.GetReportDedupWindowDuration()
+		key := iocType.String() + ":" + iocValue
+		var allowed bool
+		if allowed, suppressed = h.dedup.allow(key, window, time.Now()); !allowed {
+			logging.Debug().Str("ioc", iocValue).Str("type", iocType.String()).Msg("Duplicate IOC match not reported")
+			return nil
+		}
+		if suppressed > 0 {
+			matchContext += fmt.Sprintf("\nSuppressed duplicates: %d more matches since the previous report", suppressed)
+		}
+	}
+
+	// Run the site's own response script for this severity or IOC type, and
+	// add its result to the report
+	if scriptResult := h.runResponseScript(ctx, iocType, iocValue, matchedValue, severity); scriptResult != "" {
+		matchContext += "\n" + scriptResult
+	}
+	
+	// Add reputation context from enrichment_url. Remediation has already
+	// happened, and the lookup is bounded by enrichment_timeout.
+	if enrichment := h.enrich(ctx, iocType, iocValue); enrichment != "" {
+		matchContext += "\n" + enrichment
+	}
+	
 	report := &pb.IOCMatchReport{
-		ReportId:       reportID,
-		AgentId:        h.client.agentID,
-		Timestamp:      time.Now().Unix(),
-		Type:           iocType,
-		IocValue:       iocValue,
-		MatchedValue:   matchedValue,
-		Context:        matchContext,
-		Severity:       severity,
-		ActionTaken:    actionTaken,
-		ActionSuccess:  actionSuccess,
-		ActionMessage:  actionMessage,
+		ReportId:        reportID,
+		AgentId:         h.client.agentID,
+		Timestamp:       time.Now().Unix(),
+		Type:            iocType,
+		IocValue:        iocValue,
+		MatchedValue:    matchedValue,
+		Context:         matchContext,
+		Severity:        severity,
+		ActionTaken:     actionTaken,
+		ActionSuccess:   actionSuccess,
+		ActionMessage:   actionMessage,
+		SuppressedCount: suppressed,
 	}
 	
 	log.Printf("Reporting IOC match: %s - %s (severity: %s)", pb.IOCType_name[int32(iocType)], iocValue, severity)
@@ -172,13 +756,87 @@ func (h *CommandHandler) ReportIOCMatch(ctx context.Context, iocType pb.IOCType,
 		log.Printf("Action reported: %s (success: %v)", pb.CommandType_name[int32(actionTaken)], actionSuccess)
 	}
 	
-	// Send report to server
-	resp, err := h.client.edrClient.ReportIOCMatch(ctx, report)
+	// Journal the detection first so it is kept even if the server is unreachable
+	if jerr := h.journal.record(report); jerr != nil {
+		log.Printf("Failed to journal IOC match report %s: %v", reportID, jerr)
+	}
+	
+	// A match found by a scan is sent with the scan's other matches
+	if batch := reportBatchFrom(ctx); batch != nil {
+		if full := batch.add(report); full != nil {
+			h.sendReportBatch(ctx, full)
+		}
+		return nil
+	}
+	
+	err := h.sendReport(ctx, report)
 	if err != nil {
 		log.Printf("Failed to report IOC match: %v", err)
+	}
+	return h.settleReport(reportID, err)
+}
+
+// settleReport records the outcome of sending a report in the detection
+// journal: acknowledged, kept for retry, or rejected by the server. It
+// returns err.
+func (h *CommandHandler) settleReport(reportID string, err error) error {
+	if err == nil {
+		h.journal.ack(reportID)
+		return nil
+	}
+	if retryableReportError(err) {
+		h.journal.release(reportID)
+		log.Printf("IOC match report %s kept in the detection journal for retry", reportID)
+	} else {
+		h.journal.reject(reportID, err)
+	}
+	return err
+}
+
+// killedProcesses returns the processes listed in a file match context as
+// "killed: 2 [PID 1 ...; PID 2 ...], deleted: ...", or "" if none were killed
+func killedProcesses(actionContext string) string {
+	start := strings.Index(actionContext, "killed: ")
+	end := strings.LastIndex(actionContext, "], deleted: ")
+	if start < 0 || end < start {
+		return ""
+	}
+	count, details, ok := strings.Cut(actionContext[start+len("killed: "):end], " [")
+	if !ok {
+		return ""
+	}
+	if count == "1" {
+		return fmt.Sprintf("1 process (%s)", details)
+	}
+	return fmt.Sprintf("%s processes (%s)", count, details)
+}
+
+// sendReport sends an IOC match report and handles the server's
+// acknowledgement. A report the server does not confirm as received is an
+// error, so it stays unacknowledged in the journal.
+func (h *CommandHandler) sendReport(ctx context.Context, report *pb.IOCMatchReport) error {
+	resp, err := h.client.edrClient.ReportIOCMatch(ctx, report)
+	if err != nil {
 		return err
 	}
-	
+	if !resp.Received {
+		return fmt.Errorf("server did not confirm report %s: %s", report.ReportId, resp.Message)
+	}
+	h.handleReportAck(ctx, report, resp)
+	return nil
+}
+
+// RunReportQueue replays IOC match reports in <dataDir>/detections.jsonl that
+// the server has not acknowledged, including ones left by a previous run,
+// until ctx is cancelled
+func (h *CommandHandler) RunReportQueue(ctx context.Context) {
+	cfg := h.client.config
+	h.journal.run(ctx, cfg.GetReconnectDelayDuration(), cfg.GetMaxReconnectDelayDuration(), h.sendReport)
+}
+
+// handleReportAck logs the server's acknowledgement of a report and runs the
+// additional action the server asked for, if any
+func (h *CommandHandler) handleReportAck(ctx context.Context, report *pb.IOCMatchReport, resp *pb.IOCMatchAck) {
 	log.Printf("IOC match report acknowledged: %s", resp.Message)
 	
 	// Check if server requested additional action
@@ -187,7 +845,7 @@ func (h *CommandHandler) ReportIOCMatch(ctx context.Context, iocType pb.IOCType,
 		
 		// Create a command to execute locally
 		cmd := &pb.Command{
-			CommandId: fmt.Sprintf("%s-auto-%d", reportID, time.Now().UnixNano()),
+			CommandId: fmt.Sprintf("%s-auto-%d", report.ReportId, time.Now().UnixNano()),
 			AgentId:   h.client.agentID,
 			Timestamp: time.Now().Unix(),
 			Type:      resp.AdditionalAction,
@@ -203,13 +861,10 @@ func (h *CommandHandler) ReportIOCMatch(ctx context.Context, iocType pb.IOCType,
 		report.ActionMessage = result.Message
 		
 		// Send updated report
-		_, err = h.client.edrClient.ReportIOCMatch(ctx, report)
-		if err != nil {
+		if _, err := h.client.edrClient.ReportIOCMatch(ctx, report); err != nil {
 			log.Printf("Failed to report IOC action result: %v", err)
 		}
 	}
-	
-	return nil
 }
 
 // handleDeleteFile deletes a file at the specified path
@@ -220,7 +875,7 @@ func (h *CommandHandler) handleDeleteFile(params map[string]string) (string, err
 		return "", fmt.Errorf("missing required parameter 'path'")
 	}
 	
-	log.Printf("Attempting to delete file at path: %s", path)
+	log.Printf("Attempting to delete file at path: %s", logging.RedactParam("path", path))
 	
 	// Check if path is absolute
 	if !filepath.IsAbs(path) {
@@ -229,15 +884,21 @@ func (h *CommandHandler) handleDeleteFile(params map[string]string) (string, err
 		if err != nil {
 			log.Printf("ERROR: Failed to get absolute path: %v", err)
 		} else {
-			log.Printf("INFO: Converted relative path to absolute: %s", absPath)
+			log.Printf("INFO: Converted relative path to absolute: %s", logging.RedactParam("path", absPath))
 			path = absPath
 		}
 	}
 	
+	// Never delete files under protected_paths
+	if h.client.config.IsProtectedPath(path) {
+		log.Printf("WARNING: Refusing to delete %s: path is in protected_paths", logging.RedactParam("path", path))
+		return "", fmt.Errorf("blocked remediation on protected path: %s", path)
+	}
+	
 	// Check if file exists
 	fileInfo, err := os.Stat(path)
 	if os.IsNotExist(err) {
-		log.Printf("ERROR: File not found at path: %s", path)
+		log.Printf("ERROR: File not found at path: %s", logging.RedactParam("path", path))
 		return "", fmt.Errorf("file not found: %s", path)
 	} else if err != nil {
 		log.Printf("ERROR: Failed to check file status: %v", err)
@@ -246,6 +907,10 @@ func (h *CommandHandler) handleDeleteFile(params map[string]string) (string, err
 	
 	log.Printf("File exists, size: %d bytes, isDir: %v", fileInfo.Size(), fileInfo.IsDir())
 	
+	if isDryRun(params) {
+		return fmt.Sprintf("%sWould delete file %s (%d bytes)", dryRunPrefix, path, fileInfo.Size()), nil
+	}
+	
 	// Delete the file
 	err = os.Remove(path)
 	if err != nil {
@@ -253,7 +918,7 @@ func (h *CommandHandler) handleDeleteFile(params map[string]string) (string, err
 		return "", fmt.Errorf("failed to delete file: %v", err)
 	}
 	
-	log.Printf("SUCCESS: File %s deleted successfully", path)
+	log.Printf("SUCCESS: File %s deleted successfully", logging.RedactParam("path", path))
 	return fmt.Sprintf("File %s deleted successfully", path), nil
 }
 
@@ -267,8 +932,88 @@ func getCurrentDirectory() string {
 	return dir
 }
 
-// handleKillProcess kills a process by PID
-func (h *CommandHandler) handleKillProcess(params map[string]string) (string, error) {
+// handleKillProcess kills a process by PID, or every process with the name
+// given in 'process_name'
+func (h *CommandHandler) handleKillProcess(ctx context.Context, params map[string]string) (string, error) {
+	// A process name may match several instances, kill them all
+	if _, hasPid := params["pid"]; !hasPid {
+		if name, ok := params["process_name"]; ok {
+			return h.killProcessesByName(ctx, name, isDryRun(params))
+		}
+	}
+	
+	pid, err := h.resolveProcessID(ctx, params)
+	if err != nil {
+		return "", err
+	}
+
+	if isDryRun(params) {
+		p, err := process.NewProcess(int32(pid))
+		if err != nil {
+			return "", fmt.Errorf("process not found: %v", err)
+		}
+		name, _ := p.Name()
+		return fmt.Sprintf("%sWould kill process %d (%s)", dryRunPrefix, pid, name), nil
+	}
+
+	// Record what is being killed while it can still be read
+	p, err := process.NewProcessWithContext(ctx, int32(pid))
+	if err != nil {
+		return fmt.Sprintf("Process %d has already exited, nothing to kill", pid), nil
+	}
+	description := ioc.DescribeProcess(p)
+
+	// Find the process by PID
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return "", fmt.Errorf("process not found: %v", err)
+	}
+
+	// Kill the process. It may exit on its own between lookup and kill.
+	if err := proc.Kill(); err != nil {
+		if running, _ := process.PidExistsWithContext(ctx, int32(pid)); !running {
+			return fmt.Sprintf("Process %d had already exited before it could be killed (%s)", pid, description), nil
+		}
+		return "", fmt.Errorf("failed to kill process %s: %v", description, err)
+	}
+
+	return fmt.Sprintf("Process %d killed successfully (%s)", pid, description), nil
+}
+
+// handleSuspendProcess suspends all threads of a process so it can be
+// inspected before deciding whether to terminate it
+func (h *CommandHandler) handleSuspendProcess(ctx context.Context, params map[string]string) (string, error) {
+	pid, err := h.resolveProcessID(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	
+	threads, err := setProcessSuspended(pid, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to suspend process: %v", err)
+	}
+	
+	return fmt.Sprintf("Process %d suspended (%d threads suspended)", pid, threads), nil
+}
+
+// handleResumeProcess resumes a process previously suspended with SUSPEND_PROCESS
+func (h *CommandHandler) handleResumeProcess(ctx context.Context, params map[string]string) (string, error) {
+	pid, err := h.resolveProcessID(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	
+	threads, err := setProcessSuspended(pid, false)
+	if err != nil {
+		return "", fmt.Errorf("failed to resume process: %v", err)
+	}
+	
+	return fmt.Sprintf("Process %d resumed (%d threads resumed)", pid, threads), nil
+}
+
+// resolveProcessID gets the target PID from the 'pid' parameter, or looks it
+// up from the 'process_name' parameter
+func (h *CommandHandler) resolveProcessID(ctx context.Context, params map[string]string) (int, error) {
 	// First check if we have a PID
 	pidStr, hasPid := params["pid"]
 	
@@ -276,79 +1021,190 @@ func (h *CommandHandler) handleKillProcess(params map[string]string) (string, er
 	processName, hasProcessName := params["process_name"]
 	
 	if !hasPid && !hasProcessName {
-		return "", fmt.Errorf("missing required parameter: either 'pid' or 'process_name'")
+		return 0, fmt.Errorf("missing required parameter: either 'pid' or 'process_name'")
 	}
 
 	// If we have a process name but no PID, try to find the PID
 	if !hasPid && hasProcessName {
-		log.Printf("Finding PID for process name: %s", processName)
-		pid, err := h.findProcessIDByName(processName)
+		log.Printf("Finding PID for process name: %s", logging.RedactParam("process_name", processName))
+		pid, err := h.findProcessIDByName(ctx, processName)
 		if err != nil {
-			return "", fmt.Errorf("failed to find process %s: %v", processName, err)
+			return 0, fmt.Errorf("failed to find process %s: %v", processName, err)
 		}
 		pidStr = fmt.Sprintf("%d", pid)
-		log.Printf("Found PID %s for process %s", pidStr, processName)
+		log.Printf("Found PID %s for process %s", pidStr, logging.RedactParam("process_name", processName))
 	}
 	
 	// Convert PID to integer
 	pid, err := strconv.Atoi(pidStr)
 	if err != nil {
-		return "", fmt.Errorf("invalid PID format: %v", err)
+		return 0, fmt.Errorf("invalid PID format: %v", err)
 	}
 
-	// Find the process by PID
-	process, err := os.FindProcess(pid)
+	return pid, nil
+}
+
+// processInfo is one entry of the GET_PROCESS_LIST result
+type processInfo struct {
+	PID         int32  `json:"pid"`
+	PPID        int32  `json:"ppid"`
+	Name        string `json:"name"`
+	Executable  string `json:"executable,omitempty"`
+	CommandLine string `json:"command_line,omitempty"`
+	User        string `json:"user,omitempty"`
+	SHA256      string `json:"sha256,omitempty"`
+}
+
+// handleGetProcessList returns the running processes as a JSON array.
+// Executable hashes are only computed when the 'hash' parameter is true.
+func (h *CommandHandler) handleGetProcessList(ctx context.Context, params map[string]string) (string, error) {
+	withHashes := false
+	if hashStr, ok := params["hash"]; ok {
+		v, err := strconv.ParseBool(hashStr)
+		if err != nil {
+			return "", fmt.Errorf("invalid hash parameter: %s", hashStr)
+		}
+		withHashes = v
+	}
+	
+	procs, err := process.ProcessesWithContext(ctx)
 	if err != nil {
-		return "", fmt.Errorf("process not found: %v", err)
+		return "", fmt.Errorf("failed to enumerate processes: %v", err)
+	}
+	
+	// Many processes share an executable, so hash each image once
+	hashes := make(map[string]string)
+	
+	list := make([]processInfo, 0, len(procs))
+	for _, p := range procs {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		
+		// Fields we cannot read (access denied, process exited) are left empty
+		info := processInfo{PID: p.Pid}
+		info.PPID, _ = p.PpidWithContext(ctx)
+		info.Name, _ = p.NameWithContext(ctx)
+		info.Executable, _ = p.ExeWithContext(ctx)
+		info.CommandLine, _ = p.CmdlineWithContext(ctx)
+		info.User, _ = p.UsernameWithContext(ctx)
+		
+		if withHashes && info.Executable != "" {
+			hash, seen := hashes[info.Executable]
+			if !seen {
+				hash, _ = ioc.GetSHA256(info.Executable)
+				hashes[info.Executable] = hash
+			}
+			info.SHA256 = hash
+		}
+		
+		list = append(list, info)
+	}
+	
+	sort.Slice(list, func(i, j int) bool { return list[i].PID < list[j].PID })
+	
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode process list: %v", err)
 	}
+	
+	return string(data), nil
+}
 
-	// Kill the process
-	err = process.Kill()
+// killProcessesByName kills every process with the given image name and
+// reports how many were killed
+func (h *CommandHandler) killProcessesByName(ctx context.Context, name string, dryRun bool) (string, error) {
+	pids, err := h.findProcessIDsByName(ctx, name)
 	if err != nil {
-		return "", fmt.Errorf("failed to kill process: %v", err)
+		return "", fmt.Errorf("failed to find process %s: %v", name, err)
+	}
+	
+	pidList := make([]string, len(pids))
+	for i, pid := range pids {
+		pidList[i] = strconv.Itoa(pid)
+	}
+	
+	if dryRun {
+		return fmt.Sprintf("%sWould kill %d processes named %s (PIDs %s)",
+			dryRunPrefix, len(pids), name, strings.Join(pidList, ", ")), nil
 	}
+	
+	log.Printf("Killing %d processes named %s: %s", len(pids), logging.RedactParam("process_name", name), strings.Join(pidList, ", "))
+	
+	killed := 0
+	var failures []string
+	for _, pid := range pids {
+		p, err := os.FindProcess(pid)
+		if err == nil {
+			err = p.Kill()
+		}
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%d: %v", pid, err))
+			continue
+		}
+		killed++
+	}
+	
+	if killed == 0 {
+		return "", fmt.Errorf("failed to kill any of %d processes named %s: %s", len(pids), name, strings.Join(failures, "; "))
+	}
+	
+	message := fmt.Sprintf("Killed %d of %d processes named %s", killed, len(pids), name)
+	if len(failures) > 0 {
+		message += fmt.Sprintf(" (%d failed: %s)", len(failures), strings.Join(failures, "; "))
+	}
+	return message, nil
+}
 
-	return fmt.Sprintf("Process %d killed successfully", pid), nil
+// findProcessIDByName finds the first process ID with the given image name
+func (h *CommandHandler) findProcessIDByName(ctx context.Context, name string) (int, error) {
+	pids, err := h.findProcessIDsByName(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	return pids[0], nil
 }
 
-// findProcessIDByName finds a process ID by process name
-func (h *CommandHandler) findProcessIDByName(name string) (int, error) {
-	var cmd *exec.Cmd
+// findProcessIDsByName returns the IDs of every process with the given
+// image name, using TASKLIST's CSV output on Windows and ps elsewhere
+func (h *CommandHandler) findProcessIDsByName(ctx context.Context, name string) ([]int, error) {
+	if h.goos != "windows" {
+		return h.findProcessIDsByNamePS(ctx, name)
+	}
 	
-	// Use TASKLIST on Windows
-	cmd = exec.Command("tasklist", "/FI", fmt.Sprintf("IMAGENAME eq %s", name), "/NH", "/FO", "CSV")
+	output, err := h.runner.Run(ctx, "tasklist", "/FI", fmt.Sprintf("IMAGENAME eq %s", name), "/NH", "/FO", "CSV")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute process list command: %v", err)
+	}
 	
-	output, err := cmd.Output()
+	// A proper CSV reader keeps quoted fields containing commas intact. When
+	// nothing matches TASKLIST prints a single unquoted INFO line instead.
+	reader := csv.NewReader(strings.NewReader(string(output)))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	records, err := reader.ReadAll()
 	if err != nil {
-		return 0, fmt.Errorf("failed to execute process list command: %v", err)
+		return nil, fmt.Errorf("failed to parse process list: %v", err)
 	}
 	
-	// Parse Windows TASKLIST output (CSV format)
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, name) {
-			// Parse CSV line
-			parts := strings.Split(line, ",")
-			if len(parts) >= 2 {
-				// Remove quotes from process name and PID
-				processName := strings.Trim(parts[0], "\"")
-				pidStr := strings.Trim(parts[1], "\"")
-				
-				if strings.EqualFold(processName, name) {
-					pid, err := strconv.Atoi(pidStr)
-					if err == nil {
-						return pid, nil
-					}
-				}
-			}
+	var pids []int
+	for _, record := range records {
+		if len(record) < 2 || !strings.EqualFold(strings.TrimSpace(record[0]), name) {
+			continue
+		}
+		if pid, err := strconv.Atoi(strings.TrimSpace(record[1])); err == nil {
+			pids = append(pids, pid)
 		}
 	}
 	
-	return 0, fmt.Errorf("process '%s' not found", name)
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("process '%s' not found", name)
+	}
+	return pids, nil
 }
 
 // handleKillProcessTree kills a process and all its children
-func (h *CommandHandler) handleKillProcessTree(params map[string]string) (string, error) {
+func (h *CommandHandler) handleKillProcessTree(ctx context.Context, params map[string]string) (string, error) {
 	pidStr, ok := params["pid"]
 	if !ok {
 		return "", fmt.Errorf("missing required parameter 'pid'")
@@ -360,10 +1216,12 @@ func (h *CommandHandler) handleKillProcessTree(params map[string]string) (string
 		return "", fmt.Errorf("invalid PID format: %v", err)
 	}
 
-	// Use TASKKILL on Windows with /T flag for tree kill
-	cmd := exec.Command("taskkill", "/F", "/T", "/PID", pidStr)
+	if runtime.GOOS != "windows" {
+		return h.killProcessTreePS(ctx, pid)
+	}
 
-	output, err := cmd.CombinedOutput()
+	// Use TASKKILL on Windows with /T flag for tree kill
+	output, err := h.runner.Run(ctx, "taskkill", "/F", "/T", "/PID", pidStr)
 	if err != nil {
 		return "", fmt.Errorf("failed to kill process tree: %v, output: %s", err, string(output))
 	}
@@ -371,15 +1229,41 @@ func (h *CommandHandler) handleKillProcessTree(params map[string]string) (string
 	return fmt.Sprintf("Process tree for PID %d killed successfully", pid), nil
 }
 
+// handleCollectFile uploads a file to the server so analysts get the sample
+func (h *CommandHandler) handleCollectFile(ctx context.Context, commandID string, params map[string]string) (string, error) {
+	path, ok := params["path"]
+	if !ok || path == "" {
+		return "", fmt.Errorf("missing required parameter 'path'")
+	}
+
+	hash, size, err := h.client.UploadFile(ctx, path, commandID, "COLLECT_FILE command")
+	if err != nil {
+		return "", fmt.Errorf("failed to collect file %s: %v", path, err)
+	}
+
+	return fmt.Sprintf("File %s collected (%d bytes, sha256 %s)", path, size, hash), nil
+}
+
 // handleBlockIP blocks an IP address
-func (h *CommandHandler) handleBlockIP(params map[string]string) (string, error) {
+func (h *CommandHandler) handleBlockIP(ctx context.Context, params map[string]string) (string, error) {
 	ip, ok := params["ip"]
 	if !ok {
 		return "", fmt.Errorf("missing required parameter 'ip'")
 	}
+	ip = ioc.NormalizeIP(ip)
+	if _, _, err := net.ParseCIDR(ip); err != nil && net.ParseIP(ip) == nil {
+		return "", fmt.Errorf("invalid IP address or CIDR range: %s", ip)
+	}
+
+	if isDryRun(params) {
+		if h.blocker.IsIPBlocked(ip) {
+			return fmt.Sprintf("%sIP %s is already blocked, nothing would change", dryRunPrefix, ip), nil
+		}
+		return fmt.Sprintf("%sWould block IP %s (inbound and outbound)", dryRunPrefix, ip), nil
+	}
 
 	// Use the centralized blocker
-	err := h.blocker.BlockIP(ip)
+	err := h.blocker.BlockIP(ctx, ip, blocker.SourceCommand)
 	if err != nil {
 		return "", fmt.Errorf("failed to block IP %s: %v", ip, err)
 	}
@@ -394,8 +1278,19 @@ func (h *CommandHandler) handleBlockURL(params map[string]string) (string, error
 		return "", fmt.Errorf("missing required parameter 'url'")
 	}
 
+	if isDryRun(params) {
+		domain := h.blocker.ExtractDomain(url)
+		if domain == "" {
+			return "", fmt.Errorf("failed to extract domain from URL: %s", url)
+		}
+		if h.blocker.IsURLBlocked(url) {
+			return fmt.Sprintf("%sURL %s is already blocked, nothing would change", dryRunPrefix, url), nil
+		}
+		return fmt.Sprintf("%sWould block URL %s by adding domain %s to the %s", dryRunPrefix, url, domain, h.blocker.URLBlockTarget()), nil
+	}
+
 	// Use the centralized blocker
-	err := h.blocker.BlockURL(url)
+	err := h.blocker.BlockURL(url, blocker.SourceCommand)
 	if err != nil {
 		return "", fmt.Errorf("failed to block URL %s: %v", url, err)
 	}
@@ -403,95 +1298,492 @@ func (h *CommandHandler) handleBlockURL(params map[string]string) (string, error
 	return fmt.Sprintf("URL %s blocked successfully", url), nil
 }
 
-// handleNetworkIsolate isolates the host from the network
-func (h *CommandHandler) handleNetworkIsolate(params map[string]string) (string, error) {
-	allowedIPs := params["allowed_ips"]
+// handleUnblockIP removes the block for an IP address
+func (h *CommandHandler) handleUnblockIP(ctx context.Context, params map[string]string) (string, error) {
+	ip, ok := params["ip"]
+	if !ok {
+		return "", fmt.Errorf("missing required parameter 'ip'")
+	}
+	ip = ioc.NormalizeIP(ip)
+
+	// Use the centralized blocker
+	err := h.blocker.UnblockIP(ctx, ip, blocker.SourceCommand)
+	if err != nil {
+		return "", fmt.Errorf("failed to unblock IP %s: %v", ip, err)
+	}
+
+	return fmt.Sprintf("IP %s unblocked successfully (inbound and outbound); the scanner will not block it again until a BLOCK_IP command does", ip), nil
+}
+
+// handleUnblockURL removes the block for a URL
+func (h *CommandHandler) handleUnblockURL(params map[string]string) (string, error) {
+	url, ok := params["url"]
+	if !ok {
+		return "", fmt.Errorf("missing required parameter 'url'")
+	}
+
+	// Use the centralized blocker
+	err := h.blocker.UnblockURL(url, blocker.SourceCommand)
+	if err != nil {
+		return "", fmt.Errorf("failed to unblock URL %s: %v", url, err)
+	}
+
+	return fmt.Sprintf("URL %s unblocked successfully; the scanner will not block it again until a BLOCK_URL command does", url), nil
+}
+
+// handleScanPath hashes every file under a directory and remediates IOC matches
+func (h *CommandHandler) handleScanPath(ctx context.Context, params map[string]string) (string, error) {
+	path, ok := params["path"]
+	if !ok || path == "" {
+		return "", fmt.Errorf("missing required parameter 'path'")
+	}
 	
-	// Always ensure the server IP is in allowed IPs
-	serverIP := h.client.serverAddress
-	if serverIP != "" {
-		// Extract IP from server address (remove port)
-		if strings.Contains(serverIP, ":") {
-			serverIP = strings.Split(serverIP, ":")[0]
+	maxDepth := 0
+	if depthStr, ok := params["max_depth"]; ok && depthStr != "" {
+		depth, err := strconv.Atoi(depthStr)
+		if err != nil || depth < 0 {
+			return "", fmt.Errorf("invalid max_depth: %s", depthStr)
 		}
-		
-		if allowedIPs == "" {
-			allowedIPs = serverIP
-		} else if !strings.Contains(allowedIPs, serverIP) {
-			allowedIPs = allowedIPs + "," + serverIP
+		maxDepth = depth
+	}
+	
+	if h.scanner == nil {
+		return "", fmt.Errorf("IOC scanner not available")
+	}
+	
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("cannot access path %s: %v", path, err)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("path %s is not a directory", path)
+	}
+	
+	result, err := h.scanner.ScanDirectory(ctx, path, maxDepth)
+	if err != nil {
+		return "", err
+	}
+	
+	return fmt.Sprintf("Scanned %s: %d files hashed, %d IOC matches, %d YARA matches, %d excluded, %d errors in %v",
+		result.Root, result.FilesScanned, result.Matches, result.YaraMatches, result.Skipped, result.Errors, result.Duration.Round(time.Millisecond)), nil
+}
+
+// protectedRegistryKeys may never be deleted, nor any key above them
+var protectedRegistryKeys = []string{
+	`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\Run`,
+	`HKLM\SOFTWARE\Microsoft\Windows\CurrentVersion\RunOnce`,
+	`HKLM\SOFTWARE\Microsoft\Windows NT\CurrentVersion\Winlogon`,
+	`HKLM\SOFTWARE\Classes`,
+	`HKLM\SYSTEM\CurrentControlSet\Services`,
+	`HKCU\Software\Microsoft\Windows\CurrentVersion\Run`,
+	`HKCU\Software\Microsoft\Windows\CurrentVersion\RunOnce`,
+}
+
+// protectedRegistryTrees may not be modified at all
+var protectedRegistryTrees = []string{
+	`HKLM\SAM`,
+	`HKLM\SECURITY`,
+	`HKLM\BCD00000000`,
+	`HKLM\SYSTEM\CurrentControlSet\Control`,
+}
+
+// protectedRegistryValues may be overwritten but not deleted
+var protectedRegistryValues = map[string][]string{
+	`HKLM\SOFTWARE\Microsoft\Windows NT\CurrentVersion\Winlogon`: {"Shell", "Userinit"},
+}
+
+// handleRegistryDelete deletes a registry value, or the key itself when no value_name is given
+func (h *CommandHandler) handleRegistryDelete(params map[string]string) (string, error) {
+	hive, path, err := registryTarget(params)
+	if err != nil {
+		return "", err
+	}
+	valueName, hasValue := params["value_name"]
+	
+	if err := checkRegistryProtected(hive, path, valueName, hasValue, true); err != nil {
+		return "", err
+	}
+	
+	if hasValue {
+		if err := deleteRegistryValue(hive, path, valueName); err != nil {
+			return "", err
 		}
+		return fmt.Sprintf("Registry value %s\\%s\\%s deleted successfully", hive, path, valueName), nil
+	}
+	
+	if err := deleteRegistryKey(hive, path); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Registry key %s\\%s deleted successfully", hive, path), nil
+}
+
+// handleRegistrySet creates or overwrites a registry value
+func (h *CommandHandler) handleRegistrySet(params map[string]string) (string, error) {
+	hive, path, err := registryTarget(params)
+	if err != nil {
+		return "", err
+	}
+	
+	valueName, ok := params["value_name"]
+	if !ok {
+		return "", fmt.Errorf("missing required parameter 'value_name'")
 	}
+	valueType, ok := params["value_type"]
+	if !ok || valueType == "" {
+		return "", fmt.Errorf("missing required parameter 'value_type'")
+	}
+	valueData, ok := params["value_data"]
+	if !ok {
+		return "", fmt.Errorf("missing required parameter 'value_data'")
+	}
+	
+	if err := checkRegistryProtected(hive, path, valueName, true, false); err != nil {
+		return "", err
+	}
+	
+	if err := setRegistryValue(hive, path, valueName, valueType, valueData); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Registry value %s\\%s\\%s set (%s)", hive, path, valueName, strings.ToUpper(valueType)), nil
+}
 
-	log.Printf("Network isolation: allowing IPs: %s", allowedIPs)
+// registryTarget reads and normalizes the hive and path parameters
+func registryTarget(params map[string]string) (string, string, error) {
+	hiveParam, ok := params["hive"]
+	if !ok {
+		return "", "", fmt.Errorf("missing required parameter 'hive'")
+	}
+	path, ok := params["path"]
+	if !ok {
+		return "", "", fmt.Errorf("missing required parameter 'path'")
+	}
+	
+	var hive string
+	switch strings.ToUpper(hiveParam) {
+	case "HKLM", "HKEY_LOCAL_MACHINE":
+		hive = "HKLM"
+	case "HKCU", "HKEY_CURRENT_USER":
+		hive = "HKCU"
+	case "HKCR", "HKEY_CLASSES_ROOT":
+		hive = "HKCR"
+	case "HKU", "HKEY_USERS":
+		hive = "HKU"
+	case "HKCC", "HKEY_CURRENT_CONFIG":
+		hive = "HKCC"
+	default:
+		return "", "", fmt.Errorf("unknown registry hive: %s", hiveParam)
+	}
+	
+	path = strings.Trim(strings.ReplaceAll(path, "/", `\`), `\`)
+	if path == "" {
+		return "", "", fmt.Errorf("registry path cannot be empty")
+	}
+	
+	return hive, path, nil
+}
 
-	// FIRST: Add exception rules for allowed IPs BEFORE blocking all traffic
-	if allowedIPs != "" {
-		allowedIPList := strings.Split(allowedIPs, ",")
-		for _, ip := range allowedIPList {
-			ip = strings.TrimSpace(ip)
-			if ip == "" {
+// checkRegistryProtected refuses changes that could leave the system unbootable
+func checkRegistryProtected(hive, path, valueName string, hasValue, deleting bool) error {
+	full := strings.ToUpper(hive + `\` + path)
+	
+	// ControlSet001, ControlSet002, ... are the backing stores of CurrentControlSet
+	if strings.HasPrefix(full, `HKLM\SYSTEM\CONTROLSET`) {
+		if idx := strings.Index(full[len(`HKLM\SYSTEM\`):], `\`); idx >= 0 {
+			full = `HKLM\SYSTEM\CURRENTCONTROLSET` + full[len(`HKLM\SYSTEM\`)+idx:]
+		} else {
+			full = `HKLM\SYSTEM\CURRENTCONTROLSET`
+		}
+	}
+	
+	for _, tree := range protectedRegistryTrees {
+		tree = strings.ToUpper(tree)
+		if full == tree || strings.HasPrefix(full, tree+`\`) {
+			return fmt.Errorf("refusing to modify protected registry key %s\\%s", hive, path)
+		}
+	}
+	
+	if !deleting {
+		return nil
+	}
+	
+	if hasValue {
+		for key, values := range protectedRegistryValues {
+			if full != strings.ToUpper(key) {
 				continue
 			}
-			
-			log.Printf("Adding firewall exception for IP: %s", ip)
-			
-			// Allow inbound connections from allowed IP
-			inCmd := exec.Command("netsh", "advfirewall", "firewall", "add", "rule", 
-				fmt.Sprintf("name=EDR-Allow-%s-In", ip), "dir=in", "action=allow", 
-				"protocol=any", fmt.Sprintf("remoteip=%s", ip))
-			if output, err := inCmd.CombinedOutput(); err != nil {
-				log.Printf("WARNING: Failed to add inbound rule for %s: %v, output: %s", ip, err, string(output))
-			} else {
-				log.Printf("Successfully added inbound rule for IP: %s", ip)
-			}
-			
-			// Allow outbound connections to allowed IP
-			outCmd := exec.Command("netsh", "advfirewall", "firewall", "add", "rule", 
-				fmt.Sprintf("name=EDR-Allow-%s-Out", ip), "dir=out", "action=allow", 
-				"protocol=any", fmt.Sprintf("remoteip=%s", ip))
-			if output, err := outCmd.CombinedOutput(); err != nil {
-				log.Printf("WARNING: Failed to add outbound rule for %s: %v, output: %s", ip, err, string(output))
-			} else {
-				log.Printf("Successfully added outbound rule for IP: %s", ip)
+			for _, v := range values {
+				if strings.EqualFold(v, valueName) {
+					return fmt.Errorf("refusing to delete protected registry value %s (use REGISTRY_SET to restore it instead)", valueName)
+				}
 			}
 		}
+		return nil
+	}
+	
+	// Deleting a key also removes everything below it
+	for _, key := range protectedRegistryKeys {
+		key = strings.ToUpper(key)
+		if full == key || strings.HasPrefix(key, full+`\`) {
+			return fmt.Errorf("refusing to delete protected registry key %s\\%s", hive, path)
+		}
+	}
+	for _, tree := range protectedRegistryTrees {
+		if strings.HasPrefix(strings.ToUpper(tree), full+`\`) {
+			return fmt.Errorf("refusing to delete protected registry key %s\\%s", hive, path)
+		}
+	}
+	
+	return nil
+}
+
+// netInfrastructure lists the addresses the host needs to keep its network
+// configuration working while isolated
+type netInfrastructure struct {
+	DNSServers  []string
+	Gateways    []string
+	DHCPServers []string
+}
+
+// appendAddress adds a usable, not yet listed address to list
+func appendAddress(list []string, ip net.IP) []string {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() {
+		return list
+	}
+	addr := ip.String()
+	for _, existing := range list {
+		if existing == addr {
+			return list
+		}
+	}
+	return append(list, addr)
+}
+
+// serverIPs returns the addresses of the EDR server. A hostname is resolved
+// so its IPs can be whitelisted before DNS is cut off.
+func (h *CommandHandler) serverIPs() []string {
+	host := h.client.serverAddress
+	if host == "" {
+		return nil
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil {
+		return []string{ip.String()}
+	}
+	
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		log.Printf("WARNING: Failed to resolve server address %s: %v", host, err)
+		return nil
+	}
+	var ips []string
+	for _, addr := range addrs {
+		ips = appendAddress(ips, net.ParseIP(addr))
+	}
+	log.Printf("Resolved server %s to %s", host, strings.Join(ips, ", "))
+	return ips
+}
+
+// addIsolationRule adds an allow rule named EDR-Allow-<name> so that
+// handleNetworkRestore removes it with the other isolation rules
+func (h *CommandHandler) addIsolationRule(ctx context.Context, name string, args ...string) {
+	ruleArgs := append([]string{"advfirewall", "firewall", "add", "rule",
+		"name=EDR-Allow-" + name, "action=allow"}, args...)
+	if output, err := h.runner.Run(ctx, "netsh", ruleArgs...); err != nil {
+		log.Printf("WARNING: Failed to add firewall rule EDR-Allow-%s: %v, output: %s", name, err, string(output))
+	} else {
+		log.Printf("Successfully added firewall rule EDR-Allow-%s", name)
+	}
+}
+
+// handleNetworkIsolate isolates the host from the network with Windows
+// Firewall, or iptables on Linux. The server, the configured DNS servers,
+// the default gateways and DHCP stay reachable so the agent can keep its
+// connection to the server.
+func (h *CommandHandler) handleNetworkIsolate(ctx context.Context, params map[string]string) (string, error) {
+	if runtime.GOOS != "windows" && runtime.GOOS != "linux" {
+		return "", fmt.Errorf("network isolation is not supported on %s", runtime.GOOS)
+	}
+	
+	var allowedIPs []string
+	for _, ip := range strings.Split(params["allowed_ips"], ",") {
+		ip = strings.TrimSpace(ip)
+		if ip == "" {
+			continue
+		}
+		parsed := net.ParseIP(ip)
+		if parsed == nil {
+			return "", fmt.Errorf("invalid IP address in 'allowed_ips': %s", ip)
+		}
+		allowedIPs = appendAddress(allowedIPs, parsed)
+	}
+	
+	// Always ensure the server IPs are in allowed IPs
+	for _, ip := range h.serverIPs() {
+		allowedIPs = appendAddress(allowedIPs, net.ParseIP(ip))
+	}
+	
+	infra, err := networkInfrastructure()
+	if err != nil {
+		log.Printf("WARNING: Failed to discover DNS servers and gateways: %v", err)
+		infra = &netInfrastructure{}
+	}
+	
+	log.Printf("Network isolation: allowing IPs: %s", strings.Join(allowedIPs, ","))
+	log.Printf("Network isolation: allowing DNS servers: %s, gateways: %s",
+		strings.Join(infra.DNSServers, ","), strings.Join(infra.Gateways, ","))
+
+	if isDryRun(params) {
+		restore := "no auto-restore"
+		if maxDuration := h.client.config.GetIsolationMaxDuration(); maxDuration > 0 {
+			restore = fmt.Sprintf("auto-restore in %v unless renewed", maxDuration)
+		}
+		return fmt.Sprintf("%sWould block all traffic except IPs: %s; DNS servers: %s; gateways: %s; DHCP (%s)",
+			dryRunPrefix, strings.Join(allowedIPs, ","), strings.Join(infra.DNSServers, ","),
+			strings.Join(infra.Gateways, ","), restore), nil
+	}
+
+	if runtime.GOOS == "linux" {
+		if err := h.isolateIptables(ctx, allowedIPs, infra); err != nil {
+			return "", err
+		}
+	} else if err := h.isolateNetsh(ctx, allowedIPs, infra); err != nil {
+		return "", err
+	}
+
+	log.Printf("Network isolation activated successfully with %d allowed IPs, %d DNS servers and %d gateways",
+		len(allowedIPs), len(infra.DNSServers), len(infra.Gateways))
+	
+	// Start the dead-man timer; the server must renew isolation to keep it
+	maxDuration := h.client.config.GetIsolationMaxDuration()
+	h.isolation.start(maxDuration)
+	if maxDuration > 0 {
+		log.Printf("Network isolation will be lifted automatically in %v unless renewed", maxDuration)
+		return fmt.Sprintf("Network isolation activated successfully, auto-restore in %v unless renewed", maxDuration), nil
+	}
+	return "Network isolation activated successfully", nil
+}
+
+// isolateNetsh adds the Windows Firewall allow rules for isolation, then
+// blocks all other traffic
+func (h *CommandHandler) isolateNetsh(ctx context.Context, allowedIPs []string, infra *netInfrastructure) error {
+	// FIRST: Add exception rules for allowed IPs BEFORE blocking all traffic
+	for _, ip := range allowedIPs {
+		log.Printf("Adding firewall exception for IP: %s", logging.RedactParam("allowed_ips", ip))
+		h.addIsolationRule(ctx, ip+"-In", "dir=in", "protocol=any", "remoteip="+ip)
+		h.addIsolationRule(ctx, ip+"-Out", "dir=out", "protocol=any", "remoteip="+ip)
+	}
+	
+	// Keep name resolution working so the server hostname can be resolved
+	for _, ip := range infra.DNSServers {
+		for _, protocol := range []string{"udp", "tcp"} {
+			h.addIsolationRule(ctx, "DNS-"+ip+"-"+strings.ToUpper(protocol), "dir=out",
+				"protocol="+protocol, "remoteip="+ip, "remoteport=53")
+		}
+	}
+	
+	// Keep the default gateway reachable so the route to the server stays up
+	for _, ip := range infra.Gateways {
+		h.addIsolationRule(ctx, "Gateway-"+ip+"-In", "dir=in", "protocol=any", "remoteip="+ip)
+		h.addIsolationRule(ctx, "Gateway-"+ip+"-Out", "dir=out", "protocol=any", "remoteip="+ip)
+	}
+	
+	// Let the DHCP lease be renewed. Requests may be broadcast, so these
+	// rules match on ports rather than the DHCP server address.
+	h.addIsolationRule(ctx, "DHCP-Out", "dir=out", "protocol=udp", "localport=68", "remoteport=67")
+	h.addIsolationRule(ctx, "DHCP-In", "dir=in", "protocol=udp", "localport=68", "remoteport=67")
+	for _, ip := range infra.DHCPServers {
+		if net.ParseIP(ip).To4() != nil {
+			continue
+		}
+		h.addIsolationRule(ctx, "DHCPv6-Out", "dir=out", "protocol=udp", "localport=546", "remoteport=547")
+		h.addIsolationRule(ctx, "DHCPv6-In", "dir=in", "protocol=udp", "localport=546", "remoteport=547")
+		break
 	}
 
 	// SECOND: Now block all other traffic (after exceptions are in place)
 	log.Printf("Setting firewall policy to block all traffic except allowed IPs")
-	inboundCmd := exec.Command("netsh", "advfirewall", "set", "allprofiles", "firewallpolicy", "blockinbound,blockoutbound")
-	if output, err := inboundCmd.CombinedOutput(); err != nil {
-		return "", fmt.Errorf("failed to set firewall policy: %v, output: %s", err, string(output))
+	if output, err := h.runner.Run(ctx, "netsh", "advfirewall", "set", "allprofiles", "firewallpolicy", "blockinbound,blockoutbound"); err != nil {
+		return fmt.Errorf("failed to set firewall policy: %v, output: %s", err, string(output))
 	}
+	return nil
+}
 
-	log.Printf("Network isolation activated successfully with %d allowed IPs", len(strings.Split(allowedIPs, ",")))
-	return "Network isolation activated successfully", nil
+// removeIsolationRules deletes every EDR-Allow-* rule added by
+// handleNetworkIsolate and returns how many were removed
+func (h *CommandHandler) removeIsolationRules(ctx context.Context) (int, error) {
+	output, err := h.runner.Run(ctx, "netsh", "advfirewall", "firewall", "show", "rule", "name=all")
+	if err != nil {
+		return 0, fmt.Errorf("failed to list firewall rules: %v", err)
+	}
+	
+	// Delete each name once, in listing order
+	var names []string
+	seen := make(map[string]bool)
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "Rule Name:") {
+			continue
+		}
+		if name := strings.TrimSpace(strings.TrimPrefix(line, "Rule Name:")); strings.HasPrefix(name, "EDR-Allow-") && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	
+	removed := 0
+	var failures []string
+	for _, name := range names {
+		if output, err := h.runner.Run(ctx, "netsh", "advfirewall", "firewall", "delete", "rule", "name="+name); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v, output: %s", name, err, strings.TrimSpace(string(output))))
+			continue
+		}
+		removed++
+	}
+	if len(failures) > 0 {
+		return removed, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return removed, nil
 }
 
 // handleNetworkRestore restores network connectivity
-func (h *CommandHandler) handleNetworkRestore(params map[string]string) (string, error) {
+func (h *CommandHandler) handleNetworkRestore(ctx context.Context, params map[string]string) (string, error) {
+	if runtime.GOOS != "windows" && runtime.GOOS != "linux" {
+		return "", fmt.Errorf("network isolation is not supported on %s", runtime.GOOS)
+	}
+	
 	log.Printf("Restoring network connectivity while preserving IOC blocking rules...")
 	
+	if runtime.GOOS == "linux" {
+		// Only the isolation chains are removed, the EDR_BLOCK chain stays
+		if err := h.restoreIptables(ctx); err != nil {
+			return "", fmt.Errorf("failed to remove network isolation rules: %v", err)
+		}
+		h.isolation.stop()
+		log.Printf("Network connectivity restored successfully, IOC protections maintained")
+		return "Network connectivity restored successfully", nil
+	}
+	
 	// STEP 1: Reset firewall policy to default (allow outbound, block inbound)
 	log.Printf("Resetting firewall policy to default...")
-	policyCmd := exec.Command("netsh", "advfirewall", "set", "allprofiles", "firewallpolicy", "blockinbound,allowoutbound")
-	if output, err := policyCmd.CombinedOutput(); err != nil {
+	if output, err := h.runner.Run(ctx, "netsh", "advfirewall", "set", "allprofiles", "firewallpolicy", "blockinbound,allowoutbound"); err != nil {
 		return "", fmt.Errorf("failed to reset firewall policy: %v, output: %s", err, string(output))
 	}
+	h.isolation.stop()
 	
 	// STEP 2: Delete only network isolation rules (EDR-Allow-*), keep IOC blocking rules (EDR_Block_*)
 	log.Printf("Removing network isolation firewall rules...")
-	deleteIsolationRulesCmd := exec.Command("cmd", "/C", "for /f \"tokens=*\" %i in ('netsh advfirewall firewall show rule name^=EDR-Allow* ^| findstr \"Rule Name:\"') do netsh advfirewall firewall delete rule name=\"%i\"")
-	if output, err := deleteIsolationRulesCmd.CombinedOutput(); err != nil {
-		log.Printf("WARNING: Failed to delete network isolation rules: %v, output: %s", err, string(output))
+	if removed, err := h.removeIsolationRules(ctx); err != nil {
+		log.Printf("WARNING: Failed to delete network isolation rules: %v", err)
 	} else {
-		log.Printf("Successfully removed network isolation rules")
+		log.Printf("Successfully removed %d network isolation rules", removed)
 	}
 	
 	// STEP 3: Verify IOC blocking rules are still intact
 	log.Printf("Verifying IOC blocking rules are preserved...")
-	checkIOCRulesCmd := exec.Command("cmd", "/C", "netsh advfirewall firewall show rule name=EDR_Block* | findstr \"Rule Name:\" | find /c \"Rule Name:\"")
-	if output, err := checkIOCRulesCmd.CombinedOutput(); err != nil {
+	if output, err := h.runner.Run(ctx, "cmd", "/C", "netsh advfirewall firewall show rule name=EDR_Block* | findstr \"Rule Name:\" | find /c \"Rule Name:\""); err != nil {
 		log.Printf("WARNING: Could not verify IOC rules: %v", err)
 	} else {
 		ruleCount := strings.TrimSpace(string(output))
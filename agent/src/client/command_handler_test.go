@@ -0,0 +1,324 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRunner records the command lines it is asked to run. respond, if set,
+// decides each command's output and error.
+type fakeRunner struct {
+	mu      sync.Mutex
+	calls   [][]string
+	respond func(argv []string) ([]byte, error)
+}
+
+func (f *fakeRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	argv := append([]string{name}, args...)
+	f.mu.Lock()
+	f.calls = append(f.calls, argv)
+	f.mu.Unlock()
+	if f.respond != nil {
+		return f.respond(argv)
+	}
+	return nil, nil
+}
+
+// argvs splits each command line on spaces; none of the expected arguments
+// contain one
+func argvs(lines ...string) [][]string {
+	calls := make([][]string, len(lines))
+	for i, line := range lines {
+		calls[i] = strings.Fields(line)
+	}
+	return calls
+}
+
+// assertCalls compares the recorded command lines with want
+func assertCalls(t *testing.T, got, want [][]string) {
+	t.Helper()
+	if reflect.DeepEqual(got, want) {
+		return
+	}
+	for i := 0; i < len(got) || i < len(want); i++ {
+		var g, w string
+		if i < len(got) {
+			g = strings.Join(got[i], " ")
+		}
+		if i < len(want) {
+			w = strings.Join(want[i], " ")
+		}
+		if g != w {
+			t.Errorf("command %d:\n got: %s\nwant: %s", i, g, w)
+		}
+	}
+}
+
+var errFake = errors.New("exit status 1")
+
+func TestIsolateNetsh(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		infra   *netInfrastructure
+		want    [][]string
+	}{
+		{
+			name:    "server only",
+			allowed: []string{"10.0.0.5"},
+			infra:   &netInfrastructure{},
+			want: argvs(
+				"netsh advfirewall firewall add rule name=EDR-Allow-10.0.0.5-In action=allow dir=in protocol=any remoteip=10.0.0.5",
+				"netsh advfirewall firewall add rule name=EDR-Allow-10.0.0.5-Out action=allow dir=out protocol=any remoteip=10.0.0.5",
+				"netsh advfirewall firewall add rule name=EDR-Allow-DHCP-Out action=allow dir=out protocol=udp localport=68 remoteport=67",
+				"netsh advfirewall firewall add rule name=EDR-Allow-DHCP-In action=allow dir=in protocol=udp localport=68 remoteport=67",
+				"netsh advfirewall set allprofiles firewallpolicy blockinbound,blockoutbound",
+			),
+		},
+		{
+			name:    "DNS, gateway and DHCPv6",
+			allowed: []string{"2001:db8::5"},
+			infra: &netInfrastructure{
+				DNSServers:  []string{"10.0.0.53"},
+				Gateways:    []string{"10.0.0.1"},
+				DHCPServers: []string{"10.0.0.1", "fe80::1"},
+			},
+			want: argvs(
+				"netsh advfirewall firewall add rule name=EDR-Allow-2001:db8::5-In action=allow dir=in protocol=any remoteip=2001:db8::5",
+				"netsh advfirewall firewall add rule name=EDR-Allow-2001:db8::5-Out action=allow dir=out protocol=any remoteip=2001:db8::5",
+				"netsh advfirewall firewall add rule name=EDR-Allow-DNS-10.0.0.53-UDP action=allow dir=out protocol=udp remoteip=10.0.0.53 remoteport=53",
+				"netsh advfirewall firewall add rule name=EDR-Allow-DNS-10.0.0.53-TCP action=allow dir=out protocol=tcp remoteip=10.0.0.53 remoteport=53",
+				"netsh advfirewall firewall add rule name=EDR-Allow-Gateway-10.0.0.1-In action=allow dir=in protocol=any remoteip=10.0.0.1",
+				"netsh advfirewall firewall add rule name=EDR-Allow-Gateway-10.0.0.1-Out action=allow dir=out protocol=any remoteip=10.0.0.1",
+				"netsh advfirewall firewall add rule name=EDR-Allow-DHCP-Out action=allow dir=out protocol=udp localport=68 remoteport=67",
+				"netsh advfirewall firewall add rule name=EDR-Allow-DHCP-In action=allow dir=in protocol=udp localport=68 remoteport=67",
+				"netsh advfirewall firewall add rule name=EDR-Allow-DHCPv6-Out action=allow dir=out protocol=udp localport=546 remoteport=547",
+				"netsh advfirewall firewall add rule name=EDR-Allow-DHCPv6-In action=allow dir=in protocol=udp localport=546 remoteport=547",
+				"netsh advfirewall set allprofiles firewallpolicy blockinbound,blockoutbound",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{}
+			h := &CommandHandler{runner: runner}
+			if err := h.isolateNetsh(context.Background(), tt.allowed, tt.infra); err != nil {
+				t.Fatalf("isolateNetsh: %v", err)
+			}
+			assertCalls(t, runner.calls, tt.want)
+		})
+	}
+}
+
+func TestRemoveIsolationRules(t *testing.T) {
+	listing := `
+Rule Name:                            EDR-Allow-10.0.0.5-In
+----------------------------------------------------------------------
+Enabled:                              Yes
+Rule Name:                            EDR_Block_1.2.3.4_In
+----------------------------------------------------------------------
+Rule Name:                            EDR-Allow-10.0.0.5-Out
+----------------------------------------------------------------------
+Rule Name:                            EDR-Allow-10.0.0.5-In
+----------------------------------------------------------------------
+Rule Name:                            Core Networking - DNS (UDP-Out)
+`
+	runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+		if argv[3] == "show" {
+			return []byte(listing), nil
+		}
+		return nil, nil
+	}}
+	h := &CommandHandler{runner: runner}
+
+	removed, err := h.removeIsolationRules(context.Background())
+	if err != nil {
+		t.Fatalf("removeIsolationRules: %v", err)
+	}
+	if removed != 2 {
+		t.Errorf("removed %d rules, want 2", removed)
+	}
+	// IP block rules and other rules are left alone
+	assertCalls(t, runner.calls, argvs(
+		"netsh advfirewall firewall show rule name=all",
+		"netsh advfirewall firewall delete rule name=EDR-Allow-10.0.0.5-In",
+		"netsh advfirewall firewall delete rule name=EDR-Allow-10.0.0.5-Out",
+	))
+}
+
+func TestIsolateIptables(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		infra   *netInfrastructure
+		// middle are the allow rules between the loopback and ICMPv6 rules
+		// and the DHCP rules
+		middle [][]string
+	}{
+		{
+			name:    "server only",
+			allowed: []string{"10.0.0.5"},
+			infra:   &netInfrastructure{},
+			middle: argvs(
+				"iptables -A EDR_ISOLATE_IN -s 10.0.0.5 -j ACCEPT",
+				"iptables -A EDR_ISOLATE_OUT -d 10.0.0.5 -j ACCEPT",
+			),
+		},
+		{
+			name:    "IPv6 server, DNS and gateway",
+			allowed: []string{"2001:db8::5"},
+			infra: &netInfrastructure{
+				DNSServers: []string{"10.0.0.53"},
+				Gateways:   []string{"fe80::1"},
+			},
+			middle: argvs(
+				"ip6tables -A EDR_ISOLATE_IN -s 2001:db8::5 -j ACCEPT",
+				"ip6tables -A EDR_ISOLATE_OUT -d 2001:db8::5 -j ACCEPT",
+				"iptables -A EDR_ISOLATE_OUT -d 10.0.0.53 -p udp --dport 53 -j ACCEPT",
+				"iptables -A EDR_ISOLATE_IN -s 10.0.0.53 -p udp --sport 53 -j ACCEPT",
+				"iptables -A EDR_ISOLATE_OUT -d 10.0.0.53 -p tcp --dport 53 -j ACCEPT",
+				"iptables -A EDR_ISOLATE_IN -s 10.0.0.53 -p tcp --sport 53 -j ACCEPT",
+				"ip6tables -A EDR_ISOLATE_IN -s fe80::1 -j ACCEPT",
+				"ip6tables -A EDR_ISOLATE_OUT -d fe80::1 -j ACCEPT",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// No jump to the isolation chains exists yet
+			runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+				if argv[1] == "-C" {
+					return nil, errFake
+				}
+				return nil, nil
+			}}
+			h := &CommandHandler{runner: runner}
+			if err := h.isolateIptables(context.Background(), tt.allowed, tt.infra); err != nil {
+				t.Fatalf("isolateIptables: %v", err)
+			}
+
+			want := argvs(
+				"iptables -N EDR_ISOLATE_IN",
+				"iptables -F EDR_ISOLATE_IN",
+				"iptables -N EDR_ISOLATE_OUT",
+				"iptables -F EDR_ISOLATE_OUT",
+				"iptables -A EDR_ISOLATE_IN -i lo -j ACCEPT",
+				"iptables -A EDR_ISOLATE_OUT -o lo -j ACCEPT",
+				"ip6tables -N EDR_ISOLATE_IN",
+				"ip6tables -F EDR_ISOLATE_IN",
+				"ip6tables -N EDR_ISOLATE_OUT",
+				"ip6tables -F EDR_ISOLATE_OUT",
+				"ip6tables -A EDR_ISOLATE_IN -i lo -j ACCEPT",
+				"ip6tables -A EDR_ISOLATE_OUT -o lo -j ACCEPT",
+				"ip6tables -A EDR_ISOLATE_IN -p ipv6-icmp -j ACCEPT",
+				"ip6tables -A EDR_ISOLATE_OUT -p ipv6-icmp -j ACCEPT",
+			)
+			want = append(want, tt.middle...)
+			want = append(want, argvs(
+				"iptables -A EDR_ISOLATE_OUT -p udp --sport 68 --dport 67 -j ACCEPT",
+				"iptables -A EDR_ISOLATE_IN -p udp --sport 67 --dport 68 -j ACCEPT",
+				"ip6tables -A EDR_ISOLATE_OUT -p udp --sport 546 --dport 547 -j ACCEPT",
+				"ip6tables -A EDR_ISOLATE_IN -p udp --sport 547 --dport 546 -j ACCEPT",
+				"iptables -A EDR_ISOLATE_IN -j DROP",
+				"iptables -A EDR_ISOLATE_OUT -j DROP",
+				"iptables -C INPUT -j EDR_ISOLATE_IN",
+				"iptables -I INPUT -j EDR_ISOLATE_IN",
+				"iptables -C OUTPUT -j EDR_ISOLATE_OUT",
+				"iptables -I OUTPUT -j EDR_ISOLATE_OUT",
+				"ip6tables -A EDR_ISOLATE_IN -j DROP",
+				"ip6tables -A EDR_ISOLATE_OUT -j DROP",
+				"ip6tables -C INPUT -j EDR_ISOLATE_IN",
+				"ip6tables -I INPUT -j EDR_ISOLATE_IN",
+				"ip6tables -C OUTPUT -j EDR_ISOLATE_OUT",
+				"ip6tables -I OUTPUT -j EDR_ISOLATE_OUT",
+			)...)
+			assertCalls(t, runner.calls, want)
+		})
+	}
+}
+
+func TestRestoreIptables(t *testing.T) {
+	tests := []struct {
+		name string
+		// jumps is how many jumps to each isolation chain exist per tool
+		jumps int
+		// chains reports whether the isolation chains exist
+		chains bool
+		want   [][]string
+	}{
+		{
+			name:   "isolated",
+			jumps:  1,
+			chains: true,
+			want: argvs(
+				"iptables -D INPUT -j EDR_ISOLATE_IN",
+				"iptables -D INPUT -j EDR_ISOLATE_IN",
+				"iptables -L EDR_ISOLATE_IN -n",
+				"iptables -F EDR_ISOLATE_IN",
+				"iptables -X EDR_ISOLATE_IN",
+				"iptables -D OUTPUT -j EDR_ISOLATE_OUT",
+				"iptables -D OUTPUT -j EDR_ISOLATE_OUT",
+				"iptables -L EDR_ISOLATE_OUT -n",
+				"iptables -F EDR_ISOLATE_OUT",
+				"iptables -X EDR_ISOLATE_OUT",
+				"ip6tables -D INPUT -j EDR_ISOLATE_IN",
+				"ip6tables -D INPUT -j EDR_ISOLATE_IN",
+				"ip6tables -L EDR_ISOLATE_IN -n",
+				"ip6tables -F EDR_ISOLATE_IN",
+				"ip6tables -X EDR_ISOLATE_IN",
+				"ip6tables -D OUTPUT -j EDR_ISOLATE_OUT",
+				"ip6tables -D OUTPUT -j EDR_ISOLATE_OUT",
+				"ip6tables -L EDR_ISOLATE_OUT -n",
+				"ip6tables -F EDR_ISOLATE_OUT",
+				"ip6tables -X EDR_ISOLATE_OUT",
+			),
+		},
+		{
+			name:   "not isolated",
+			jumps:  0,
+			chains: false,
+			want: argvs(
+				"iptables -D INPUT -j EDR_ISOLATE_IN",
+				"iptables -L EDR_ISOLATE_IN -n",
+				"iptables -D OUTPUT -j EDR_ISOLATE_OUT",
+				"iptables -L EDR_ISOLATE_OUT -n",
+				"ip6tables -D INPUT -j EDR_ISOLATE_IN",
+				"ip6tables -L EDR_ISOLATE_IN -n",
+				"ip6tables -D OUTPUT -j EDR_ISOLATE_OUT",
+				"ip6tables -L EDR_ISOLATE_OUT -n",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jumps := make(map[string]int)
+			runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+				switch argv[1] {
+				case "-D":
+					key := argv[0] + " " + argv[2]
+					if jumps[key] >= tt.jumps {
+						return nil, errFake
+					}
+					jumps[key]++
+				case "-L":
+					if !tt.chains {
+						return nil, errFake
+					}
+				}
+				return nil, nil
+			}}
+			h := &CommandHandler{runner: runner}
+			if err := h.restoreIptables(context.Background()); err != nil {
+				t.Fatalf("restoreIptables: %v", err)
+			}
+			assertCalls(t, runner.calls, tt.want)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	pb "agent/proto"
+)
+
+// maxQueuedCommands is how many commands may wait for a free slot while
+// max_concurrent_commands are running; further commands are refused as busy
+const maxQueuedCommands = 64
+
+// errCommandQueueFull is returned when a command cannot even be queued
+var errCommandQueueFull = errors.New("command queue full")
+
+// commandLimiter bounds the number of commands from the server that execute
+// at the same time, so a flood of commands cannot start thousands of netsh
+// or taskkill processes at once
+type commandLimiter struct {
+	slots  chan struct{}
+	queued int32 // Commands waiting for a slot
+}
+
+// newCommandLimiter creates a limiter running at most limit commands at once
+func newCommandLimiter(limit int) *commandLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	return &commandLimiter{slots: make(chan struct{}, limit)}
+}
+
+// acquire takes a slot, waiting behind at most maxQueuedCommands other
+// commands. It returns errCommandQueueFull if the queue is full, or the
+// context's error if ctx is done first.
+func (l *commandLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if atomic.AddInt32(&l.queued, 1) > maxQueuedCommands {
+		atomic.AddInt32(&l.queued, -1)
+		return errCommandQueueFull
+	}
+	defer atomic.AddInt32(&l.queued, -1)
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot taken by acquire
+func (l *commandLimiter) release() {
+	<-l.slots
+}
+
+// busyResult is the result of a command refused because the queue is full.
+// It is not recorded for de-duplication, so the server can send it again.
+func (l *commandLimiter) busyResult(cmd *pb.Command) *pb.CommandResult {
+	running, queued := len(l.slots), atomic.LoadInt32(&l.queued)
+	log.Printf("Command %s (%s) refused: %d commands running and %d queued",
+		cmd.CommandId, cmd.Type.String(), running, queued)
+	return &pb.CommandResult{
+		CommandId:     cmd.CommandId,
+		AgentId:       cmd.AgentId,
+		ExecutionTime: time.Now().Unix(),
+		Success:       false,
+		Message:       fmt.Sprintf("Error: agent busy, %d commands running and %d queued; retry later", running, queued),
+	}
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"context"
+
+	"google.golang.org/grpc/stats"
+
+	pb "agent/proto"
+	"agent/logging"
+)
+
+// iocSizeLogger is a gRPC stats handler that logs the wire and decoded size
+// of IOC updates received on the command stream, so the effect of message
+// compression can be checked at debug level
+type iocSizeLogger struct{}
+
+func (iocSizeLogger) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (iocSizeLogger) HandleRPC(_ context.Context, s stats.RPCStats) {
+	in, ok := s.(*stats.InPayload)
+	if !ok || !in.IsClient() {
+		return
+	}
+	msg, ok := in.Payload.(*pb.CommandMessage)
+	if !ok || msg.GetIocData() == nil {
+		return
+	}
+
+	logging.Debug().
+		Int64("ioc_version", msg.GetIocData().Version).
+		Int("uncompressed_bytes", in.Length).
+		Int("wire_bytes", in.WireLength).
+		Msg("Received IOC update")
+}
+
+func (iocSizeLogger) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (iocSizeLogger) HandleConn(context.Context, stats.ConnStats) {}
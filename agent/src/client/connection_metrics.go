@@ -0,0 +1,103 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	pb "agent/proto"
+)
+
+// connectionMetrics tracks the health of the command stream: how long it has
+// been up, how often it was lost and the heartbeat round-trip latency
+type connectionMetrics struct {
+	mu             sync.Mutex
+	startedAt      time.Time     // When the agent started
+	connectedSince time.Time     // When the current stream came up, zero while down
+	upTotal        time.Duration // Time the stream was up before connectedSince
+	reconnects     uint64
+	lastCause      string    // Why the stream was last lost
+	lastLost       time.Time // When the stream was last lost
+	heartbeatSeq   uint64    // Sequence of the last heartbeat sent
+	heartbeatSent  time.Time // When heartbeatSeq was sent
+	latency        time.Duration
+}
+
+// newConnectionMetrics starts tracking at now, with the stream down
+func newConnectionMetrics(now time.Time) *connectionMetrics {
+	return &connectionMetrics{startedAt: now}
+}
+
+// stateChanged records a transition of the command stream from previous to
+// state at now
+func (m *connectionMetrics) stateChanged(previous, state ConnectionState, cause string, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if state == StateConnected {
+		m.connectedSince = now
+		if previous == StateReconnecting {
+			m.reconnects++
+		}
+		return
+	}
+	if previous == StateConnected {
+		m.upTotal += now.Sub(m.connectedSince)
+		m.connectedSince = time.Time{}
+		m.lastCause = cause
+		m.lastLost = now
+	}
+}
+
+// heartbeatSending returns the sequence number of the next heartbeat and
+// remembers when it was sent
+func (m *connectionMetrics) heartbeatSending(now time.Time) uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.heartbeatSeq++
+	m.heartbeatSent = now
+	return m.heartbeatSeq
+}
+
+// heartbeatEchoed records the latency of a heartbeat the server sent back.
+// Echoes of older heartbeats are ignored, since their send time is gone.
+func (m *connectionMetrics) heartbeatEchoed(seq uint64, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if seq == 0 || seq != m.heartbeatSeq {
+		return
+	}
+	m.latency = now.Sub(m.heartbeatSent)
+}
+
+// snapshot returns the metrics at now
+func (m *connectionMetrics) snapshot(now time.Time) *pb.ConnectionMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	up := m.upTotal
+	snapshot := &pb.ConnectionMetrics{
+		Reconnects:           m.reconnects,
+		LastDisconnectReason: m.lastCause,
+		LatencyMs:            float64(m.latency.Microseconds()) / 1000,
+	}
+	if !m.connectedSince.IsZero() {
+		up += now.Sub(m.connectedSince)
+		snapshot.ConnectedSince = m.connectedSince.Unix()
+	}
+	if !m.lastLost.IsZero() {
+		snapshot.LastDisconnectTime = m.lastLost.Unix()
+	}
+	if elapsed := now.Sub(m.startedAt); elapsed > 0 {
+		snapshot.UptimePercent = float64(up) / float64(elapsed) * 100
+	}
+	return snapshot
+}
+
+// addConnectionMetrics fills in the command stream health and clock skew of
+// a metrics sample
+func (c *EDRClient) addConnectionMetrics(m *pb.SystemMetrics) {
+	m.ConnectionMetrics = c.connMetrics.snapshot(time.Now())
+	if skew, ok := c.clockSkew.get(); ok {
+		m.ConnectionMetrics.ClockSkewSeconds = int64(skew / time.Second)
+	}
+}
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	pb "agent/proto"
+)
+
+// ConnectionState is the state of the command stream to the server
+type ConnectionState int
+
+const (
+	StateConnecting   ConnectionState = iota // Not connected yet since the agent started
+	StateConnected                           // The command stream is up
+	StateReconnecting                        // The stream was lost and is being re-established
+	StateDisconnected                        // The stream was stopped because the agent is shutting down
+)
+
+// String returns the lower-case name of the state
+func (s ConnectionState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateDisconnected:
+		return "disconnected"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(s))
+	}
+}
+
+// connectionStates lists every state, in the order of their values
+var connectionStates = []ConnectionState{StateConnecting, StateConnected, StateReconnecting, StateDisconnected}
+
+// State returns the current state of the command stream
+func (c *EDRClient) State() ConnectionState {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state
+}
+
+// stateDetails returns the current state with the cause and time of the
+// transition into it
+func (c *EDRClient) stateDetails() (ConnectionState, string, time.Time) {
+	c.stateMu.Lock()
+	defer c.stateMu.Unlock()
+	return c.state, c.stateCause, c.stateSince
+}
+
+// retryState is the state while the command stream is being retried:
+// Connecting until it has been up once, Reconnecting after that
+func (c *EDRClient) retryState() ConnectionState {
+	if c.State() == StateConnecting {
+		return StateConnecting
+	}
+	return StateReconnecting
+}
+
+// setState moves the command stream to state and logs the transition with
+// its cause. Staying in the same state keeps the cause of the original
+// transition, so retries do not hide why the stream went down. Reaching
+// Connected after the stream was lost queues an AGENT_RECONNECTED event.
+func (c *EDRClient) setState(state ConnectionState, cause string) {
+	c.stateMu.Lock()
+	previous, previousCause, since := c.state, c.stateCause, c.stateSince
+	if state == previous {
+		c.stateMu.Unlock()
+		return
+	}
+	now := time.Now()
+	c.state = state
+	c.stateCause = cause
+	c.stateSince = now
+	c.stateMu.Unlock()
+	c.connMetrics.stateChanged(previous, state, cause, now)
+
+	log.Printf("Connection state changed from %s to %s: %s", previous, state, cause)
+
+	if previous == StateReconnecting && state == StateConnected {
+		downtime := now.Sub(since).Round(time.Second)
+		c.SendEvent(pb.AgentEventType_AGENT_RECONNECTED,
+			fmt.Sprintf("Command stream reconnected after %v", downtime),
+			map[string]string{
+				"cause":            previousCause,
+				"down_since":       since.UTC().Format(time.RFC3339),
+				"downtime_seconds": fmt.Sprintf("%.0f", downtime.Seconds()),
+			})
+	}
+}
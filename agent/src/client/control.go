@@ -0,0 +1,220 @@
+package client
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"agent/config"
+	"agent/logging"
+)
+
+// controlTimeout bounds how long a control connection may take
+const controlTimeout = 10 * time.Second
+
+// maxControlRequest is the longest command line accepted
+const maxControlRequest = 256
+
+// errControlClosed is returned by Accept after the listener is closed
+var errControlClosed = errors.New("control listener closed")
+
+// controlListener accepts connections on the local control endpoint, a Unix
+// socket or a Windows named pipe
+type controlListener interface {
+	Accept() (io.ReadWriteCloser, error)
+	Close() error
+}
+
+// ControlServer answers operator commands sent by agentctl over a local
+// endpoint only administrators and SYSTEM can open. Each connection carries
+// one command line and gets one JSON object back.
+type ControlServer struct {
+	client   *EDRClient
+	address  string
+	mu       sync.Mutex
+	listener controlListener
+}
+
+// controlCommands are the commands agentctl can send, with their descriptions
+var controlCommands = map[string]string{
+	"status":    "Connection state, agent ID and version",
+	"ioc-stats": "Version and size of the local IOC database",
+	"blocks":    "Number of active IP and URL blocks",
+	"scan-now":  "Start an IOC scan immediately",
+	"log-level": "Show the log level; 'log-level <level> [minutes]' changes it for 30 minutes or the given time (0 = until restart), 'log-level reset' restores log_level",
+	"help":      "List the available commands",
+}
+
+// NewControlServer creates a control server at the platform's control address
+func NewControlServer(client *EDRClient) *ControlServer {
+	return &ControlServer{client: client, address: ControlAddress(client.config)}
+}
+
+// ControlAddress returns the path of the control socket or named pipe
+func ControlAddress(cfg *config.Config) string {
+	return controlAddress(cfg.DataDir)
+}
+
+// Start creates the endpoint and serves connections until Close
+func (s *ControlServer) Start() error {
+	listener, err := listenControl(s.address)
+	if err != nil {
+		return fmt.Errorf("failed to start control server: %v", err)
+	}
+	s.mu.Lock()
+	s.listener = listener
+	s.mu.Unlock()
+
+	log.Printf("Control server listening on %s", s.address)
+	go s.serve(listener)
+	return nil
+}
+
+// Close stops accepting control connections
+func (s *ControlServer) Close() error {
+	s.mu.Lock()
+	listener := s.listener
+	s.listener = nil
+	s.mu.Unlock()
+	if listener == nil {
+		return nil
+	}
+	return listener.Close()
+}
+
+// serve accepts connections until the listener is closed
+func (s *ControlServer) serve(listener controlListener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if err != errControlClosed {
+				log.Printf("Control server stopped: %v", err)
+			}
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle reads one command line and writes its JSON result
+func (s *ControlServer) handle(conn io.ReadWriteCloser) {
+	defer conn.Close()
+	if d, ok := conn.(interface{ SetDeadline(time.Time) error }); ok {
+		d.SetDeadline(time.Now().Add(controlTimeout))
+	}
+
+	line, err := bufio.NewReader(io.LimitReader(conn, maxControlRequest)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return
+	}
+	command := strings.TrimSpace(line)
+	log.Printf("Control command received: %s", command)
+
+	result, err := s.run(command)
+	if err != nil {
+		result = map[string]interface{}{"error": err.Error()}
+	}
+	json.NewEncoder(conn).Encode(result)
+}
+
+// run executes a control command
+func (s *ControlServer) run(command string) (map[string]interface{}, error) {
+	handler := s.client.GetCommandHandler()
+
+	var args []string
+	if fields := strings.Fields(command); len(fields) > 0 {
+		command, args = fields[0], fields[1:]
+	}
+
+	switch command {
+	case "status":
+		state, cause, since := s.client.stateDetails()
+		status := map[string]interface{}{
+			"agent_id":         s.client.agentID,
+			"version":          s.client.agentVersion,
+			"server":           s.client.serverAddress,
+			"stream_connected": state == StateConnected,
+			"connection_state": state.String(),
+			"state_cause":      cause,
+			"state_since":      since.UTC().Format(time.RFC3339),
+			"log_level":        logging.Level(),
+			"mode":             s.client.config.Current().Mode,
+			"protected":        handler != nil && handler.Protected(),
+		}
+		if skew, ok := s.client.ClockSkew(); ok {
+			status["clock_skew_seconds"] = int64(skew / time.Second)
+		}
+		if handler != nil {
+			if problems := handler.DatabaseProblems(); problems != nil {
+				status["database_problems"] = problems
+			}
+		}
+		return status, nil
+	case "ioc-stats":
+		if handler == nil || handler.GetIOCManager() == nil {
+			return nil, fmt.Errorf("IOC manager not available")
+		}
+		return handler.GetIOCManager().GetStats(), nil
+	case "blocks":
+		if handler == nil || handler.GetBlocker() == nil {
+			return nil, fmt.Errorf("blocker not available")
+		}
+		ips, urls := handler.GetBlocker().GetBlockedCount()
+		return map[string]interface{}{"blocked_ips": ips, "blocked_urls": urls}, nil
+	case "scan-now":
+		if handler == nil || handler.GetScanner() == nil {
+			return nil, fmt.Errorf("IOC scanner not available")
+		}
+		handler.GetScanner().TriggerScan()
+		return map[string]interface{}{"scan_triggered": true}, nil
+	case "log-level":
+		if len(args) == 0 {
+			return map[string]interface{}{"log_level": logging.Level(), "configured": s.client.config.Current().LogLevel}, nil
+		}
+		if len(args) > 2 {
+			return nil, fmt.Errorf("usage: log-level <level|reset> [minutes]")
+		}
+		duration := ""
+		if len(args) == 2 {
+			duration = args[1]
+		}
+		message, err := setLogLevel(args[0], duration, s.client.config.Current().LogLevel)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"log_level": logging.Level(), "message": message}, nil
+	case "help", "":
+		return map[string]interface{}{"commands": controlCommands}, nil
+	default:
+		return nil, fmt.Errorf("unknown command %q, use help to list commands", command)
+	}
+}
+
+// ControlRequest sends command to the running agent's control endpoint and
+// returns its JSON response
+func ControlRequest(cfg *config.Config, command string) (string, error) {
+	address := ControlAddress(cfg)
+	conn, err := dialControl(address)
+	if err != nil {
+		return "", fmt.Errorf("cannot reach the agent at %s (is it running, and are you an administrator?): %v", address, err)
+	}
+	defer conn.Close()
+	if d, ok := conn.(interface{ SetDeadline(time.Time) error }); ok {
+		d.SetDeadline(time.Now().Add(controlTimeout))
+	}
+
+	if _, err := io.WriteString(conn, command+"\n"); err != nil {
+		return "", fmt.Errorf("failed to send command: %v", err)
+	}
+	response, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response: %v", err)
+	}
+	return strings.TrimSpace(string(response)), nil
+}
@@ -0,0 +1,109 @@
+// +build !windows
+
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// controlDir is the directory in the data directory that holds the control
+// socket. It is only accessible to the agent's user, which restricts who can
+// connect without depending on the mode the socket is created with.
+const controlDir = "control"
+
+// controlAddress returns the control socket path in the data directory
+func controlAddress(dataDir string) string {
+	if abs, err := filepath.Abs(dataDir); err == nil {
+		dataDir = abs
+	}
+	return filepath.Join(dataDir, controlDir, "agentctl.sock")
+}
+
+// unixControlListener is a controlListener on a Unix socket
+type unixControlListener struct {
+	listener net.Listener
+	path     string
+}
+
+// listenControl creates the control socket in a directory only the agent's
+// user (root) can enter. A socket left behind by a previous run is replaced.
+func listenControl(path string) (controlListener, error) {
+	if err := privateDir(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another agent is listening on %s", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to restrict %s: %v", path, err)
+	}
+	return &unixControlListener{listener: listener, path: path}, nil
+}
+
+// privateDir creates dir with mode 0700, or checks that an existing dir is a
+// real directory owned by the agent's user and resets its mode to 0700
+func privateDir(dir string) error {
+	if err := os.Mkdir(dir, 0700); err != nil && !os.IsExist(err) {
+		return fmt.Errorf("failed to create %s: %v", dir, err)
+	}
+
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s exists and is not a directory", dir)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("%s is owned by uid %d, not the agent's user", dir, stat.Uid)
+	}
+	if info.Mode().Perm() != 0700 {
+		if err := os.Chmod(dir, 0700); err != nil {
+			return fmt.Errorf("failed to restrict %s: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+func (l *unixControlListener) Accept() (io.ReadWriteCloser, error) {
+	conn, err := l.listener.Accept()
+	if err != nil {
+		if errors.Is(err, net.ErrClosed) {
+			return nil, errControlClosed
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Close stops listening and removes the socket file
+func (l *unixControlListener) Close() error {
+	err := l.listener.Close()
+	os.Remove(l.path)
+	return err
+}
+
+// dialControl connects to the control socket
+func dialControl(path string) (io.ReadWriteCloser, error) {
+	return net.Dial("unix", path)
+}
@@ -0,0 +1,72 @@
+// +build !windows
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListenControlPrivateDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, dir string)
+		wantErr bool
+	}{
+		{name: "created", prepare: func(t *testing.T, dir string) {}},
+		{name: "existing dir is restricted", prepare: func(t *testing.T, dir string) {
+			if err := os.Mkdir(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "symlink refused", wantErr: true, prepare: func(t *testing.T, dir string) {
+			if err := os.Symlink(t.TempDir(), dir); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "file refused", wantErr: true, prepare: func(t *testing.T, dir string) {
+			if err := os.WriteFile(dir, nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Unix socket paths are limited to about 100 bytes, shorter than
+			// some test temporary directories
+			dataDir, err := os.MkdirTemp("", "ctl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.RemoveAll(dataDir) })
+			path := controlAddress(dataDir)
+			tt.prepare(t, filepath.Dir(path))
+
+			listener, err := listenControl(path)
+			if tt.wantErr {
+				if err == nil {
+					listener.Close()
+					t.Fatal("listenControl succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("listenControl: %v", err)
+			}
+			defer listener.Close()
+
+			info, err := os.Stat(filepath.Dir(path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mode := info.Mode().Perm(); mode != 0700 {
+				t.Errorf("control directory mode = %o, want 700", mode)
+			}
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+				t.Errorf("control socket = %v, %v; want mode 600", info, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+// +build windows
+
+package client
+
+import (
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// controlPipeName is the named pipe agentctl connects to
+const controlPipeName = `\\.\pipe\edr-agent`
+
+// controlPipeSDDL grants SYSTEM and elevated Administrators full access and
+// nobody else any access
+const controlPipeSDDL = "D:P(A;;GA;;;" + sidLocalSystem + ")(A;;GA;;;" + sidAdministrators + ")"
+
+// controlAddress returns the control pipe name; it does not depend on the
+// data directory
+func controlAddress(dataDir string) string {
+	return controlPipeName
+}
+
+// pipeControlListener is a controlListener on a named pipe. Each Accept
+// serves one pipe instance.
+type pipeControlListener struct {
+	name   string
+	sa     *windows.SecurityAttributes
+	mu     sync.Mutex
+	next   windows.Handle // Instance created ahead of Accept, 0 if none
+	closed bool
+}
+
+// listenControl creates the control pipe. The first instance is created
+// with FILE_FLAG_FIRST_PIPE_INSTANCE so that a pipe already created by
+// another process, which could impersonate the agent, is an error.
+func listenControl(name string) (controlListener, error) {
+	sd, err := windows.SecurityDescriptorFromString(controlPipeSDDL)
+	if err != nil {
+		return nil, err
+	}
+	l := &pipeControlListener{
+		name: name,
+		sa: &windows.SecurityAttributes{
+			Length:             uint32(unsafe.Sizeof(windows.SecurityAttributes{})),
+			SecurityDescriptor: sd,
+		},
+	}
+
+	first, err := l.createInstance(windows.FILE_FLAG_FIRST_PIPE_INSTANCE)
+	if err != nil {
+		return nil, err
+	}
+	l.next = first
+	return l, nil
+}
+
+// createInstance creates a pipe instance that refuses remote clients
+func (l *pipeControlListener) createInstance(flags uint32) (windows.Handle, error) {
+	name, err := windows.UTF16PtrFromString(l.name)
+	if err != nil {
+		return 0, err
+	}
+	return windows.CreateNamedPipe(name,
+		windows.PIPE_ACCESS_DUPLEX|flags,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES, 4096, 4096, 0, l.sa)
+}
+
+func (l *pipeControlListener) Accept() (io.ReadWriteCloser, error) {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil, errControlClosed
+	}
+	h := l.next
+	l.next = 0
+	l.mu.Unlock()
+
+	if h == 0 {
+		var err error
+		if h, err = l.createInstance(0); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := windows.ConnectNamedPipe(h, nil); err != nil && err != windows.ERROR_PIPE_CONNECTED {
+		windows.CloseHandle(h)
+		return nil, err
+	}
+
+	l.mu.Lock()
+	closed := l.closed
+	l.mu.Unlock()
+	if closed {
+		windows.CloseHandle(h)
+		return nil, errControlClosed
+	}
+	return &pipeConn{File: os.NewFile(uintptr(h), l.name), handle: h}, nil
+}
+
+// Close stops accepting connections, waking an Accept blocked waiting for a
+// client by connecting to the pipe
+func (l *pipeControlListener) Close() error {
+	l.mu.Lock()
+	l.closed = true
+	next := l.next
+	l.next = 0
+	l.mu.Unlock()
+
+	if next != 0 {
+		return windows.CloseHandle(next)
+	}
+	if f, err := os.OpenFile(l.name, os.O_RDWR, 0); err == nil {
+		f.Close()
+	}
+	return nil
+}
+
+// pipeConn is the server end of a connected pipe instance
+type pipeConn struct {
+	*os.File
+	handle windows.Handle
+}
+
+// Close waits for the client to read the response before closing
+func (c *pipeConn) Close() error {
+	windows.FlushFileBuffers(c.handle)
+	return c.File.Close()
+}
+
+// dialControl connects to the control pipe
+func dialControl(name string) (io.ReadWriteCloser, error) {
+	return os.OpenFile(name, os.O_RDWR, 0)
+}
@@ -0,0 +1,40 @@
+package client
+
+import "log"
+
+// Protected reports whether the IOC and block databases loaded cleanly at
+// startup, or have since been replaced by a full IOC update. While it is
+// false the agent may be missing IOCs or blocks it had before.
+func (h *CommandHandler) Protected() bool {
+	h.dbMu.Lock()
+	defer h.dbMu.Unlock()
+	return len(h.dbProblems) == 0
+}
+
+// DatabaseProblems returns why each database file did not load cleanly, by
+// file name, or nil if all did
+func (h *CommandHandler) DatabaseProblems() map[string]string {
+	h.dbMu.Lock()
+	defer h.dbMu.Unlock()
+	if len(h.dbProblems) == 0 {
+		return nil
+	}
+	problems := make(map[string]string, len(h.dbProblems))
+	for file, problem := range h.dbProblems {
+		problems[file] = problem
+	}
+	return problems
+}
+
+// fullUpdateApplied records that a full IOC snapshot was applied. It
+// replaces the IOC database, and the scan it triggers blocks the IOC IPs and
+// URLs again, so databases lost at startup no longer leave the agent
+// unprotected.
+func (h *CommandHandler) fullUpdateApplied() {
+	h.dbMu.Lock()
+	defer h.dbMu.Unlock()
+	if len(h.dbProblems) > 0 {
+		log.Printf("Full IOC update applied, databases that failed to load at startup have been rebuilt")
+		h.dbProblems = nil
+	}
+}
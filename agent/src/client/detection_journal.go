@@ -0,0 +1,427 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"agent/persist"
+	pb "agent/proto"
+)
+
+// maxJournalEntries bounds the detections kept in the journal. Beyond it the
+// oldest acknowledged entries are dropped first, then the oldest unsent ones.
+const maxJournalEntries = 1000
+
+// legacyReportExt is the file extension of reports queued in the
+// pending_reports directory by earlier versions
+const legacyReportExt = ".pb"
+
+// journalEntry is one line of the detection journal
+type journalEntry struct {
+	ReportID string          `json:"report_id"`
+	Time     time.Time       `json:"time"`
+	Acked    bool            `json:"acked"`              // The server confirmed the report
+	Rejected string          `json:"rejected,omitempty"` // Why the server refused the report; it is not re-sent
+	Report   json.RawMessage `json:"report"`             // The IOCMatchReport in protobuf JSON form
+	
+	sending bool // A send of the report is in progress
+}
+
+// settled reports whether the entry needs no further delivery attempts
+func (e *journalEntry) settled() bool {
+	return e.Acked || e.Rejected != ""
+}
+
+// detectionJournal records every IOC match report in a JSON lines file,
+// whether or not the server is reachable, and tracks which reports the server
+// has acknowledged so unsent ones can be replayed after an outage or restart.
+// Replays keep the original report ID, so a report that reached the server but
+// whose acknowledgement was lost is stored once.
+type detectionJournal struct {
+	mu      sync.Mutex
+	path    string
+	entries []*journalEntry // Oldest first
+	dropped int64           // Unsent reports dropped because the journal was full
+	
+	queued      chan struct{} // A report failed to send and awaits a retry
+	reconnected chan struct{} // The command stream came back up
+}
+
+// newDetectionJournal opens the journal at path, importing any reports left
+// in the legacy pending reports directory
+func newDetectionJournal(path, legacyDir string) *detectionJournal {
+	j := &detectionJournal{
+		path:        path,
+		queued:      make(chan struct{}, 1),
+		reconnected: make(chan struct{}, 1),
+	}
+	
+	if err := j.load(); err != nil {
+		log.Printf("Warning: failed to read detection journal, starting a new one: %v", err)
+	}
+	j.importLegacy(legacyDir)
+	return j
+}
+
+// load reads the journal file. Lines that cannot be parsed are skipped.
+func (j *detectionJournal) load() error {
+	data, err := os.ReadFile(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry := &journalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil || entry.ReportID == "" {
+			log.Printf("Skipping unreadable detection journal line %d", line)
+			continue
+		}
+		j.entries = append(j.entries, entry)
+	}
+	return scanner.Err()
+}
+
+// importLegacy moves reports queued as one file each in dir by earlier
+// versions into the journal and removes the directory
+func (j *detectionJournal) importLegacy(dir string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), legacyReportExt) {
+			files = append(files, entry.Name())
+		}
+	}
+	sort.Strings(files)
+	
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	
+	imported := 0
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			continue
+		}
+		report := &pb.IOCMatchReport{}
+		if err := proto.Unmarshal(data, report); err != nil {
+			log.Printf("Discarding unreadable pending report %s: %v", name, err)
+			continue
+		}
+		if j.findLocked(report.ReportId) != nil {
+			continue
+		}
+		if entry, err := newJournalEntry(report); err == nil {
+			j.entries = append(j.entries, entry)
+			imported++
+		}
+	}
+	
+	j.trimLocked()
+	if err := j.saveLocked(); err != nil {
+		log.Printf("Warning: failed to import pending reports into the detection journal: %v", err)
+		return
+	}
+	if imported > 0 {
+		log.Printf("Imported %d pending IOC match reports into the detection journal", imported)
+	}
+	os.RemoveAll(dir)
+}
+
+// newJournalEntry creates an unsent entry for a report
+func newJournalEntry(report *pb.IOCMatchReport) (*journalEntry, error) {
+	data, err := protojson.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode report: %v", err)
+	}
+	return &journalEntry{
+		ReportID: report.ReportId,
+		Time:     time.Unix(report.Timestamp, 0).UTC(),
+		Report:   data,
+	}, nil
+}
+
+// record adds a report to the journal before it is sent. The entry is marked
+// as being sent until ack, reject or release is called for it.
+func (j *detectionJournal) record(report *pb.IOCMatchReport) error {
+	entry, err := newJournalEntry(report)
+	if err != nil {
+		return err
+	}
+	entry.sending = true
+	
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	
+	j.entries = append(j.entries, entry)
+	j.trimLocked()
+	return j.saveLocked()
+}
+
+// ack marks a report as confirmed by the server
+func (j *detectionJournal) ack(reportID string) {
+	j.settle(reportID, func(e *journalEntry) { e.Acked = true })
+}
+
+// reject marks a report the server refused, so it is kept for reference but
+// never re-sent
+func (j *detectionJournal) reject(reportID string, reason error) {
+	j.settle(reportID, func(e *journalEntry) { e.Rejected = reason.Error() })
+}
+
+// settle applies mark to a report's entry and saves the journal
+func (j *detectionJournal) settle(reportID string, mark func(*journalEntry)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	
+	entry := j.findLocked(reportID)
+	if entry == nil {
+		return
+	}
+	entry.sending = false
+	mark(entry)
+	if err := j.saveLocked(); err != nil {
+		log.Printf("Warning: failed to update detection journal for report %s: %v", reportID, err)
+	}
+}
+
+// release returns a report whose send failed to the unsent entries and wakes
+// the replay loop
+func (j *detectionJournal) release(reportID string) {
+	j.unclaim(reportID)
+	notifyChan(j.queued)
+}
+
+// unclaim clears the in-progress mark of a report
+func (j *detectionJournal) unclaim(reportID string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	
+	if entry := j.findLocked(reportID); entry != nil {
+		entry.sending = false
+	}
+}
+
+// claim marks an unsent report as being sent and returns it
+func (j *detectionJournal) claim(reportID string) (*pb.IOCMatchReport, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	
+	entry := j.findLocked(reportID)
+	if entry == nil || entry.sending || entry.settled() {
+		return nil, false
+	}
+	
+	report := &pb.IOCMatchReport{}
+	if err := protojson.Unmarshal(entry.Report, report); err != nil {
+		log.Printf("Discarding unreadable journaled report %s: %v", reportID, err)
+		entry.Rejected = fmt.Sprintf("unreadable journal entry: %v", err)
+		j.saveLocked()
+		return nil, false
+	}
+	entry.sending = true
+	return report, true
+}
+
+// unsent returns the IDs of reports awaiting delivery, oldest first
+func (j *detectionJournal) unsent() []string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	
+	var ids []string
+	for _, entry := range j.entries {
+		if !entry.settled() && !entry.sending {
+			ids = append(ids, entry.ReportID)
+		}
+	}
+	return ids
+}
+
+// findLocked returns the entry for a report ID, or nil (caller must hold the lock)
+func (j *detectionJournal) findLocked(reportID string) *journalEntry {
+	for i := len(j.entries) - 1; i >= 0; i-- {
+		if j.entries[i].ReportID == reportID {
+			return j.entries[i]
+		}
+	}
+	return nil
+}
+
+// trimLocked drops entries beyond maxJournalEntries, oldest acknowledged or
+// rejected ones first (caller must hold the lock)
+func (j *detectionJournal) trimLocked() {
+	excess := len(j.entries) - maxJournalEntries
+	if excess <= 0 {
+		return
+	}
+	
+	drop := make(map[*journalEntry]bool, excess)
+	for _, entry := range j.entries {
+		if len(drop) == excess {
+			break
+		}
+		if entry.settled() {
+			drop[entry] = true
+		}
+	}
+	for _, entry := range j.entries {
+		if len(drop) == excess {
+			break
+		}
+		if !entry.settled() && !entry.sending {
+			drop[entry] = true
+			j.dropped++
+			log.Printf("Detection journal full (%d entries), dropped unsent report %s (%d dropped so far)",
+				maxJournalEntries, entry.ReportID, j.dropped)
+		}
+	}
+	
+	kept := j.entries[:0]
+	for _, entry := range j.entries {
+		if !drop[entry] {
+			kept = append(kept, entry)
+		}
+	}
+	j.entries = kept
+}
+
+// saveLocked rewrites the journal file (caller must hold the lock)
+func (j *detectionJournal) saveLocked() error {
+	var buf bytes.Buffer
+	for _, entry := range j.entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode journal entry %s: %v", entry.ReportID, err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	
+	if err := persist.WriteFileAtomic(j.path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write detection journal: %v", err)
+	}
+	return nil
+}
+
+// connected makes the replay loop retry without waiting for its backoff,
+// called when the connection to the server is restored
+func (j *detectionJournal) connected() {
+	notifyChan(j.reconnected)
+}
+
+// notifyChan does a non-blocking send on a 1-buffered notification channel
+func notifyChan(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// replay sends unsent reports oldest first until none are left or a send
+// fails. It returns the number sent and the error that stopped it.
+func (j *detectionJournal) replay(ctx context.Context, send func(context.Context, *pb.IOCMatchReport) error) (int, error) {
+	sent := 0
+	for _, id := range j.unsent() {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		
+		report, ok := j.claim(id)
+		if !ok {
+			// Sent, dropped or being sent elsewhere in the meantime
+			continue
+		}
+		
+		if err := send(ctx, report); err != nil {
+			if retryableReportError(err) {
+				j.unclaim(id)
+				return sent, err
+			}
+			// The server will never accept it, so retrying would block the journal
+			log.Printf("Server rejected journaled report %s, not retrying it: %v", id, err)
+			j.reject(id, err)
+			continue
+		}
+		j.ack(id)
+		sent++
+	}
+	return sent, nil
+}
+
+// run replays unsent reports at startup and whenever a send fails. While
+// sends fail it retries with exponential backoff, or as soon as the command
+// stream reconnects. It returns when ctx is cancelled.
+func (j *detectionJournal) run(ctx context.Context, base, maxDelay time.Duration, send func(context.Context, *pb.IOCMatchReport) error) {
+	failures := 0
+	for {
+		sent, err := j.replay(ctx, send)
+		if sent > 0 {
+			log.Printf("Delivered %d journaled IOC match reports", sent)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != nil {
+			delay := reconnectBackoff(base, maxDelay, failures)
+			failures++
+			log.Printf("Failed to deliver journaled IOC match reports, retrying in %v: %v", delay, err)
+			
+			// Newly failed reports wait for the retry instead of hammering a
+			// server that is still down
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			case <-j.reconnected:
+			}
+			continue
+		}
+
+		failures = 0
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.queued:
+		case <-j.reconnected:
+		}
+	}
+}
+
+// retryableReportError reports whether a failed report RPC may succeed later,
+// as opposed to being rejected by the server
+func retryableReportError(err error) bool {
+	switch status.Code(err) {
+	case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.FailedPrecondition, codes.OutOfRange, codes.Unimplemented, codes.Unauthenticated:
+		return false
+	}
+	return true
+}
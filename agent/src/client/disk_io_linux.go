@@ -0,0 +1,27 @@
+// +build linux
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// sysBlock lists the kernel's block devices. Whole disks have an entry,
+// partitions only appear under their disk.
+const sysBlock = "/sys/block"
+
+// countDiskIO reports whether the I/O of a device from disk.IOCounters is
+// added to the disk totals. /proc/diskstats lists partitions, device-mapper,
+// md, loop and zram devices next to the disks they are backed by, so only
+// whole disks are counted to not count the same I/O several times.
+func countDiskIO(name string) bool {
+	return physicalDisk(sysBlock, name)
+}
+
+// physicalDisk reports whether name is a whole disk backed by a device.
+// Virtual block devices have an entry in sysBlock but no device link.
+func physicalDisk(sysBlock, name string) bool {
+	_, err := os.Stat(filepath.Join(sysBlock, name, "device"))
+	return err == nil
+}
@@ -0,0 +1,34 @@
+// +build linux
+
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPhysicalDisk(t *testing.T) {
+	sysBlock := t.TempDir()
+	for _, dev := range []string{"sda/device", "nvme0n1/device", "dm-0", "loop0", "md0", "zram0"} {
+		if err := os.MkdirAll(filepath.Join(sysBlock, dev), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := map[string]bool{
+		"sda":       true,
+		"nvme0n1":   true,
+		"sda1":      false, // Partitions are not listed in /sys/block
+		"nvme0n1p2": false,
+		"dm-0":      false,
+		"loop0":     false,
+		"md0":       false,
+		"zram0":     false,
+	}
+	for name, want := range tests {
+		if got := physicalDisk(sysBlock, name); got != want {
+			t.Errorf("physicalDisk(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
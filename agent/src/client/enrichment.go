@@ -0,0 +1,229 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"agent/config"
+	pb "agent/proto"
+)
+
+const (
+	enrichmentCacheTTL     = time.Hour // How long a lookup result is reused
+	maxEnrichmentCache     = 1000      // Lookups kept in the cache
+	maxEnrichmentResponse  = 64 << 10  // Largest response body read
+	maxEnrichmentFields    = 16        // Fields added to a report
+	maxEnrichmentValueSize = 256       // Longer values are cut
+)
+
+// Enricher looks up reputation context, such as a score or categories, for
+// a matched IOC so it can be added to the match report
+type Enricher interface {
+	// Enrich returns metadata about value, or nil if there is none. It must
+	// return once ctx is done.
+	Enrich(ctx context.Context, iocType pb.IOCType, value string) (map[string]string, error)
+}
+
+// noopEnricher adds nothing to reports; it is used when enrichment_url is
+// empty
+type noopEnricher struct{}
+
+func (noopEnricher) Enrich(ctx context.Context, iocType pb.IOCType, value string) (map[string]string, error) {
+	return nil, nil
+}
+
+// httpEnricher asks the intel API at enrichment_url about each matched IOC.
+// It POSTs {"type": "ip"|"hash"|"url", "value": ...} and reads a JSON
+// object whose fields are added to the report. Results are cached for an
+// hour so repeated matches do not query the API again.
+type httpEnricher struct {
+	url    string
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[string]enrichmentEntry
+}
+
+// enrichmentEntry is a cached lookup result
+type enrichmentEntry struct {
+	fields  map[string]string
+	expires time.Time
+}
+
+// newEnricher returns the enricher for cfg: HTTP lookups if enrichment_url
+// is set, nothing otherwise
+func newEnricher(cfg *config.Config) Enricher {
+	if cfg.EnrichmentURL == "" {
+		return noopEnricher{}
+	}
+	return &httpEnricher{
+		url:    cfg.EnrichmentURL,
+		client: &http.Client{},
+		cache:  make(map[string]enrichmentEntry),
+	}
+}
+
+// enrichmentType is the type name sent to the intel API, empty for IOC
+// types that are not looked up
+func enrichmentType(iocType pb.IOCType) string {
+	switch iocType {
+	case pb.IOCType_IOC_IP:
+		return "ip"
+	case pb.IOCType_IOC_HASH:
+		return "hash"
+	case pb.IOCType_IOC_URL:
+		return "url"
+	}
+	return ""
+}
+
+func (e *httpEnricher) Enrich(ctx context.Context, iocType pb.IOCType, value string) (map[string]string, error) {
+	kind := enrichmentType(iocType)
+	if kind == "" {
+		return nil, nil
+	}
+
+	key := kind + ":" + value
+	e.mu.Lock()
+	entry, ok := e.cache[key]
+	e.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.fields, nil
+	}
+
+	body, err := json.Marshal(map[string]string{"type": kind, "value": value})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("invalid enrichment URL: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("enrichment lookup failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		e.store(key, nil)
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("enrichment lookup failed: server returned %s", resp.Status)
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxEnrichmentResponse)).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid enrichment response: %v", err)
+	}
+
+	names := make([]string, 0, len(result))
+	for name := range result {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fields := make(map[string]string)
+	for _, name := range names {
+		if len(fields) == maxEnrichmentFields {
+			break
+		}
+		if s := enrichmentValue(result[name]); s != "" {
+			fields[enrichmentValue(name)] = s
+		}
+	}
+	e.store(key, fields)
+	return fields, nil
+}
+
+// store caches a lookup result, dropping expired entries once the cache is
+// full, or everything if none has expired
+func (e *httpEnricher) store(key string, fields map[string]string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := time.Now()
+	if len(e.cache) >= maxEnrichmentCache {
+		for k, entry := range e.cache {
+			if now.After(entry.expires) {
+				delete(e.cache, k)
+			}
+		}
+		if len(e.cache) >= maxEnrichmentCache {
+			e.cache = make(map[string]enrichmentEntry)
+		}
+	}
+	e.cache[key] = enrichmentEntry{fields: fields, expires: now.Add(enrichmentCacheTTL)}
+}
+
+// enrichmentValue formats a JSON value for the report: scalars as they are
+// and lists of scalars comma separated. Objects are left out.
+func enrichmentValue(v interface{}) string {
+	var s string
+	switch value := v.(type) {
+	case string:
+		s = value
+	case float64, bool:
+		s = fmt.Sprint(value)
+	case []interface{}:
+		var items []string
+		for _, item := range value {
+			switch item.(type) {
+			case string, float64, bool:
+				items = append(items, fmt.Sprint(item))
+			}
+		}
+		s = strings.Join(items, ",")
+	}
+	// Keep the report on one line per field
+	s = strings.Join(strings.Fields(s), " ")
+	if len(s) > maxEnrichmentValueSize {
+		s = strings.ToValidUTF8(s[:maxEnrichmentValueSize], "") + "..."
+	}
+	return s
+}
+
+// SetEnricher replaces the enricher that adds reputation context to IOC
+// match reports
+func (h *CommandHandler) SetEnricher(enricher Enricher) {
+	h.enricher = enricher
+}
+
+// enrich looks up the matched IOC with the enricher, waiting at most
+// enrichment_timeout, and returns the line added to the report's context,
+// or "" if there is nothing to add
+func (h *CommandHandler) enrich(ctx context.Context, iocType pb.IOCType, value string) string {
+	ctx, cancel := context.WithTimeout(ctx, h.client.config.GetEnrichmentTimeoutDuration())
+	defer cancel()
+
+	fields, err := h.enricher.Enrich(ctx, iocType, value)
+	if err != nil {
+		log.Printf("Failed to enrich IOC match %s: %v", value, err)
+		return ""
+	}
+	if len(fields) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + "=" + fields[name]
+	}
+	return "Enrichment: " + strings.Join(pairs, ", ")
+}
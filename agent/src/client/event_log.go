@@ -0,0 +1,88 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"agent/ioc"
+)
+
+const (
+	// defaultEventLogEvents is the number of events returned when
+	// max_events is not given
+	defaultEventLogEvents = 100
+	// maxEventLogEvents caps max_events for a single COLLECT_EVENT_LOG
+	maxEventLogEvents = 1000
+	// maxEventLogResultBytes caps the size of the JSON result; older events
+	// beyond it are dropped
+	maxEventLogResultBytes = 1024 * 1024
+)
+
+// eventLogResult is the COLLECT_EVENT_LOG result
+type eventLogResult struct {
+	LogName   string         `json:"log_name"`
+	Count     int            `json:"count"`
+	Truncated bool           `json:"truncated,omitempty"` // Events were dropped to fit maxEventLogResultBytes
+	Events    []ioc.LogEvent `json:"events"`
+}
+
+// handleCollectEventLog returns the most recent entries of a Windows event
+// log as JSON, newest first
+func (h *CommandHandler) handleCollectEventLog(params map[string]string) (string, error) {
+	logName := strings.TrimSpace(params["log_name"])
+	if logName == "" {
+		return "", fmt.Errorf("missing required parameter 'log_name'")
+	}
+
+	maxEvents := defaultEventLogEvents
+	if v := params["max_events"]; v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("invalid max_events: %s", v)
+		}
+		maxEvents = n
+	}
+	if maxEvents > maxEventLogEvents {
+		maxEvents = maxEventLogEvents
+	}
+
+	var eventIDs []uint32
+	if v := params["event_ids"]; v != "" {
+		for _, s := range strings.Split(v, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+			if err != nil {
+				return "", fmt.Errorf("invalid event ID in event_ids: %s", s)
+			}
+			eventIDs = append(eventIDs, uint32(id))
+		}
+	}
+
+	events, err := ioc.CollectEvents(logName, eventIDs, maxEvents)
+	if err != nil && len(events) == 0 {
+		return "", fmt.Errorf("failed to read event log %s: %v", logName, err)
+	}
+
+	result := eventLogResult{LogName: logName, Events: events}
+	size := 0
+	for i, event := range events {
+		data, _ := json.Marshal(event)
+		size += len(data)
+		if size > maxEventLogResultBytes {
+			result.Events = events[:i]
+			result.Truncated = true
+			break
+		}
+	}
+	if result.Events == nil {
+		result.Events = []ioc.LogEvent{}
+	}
+	result.Count = len(result.Events)
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode event log: %v", err)
+	}
+	return string(data), nil
+}
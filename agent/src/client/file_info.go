@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"agent/ioc"
+)
+
+// fileInfo is the GET_FILE_INFO result
+type fileInfo struct {
+	Path      string         `json:"path"`
+	Size      int64          `json:"size"`
+	Mode      string         `json:"mode"`
+	IsDir     bool           `json:"is_dir"`
+	Modified  time.Time      `json:"modified"`
+	Created   *time.Time     `json:"created,omitempty"`
+	Accessed  *time.Time     `json:"accessed,omitempty"`
+	MD5       string         `json:"md5,omitempty"`
+	SHA1      string         `json:"sha1,omitempty"`
+	SHA256    string         `json:"sha256,omitempty"`
+	HashError string         `json:"hash_error,omitempty"`
+	Signature *fileSignature `json:"signature,omitempty"`
+	Version   *fileVersion   `json:"version,omitempty"`
+}
+
+// fileSignature is the Authenticode status of a file
+type fileSignature struct {
+	Signed bool   `json:"signed"`
+	Valid  bool   `json:"valid"`
+	Status string `json:"status"`
+	Signer string `json:"signer,omitempty"`
+}
+
+// fileVersion is the version resource of an executable
+type fileVersion struct {
+	FileVersion      string `json:"file_version,omitempty"`
+	ProductVersion   string `json:"product_version,omitempty"`
+	CompanyName      string `json:"company_name,omitempty"`
+	ProductName      string `json:"product_name,omitempty"`
+	FileDescription  string `json:"file_description,omitempty"`
+	OriginalFilename string `json:"original_filename,omitempty"`
+}
+
+// handleGetFileInfo returns a file's metadata, hashes, signature and version
+// information as JSON without modifying it
+func (h *CommandHandler) handleGetFileInfo(params map[string]string) (string, error) {
+	path, ok := params["path"]
+	if !ok || path == "" {
+		return "", fmt.Errorf("missing required parameter 'path'")
+	}
+
+	stat, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("file not found: %s", path)
+		}
+		return "", fmt.Errorf("failed to stat %s: %v", path, err)
+	}
+
+	info := fileInfo{
+		Path:     path,
+		Size:     stat.Size(),
+		Mode:     stat.Mode().String(),
+		IsDir:    stat.IsDir(),
+		Modified: stat.ModTime().UTC(),
+	}
+	created, accessed := fileTimes(stat)
+	if !created.IsZero() {
+		created = created.UTC()
+		info.Created = &created
+	}
+	if !accessed.IsZero() {
+		accessed = accessed.UTC()
+		info.Accessed = &accessed
+	}
+
+	if !stat.IsDir() {
+		// A file locked by another process still gets its metadata reported
+		var hashErr error
+		if info.MD5, hashErr = ioc.GetMD5(path); hashErr == nil {
+			if info.SHA1, hashErr = ioc.GetSHA1(path); hashErr == nil {
+				info.SHA256, hashErr = ioc.GetSHA256(path)
+			}
+		}
+		if hashErr != nil {
+			info.MD5, info.SHA1, info.SHA256 = "", "", ""
+			info.HashError = hashErr.Error()
+		}
+
+		info.Signature = fileSignatureInfo(path)
+		info.Version = fileVersionInfo(path)
+	}
+
+	data, err := json.Marshal(info)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode file info: %v", err)
+	}
+
+	return string(data), nil
+}
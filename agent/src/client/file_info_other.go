@@ -0,0 +1,22 @@
+// +build !windows
+
+package client
+
+import (
+	"os"
+	"time"
+)
+
+// Creation times, Authenticode and version resources are only read on Windows
+
+func fileTimes(info os.FileInfo) (created, accessed time.Time) {
+	return time.Time{}, time.Time{}
+}
+
+func fileSignatureInfo(path string) *fileSignature {
+	return nil
+}
+
+func fileVersionInfo(path string) *fileVersion {
+	return nil
+}
@@ -0,0 +1,181 @@
+// +build windows
+
+package client
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// cmsgSignerCertInfoParam is CMSG_SIGNER_CERT_INFO_PARAM, the issuer and
+// serial number of a PKCS #7 message's signer
+const cmsgSignerCertInfoParam = 7
+
+var (
+	crypt32              = windows.NewLazySystemDLL("crypt32.dll")
+	procCryptMsgGetParam = crypt32.NewProc("CryptMsgGetParam")
+	procCryptMsgClose    = crypt32.NewProc("CryptMsgClose")
+)
+
+// fileTimes returns the creation and last access times NTFS keeps for a file
+func fileTimes(info os.FileInfo) (created, accessed time.Time) {
+	data, ok := info.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}, time.Time{}
+	}
+	return time.Unix(0, data.CreationTime.Nanoseconds()), time.Unix(0, data.LastAccessTime.Nanoseconds())
+}
+
+// fileSignatureInfo checks a file's embedded Authenticode signature with
+// WinVerifyTrust. Files only signed through a security catalog, like many
+// Windows components, are reported as unsigned.
+func fileSignatureInfo(path string) *fileSignature {
+	path16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return nil
+	}
+
+	data := &windows.WinTrustData{
+		Size:             uint32(unsafe.Sizeof(windows.WinTrustData{})),
+		UIChoice:         windows.WTD_UI_NONE,
+		RevocationChecks: windows.WTD_REVOKE_NONE, // Revocation lookups could hang on an isolated host
+		UnionChoice:      windows.WTD_CHOICE_FILE,
+		StateAction:      windows.WTD_STATEACTION_VERIFY,
+		FileOrCatalogOrBlobOrSgnrOrCert: unsafe.Pointer(&windows.WinTrustFileInfo{
+			Size:     uint32(unsafe.Sizeof(windows.WinTrustFileInfo{})),
+			FilePath: path16,
+		}),
+	}
+	verifyErr := windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, data)
+	data.StateAction = windows.WTD_STATEACTION_CLOSE
+	windows.WinVerifyTrustEx(windows.InvalidHWND, &windows.WINTRUST_ACTION_GENERIC_VERIFY_V2, data)
+
+	sig := &fileSignature{Signed: true}
+	switch verifyErr {
+	case nil:
+		sig.Valid = true
+		sig.Status = "valid"
+	case windows.Errno(windows.TRUST_E_NOSIGNATURE):
+		sig.Signed = false
+		sig.Status = "unsigned"
+		return sig
+	case windows.Errno(windows.TRUST_E_BAD_DIGEST):
+		sig.Status = "bad_digest"
+	case windows.Errno(windows.CERT_E_EXPIRED):
+		sig.Status = "expired"
+	case windows.Errno(windows.CERT_E_REVOKED):
+		sig.Status = "revoked"
+	case windows.Errno(windows.CERT_E_UNTRUSTEDROOT):
+		sig.Status = "untrusted_root"
+	case windows.Errno(windows.TRUST_E_EXPLICIT_DISTRUST), windows.Errno(windows.TRUST_E_SUBJECT_NOT_TRUSTED):
+		sig.Status = "not_trusted"
+	default:
+		sig.Status = verifyErr.Error()
+	}
+
+	// The signer is reported for invalid signatures too, it may still tell
+	// an analyst who the file claims to come from
+	sig.Signer = fileSigner(path16)
+	return sig
+}
+
+// fileSigner returns the display name of the certificate that signed a file,
+// or "" if it cannot be read
+func fileSigner(path16 *uint16) string {
+	var encoding, contentType, formatType uint32
+	var store, msg windows.Handle
+	err := windows.CryptQueryObject(
+		windows.CERT_QUERY_OBJECT_FILE,
+		unsafe.Pointer(path16),
+		windows.CERT_QUERY_CONTENT_FLAG_PKCS7_SIGNED_EMBED,
+		windows.CERT_QUERY_FORMAT_FLAG_BINARY,
+		0,
+		&encoding, &contentType, &formatType,
+		&store, &msg, nil,
+	)
+	if err != nil {
+		return ""
+	}
+	defer windows.CertCloseStore(store, 0)
+	defer procCryptMsgClose.Call(uintptr(msg))
+
+	// The signer's issuer and serial number identify its certificate in the
+	// store that came with the signature
+	var size uint32
+	ret, _, _ := procCryptMsgGetParam.Call(uintptr(msg), cmsgSignerCertInfoParam, 0, 0, uintptr(unsafe.Pointer(&size)))
+	if ret == 0 || size == 0 {
+		return ""
+	}
+	buf := make([]byte, size)
+	ret, _, _ = procCryptMsgGetParam.Call(uintptr(msg), cmsgSignerCertInfoParam, 0,
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(unsafe.Pointer(&size)))
+	if ret == 0 {
+		return ""
+	}
+
+	cert, err := windows.CertFindCertificateInStore(store, encoding, 0, windows.CERT_FIND_SUBJECT_CERT,
+		unsafe.Pointer(&buf[0]), nil)
+	if err != nil {
+		return ""
+	}
+	defer windows.CertFreeCertificateContext(cert)
+
+	chars := windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, nil, 0)
+	if chars <= 1 {
+		return ""
+	}
+	name := make([]uint16, chars)
+	windows.CertGetNameString(cert, windows.CERT_NAME_SIMPLE_DISPLAY_TYPE, 0, nil, &name[0], chars)
+	return windows.UTF16ToString(name)
+}
+
+// fileVersionInfo reads the version resource of an executable, or returns
+// nil if it has none
+func fileVersionInfo(path string) *fileVersion {
+	size, err := windows.GetFileVersionInfoSize(path, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	block := make([]byte, size)
+	if err := windows.GetFileVersionInfo(path, 0, size, unsafe.Pointer(&block[0])); err != nil {
+		return nil
+	}
+
+	version := &fileVersion{}
+
+	var fixed *windows.VS_FIXEDFILEINFO
+	var fixedLen uint32
+	if err := windows.VerQueryValue(unsafe.Pointer(&block[0]), `\`, unsafe.Pointer(&fixed), &fixedLen); err == nil && fixedLen > 0 {
+		version.FileVersion = fmt.Sprintf("%d.%d.%d.%d",
+			fixed.FileVersionMS>>16, fixed.FileVersionMS&0xffff, fixed.FileVersionLS>>16, fixed.FileVersionLS&0xffff)
+		version.ProductVersion = fmt.Sprintf("%d.%d.%d.%d",
+			fixed.ProductVersionMS>>16, fixed.ProductVersionMS&0xffff, fixed.ProductVersionLS>>16, fixed.ProductVersionLS&0xffff)
+	}
+
+	// String values are stored per language and code page; use the first
+	// translation the file lists
+	var translation *[2]uint16
+	var translationLen uint32
+	if err := windows.VerQueryValue(unsafe.Pointer(&block[0]), `\VarFileInfo\Translation`, unsafe.Pointer(&translation), &translationLen); err == nil && translationLen >= 4 {
+		prefix := fmt.Sprintf(`\StringFileInfo\%04x%04x\`, translation[0], translation[1])
+		value := func(name string) string {
+			var ptr *uint16
+			var chars uint32
+			if err := windows.VerQueryValue(unsafe.Pointer(&block[0]), prefix+name, unsafe.Pointer(&ptr), &chars); err != nil || chars == 0 {
+				return ""
+			}
+			return windows.UTF16PtrToString(ptr)
+		}
+		version.CompanyName = value("CompanyName")
+		version.ProductName = value("ProductName")
+		version.FileDescription = value("FileDescription")
+		version.OriginalFilename = value("OriginalFilename")
+	}
+
+	return version
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"agent/logging"
+	pb "agent/proto"
+)
+
+// uploadChunkSize is the size of the data frames sent by UploadFile
+const uploadChunkSize = 64 * 1024
+
+// UploadFile streams the file at path to the server in uploadChunkSize frames
+// so it is never loaded into memory whole. The SHA256 is computed while
+// reading and sent in the final frame. Files larger than max_upload_size are
+// refused. It returns the file's SHA256 and size.
+func (c *EDRClient) UploadFile(ctx context.Context, path, commandID, reason string) (string, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open file: %v", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to stat file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", 0, fmt.Errorf("%s is not a regular file", path)
+	}
+
+	maxSize := c.config.GetMaxUploadSizeBytes()
+	if info.Size() > maxSize {
+		return "", 0, fmt.Errorf("file %s is %d bytes, larger than the upload limit of %d MB (max_upload_size)",
+			path, info.Size(), c.config.Current().MaxUploadSize)
+	}
+
+	stream, err := c.edrClient.UploadFile(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to open upload stream: %v", err)
+	}
+
+	uploadID := fmt.Sprintf("%s-%d", c.agentID, time.Now().UnixNano())
+	log.Printf("Uploading %s (%d bytes) to server as %s", logging.RedactParam("path", path), info.Size(), uploadID)
+
+	// newChunk builds a frame; the first one also carries the file metadata
+	first := true
+	newChunk := func(offset int64) *pb.FileChunk {
+		chunk := &pb.FileChunk{
+			AgentId:  c.agentID,
+			UploadId: uploadID,
+			Offset:   offset,
+		}
+		if first {
+			chunk.CommandId = commandID
+			chunk.FilePath = path
+			chunk.FileSize = info.Size()
+			chunk.Reason = reason
+			first = false
+		}
+		return chunk
+	}
+
+	hasher := sha256.New()
+	buf := make([]byte, uploadChunkSize)
+	var offset int64
+	for {
+		n, readErr := io.ReadFull(file, buf)
+		if n > 0 {
+			// The file may have grown since it was checked
+			if offset+int64(n) > maxSize {
+				stream.CloseSend()
+				return "", offset, fmt.Errorf("file %s grew past the upload limit of %d MB (max_upload_size) while uploading",
+					path, c.config.Current().MaxUploadSize)
+			}
+
+			hasher.Write(buf[:n])
+			chunk := newChunk(offset)
+			chunk.Data = buf[:n]
+			if err := stream.Send(chunk); err != nil {
+				return "", offset, fmt.Errorf("failed to send file chunk at offset %d: %v", offset, err)
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			stream.CloseSend()
+			return "", offset, fmt.Errorf("failed to read file: %v", readErr)
+		}
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	final := newChunk(offset)
+	final.Last = true
+	final.Sha256 = hash
+	if err := stream.Send(final); err != nil {
+		return "", offset, fmt.Errorf("failed to send final file chunk: %v", err)
+	}
+
+	ack, err := stream.CloseAndRecv()
+	if err != nil {
+		return "", offset, fmt.Errorf("upload failed: %v", err)
+	}
+	if !ack.Success {
+		return "", offset, fmt.Errorf("server rejected upload: %s", ack.Message)
+	}
+
+	log.Printf("Uploaded %s (%d bytes, sha256 %s)", logging.RedactParam("path", path), offset, hash)
+	return hash, offset, nil
+}
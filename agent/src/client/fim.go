@@ -0,0 +1,47 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+
+	"agent/ioc"
+	pb "agent/proto"
+)
+
+// reportFIMChange sends a FIM_CHANGE event for a file in fim_paths that
+// differs from its baseline
+func (h *CommandHandler) reportFIMChange(change ioc.FIMChange) {
+	details := map[string]string{
+		"path":   change.Path,
+		"change": change.Change,
+	}
+	if change.OldSHA256 != "" {
+		details["old_sha256"] = change.OldSHA256
+	}
+	if change.NewSHA256 != "" {
+		details["new_sha256"] = change.NewSHA256
+	}
+	h.client.SendEvent(pb.AgentEventType_FIM_CHANGE,
+		fmt.Sprintf("Monitored file %s: %s", change.Change, change.Path), details)
+}
+
+// handleFIMRebaseline accepts the current state of the files in fim_paths,
+// or only of the optional path parameter, as their new baseline
+func (h *CommandHandler) handleFIMRebaseline(params map[string]string) (string, error) {
+	if h.scanner == nil {
+		return "", fmt.Errorf("IOC scanner not available")
+	}
+	if len(h.client.config.Current().FIMPaths) == 0 {
+		return "", fmt.Errorf("no fim_paths are configured")
+	}
+
+	path := strings.TrimSpace(params["path"])
+	count, err := h.scanner.FIM().Rebaseline(path)
+	if err != nil {
+		return "", err
+	}
+	if path != "" {
+		return fmt.Sprintf("Re-baselined %d files under %s", count, path), nil
+	}
+	return fmt.Sprintf("Re-baselined %d files", count), nil
+}
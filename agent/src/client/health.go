@@ -0,0 +1,169 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// HealthServer serves local health, metrics and version endpoints for
+// monitoring tools. It only listens on the loopback interface.
+type HealthServer struct {
+	client *EDRClient
+	server *http.Server
+}
+
+// NewHealthServer creates a health server for port on 127.0.0.1
+func NewHealthServer(client *EDRClient, port int) *HealthServer {
+	h := &HealthServer{client: client}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", h.handleHealthz)
+	mux.HandleFunc("/metrics", h.handleMetrics)
+	mux.HandleFunc("/version", h.handleVersion)
+
+	h.server = &http.Server{
+		Addr:              net.JoinHostPort("127.0.0.1", strconv.Itoa(port)),
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return h
+}
+
+// Start begins listening. The port is bound before Start returns so a port
+// conflict is reported to the caller.
+func (h *HealthServer) Start() error {
+	listener, err := net.Listen("tcp", h.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to start health server: %v", err)
+	}
+
+	log.Printf("Health server listening on http://%s", h.server.Addr)
+	go func() {
+		if err := h.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Health server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the server, waiting for in-flight requests until ctx expires
+func (h *HealthServer) Shutdown(ctx context.Context) error {
+	return h.server.Shutdown(ctx)
+}
+
+// handleHealthz returns 200 while the command stream is connected and 503
+// with the connection state, its cause and start time otherwise
+func (h *HealthServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	state, cause, since := h.client.stateDetails()
+	if state != StateConnected {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "command stream %s since %s: %s\n", state, since.UTC().Format(time.RFC3339), cause)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// handleMetrics writes system, IOC, scan and block metrics in the Prometheus text format
+func (h *HealthServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	connected := 0
+	if h.client.StreamConnected() {
+		connected = 1
+	}
+
+	writeMetric(w, "edr_agent_stream_connected", "Whether the command stream to the server is up", "gauge", float64(connected))
+	state := h.client.State()
+	fmt.Fprintln(w, "# HELP edr_agent_connection_state Current state of the command stream (1 for the current state)")
+	fmt.Fprintln(w, "# TYPE edr_agent_connection_state gauge")
+	for _, s := range connectionStates {
+		current := 0
+		if s == state {
+			current = 1
+		}
+		fmt.Fprintf(w, "edr_agent_connection_state{state=%q} %d\n", s, current)
+	}
+	conn := h.client.connMetrics.snapshot(time.Now())
+	writeMetric(w, "edr_agent_connection_uptime_percent", "Share of the time since the agent started that the command stream was up", "gauge", conn.UptimePercent)
+	writeMetric(w, "edr_agent_reconnects_total", "Times the command stream was re-established after being lost", "counter", float64(conn.Reconnects))
+	writeMetric(w, "edr_agent_heartbeat_latency_seconds", "Round trip of the last heartbeat echoed by the server (0 if none was)", "gauge", conn.LatencyMs/1000)
+	if skew, ok := h.client.ClockSkew(); ok {
+		writeMetric(w, "edr_agent_clock_skew_seconds", "Agent clock minus server clock, estimated from server replies", "gauge", skew.Seconds())
+	}
+	if conn.LastDisconnectTime > 0 {
+		fmt.Fprintln(w, "# HELP edr_agent_last_disconnect_timestamp_seconds Unix time the command stream was last lost, labelled with why")
+		fmt.Fprintln(w, "# TYPE edr_agent_last_disconnect_timestamp_seconds gauge")
+		fmt.Fprintf(w, "edr_agent_last_disconnect_timestamp_seconds{reason=\"%s\"} %d\n", labelEscaper.Replace(conn.LastDisconnectReason), conn.LastDisconnectTime)
+	}
+	writeMetric(w, "edr_agent_cpu_usage_percent", "System CPU usage", "gauge", getCPUUsage(h.client.config)*100)
+	writeMetric(w, "edr_agent_memory_usage_percent", "System memory usage", "gauge", getMemoryUsage()*100)
+	writeMetric(w, "edr_agent_uptime_seconds", "System uptime", "gauge", float64(getUptime()))
+
+	if handler := h.client.GetCommandHandler(); handler != nil {
+		protected := 0
+		if handler.Protected() {
+			protected = 1
+		}
+		writeMetric(w, "edr_agent_protected", "Whether the IOC and block databases loaded cleanly or were rebuilt by a full IOC update", "gauge", float64(protected))
+
+		if manager := handler.GetIOCManager(); manager != nil {
+			stats := manager.GetStats()
+			fmt.Fprintln(w, "# HELP edr_agent_iocs Indicators in the local IOC database")
+			fmt.Fprintln(w, "# TYPE edr_agent_iocs gauge")
+			for _, kind := range []struct{ label, key string }{
+				{"ip", "ip_count"},
+				{"file_hash", "file_count"},
+				{"url", "url_count"},
+			} {
+				fmt.Fprintf(w, "edr_agent_iocs{type=%q} %v\n", kind.label, stats[kind.key])
+			}
+			if version, ok := stats["version"].(int64); ok {
+				writeMetric(w, "edr_agent_ioc_version", "Version of the local IOC database", "gauge", float64(version))
+			}
+		}
+
+		if scanner := handler.GetScanner(); scanner != nil {
+			stats := scanner.Stats()
+			writeMetric(w, "edr_agent_last_scan_duration_seconds", "Duration of the most recent periodic IOC scan", "gauge", stats.LastScanDuration.Seconds())
+			if !stats.LastScanTime.IsZero() {
+				writeMetric(w, "edr_agent_last_scan_timestamp_seconds", "Unix time the most recent periodic IOC scan started", "gauge", float64(stats.LastScanTime.Unix()))
+			}
+			writeMetric(w, "edr_agent_files_hashed_total", "Files hashed for IOC matching", "counter", float64(stats.FilesHashed))
+			writeMetric(w, "edr_agent_events_processed_total", "Sysmon events processed", "counter", float64(stats.EventsProcessed))
+			writeMetric(w, "edr_agent_matches_found_total", "IOC and YARA matches reported", "counter", float64(stats.MatchesFound))
+		}
+
+		if b := handler.GetBlocker(); b != nil {
+			ips, urls := b.GetBlockedCount()
+			fmt.Fprintln(w, "# HELP edr_agent_blocked Active IP and URL blocks")
+			fmt.Fprintln(w, "# TYPE edr_agent_blocked gauge")
+			fmt.Fprintf(w, "edr_agent_blocked{type=\"ip\"} %d\n", ips)
+			fmt.Fprintf(w, "edr_agent_blocked{type=\"url\"} %d\n", urls)
+		}
+	}
+}
+
+// handleVersion returns the agent version and ID as JSON
+func (h *HealthServer) handleVersion(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"agent_id": h.client.agentID,
+		"version":  h.client.agentVersion,
+	})
+}
+
+// labelEscaper escapes a Prometheus label value
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetric writes a single Prometheus sample with its HELP and TYPE lines
+func writeMetric(w http.ResponseWriter, name, help, kind string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// Chains holding the network isolation rules on Linux. INPUT and OUTPUT
+// jump to them; the allow rules come first and each ends with a DROP.
+const (
+	isolationChainIn  = "EDR_ISOLATE_IN"
+	isolationChainOut = "EDR_ISOLATE_OUT"
+)
+
+// isolationHooks pairs each built-in chain with the isolation chain it
+// jumps to, in the order rules are added and removed
+var isolationHooks = []struct{ hook, chain string }{
+	{"INPUT", isolationChainIn},
+	{"OUTPUT", isolationChainOut},
+}
+
+// isolationTools are the firewall tools isolation is applied with, so that
+// IPv6 traffic is cut off as well
+var isolationTools = []string{"iptables", "ip6tables"}
+
+// isolationTool returns the tool that manages rules for ip
+func isolationTool(ip string) string {
+	if net.ParseIP(ip).To4() != nil {
+		return "iptables"
+	}
+	return "ip6tables"
+}
+
+// addIptablesIsolationRule appends an ACCEPT rule to an isolation chain,
+// logging rather than failing so one bad rule does not stop isolation
+func (h *CommandHandler) addIptablesIsolationRule(ctx context.Context, tool, chain string, args ...string) {
+	ruleArgs := append([]string{"-A", chain}, args...)
+	ruleArgs = append(ruleArgs, "-j", "ACCEPT")
+	if output, err := h.runner.Run(ctx, tool, ruleArgs...); err != nil {
+		log.Printf("WARNING: Failed to add %s rule %s: %v, output: %s", tool, strings.Join(ruleArgs, " "), err, string(output))
+	}
+}
+
+// isolateIptables isolates the host with iptables and ip6tables, keeping
+// the same addresses reachable as the Windows Firewall rules do. IP blocks
+// in the EDR_BLOCK chain are left alone.
+func (h *CommandHandler) isolateIptables(ctx context.Context, allowedIPs []string, infra *netInfrastructure) error {
+	for _, tool := range isolationTools {
+		for _, chain := range []string{isolationChainIn, isolationChainOut} {
+			// Creating the chain fails if it already exists, which is fine;
+			// flushing drops the rules of an earlier isolation
+			h.runner.Run(ctx, tool, "-N", chain)
+			if output, err := h.runner.Run(ctx, tool, "-F", chain); err != nil {
+				return fmt.Errorf("failed to set up %s chain %s: %v, output: %s", tool, chain, err, string(output))
+			}
+		}
+		h.addIptablesIsolationRule(ctx, tool, isolationChainIn, "-i", "lo")
+		h.addIptablesIsolationRule(ctx, tool, isolationChainOut, "-o", "lo")
+	}
+	// IPv6 finds the gateway and neighbours with ICMPv6, which iptables
+	// filters unlike ARP
+	h.addIptablesIsolationRule(ctx, "ip6tables", isolationChainIn, "-p", "ipv6-icmp")
+	h.addIptablesIsolationRule(ctx, "ip6tables", isolationChainOut, "-p", "ipv6-icmp")
+
+	// FIRST: Add exception rules for allowed IPs BEFORE blocking all traffic
+	for _, ip := range allowedIPs {
+		tool := isolationTool(ip)
+		h.addIptablesIsolationRule(ctx, tool, isolationChainIn, "-s", ip)
+		h.addIptablesIsolationRule(ctx, tool, isolationChainOut, "-d", ip)
+	}
+
+	// Keep name resolution working so the server hostname can be resolved
+	for _, ip := range infra.DNSServers {
+		tool := isolationTool(ip)
+		for _, protocol := range []string{"udp", "tcp"} {
+			h.addIptablesIsolationRule(ctx, tool, isolationChainOut, "-d", ip, "-p", protocol, "--dport", "53")
+			h.addIptablesIsolationRule(ctx, tool, isolationChainIn, "-s", ip, "-p", protocol, "--sport", "53")
+		}
+	}
+
+	// Keep the default gateway reachable so the route to the server stays up
+	for _, ip := range infra.Gateways {
+		tool := isolationTool(ip)
+		h.addIptablesIsolationRule(ctx, tool, isolationChainIn, "-s", ip)
+		h.addIptablesIsolationRule(ctx, tool, isolationChainOut, "-d", ip)
+	}
+
+	// Let the DHCP lease be renewed. Requests may be broadcast, so these
+	// rules match on ports rather than the DHCP server address.
+	h.addIptablesIsolationRule(ctx, "iptables", isolationChainOut, "-p", "udp", "--sport", "68", "--dport", "67")
+	h.addIptablesIsolationRule(ctx, "iptables", isolationChainIn, "-p", "udp", "--sport", "67", "--dport", "68")
+	h.addIptablesIsolationRule(ctx, "ip6tables", isolationChainOut, "-p", "udp", "--sport", "546", "--dport", "547")
+	h.addIptablesIsolationRule(ctx, "ip6tables", isolationChainIn, "-p", "udp", "--sport", "547", "--dport", "546")
+
+	// SECOND: Now block all other traffic (after exceptions are in place)
+	for _, tool := range isolationTools {
+		for _, chain := range []string{isolationChainIn, isolationChainOut} {
+			if output, err := h.runner.Run(ctx, tool, "-A", chain, "-j", "DROP"); err != nil {
+				return fmt.Errorf("failed to block traffic with %s: %v, output: %s", tool, err, string(output))
+			}
+		}
+		for _, jump := range isolationHooks {
+			if _, err := h.runner.Run(ctx, tool, "-C", jump.hook, "-j", jump.chain); err == nil {
+				continue
+			}
+			if output, err := h.runner.Run(ctx, tool, "-I", jump.hook, "-j", jump.chain); err != nil {
+				return fmt.Errorf("failed to block traffic with %s: %v, output: %s", tool, err, string(output))
+			}
+		}
+	}
+	return nil
+}
+
+// restoreIptables removes the isolation chains and the jumps to them. Every
+// step is tried even if an earlier one fails.
+func (h *CommandHandler) restoreIptables(ctx context.Context) error {
+	var failures []string
+	for _, tool := range isolationTools {
+		for _, jump := range isolationHooks {
+			// Delete every jump, there may be more than one
+			for {
+				if _, err := h.runner.Run(ctx, tool, "-D", jump.hook, "-j", jump.chain); err != nil {
+					break
+				}
+			}
+			// A chain that is already gone has nothing to restore
+			if _, err := h.runner.Run(ctx, tool, "-L", jump.chain, "-n"); err != nil {
+				continue
+			}
+			for _, args := range [][]string{{"-F", jump.chain}, {"-X", jump.chain}} {
+				if output, err := h.runner.Run(ctx, tool, args...); err != nil {
+					failures = append(failures, fmt.Sprintf("%s %s: %v, output: %s",
+						tool, strings.Join(args, " "), err, strings.TrimSpace(string(output))))
+				}
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	pb "agent/proto"
+	"agent/persist"
+)
+
+// isolationState is persisted while the host is isolated so the watchdog
+// survives an agent restart
+type isolationState struct {
+	Deadline time.Time `json:"deadline"`
+}
+
+// isolationWatchdog is a dead-man timer for network isolation. Isolation cuts
+// the host off from everything but the server, so if the server stops
+// renewing it (for example because isolation broke the connection) the
+// watchdog lifts isolation when the timer runs out instead of leaving the
+// host unreachable.
+type isolationWatchdog struct {
+	mu        sync.Mutex
+	path      string
+	timer     *time.Timer
+	deadline  time.Time
+	onExpired func() // Called when the timer runs out
+}
+
+// newIsolationWatchdog creates a stopped watchdog that stores its deadline in path
+func newIsolationWatchdog(path string, onExpired func()) *isolationWatchdog {
+	return &isolationWatchdog{path: path, onExpired: onExpired}
+}
+
+// start (re)arms the timer to fire after d. A d of 0 disarms it.
+func (w *isolationWatchdog) start(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if d <= 0 {
+		w.stopUnlocked()
+		return
+	}
+	w.armUnlocked(time.Now().Add(d))
+}
+
+// renew pushes the deadline back by d. It fails if the timer is not running.
+func (w *isolationWatchdog) renew(d time.Duration) (time.Time, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer == nil {
+		return time.Time{}, fmt.Errorf("network isolation is not active")
+	}
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("isolation auto-restore is disabled (isolation_max_duration is 0)")
+	}
+	deadline := time.Now().Add(d)
+	w.armUnlocked(deadline)
+	return deadline, nil
+}
+
+// stop disarms the timer, used when isolation is lifted by a command
+func (w *isolationWatchdog) stop() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stopUnlocked()
+}
+
+// resume re-arms the timer from the deadline saved by a previous run. An
+// isolation whose deadline passed while the agent was down is lifted right away.
+func (w *isolationWatchdog) resume() {
+	data, err := os.ReadFile(w.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Warning: failed to read isolation state: %v", err)
+		}
+		return
+	}
+
+	var state isolationState
+	if err := json.Unmarshal(data, &state); err != nil || state.Deadline.IsZero() {
+		log.Printf("Warning: invalid isolation state in %s, ignoring", w.path)
+		os.Remove(w.path)
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	log.Printf("Network isolation is active, auto-restore at %s", state.Deadline.Format(time.RFC3339))
+	w.armUnlocked(state.Deadline)
+}
+
+func (w *isolationWatchdog) armUnlocked(deadline time.Time) {
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.deadline = deadline
+
+	var timer *time.Timer
+	timer = time.AfterFunc(time.Until(deadline), func() {
+		w.mu.Lock()
+		if w.timer != timer {
+			// Renewed or stopped after the timer fired
+			w.mu.Unlock()
+			return
+		}
+		w.stopUnlocked()
+		w.mu.Unlock()
+
+		w.onExpired()
+	})
+	w.timer = timer
+
+	data, err := json.Marshal(isolationState{Deadline: deadline})
+	if err == nil {
+		err = persist.WriteFileAtomic(w.path, data, 0600)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to save isolation state, auto-restore will not survive a restart: %v", err)
+	}
+}
+
+func (w *isolationWatchdog) stopUnlocked() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.deadline = time.Time{}
+	if err := os.Remove(w.path); err != nil && !os.IsNotExist(err) {
+		log.Printf("Warning: failed to remove isolation state: %v", err)
+	}
+}
+
+// handleIsolationExpired lifts isolation after the server failed to renew it
+func (h *CommandHandler) handleIsolationExpired() {
+	log.Printf("==========================================================")
+	log.Printf("WARNING: Network isolation was not renewed within %d minutes, restoring network connectivity",
+		h.client.config.Current().IsolationMaxDuration)
+	log.Printf("==========================================================")
+
+	message, err := h.handleNetworkRestore(context.Background(), nil)
+	if err != nil {
+		log.Printf("CRITICAL: Automatic network restore failed, host is still isolated: %v", err)
+		message = "Error: " + err.Error()
+	}
+
+	h.client.SendEvent(pb.AgentEventType_ISOLATION_EXPIRED,
+		"Network isolation lapsed without renewal and was lifted automatically",
+		map[string]string{"result": message})
+}
+
+// handleNetworkIsolateRenew resets the isolation watchdog. The server sends it
+// periodically while it wants the host to stay isolated.
+func (h *CommandHandler) handleNetworkIsolateRenew(params map[string]string) (string, error) {
+	deadline, err := h.isolation.renew(h.client.config.GetIsolationMaxDuration())
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("Network isolation renewed until %s", deadline.Format(time.RFC3339)), nil
+}
@@ -0,0 +1,33 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"agent/ioc"
+)
+
+// handleKillByHash kills every running process whose executable image has
+// the given hash and returns each one killed as JSON
+func (h *CommandHandler) handleKillByHash(ctx context.Context, params map[string]string) (string, error) {
+	hash := strings.TrimSpace(params["hash"])
+	if hash == "" {
+		return "", fmt.Errorf("missing required parameter 'hash'")
+	}
+
+	result, err := ioc.KillProcessesByHash(ctx, []string{hash})
+	if err != nil {
+		return "", err
+	}
+	if len(result.Killed) == 0 && len(result.Failed) > 0 {
+		return "", fmt.Errorf("failed to kill %d processes matching %s: %s", len(result.Failed), hash, result.Failed[0].Error)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode result: %v", err)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// blockList is the LIST_BLOCKS result. The blocked_* lists are the agent's
+// records; firewall_ips is read back from the firewall, and URL blocks are
+// checked against the hosts file or DNS policy, so the server can spot drift,
+// which the missing_* and unexpected_* lists spell out.
+type blockList struct {
+	BlockedIPs        []string `json:"blocked_ips"`
+	BlockedURLs       []string `json:"blocked_urls"`
+	FirewallIPs       []string `json:"firewall_ips"`
+	FirewallError     string   `json:"firewall_error,omitempty"`
+	MissingIPRules    []string `json:"missing_ip_rules"`      // Recorded as blocked but without a firewall rule
+	UnexpectedIPRules []string `json:"unexpected_ip_rules"`   // EDR firewall rules the agent has no record of
+	MissingURLBlocks  []string `json:"missing_url_blocks"`    // Recorded as blocked but their domain is not blocked
+	HostsError        string   `json:"hosts_error,omitempty"` // The hosts file or DNS policy could not be read
+	SuppressedIPs     []string `json:"suppressed_ips"`        // Unblocked by UNBLOCK_IP, the scanner does not block them
+	SuppressedURLs    []string `json:"suppressed_urls"`       // Unblocked by UNBLOCK_URL, the scanner does not block them
+}
+
+// handleListBlocks returns the IPs and URLs the agent has blocked, together
+// with the live firewall rules and hosts file or DNS policy entries behind
+// them, as JSON
+func (h *CommandHandler) handleListBlocks(ctx context.Context, params map[string]string) (string, error) {
+	blockedIPs := h.blocker.GetBlockedIPs()
+	blockedURLs := h.blocker.GetBlockedURLs()
+
+	list := blockList{
+		BlockedIPs:        sortedKeys(blockedIPs),
+		BlockedURLs:       sortedKeys(blockedURLs),
+		FirewallIPs:       []string{},
+		MissingIPRules:    []string{},
+		UnexpectedIPRules: []string{},
+		MissingURLBlocks:  []string{},
+		SuppressedIPs:     sortedKeys(h.blocker.GetSuppressedIPs()),
+		SuppressedURLs:    sortedKeys(h.blocker.GetSuppressedURLs()),
+	}
+
+	// A firewall or URL block list that cannot be read leaves its checks empty
+	// but still reports the agent's records
+	if active, err := h.blocker.ActiveFirewallIPs(ctx); err != nil {
+		list.FirewallError = err.Error()
+	} else {
+		list.FirewallIPs = sortedKeys(active)
+		for _, ip := range list.BlockedIPs {
+			if !active[ip] {
+				list.MissingIPRules = append(list.MissingIPRules, ip)
+			}
+		}
+		for _, ip := range list.FirewallIPs {
+			if !blockedIPs[ip] {
+				list.UnexpectedIPRules = append(list.UnexpectedIPRules, ip)
+			}
+		}
+	}
+
+	if domains, err := h.blocker.URLBlockDomains(ctx); err != nil {
+		list.HostsError = err.Error()
+	} else {
+		for _, url := range list.BlockedURLs {
+			if !domains[h.blocker.ExtractDomain(url)] {
+				list.MissingURLBlocks = append(list.MissingURLBlocks, url)
+			}
+		}
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode block list: %v", err)
+	}
+
+	return string(data), nil
+}
+
+// sortedKeys returns the keys of a set in sorted order, never nil so empty
+// sets encode as []
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
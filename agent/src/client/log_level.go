@@ -0,0 +1,54 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"agent/logging"
+)
+
+const (
+	defaultLogLevelDuration = 30   // Minutes a level set at runtime lasts by default
+	maxLogLevelDuration     = 1440 // Longest a level set at runtime can last, in minutes
+)
+
+// handleSetLogLevel changes the agent's log level without a restart. The
+// 'level' parameter is a level such as debug, or reset to go back to
+// log_level. 'duration' is how many minutes the level lasts before log_level
+// is restored, 30 by default; 0 keeps it until the next reload or restart.
+func (h *CommandHandler) handleSetLogLevel(params map[string]string) (string, error) {
+	level, ok := params["level"]
+	if !ok {
+		return "", fmt.Errorf("missing required parameter 'level'")
+	}
+	return setLogLevel(level, params["duration"], h.client.config.Current().LogLevel)
+}
+
+// setLogLevel applies level for duration minutes (empty for the default)
+// and describes the result. configured is log_level, which the level
+// reverts to.
+func setLogLevel(level, duration, configured string) (string, error) {
+	if level == "reset" {
+		logging.ResetLevel()
+		return fmt.Sprintf("Log level reset to %s", logging.Level()), nil
+	}
+
+	minutes := defaultLogLevelDuration
+	if duration != "" {
+		v, err := strconv.Atoi(duration)
+		if err != nil || v < 0 || v > maxLogLevelDuration {
+			return "", fmt.Errorf("invalid duration %q, must be 0 to %d minutes", duration, maxLogLevelDuration)
+		}
+		minutes = v
+	}
+
+	d := time.Duration(minutes) * time.Minute
+	if err := logging.SetLevelFor(level, d); err != nil {
+		return "", err
+	}
+	if d == 0 {
+		return fmt.Sprintf("Log level set to %s until the next reload or restart", logging.Level()), nil
+	}
+	return fmt.Sprintf("Log level set to %s for %v, then back to %s", logging.Level(), d, configured), nil
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"agent/ioc"
+	pb "agent/proto"
+)
+
+// handleScanMemory matches the memory of one process ('pid' or
+// 'process_name'), or of every process if neither is given, against the
+// loaded YARA rules or the hex byte signatures in the comma separated
+// 'signatures' parameter. Each match is reported as a YARA IOC match and
+// the JSON result lists them all.
+func (h *CommandHandler) handleScanMemory(ctx context.Context, params map[string]string) (string, error) {
+	var engine *ioc.YaraEngine
+	if signatures := strings.TrimSpace(params["signatures"]); signatures != "" {
+		var err error
+		if engine, err = ioc.NewSignatureEngine(strings.Split(signatures, ",")); err != nil {
+			return "", err
+		}
+	} else {
+		if h.scanner == nil {
+			return "", fmt.Errorf("IOC scanner not available")
+		}
+		engine = h.scanner.Yara()
+	}
+
+	var pids []int32
+	_, hasPid := params["pid"]
+	_, hasName := params["process_name"]
+	if hasPid || hasName {
+		pid, err := h.resolveProcessID(ctx, params)
+		if err != nil {
+			return "", err
+		}
+		pids = []int32{int32(pid)}
+	}
+
+	result, err := ioc.ScanProcessMemory(ctx, pids, engine, h.client.config.GetMemoryScanMaxBytes())
+	if err != nil {
+		return "", err
+	}
+
+	for _, match := range result.Matches {
+		if err := h.ReportIOCMatch(ctx, pb.IOCType_IOC_YARA, match.Rule, match.Image,
+			fmt.Sprintf("YARA rule %s matched memory of %s at %s (strings: %s)", match.Rule, match.Process, match.Region, strings.Join(match.Strings, ", ")),
+			match.Severity); err != nil {
+			log.Printf("Failed to report memory match in process %d: %v", match.PID, err)
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode result: %v", err)
+	}
+	return string(data), nil
+}
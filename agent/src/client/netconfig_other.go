@@ -0,0 +1,10 @@
+// +build !windows
+
+package client
+
+import "fmt"
+
+// networkInfrastructure is only implemented on Windows
+func networkInfrastructure() (*netInfrastructure, error) {
+	return nil, fmt.Errorf("network configuration discovery is not supported on this platform")
+}
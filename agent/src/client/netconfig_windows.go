@@ -0,0 +1,47 @@
+// +build windows
+
+package client
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// gaaFlagIncludeGateways asks GetAdaptersAddresses for gateway addresses
+const gaaFlagIncludeGateways = 0x0080
+
+// networkInfrastructure returns the DNS servers, default gateways and DHCP
+// servers configured on the adapters that are up
+func networkInfrastructure() (*netInfrastructure, error) {
+	size := uint32(15 * 1024)
+	var buf []byte
+	for {
+		buf = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_UNSPEC, gaaFlagIncludeGateways, 0,
+			(*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		if err != windows.ERROR_BUFFER_OVERFLOW {
+			return nil, fmt.Errorf("failed to read adapter addresses: %v", err)
+		}
+	}
+
+	infra := &netInfrastructure{}
+	for adapter := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); adapter != nil; adapter = adapter.Next {
+		if adapter.OperStatus != windows.IfOperStatusUp || adapter.IfType == windows.IF_TYPE_SOFTWARE_LOOPBACK {
+			continue
+		}
+		for dns := adapter.FirstDnsServerAddress; dns != nil; dns = dns.Next {
+			infra.DNSServers = appendAddress(infra.DNSServers, dns.Address.IP())
+		}
+		for gw := adapter.FirstGatewayAddress; gw != nil; gw = gw.Next {
+			infra.Gateways = appendAddress(infra.Gateways, gw.Address.IP())
+		}
+		infra.DHCPServers = appendAddress(infra.DHCPServers, adapter.Dhcpv4Server.IP())
+		infra.DHCPServers = appendAddress(infra.DHCPServers, adapter.Dhcpv6Server.IP())
+	}
+	return infra, nil
+}
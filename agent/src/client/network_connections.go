@@ -0,0 +1,144 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"syscall"
+
+	psnet "github.com/shirou/gopsutil/v3/net"
+	"github.com/shirou/gopsutil/v3/process"
+
+	"agent/privilege"
+)
+
+// networkConnection is one socket in the GET_NETWORK_CONNECTIONS result
+type networkConnection struct {
+	Protocol    string `json:"protocol"` // tcp, tcp6, udp, udp6 or unix
+	LocalAddr   string `json:"local_addr"`
+	RemoteAddr  string `json:"remote_addr,omitempty"`
+	State       string `json:"state,omitempty"`
+	PID         int32  `json:"pid"`          // 0 if the owning process is not visible to the agent
+	ProcessName string `json:"process_name,omitempty"`
+	IOCMatch    bool   `json:"ioc_match,omitempty"` // The remote IP is an IP IOC
+	IOCSeverity string `json:"ioc_severity,omitempty"`
+}
+
+// connectionList is the GET_NETWORK_CONNECTIONS result
+type connectionList struct {
+	Connections []networkConnection `json:"connections"`
+	IOCMatches  int                 `json:"ioc_matches"`
+	Partial     bool                `json:"partial"` // Not elevated, other users' sockets may lack their PID and process
+}
+
+// handleGetNetworkConnections lists the sockets open on the machine with the
+// process owning each one, like netstat -ano, as JSON. Remote IPs that are
+// IP IOCs are flagged. 'pid' limits the list to one process. Without
+// elevated privileges the sockets the agent can see are still returned.
+func (h *CommandHandler) handleGetNetworkConnections(ctx context.Context, params map[string]string) (string, error) {
+	var pid int32
+	if pidStr, ok := params["pid"]; ok {
+		v, err := strconv.ParseInt(pidStr, 10, 32)
+		if err != nil || v <= 0 {
+			return "", fmt.Errorf("invalid PID: %s", pidStr)
+		}
+		pid = int32(v)
+	}
+
+	var conns []psnet.ConnectionStat
+	var err error
+	if pid > 0 {
+		conns, err = psnet.ConnectionsPidWithContext(ctx, "all", pid)
+	} else {
+		conns, err = psnet.ConnectionsWithContext(ctx, "all")
+	}
+	if err != nil && len(conns) == 0 {
+		return "", fmt.Errorf("failed to list network connections: %v", err)
+	}
+
+	// Many sockets share a process, so look each one up once
+	names := make(map[int32]string)
+
+	list := connectionList{
+		Connections: make([]networkConnection, 0, len(conns)),
+		Partial:     !privilege.IsElevated(),
+	}
+	for _, c := range conns {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		conn := networkConnection{
+			Protocol:  socketProtocol(c.Family, c.Type),
+			LocalAddr: socketAddr(c.Laddr),
+			State:     c.Status,
+			PID:       c.Pid,
+		}
+		if c.Status == "NONE" {
+			conn.State = ""
+		}
+		if c.Raddr.Port != 0 || (c.Raddr.IP != "" && !net.ParseIP(c.Raddr.IP).IsUnspecified()) {
+			conn.RemoteAddr = socketAddr(c.Raddr)
+			if match, ioc := h.iocManager.CheckIP(c.Raddr.IP); match {
+				conn.IOCMatch = true
+				conn.IOCSeverity = ioc.Severity
+				list.IOCMatches++
+			}
+		}
+
+		if c.Pid > 0 {
+			name, seen := names[c.Pid]
+			if !seen {
+				if p, err := process.NewProcessWithContext(ctx, c.Pid); err == nil {
+					name, _ = p.NameWithContext(ctx)
+				}
+				names[c.Pid] = name
+			}
+			conn.ProcessName = name
+		}
+
+		list.Connections = append(list.Connections, conn)
+	}
+
+	sort.Slice(list.Connections, func(i, j int) bool {
+		a, b := list.Connections[i], list.Connections[j]
+		if a.PID != b.PID {
+			return a.PID < b.PID
+		}
+		return a.LocalAddr < b.LocalAddr
+	})
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode connection list: %v", err)
+	}
+
+	return string(data), nil
+}
+
+// socketProtocol names a socket's protocol from its address family and type
+func socketProtocol(family, sockType uint32) string {
+	protocol := "tcp"
+	switch {
+	case family == syscall.AF_UNIX:
+		return "unix"
+	case sockType == syscall.SOCK_DGRAM:
+		protocol = "udp"
+	}
+	if family == syscall.AF_INET6 {
+		protocol += "6"
+	}
+	return protocol
+}
+
+// socketAddr formats a socket address as host:port, or as the path of a
+// unix socket
+func socketAddr(addr psnet.Addr) string {
+	if addr.Port == 0 && net.ParseIP(addr.IP) == nil {
+		return addr.IP
+	}
+	return net.JoinHostPort(addr.IP, strconv.Itoa(int(addr.Port)))
+}
@@ -0,0 +1,10 @@
+// +build !windows
+
+package client
+
+import "fmt"
+
+// setProcessSuspended is only implemented on Windows
+func setProcessSuspended(pid int, suspend bool) (int, error) {
+	return 0, fmt.Errorf("process suspension is not supported on this platform")
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// linuxCommLength is how much of a process name ps shows on Linux, where
+// the kernel keeps only the first 15 bytes
+const linuxCommLength = 15
+
+// psProcess is one process listed by ps
+type psProcess struct {
+	pid  int
+	ppid int
+	name string
+}
+
+// listProcessesPS lists the running processes with ps, which stands in for
+// TASKLIST on Linux and macOS
+func (h *CommandHandler) listProcessesPS(ctx context.Context) ([]psProcess, error) {
+	output, err := h.runner.Run(ctx, "ps", "-A", "-o", "pid=", "-o", "ppid=", "-o", "comm=")
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute process list command: %v", err)
+	}
+
+	var procs []psProcess
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		ppid, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+		// macOS prints the full image path, which may contain spaces
+		name := filepath.Base(strings.Join(fields[2:], " "))
+		procs = append(procs, psProcess{pid: pid, ppid: ppid, name: name})
+	}
+	return procs, nil
+}
+
+// psNameMatches reports whether a name listed by ps is the image name the
+// server asked for, allowing for Linux cutting names short
+func psNameMatches(listed, name string) bool {
+	if runtime.GOOS == "linux" && len(name) > linuxCommLength {
+		name = name[:linuxCommLength]
+	}
+	return listed == name
+}
+
+// findProcessIDsByNamePS returns the IDs of every process with the given
+// image name using ps. Unlike on Windows the name is case sensitive.
+func (h *CommandHandler) findProcessIDsByNamePS(ctx context.Context, name string) ([]int, error) {
+	procs, err := h.listProcessesPS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for _, p := range procs {
+		if psNameMatches(p.name, name) {
+			pids = append(pids, p.pid)
+		}
+	}
+
+	if len(pids) == 0 {
+		return nil, fmt.Errorf("process '%s' not found", name)
+	}
+	return pids, nil
+}
+
+// killProcessTreePS kills a process and all its descendants with kill -KILL,
+// finding the descendants from the parent IDs ps reports
+func (h *CommandHandler) killProcessTreePS(ctx context.Context, pid int) (string, error) {
+	// kill treats 0 and negative IDs as process groups
+	if pid <= 0 {
+		return "", fmt.Errorf("invalid PID: %d", pid)
+	}
+
+	procs, err := h.listProcessesPS(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	children := make(map[int][]int)
+	found := false
+	for _, p := range procs {
+		if p.pid == pid {
+			found = true
+		} else {
+			children[p.ppid] = append(children[p.ppid], p.pid)
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("process %d not found", pid)
+	}
+
+	tree := []int{pid}
+	for i := 0; i < len(tree); i++ {
+		tree = append(tree, children[tree[i]]...)
+	}
+
+	args := []string{"-KILL"}
+	for _, p := range tree {
+		args = append(args, strconv.Itoa(p))
+	}
+	output, err := h.runner.Run(ctx, "kill", args...)
+	if err != nil {
+		// kill fails if any process in the tree exited on its own first,
+		// which is only a failure if some of them are still running
+		if running := h.runningPIDs(ctx, tree); len(running) > 0 {
+			return "", fmt.Errorf("failed to kill process tree: %v, output: %s", err, string(output))
+		}
+	}
+
+	return fmt.Sprintf("Process tree for PID %d killed successfully (%d processes)", pid, len(tree)), nil
+}
+
+// runningPIDs returns the processes of pids that ps still lists. If ps
+// fails they are all assumed to be running.
+func (h *CommandHandler) runningPIDs(ctx context.Context, pids []int) []int {
+	procs, err := h.listProcessesPS(ctx)
+	if err != nil {
+		return pids
+	}
+	alive := make(map[int]bool, len(procs))
+	for _, p := range procs {
+		alive[p.pid] = true
+	}
+	var running []int
+	for _, pid := range pids {
+		if alive[pid] {
+			running = append(running, pid)
+		}
+	}
+	return running
+}
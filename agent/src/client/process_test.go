@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+// tasklistOutput is TASKLIST /NH /FO CSV output (with the /V columns) in
+// which quoted fields hold commas, including a window title that looks like
+// another record
+const tasklistOutput = `"System Idle Process","0","Services","0","8 K","Unknown","NT AUTHORITY\SYSTEM","12:01:33","N/A"
+"WINWORD.EXE","5120","Console","1","182,436 K","Running","HOST\alice","0:00:41","Budget, Q3, final.docx - Word"
+"notepad.exe","6004","Console","1","14,212 K","Running","HOST\alice","0:00:00","evil.exe,""666"",notes.txt - Notepad"
+"evil.exe","7311","Console","1","3,100 K","Running","HOST\alice","0:00:02","N/A"
+"Evil.exe","7420","Console","1","3,104 K","Running","HOST\alice","0:00:02","Setup, step 1, of 3"
+`
+
+func TestFindProcessIDsByNameTasklist(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		image   string
+		want    []int
+		wantErr bool
+	}{
+		{name: "title with commas", output: tasklistOutput, image: "winword.exe", want: []int{5120}},
+		{name: "name inside another title", output: tasklistOutput, image: "evil.exe", want: []int{7311, 7420}},
+		{name: "first column only", output: tasklistOutput, image: "Console", wantErr: true},
+		{name: "no match", output: "INFO: No tasks are running which match the specified criteria.\r\n", image: "calc.exe", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			runner := &fakeRunner{respond: func(argv []string) ([]byte, error) {
+				return []byte(tt.output), nil
+			}}
+			h := &CommandHandler{runner: runner, goos: "windows"}
+
+			pids, err := h.findProcessIDsByName(context.Background(), tt.image)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got PIDs %v, want an error", pids)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findProcessIDsByName: %v", err)
+			}
+			if !reflect.DeepEqual(pids, tt.want) {
+				t.Errorf("PIDs = %v, want %v", pids, tt.want)
+			}
+			assertCalls(t, runner.calls, [][]string{
+				{"tasklist", "/FI", "IMAGENAME eq " + tt.image, "/NH", "/FO", "CSV"},
+			})
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// +build windows
+
+package client
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	kernel32          = windows.NewLazySystemDLL("kernel32.dll")
+	procSuspendThread = kernel32.NewProc("SuspendThread")
+)
+
+// setProcessSuspended suspends or resumes every thread of a process and
+// returns the number of threads that were changed
+func setProcessSuspended(pid int, suspend bool) (int, error) {
+	snapshot, err := windows.CreateToolhelp32Snapshot(windows.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create thread snapshot: %v", err)
+	}
+	defer windows.CloseHandle(snapshot)
+	
+	var entry windows.ThreadEntry32
+	entry.Size = uint32(unsafe.Sizeof(entry))
+	
+	if err := windows.Thread32First(snapshot, &entry); err != nil {
+		return 0, fmt.Errorf("failed to enumerate threads: %v", err)
+	}
+	
+	found := false
+	changed := 0
+	for {
+		if entry.OwnerProcessID == uint32(pid) {
+			found = true
+			if setThreadSuspended(entry.ThreadID, suspend) {
+				changed++
+			}
+		}
+		
+		if err := windows.Thread32Next(snapshot, &entry); err != nil {
+			break
+		}
+	}
+	
+	if !found {
+		return 0, fmt.Errorf("process %d not found", pid)
+	}
+	if changed == 0 {
+		return 0, fmt.Errorf("failed to access any thread of process %d", pid)
+	}
+	
+	return changed, nil
+}
+
+// setThreadSuspended suspends or resumes a single thread
+func setThreadSuspended(threadID uint32, suspend bool) bool {
+	thread, err := windows.OpenThread(windows.THREAD_SUSPEND_RESUME, false, threadID)
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(thread)
+	
+	if suspend {
+		ret, _, _ := procSuspendThread.Call(uintptr(thread))
+		return uint32(ret) != 0xFFFFFFFF
+	}
+	
+	_, err = windows.ResumeThread(thread)
+	return err == nil
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"agent/config"
+)
+
+// proxyDialer returns a dial function that connects to the server through the
+// configured proxy_url, for use with grpc.WithContextDialer. It returns nil
+// when no proxy is configured so the connection is made directly.
+func proxyDialer(cfg *config.Config) (func(context.Context, string) (net.Conn, error), error) {
+	if cfg.ProxyURL == "" {
+		return nil, nil
+	}
+
+	proxyURL, err := url.Parse(cfg.ProxyURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy_url: %v", err)
+	}
+
+	switch proxyURL.Scheme {
+	case "http":
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialHTTPConnect(ctx, proxyURL.Host, addr, cfg.ProxyUsername, cfg.ProxyPassword)
+		}, nil
+	case "socks5":
+		var auth *proxy.Auth
+		if cfg.ProxyUsername != "" {
+			auth = &proxy.Auth{User: cfg.ProxyUsername, Password: cfg.ProxyPassword}
+		}
+		dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SOCKS5 dialer: %v", err)
+		}
+		contextDialer, ok := dialer.(proxy.ContextDialer)
+		if !ok {
+			return nil, fmt.Errorf("SOCKS5 dialer does not support contexts")
+		}
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			conn, err := contextDialer.DialContext(ctx, "tcp", addr)
+			if err != nil {
+				return nil, fmt.Errorf("SOCKS5 proxy %s: %v", proxyURL.Host, err)
+			}
+			return conn, nil
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported proxy_url scheme %q", proxyURL.Scheme)
+	}
+}
+
+// dialHTTPConnect opens a tunnel to addr through an HTTP proxy with the
+// CONNECT method, authenticating with Basic credentials when a username is set
+func dialHTTPConnect(ctx context.Context, proxyAddr, addr, username, password string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy %s: %v", proxyAddr, err)
+	}
+
+	// Bound the handshake by the dial context; gRPC sets its own deadlines
+	// once the tunnel is up
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if username != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send CONNECT to proxy %s: %v", proxyAddr, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to read CONNECT response from proxy %s: %v", proxyAddr, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused CONNECT to %s: %s", proxyAddr, addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// The server may start talking before the agent does (the HTTP/2 server
+	// preface), so keep anything the reader already buffered
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose first reads drain a bufio.Reader
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
@@ -0,0 +1,19 @@
+// +build !windows
+
+package client
+
+import "fmt"
+
+// Registry remediation is only implemented on Windows
+
+func deleteRegistryValue(hive, path, name string) error {
+	return fmt.Errorf("registry is not supported on this platform")
+}
+
+func deleteRegistryKey(hive, path string) error {
+	return fmt.Errorf("registry is not supported on this platform")
+}
+
+func setRegistryValue(hive, path, name, valueType, data string) error {
+	return fmt.Errorf("registry is not supported on this platform")
+}
@@ -0,0 +1,101 @@
+// +build windows
+
+package client
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// registryRoots maps canonical hive names to registry root keys
+var registryRoots = map[string]registry.Key{
+	"HKLM": registry.LOCAL_MACHINE,
+	"HKCU": registry.CURRENT_USER,
+	"HKCR": registry.CLASSES_ROOT,
+	"HKU":  registry.USERS,
+	"HKCC": registry.CURRENT_CONFIG,
+}
+
+// deleteRegistryValue deletes a single value from a registry key
+func deleteRegistryValue(hive, path, name string) error {
+	key, err := registry.OpenKey(registryRoots[hive], path, registry.SET_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return fmt.Errorf("failed to open key %s\\%s: %v", hive, path, err)
+	}
+	defer key.Close()
+	
+	if err := key.DeleteValue(name); err != nil {
+		return fmt.Errorf("failed to delete value %s: %v", name, err)
+	}
+	return nil
+}
+
+// deleteRegistryKey deletes a registry key. The key must not have subkeys.
+func deleteRegistryKey(hive, path string) error {
+	idx := strings.LastIndex(path, `\`)
+	if idx < 0 {
+		return fmt.Errorf("refusing to delete top-level key %s\\%s", hive, path)
+	}
+	
+	parent, err := registry.OpenKey(registryRoots[hive], path[:idx], registry.ENUMERATE_SUB_KEYS|registry.WOW64_64KEY)
+	if err != nil {
+		return fmt.Errorf("failed to open key %s\\%s: %v", hive, path[:idx], err)
+	}
+	defer parent.Close()
+	
+	if err := registry.DeleteKey(parent, path[idx+1:]); err != nil {
+		return fmt.Errorf("failed to delete key %s\\%s: %v", hive, path, err)
+	}
+	return nil
+}
+
+// setRegistryValue creates or overwrites a registry value, creating the key if needed.
+// REG_MULTI_SZ data is newline separated and REG_BINARY data is hex encoded.
+func setRegistryValue(hive, path, name, valueType, data string) error {
+	key, _, err := registry.CreateKey(registryRoots[hive], path, registry.SET_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return fmt.Errorf("failed to open key %s\\%s: %v", hive, path, err)
+	}
+	defer key.Close()
+	
+	switch strings.ToUpper(valueType) {
+	case "REG_SZ":
+		err = key.SetStringValue(name, data)
+	case "REG_EXPAND_SZ":
+		err = key.SetExpandStringValue(name, data)
+	case "REG_MULTI_SZ":
+		err = key.SetStringsValue(name, strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n"))
+	case "REG_DWORD":
+		var v uint64
+		v, err = strconv.ParseUint(data, 0, 32)
+		if err != nil {
+			return fmt.Errorf("invalid REG_DWORD data: %s", data)
+		}
+		err = key.SetDWordValue(name, uint32(v))
+	case "REG_QWORD":
+		var v uint64
+		v, err = strconv.ParseUint(data, 0, 64)
+		if err != nil {
+			return fmt.Errorf("invalid REG_QWORD data: %s", data)
+		}
+		err = key.SetQWordValue(name, v)
+	case "REG_BINARY":
+		var b []byte
+		b, err = hex.DecodeString(strings.ReplaceAll(data, " ", ""))
+		if err != nil {
+			return fmt.Errorf("invalid REG_BINARY data, expected hex: %s", data)
+		}
+		err = key.SetBinaryValue(name, b)
+	default:
+		return fmt.Errorf("unsupported value_type: %s", valueType)
+	}
+	
+	if err != nil {
+		return fmt.Errorf("failed to set value %s: %v", name, err)
+	}
+	return nil
+}
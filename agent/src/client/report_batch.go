@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "agent/proto"
+)
+
+// maxReportBatch is how many match reports a scan collects before sending
+// them, so a scan with thousands of matches does not hold them all until it
+// ends
+const maxReportBatch = 100
+
+// reportBatchKey is the context key of a scan's reportBatch
+type reportBatchKey struct{}
+
+// reportBatch collects the match reports of one scan until they are sent
+// together. Scan workers add to it concurrently.
+type reportBatch struct {
+	mu      sync.Mutex
+	reports []*pb.IOCMatchReport
+}
+
+// add queues a report. Once maxReportBatch reports are queued it empties the
+// batch and returns them for sending, otherwise it returns nil.
+func (b *reportBatch) add(report *pb.IOCMatchReport) []*pb.IOCMatchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.reports = append(b.reports, report)
+	if len(b.reports) < maxReportBatch {
+		return nil
+	}
+	full := b.reports
+	b.reports = nil
+	return full
+}
+
+// take empties the batch and returns the queued reports
+func (b *reportBatch) take() []*pb.IOCMatchReport {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	reports := b.reports
+	b.reports = nil
+	return reports
+}
+
+// reportBatchFrom returns the batch started for ctx by BatchReports, or nil
+func reportBatchFrom(ctx context.Context) *reportBatch {
+	batch, _ := ctx.Value(reportBatchKey{}).(*reportBatch)
+	return batch
+}
+
+// BatchReports starts a batch of IOC match reports for a scan. Matches
+// reported with the returned context are journaled as usual but sent in one
+// ReportIOCMatches stream when the returned function is called, or earlier
+// once maxReportBatch of them are waiting. Reports that cannot be sent because
+// ctx is done stay in the detection journal and are replayed.
+func (h *CommandHandler) BatchReports(ctx context.Context) (context.Context, func()) {
+	batch := &reportBatch{}
+	return context.WithValue(ctx, reportBatchKey{}, batch), func() {
+		if reports := batch.take(); len(reports) > 0 {
+			h.sendReportBatch(ctx, reports)
+		}
+	}
+}
+
+// sendReportBatch sends reports in one ReportIOCMatches stream and settles
+// each one in the detection journal. If the server does not implement the
+// RPC they are sent one at a time with ReportIOCMatch, as are all later
+// batches.
+func (h *CommandHandler) sendReportBatch(ctx context.Context, reports []*pb.IOCMatchReport) {
+	if atomic.LoadInt32(&h.noBatching) == 0 {
+		acks, err := h.streamReports(ctx, reports)
+		if status.Code(err) != codes.Unimplemented {
+			if err != nil {
+				log.Printf("Failed to report %d IOC matches: %v", len(reports), err)
+			}
+			for _, report := range reports {
+				h.settleReport(report.ReportId, batchReportError(report, acks, err))
+			}
+			return
+		}
+		log.Printf("Server does not support batched IOC match reports, sending them one at a time")
+		atomic.StoreInt32(&h.noBatching, 1)
+	}
+
+	for _, report := range reports {
+		err := h.sendReport(ctx, report)
+		if err != nil {
+			log.Printf("Failed to report IOC match: %v", err)
+		}
+		h.settleReport(report.ReportId, err)
+	}
+}
+
+// streamReports sends reports over a ReportIOCMatches stream and returns the
+// server's acknowledgements by report ID
+func (h *CommandHandler) streamReports(ctx context.Context, reports []*pb.IOCMatchReport) (map[string]*pb.IOCMatchAck, error) {
+	stream, err := h.client.edrClient.ReportIOCMatches(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, report := range reports {
+		// A failed send means the stream is over; CloseAndRecv returns why
+		if err := stream.Send(report); err != nil {
+			break
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("IOC match batch acknowledged: %d of %d reports received: %s", resp.Received, len(reports), resp.Message)
+	acks := make(map[string]*pb.IOCMatchAck, len(resp.Acks))
+	for _, ack := range resp.Acks {
+		acks[ack.ReportId] = ack
+	}
+	for _, report := range reports {
+		if ack, ok := acks[report.ReportId]; ok && ack.Received {
+			h.handleReportAck(ctx, report, ack)
+		}
+	}
+	return acks, nil
+}
+
+// batchReportError returns why a report sent in a batch was not delivered,
+// or nil if the server confirmed it. Reports the server did not confirm are
+// kept for retry, as with ReportIOCMatch.
+func batchReportError(report *pb.IOCMatchReport, acks map[string]*pb.IOCMatchAck, err error) error {
+	if err != nil {
+		return err
+	}
+	ack, ok := acks[report.ReportId]
+	if !ok {
+		return fmt.Errorf("server did not acknowledge report %s", report.ReportId)
+	}
+	if !ack.Received {
+		return fmt.Errorf("server did not confirm report %s: %s", report.ReportId, ack.Message)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// maxReportDedupEntries bounds the IOCs tracked for report de-duplication
+const maxReportDedupEntries = 10000
+
+// reportDeduper holds back repeated match reports of the same IOC within
+// report_dedup_window, so an IP seen in hundreds of Sysmon network events
+// raises one alert rather than hundreds. Suppressed matches are counted and
+// the count is sent with the IOC's next report.
+type reportDeduper struct {
+	mu      sync.Mutex
+	entries map[string]*reportDedupEntry
+}
+
+// reportDedupEntry tracks one IOC's last report
+type reportDedupEntry struct {
+	reported   time.Time // When the IOC was last reported
+	suppressed int32     // Matches held back since then
+}
+
+// newReportDeduper creates an empty de-duplication cache
+func newReportDeduper() *reportDeduper {
+	return &reportDeduper{entries: make(map[string]*reportDedupEntry)}
+}
+
+// allow reports whether a match of key should be reported at now. A match
+// within window of the IOC's last report is counted and refused. An allowed
+// match starts a new window and returns how many matches were held back
+// since the last report. A window of 0 allows every match.
+func (d *reportDeduper) allow(key string, window time.Duration, now time.Time) (bool, int32) {
+	if window <= 0 {
+		return true, 0
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry, ok := d.entries[key]; ok {
+		if now.Sub(entry.reported) < window {
+			entry.suppressed++
+			return false, 0
+		}
+		suppressed := entry.suppressed
+		entry.reported = now
+		entry.suppressed = 0
+		return true, suppressed
+	}
+
+	if len(d.entries) >= maxReportDedupEntries {
+		// Drop entries whose window has passed, or everything if none has
+		for k, entry := range d.entries {
+			if now.Sub(entry.reported) >= window {
+				delete(d.entries, k)
+			}
+		}
+		if len(d.entries) >= maxReportDedupEntries {
+			d.entries = make(map[string]*reportDedupEntry)
+		}
+	}
+	d.entries[key] = &reportDedupEntry{reported: now}
+	return true, 0
+}
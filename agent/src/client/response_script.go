@@ -0,0 +1,95 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	pb "agent/proto"
+)
+
+// maxResponseScriptOutput is how much of a response script's output is
+// added to the match report
+const maxResponseScriptOutput = 4096
+
+// responseScriptWaitDelay is how long output is still read after a timed out
+// script is killed, in case processes it started hold the pipe open
+const responseScriptWaitDelay = 5 * time.Second
+
+// responseScriptType is the response_scripts key and first argument for an
+// IOC type: ip, hash, url, yara or behavior
+func responseScriptType(iocType pb.IOCType) string {
+	return strings.ToLower(strings.TrimPrefix(iocType.String(), "IOC_"))
+}
+
+// runResponseScript runs the response_scripts entry for a match, if there
+// is one, after the built-in action has been taken. The script gets the IOC
+// type, IOC value, matched value and severity as arguments and in the
+// EDR_IOC_TYPE, EDR_IOC_VALUE, EDR_MATCHED_VALUE and EDR_SEVERITY
+// variables, and is killed after response_script_timeout. It returns the
+// lines added to the report's context, or "" if no script ran.
+func (h *CommandHandler) runResponseScript(ctx context.Context, iocType pb.IOCType, iocValue, matchedValue, severity string) string {
+	kind := responseScriptType(iocType)
+	script := h.client.config.ResponseScript(kind, severity)
+	if script == "" {
+		return ""
+	}
+	if h.client.config.MonitorOnly() {
+		log.Printf("Response script %s not run for %s (monitor mode)", script, iocValue)
+		return fmt.Sprintf("Response script %s: not run (monitor mode)", script)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.client.config.GetResponseScriptTimeoutDuration())
+	defer cancel()
+
+	args := []string{kind, iocValue, matchedValue, severity}
+	name := script
+	if strings.EqualFold(filepath.Ext(script), ".ps1") {
+		name = "powershell.exe"
+		args = append([]string{"-NoProfile", "-NonInteractive", "-ExecutionPolicy", "Bypass", "-File", script}, args...)
+	}
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(),
+		"EDR_IOC_TYPE="+kind,
+		"EDR_IOC_VALUE="+iocValue,
+		"EDR_MATCHED_VALUE="+matchedValue,
+		"EDR_SEVERITY="+severity,
+	)
+	cmd.WaitDelay = responseScriptWaitDelay
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+	err := cmd.Run()
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	status := "exit code 0"
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		status = fmt.Sprintf("killed after %s timeout", h.client.config.GetResponseScriptTimeoutDuration())
+	case errors.As(err, &exitErr):
+		status = fmt.Sprintf("exit code %d", exitErr.ExitCode())
+	case err != nil:
+		status = fmt.Sprintf("failed to start: %v", err)
+	}
+	log.Printf("Response script %s for %s finished in %s: %s", script, iocValue, elapsed, status)
+
+	result := fmt.Sprintf("Response script %s: %s", script, status)
+	if out := strings.TrimSpace(output.String()); out != "" {
+		if len(out) > maxResponseScriptOutput {
+			out = strings.ToValidUTF8(out[:maxResponseScriptOutput], "") + "..."
+		}
+		result += "\nResponse script output:\n" + out
+	}
+	return result
+}
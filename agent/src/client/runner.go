@@ -0,0 +1,22 @@
+package client
+
+import (
+	"context"
+	"os/exec"
+)
+
+// commandRunner starts external tools. The process and network isolation
+// commands shell out only through it, so the exact command lines they
+// produce can be checked against a fake.
+type commandRunner interface {
+	// Run runs name with args and returns its combined output
+	Run(ctx context.Context, name string, args ...string) ([]byte, error)
+}
+
+// execRunner is the commandRunner used in production; tools are killed when
+// ctx is cancelled
+type execRunner struct{}
+
+func (execRunner) Run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, name, args...).CombinedOutput()
+}
@@ -0,0 +1,411 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	pb "agent/proto"
+	"agent/persist"
+)
+
+// updatePublicKey is the hex-encoded Ed25519 public key agent updates must be
+// signed with. It is pinned at build time:
+//
+//	go build -ldflags "-X agent/client.updatePublicKey=<hex key>"
+//
+// Self-update is refused when no key was built in.
+var updatePublicKey string
+
+const (
+	// updateSignatureMagic ends a signed binary, preceded by the 64-byte
+	// Ed25519 signature of the SHA256 digest of everything before the signature
+	updateSignatureMagic = "EDRSIG01"
+
+	maxUpdateSize       = 256 << 20        // Largest binary accepted for download
+	updateStartTimeout  = 2 * time.Minute  // Time the new agent has to complete its first HELLO
+	updateStopTimeout   = time.Minute      // Time the service manager has to stop the agent
+	updateExitTimeout   = 2 * time.Minute  // Time the new agent waits for the watchdog to finish
+	updateHandoverDelay = 2 * time.Second  // Time the command result has to reach the server
+
+	updateStateFile = "self_update.json" // Update in progress, in the data directory
+	updateAckFile   = "update_ack"       // Written by the new agent after its first HELLO
+	updateLogFile   = "self_update.log"  // Log of the update watchdog
+
+	// UpdateWatchdogFlag starts the agent as the update watchdog for the
+	// state file given as its value
+	UpdateWatchdogFlag = "update-watchdog"
+)
+
+// Status of an update in its state file
+const (
+	updatePending    = "pending"
+	updateRolledBack = "rolled_back"
+)
+
+// updateState is the update in progress, shared between the agent that
+// installed it, the watchdog and the agent that was installed
+type updateState struct {
+	Service string `json:"service"`         // Service or systemd unit the agent runs as
+	ExePath string `json:"exe_path"`        // Installed executable
+	OldPath string `json:"old_path"`        // Previous executable, restored on rollback
+	Version string `json:"version"`         // Version being installed
+	Status  string `json:"status"`          // updatePending or updateRolledBack
+	Error   string `json:"error,omitempty"` // Why the update was rolled back
+}
+
+// handleSelfUpdate downloads a new agent binary, verifies its hash and
+// signature and swaps it in place of the running executable. The running exe
+// is renamed rather than overwritten since Windows does not allow writing to
+// it. A watchdog started through the service manager, outside this agent's
+// process tree, then restarts the agent's service. If the new agent does not
+// complete its first HELLO with the server within updateStartTimeout the
+// watchdog restores the previous executable and restarts the service again.
+func (h *CommandHandler) handleSelfUpdate(ctx context.Context, params map[string]string) (string, error) {
+	url, ok := params["url"]
+	if !ok || url == "" {
+		return "", fmt.Errorf("missing required parameter 'url'")
+	}
+	expectedHash := strings.ToLower(params["sha256"])
+	if expectedHash == "" {
+		return "", fmt.Errorf("missing required parameter 'sha256'")
+	}
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return "", fmt.Errorf("unsupported update URL: %s", url)
+	}
+
+	publicKey, err := pinnedUpdateKey()
+	if err != nil {
+		return "", err
+	}
+
+	// Only the service manager can restart the agent without leaving it
+	// running twice, so refuse before anything is downloaded
+	service, err := findAgentService()
+	if err != nil {
+		return "", fmt.Errorf("self-update needs the agent to run as a service: %v", err)
+	}
+
+	statePath := filepath.Join(h.client.dataDir, updateStateFile)
+	if _, err := os.Stat(statePath); err == nil {
+		return "", fmt.Errorf("another self-update is in progress")
+	}
+
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate agent executable: %v", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exePath); err == nil {
+		exePath = resolved
+	}
+
+	// Download next to the executable so the final rename stays on one volume
+	newPath := exePath + ".new"
+	defer os.Remove(newPath)
+
+	log.Printf("Downloading agent update from %s", url)
+	actualHash, err := downloadUpdate(ctx, url, newPath)
+	if err != nil {
+		return "", err
+	}
+	if actualHash != expectedHash {
+		return "", fmt.Errorf("update hash mismatch: expected %s, got %s", expectedHash, actualHash)
+	}
+	if err := verifyUpdateSignature(newPath, publicKey); err != nil {
+		return "", err
+	}
+
+	version := params["version"]
+	if version == "" {
+		version = actualHash[:12]
+	}
+
+	// Tamper protection leaves SYSTEM, which the agent runs as, full control
+	// of the executable, so the swap below is still permitted
+	oldPath := exePath + ".old"
+	os.Remove(oldPath) // Left over from a previous update
+	if err := os.Rename(exePath, oldPath); err != nil {
+		return "", fmt.Errorf("failed to move current executable aside: %v", err)
+	}
+	if err := os.Rename(newPath, exePath); err != nil {
+		if restoreErr := os.Rename(oldPath, exePath); restoreErr != nil {
+			log.Printf("CRITICAL: failed to restore agent executable: %v", restoreErr)
+		}
+		return "", fmt.Errorf("failed to install new executable: %v", err)
+	}
+
+	state := &updateState{
+		Service: service,
+		ExePath: exePath,
+		OldPath: oldPath,
+		Version: version,
+		Status:  updatePending,
+	}
+	err = writeUpdateState(statePath, state)
+	if err == nil {
+		// The watchdog runs the previous executable, which is known to work
+		h.client.updating.Store(true)
+		err = startUpdateWatchdog(ctx, oldPath, statePath)
+		if err != nil {
+			h.client.updating.Store(false)
+			os.Remove(statePath)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to hand the update to the service manager, rolling back: %v", err)
+		if rollbackErr := rollbackUpdate(exePath, oldPath); rollbackErr != nil {
+			return "", fmt.Errorf("%v; rollback failed: %v", err, rollbackErr)
+		}
+		return "", fmt.Errorf("%v; previous version restored", err)
+	}
+
+	log.Printf("Agent update %s installed, service %s will be restarted", version, service)
+	return fmt.Sprintf("Agent updated to %s, restarting service %s", version, service), nil
+}
+
+// pinnedUpdateKey decodes the public key built into the agent
+func pinnedUpdateKey() (ed25519.PublicKey, error) {
+	if updatePublicKey == "" {
+		return nil, fmt.Errorf("self-update is disabled: agent was built without an update public key")
+	}
+	key, err := hex.DecodeString(updatePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid update public key built into agent")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// downloadUpdate saves the binary at url to path and returns its SHA256
+func downloadUpdate(ctx context.Context, url, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid update URL: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download update: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download update: server returned %s", resp.Status)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0755)
+	if err != nil {
+		return "", fmt.Errorf("failed to create update file: %v", err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(file, hasher), io.LimitReader(resp.Body, maxUpdateSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to download update: %v", err)
+	}
+	if n > maxUpdateSize {
+		return "", fmt.Errorf("update is larger than %d bytes", maxUpdateSize)
+	}
+	if err := file.Sync(); err != nil {
+		return "", fmt.Errorf("failed to write update file: %v", err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// verifyUpdateSignature checks the signature embedded at the end of a binary
+func verifyUpdateSignature(path string, publicKey ed25519.PublicKey) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read update file: %v", err)
+	}
+
+	trailer := ed25519.SignatureSize + len(updateSignatureMagic)
+	if len(data) < trailer || !bytes.HasSuffix(data, []byte(updateSignatureMagic)) {
+		return fmt.Errorf("update binary is not signed")
+	}
+
+	payload := data[:len(data)-trailer]
+	signature := data[len(data)-trailer : len(data)-len(updateSignatureMagic)]
+	digest := sha256.Sum256(payload)
+	if !ed25519.Verify(publicKey, digest[:], signature) {
+		return fmt.Errorf("update signature verification failed")
+	}
+
+	return nil
+}
+
+// rollbackUpdate puts the previous executable back in place
+func rollbackUpdate(exePath, oldPath string) error {
+	if err := os.Remove(exePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove new executable: %v", err)
+	}
+	if err := os.Rename(oldPath, exePath); err != nil {
+		return fmt.Errorf("failed to restore previous executable: %v", err)
+	}
+	return nil
+}
+
+// writeUpdateState saves the update state next to the agent's other data
+func writeUpdateState(path string, state *updateState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return persist.WriteFileAtomic(path, data, 0600)
+}
+
+// readUpdateState loads the update state, nil if no update is in progress
+func readUpdateState(path string) (*updateState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state updateState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid update state %s: %v", path, err)
+	}
+	return &state, nil
+}
+
+// RunUpdateWatchdog is the watchdog started by a self-update. It restarts
+// the agent's service so the new executable takes over, and waits for the
+// new agent to confirm its first HELLO. Without the confirmation it stops
+// the service, restores the previous executable and starts it again. It
+// returns the process exit code.
+func RunUpdateWatchdog(statePath string) int {
+	dir := filepath.Dir(statePath)
+	if logFile, err := os.OpenFile(filepath.Join(dir, updateLogFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600); err == nil {
+		defer logFile.Close()
+		log.SetOutput(logFile)
+	}
+	defer removeUpdateWatchdog()
+
+	state, err := readUpdateState(statePath)
+	if err != nil || state == nil || state.Status != updatePending {
+		log.Printf("Update watchdog: no pending update in %s (%v)", statePath, err)
+		return 1
+	}
+
+	ackPath := filepath.Join(dir, updateAckFile)
+	os.Remove(ackPath)
+
+	// Let the previous agent send the SELF_UPDATE result before it is stopped
+	time.Sleep(updateHandoverDelay)
+
+	log.Printf("Update watchdog: restarting service %s to run agent %s", state.Service, state.Version)
+	if err := restartUpdatedService(state); err == nil {
+		deadline := time.Now().Add(updateStartTimeout)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(ackPath); err == nil {
+				os.Remove(ackPath)
+				os.Remove(statePath)
+				log.Printf("Update watchdog: agent %s connected to the server, update complete", state.Version)
+				return 0
+			}
+			time.Sleep(time.Second)
+		}
+		err = fmt.Errorf("new agent did not connect to the server within %v", updateStartTimeout)
+		state.Error = err.Error()
+	} else {
+		state.Error = err.Error()
+	}
+
+	log.Printf("Update watchdog: %s, rolling back", state.Error)
+	if err := stopAgentService(state.Service); err != nil {
+		log.Printf("Update watchdog: %v", err)
+	}
+	if err := rollbackUpdate(state.ExePath, state.OldPath); err != nil {
+		log.Printf("CRITICAL: update watchdog: %v", err)
+	}
+
+	// The restored agent reports the failed update once it is connected
+	state.Status = updateRolledBack
+	if err := writeUpdateState(statePath, state); err != nil {
+		log.Printf("Update watchdog: failed to record rollback: %v", err)
+	}
+	if err := startAgentService(state.Service); err != nil {
+		log.Printf("CRITICAL: update watchdog: %v", err)
+	}
+	return 1
+}
+
+// restartUpdatedService stops the agent's service and starts it again on
+// the new executable
+func restartUpdatedService(state *updateState) error {
+	if err := stopAgentService(state.Service); err != nil {
+		return err
+	}
+	return startAgentService(state.Service)
+}
+
+// completeSelfUpdate is called once the server has acknowledged the first
+// HELLO. After a self-update it tells the watchdog the new agent is up and
+// removes the previous executable once the watchdog is done. After a rolled
+// back update it reports the failure.
+func (c *EDRClient) completeSelfUpdate() {
+	statePath := filepath.Join(c.dataDir, updateStateFile)
+	state, err := readUpdateState(statePath)
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		return
+	}
+	if state == nil {
+		return
+	}
+
+	if state.Status == updateRolledBack {
+		log.Printf("WARNING: Self-update to %s was rolled back: %s", state.Version, state.Error)
+		c.SendEvent(pb.AgentEventType_SELF_UPDATE_FAILED,
+			fmt.Sprintf("Self-update to %s was rolled back", state.Version),
+			map[string]string{"version": state.Version, "error": state.Error})
+		os.Remove(statePath)
+		os.Remove(state.OldPath) // The rollback leaves none, but a failed rename might
+		return
+	}
+
+	ackPath := filepath.Join(c.dataDir, updateAckFile)
+	if err := os.WriteFile(ackPath, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		log.Printf("Failed to confirm self-update: %v", err)
+		return
+	}
+	log.Printf("Self-update to %s confirmed", state.Version)
+
+	// The watchdog runs the previous executable, which cannot be removed on
+	// Windows until it has exited
+	go func() {
+		deadline := time.Now().Add(updateExitTimeout)
+		for time.Now().Before(deadline) {
+			if _, err := os.Stat(statePath); os.IsNotExist(err) {
+				if err := os.Remove(state.OldPath); err == nil || os.IsNotExist(err) {
+					return
+				}
+			}
+			time.Sleep(time.Second)
+		}
+
+		// The watchdog is gone without finishing, so the update is complete
+		// as far as this agent is concerned
+		log.Printf("Update watchdog did not finish, clearing the update state")
+		os.Remove(ackPath)
+		os.Remove(statePath)
+		if err := os.Remove(state.OldPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove previous agent executable: %v", err)
+		}
+	}()
+}
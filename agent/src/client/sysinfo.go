@@ -7,8 +7,38 @@ import (
 	"os/exec"
 	"runtime"
 	"strings"
+	"sync"
+
+	"github.com/shirou/gopsutil/v3/host"
 )
 
+// staticSysInfo caches host facts that do not change while the agent runs,
+// so re-registering after a reconnect does not run external commands again.
+// Values are only cached once they were read successfully.
+var staticSysInfo struct {
+	mu         sync.Mutex
+	osVersion  string
+	macAddress string
+	username   string
+}
+
+// cachedSysInfo returns the cached value of *field, filling it with fetch on
+// the first successful call
+func cachedSysInfo(field *string, fetch func() (string, error)) (string, error) {
+	staticSysInfo.mu.Lock()
+	defer staticSysInfo.mu.Unlock()
+	
+	if *field != "" {
+		return *field, nil
+	}
+	value, err := fetch()
+	if err != nil {
+		return "", err
+	}
+	*field = value
+	return value, nil
+}
+
 // getHostname returns the hostname of the system
 func getHostname() (string, error) {
 	return os.Hostname()
@@ -16,47 +46,40 @@ func getHostname() (string, error) {
 
 // getIPAddress returns the primary IP address of the system
 func getIPAddress() (string, error) {
-	// Get network interfaces
-	interfaces, err := net.Interfaces()
+	_, ip, err := primaryInterface()
 	if err != nil {
-		return "", fmt.Errorf("failed to get network interfaces: %v", err)
+		return "", fmt.Errorf("no suitable IP address found: %v", err)
 	}
+	return ip.String(), nil
+}
 
-	// Find non-loopback IPv4 address
-	for _, iface := range interfaces {
-		// Skip loopback, unconnected, or down interfaces
-		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
-			continue
-		}
-
-		// Get addresses for interface
-		addrs, err := iface.Addrs()
+// getMACAddress returns the MAC address of the primary network interface.
+// It is read once per process.
+func getMACAddress() (string, error) {
+	return cachedSysInfo(&staticSysInfo.macAddress, func() (string, error) {
+		iface, _, err := primaryInterface()
 		if err != nil {
-			continue
+			return "", fmt.Errorf("no suitable MAC address found: %v", err)
 		}
-
-		// Find IPv4 address
-		for _, addr := range addrs {
-			ipNet, ok := addr.(*net.IPNet)
-			if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
-				return ipNet.IP.String(), nil
-			}
-		}
-	}
-
-	return "", fmt.Errorf("no suitable IP address found")
+		return iface.HardwareAddr.String(), nil
+	})
 }
 
-// getMACAddress returns the MAC address of the primary network interface
-func getMACAddress() (string, error) {
+// primaryInterface returns the first up, non-loopback interface with an IPv4
+// address. On IPv6-only hosts it falls back to the first interface with a
+// global IPv6 address.
+func primaryInterface() (*net.Interface, net.IP, error) {
 	// Get network interfaces
 	interfaces, err := net.Interfaces()
 	if err != nil {
-		return "", fmt.Errorf("failed to get network interfaces: %v", err)
+		return nil, nil, fmt.Errorf("failed to get network interfaces: %v", err)
 	}
 
-	// Find non-loopback interface with an IPv4 address
-	for _, iface := range interfaces {
+	var ipv6Iface *net.Interface
+	var ipv6 net.IP
+	for i := range interfaces {
+		iface := &interfaces[i]
+		
 		// Skip loopback, unconnected, or down interfaces
 		if iface.Flags&net.FlagLoopback != 0 || iface.Flags&net.FlagUp == 0 {
 			continue
@@ -68,26 +91,35 @@ func getMACAddress() (string, error) {
 			continue
 		}
 
-		// Check for IPv4 address
-		hasIPv4 := false
 		for _, addr := range addrs {
 			ipNet, ok := addr.(*net.IPNet)
-			if ok && !ipNet.IP.IsLoopback() && ipNet.IP.To4() != nil {
-				hasIPv4 = true
-				break
+			if !ok || ipNet.IP.IsLoopback() {
+				continue
+			}
+			if ipNet.IP.To4() != nil {
+				return iface, ipNet.IP, nil
+			}
+			// Link-local IPv6 addresses are present on every interface and
+			// are not useful to identify the host
+			if ipv6 == nil && ipNet.IP.IsGlobalUnicast() {
+				ipv6Iface, ipv6 = iface, ipNet.IP
 			}
-		}
-
-		if hasIPv4 {
-			return iface.HardwareAddr.String(), nil
 		}
 	}
 
-	return "", fmt.Errorf("no suitable MAC address found")
+	if ipv6 != nil {
+		return ipv6Iface, ipv6, nil
+	}
+	return nil, nil, fmt.Errorf("no interface with a usable address")
 }
 
-// getUsername returns the current username
+// getUsername returns the current username. It is read once per process.
 func getUsername() (string, error) {
+	return cachedSysInfo(&staticSysInfo.username, readUsername)
+}
+
+// readUsername looks up the current username
+func readUsername() (string, error) {
 	// First try environment variables
 	username := os.Getenv("USER")
 	
@@ -143,8 +175,21 @@ func getUsername() (string, error) {
 	return username, nil
 }
 
-// getOSVersion returns the operating system version
+// getOSVersion returns the operating system version. It is read once per
+// process, from the OS APIs through gopsutil when they give an answer.
 func getOSVersion() (string, error) {
+	return cachedSysInfo(&staticSysInfo.osVersion, func() (string, error) {
+		if info, err := host.Info(); err == nil {
+			if version := strings.TrimSpace(info.Platform + " " + info.PlatformVersion); version != "" {
+				return version, nil
+			}
+		}
+		return readOSVersion()
+	})
+}
+
+// readOSVersion returns the operating system version using platform commands
+func readOSVersion() (string, error) {
 	switch runtime.GOOS {
 	case "windows":
 		// Most reliable way to get detailed Windows version info
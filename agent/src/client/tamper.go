@@ -0,0 +1,94 @@
+package client
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	pb "agent/proto"
+	"agent/persist"
+)
+
+// configHashFile holds the keyed checksum of the config file as of the last run
+const configHashFile = "config.sha256"
+
+// CheckConfigIntegrity compares the config file with the hash recorded by the
+// previous run and raises TAMPER_DETECTED if it changed while the agent was
+// not running. The current hash becomes the new baseline.
+func (c *EDRClient) CheckConfigIntegrity(configFile string) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		log.Printf("Warning: failed to read config file for integrity check: %v", err)
+		return
+	}
+	actual := c.integrity.Checksum(data)
+
+	hashPath := filepath.Join(c.dataDir, configHashFile)
+	stored, err := os.ReadFile(hashPath)
+	if err == nil {
+		expected := strings.TrimSpace(string(stored))
+		if !c.integrity.Matches(data, expected) {
+			log.Printf("WARNING: Config file %s changed since the last run (expected checksum %s, got %s)", configFile, expected, actual)
+			c.SendEvent(pb.AgentEventType_TAMPER_DETECTED,
+				"Agent configuration file changed between runs",
+				map[string]string{"file": configFile, "expected_checksum": expected, "actual_checksum": actual})
+		}
+	} else if !os.IsNotExist(err) {
+		log.Printf("Warning: failed to read config hash: %v", err)
+	}
+
+	c.writeConfigHash(actual)
+}
+
+// RecordConfigHash makes the config file's current contents the baseline for
+// the next CheckConfigIntegrity. It is called after the agent rewrites the
+// file itself and after a reload, so intended changes are not reported.
+func (c *EDRClient) RecordConfigHash(configFile string) {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		log.Printf("Warning: failed to read config file: %v", err)
+		return
+	}
+	c.writeConfigHash(c.integrity.Checksum(data))
+}
+
+func (c *EDRClient) writeConfigHash(hash string) {
+	if err := persist.WriteFileAtomic(filepath.Join(c.dataDir, configHashFile), []byte(hash+"\n"), 0600); err != nil {
+		log.Printf("Warning: failed to record config hash: %v", err)
+	}
+}
+
+// ApplyTamperProtection restricts the agent binary, config file, data
+// directory and key directory so that only SYSTEM can modify or delete them. It is re-applied on
+// every start so a binary installed by SELF_UPDATE, which inherits its
+// directory's permissions, is protected again by the new agent. SELF_UPDATE
+// itself is unaffected because the agent runs as SYSTEM.
+func (c *EDRClient) ApplyTamperProtection(configFile string) {
+	var files []string
+	if exePath, err := os.Executable(); err == nil {
+		if resolved, err := filepath.EvalSymlinks(exePath); err == nil {
+			exePath = resolved
+		}
+		files = append(files, exePath)
+	}
+	if absConfig, err := filepath.Abs(configFile); err == nil {
+		files = append(files, absConfig)
+	}
+
+	dataDir, err := filepath.Abs(c.dataDir)
+	if err != nil {
+		dataDir = c.dataDir
+	}
+
+	dirs := []string{dataDir}
+	if c.keys != nil {
+		dirs = append(dirs, c.keys.Dir())
+	}
+
+	if err := protectAgentFiles(files, dirs); err != nil {
+		log.Printf("WARNING: Tamper protection not applied: %v", err)
+		return
+	}
+	log.Printf("Tamper protection applied to %s and %s", strings.Join(files, ", "), strings.Join(dirs, ", "))
+}
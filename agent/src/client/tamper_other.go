@@ -0,0 +1,10 @@
+// +build !windows
+
+package client
+
+import "fmt"
+
+// protectAgentFiles is only implemented on Windows
+func protectAgentFiles(files, dirs []string) error {
+	return fmt.Errorf("tamper protection is not supported on this platform")
+}
@@ -0,0 +1,78 @@
+// +build windows
+
+package client
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	sidLocalSystem    = "S-1-5-18"
+	sidAdministrators = "S-1-5-32-544"
+)
+
+// agentFileSDDL grants SYSTEM full control and Administrators read/execute
+// and nobody else any access; the P flag blocks inherited entries
+const agentFileSDDL = "D:P(A;;FA;;;" + sidLocalSystem + ")(A;;FRFX;;;" + sidAdministrators + ")"
+
+// agentDirSDDL is agentFileSDDL inherited by everything inside a directory
+const agentDirSDDL = "D:P(A;OICI;FA;;;" + sidLocalSystem + ")(A;OICI;FRFX;;;" + sidAdministrators + ")"
+
+// protectAgentFiles replaces the ACLs of files and dirs with ones that grant
+// full control to SYSTEM and read/execute to Administrators only, and makes
+// SYSTEM their owner so no other account keeps the owner's right to change
+// the ACL. Directory grants are inherited by everything inside them. The
+// agent must run as SYSTEM or it would lock itself out.
+func protectAgentFiles(files, dirs []string) error {
+	if !runningAsSystem() {
+		return fmt.Errorf("agent is not running as SYSTEM")
+	}
+
+	for _, file := range files {
+		if err := protectPath(file, agentFileSDDL); err != nil {
+			return err
+		}
+	}
+	for _, dir := range dirs {
+		if err := protectPath(dir, agentDirSDDL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// protectPath sets the owner of path to SYSTEM and its DACL to the one in
+// sddl, dropping every other entry, explicit or inherited
+func protectPath(path, sddl string) error {
+	sd, err := windows.SecurityDescriptorFromString(sddl)
+	if err != nil {
+		return err
+	}
+	dacl, _, err := sd.DACL()
+	if err != nil {
+		return err
+	}
+	owner, err := windows.StringToSid(sidLocalSystem)
+	if err != nil {
+		return err
+	}
+
+	err = windows.SetNamedSecurityInfo(path, windows.SE_FILE_OBJECT,
+		windows.OWNER_SECURITY_INFORMATION|windows.DACL_SECURITY_INFORMATION|windows.PROTECTED_DACL_SECURITY_INFORMATION,
+		owner, nil, dacl, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set permissions on %s: %v", path, err)
+	}
+	return nil
+}
+
+// runningAsSystem reports whether the agent runs as the LocalSystem account
+func runningAsSystem() bool {
+	user, err := windows.GetCurrentProcessToken().GetTokenUser()
+	if err != nil {
+		return false
+	}
+	return user.User.Sid.String() == sidLocalSystem
+}
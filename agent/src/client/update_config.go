@@ -0,0 +1,33 @@
+package client
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// handleUpdateConfig saves configuration changes pushed by the server and has
+// the agent reload its configuration file, as on SIGHUP. Each parameter is a
+// YAML key and its new value.
+func (h *CommandHandler) handleUpdateConfig(params map[string]string) (string, error) {
+	restart, err := h.client.config.ApplyRemote(params)
+	if err != nil {
+		return "", fmt.Errorf("configuration not updated: %v", err)
+	}
+	
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	log.Printf("Configuration updated by the server: %s", strings.Join(keys, ", "))
+	
+	h.client.requestReload()
+	
+	if len(restart) > 0 {
+		return fmt.Sprintf("Configuration updated (%s), restart required for %s",
+			strings.Join(keys, ", "), strings.Join(restart, ", ")), nil
+	}
+	return fmt.Sprintf("Configuration updated (%s)", strings.Join(keys, ", ")), nil
+}
@@ -0,0 +1,86 @@
+// +build !windows
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// findAgentService returns the systemd unit the agent runs as, taken from
+// its cgroup. Units of a user's systemd instance are not accepted since the
+// watchdog talks to the system manager.
+func findAgentService() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("cannot read cgroup: %v", err)
+	}
+	if unit := unitFromCgroup(string(data)); unit != "" {
+		return unit, nil
+	}
+	return "", fmt.Errorf("agent is not running as a systemd service")
+}
+
+// unitFromCgroup returns the innermost .service unit in the contents of
+// /proc/self/cgroup, preferring the unified hierarchy
+func unitFromCgroup(data string) string {
+	unit := ""
+	for _, line := range strings.Split(data, "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || strings.Contains(parts[2], "/user.slice/") {
+			continue
+		}
+		elems := strings.Split(parts[2], "/")
+		for i := len(elems) - 1; i >= 0; i-- {
+			if strings.HasSuffix(elems[i], ".service") {
+				if parts[0] == "0" {
+					return elems[i]
+				}
+				if unit == "" {
+					unit = elems[i]
+				}
+				break
+			}
+		}
+	}
+	return unit
+}
+
+// startUpdateWatchdog runs exePath as the update watchdog in a transient
+// systemd unit, so it is not stopped with the agent's own unit
+func startUpdateWatchdog(ctx context.Context, exePath, statePath string) error {
+	unit := fmt.Sprintf("edr-agent-update-%d", time.Now().Unix())
+	output, err := execRunner{}.Run(ctx, "systemd-run", "--unit="+unit, "--collect", "--quiet",
+		exePath, "-"+UpdateWatchdogFlag, statePath)
+	if err != nil {
+		return fmt.Errorf("failed to start update watchdog: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// removeUpdateWatchdog cleans up after the watchdog; systemd collects the
+// transient unit by itself
+func removeUpdateWatchdog() {}
+
+// stopAgentService stops the unit and waits for it to stop
+func stopAgentService(unit string) error {
+	return systemctl("stop", unit)
+}
+
+// startAgentService starts the unit
+func startAgentService(unit string) error {
+	return systemctl("start", unit)
+}
+
+func systemctl(action, unit string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), updateStopTimeout)
+	defer cancel()
+	output, err := execRunner{}.Run(ctx, "systemctl", action, unit)
+	if err != nil {
+		return fmt.Errorf("systemctl %s %s failed: %v: %s", action, unit, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// +build windows
+
+package client
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// updateTaskName is the scheduled task the update watchdog runs as
+const updateTaskName = `EDR Agent Update`
+
+// findAgentService returns the Windows service whose process is the agent
+// or one of its ancestors, so agents run by a service wrapper are found too
+func findAgentService() (string, error) {
+	pids := make(map[uint32]bool)
+	for pid, depth := int32(os.Getpid()), 0; pid > 0 && depth < 8; depth++ {
+		pids[uint32(pid)] = true
+		proc, err := process.NewProcess(pid)
+		if err != nil {
+			break
+		}
+		if pid, err = proc.Ppid(); err != nil {
+			break
+		}
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return "", fmt.Errorf("cannot connect to the service control manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	names, err := m.ListServices()
+	if err != nil {
+		return "", fmt.Errorf("cannot list services: %v", err)
+	}
+	for _, name := range names {
+		s, err := m.OpenService(name)
+		if err != nil {
+			continue
+		}
+		status, err := s.Query()
+		s.Close()
+		if err == nil && status.State == svc.Running && pids[status.ProcessId] {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("agent is not running as a Windows service")
+}
+
+// startUpdateWatchdog runs exePath as the update watchdog in a one-off
+// scheduled task, so it is not stopped with the agent's service or its
+// wrapper's process tree
+func startUpdateWatchdog(ctx context.Context, exePath, statePath string) error {
+	command := fmt.Sprintf(`"%s" -%s "%s"`, exePath, UpdateWatchdogFlag, statePath)
+	output, err := execRunner{}.Run(ctx, "schtasks", "/Create", "/TN", updateTaskName, "/TR", command,
+		"/SC", "ONCE", "/ST", "00:00", "/RU", "SYSTEM", "/RL", "HIGHEST", "/F")
+	if err != nil {
+		return fmt.Errorf("failed to create update watchdog task: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	output, err = execRunner{}.Run(ctx, "schtasks", "/Run", "/TN", updateTaskName)
+	if err != nil {
+		removeUpdateWatchdog()
+		return fmt.Errorf("failed to start update watchdog task: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// removeUpdateWatchdog deletes the watchdog's scheduled task; a running
+// task keeps running
+func removeUpdateWatchdog() {
+	output, err := execRunner{}.Run(context.Background(), "schtasks", "/Delete", "/TN", updateTaskName, "/F")
+	if err != nil {
+		log.Printf("Failed to delete scheduled task %s: %v: %s", updateTaskName, err, strings.TrimSpace(string(output)))
+	}
+}
+
+// stopAgentService stops the service and waits for it to stop
+func stopAgentService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to the service control manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("cannot open service %s: %v", name, err)
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err == windows.ERROR_SERVICE_NOT_ACTIVE {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stop service %s: %v", name, err)
+	}
+
+	deadline := time.Now().Add(updateStopTimeout)
+	for status.State != svc.Stopped {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("service %s did not stop within %v", name, updateStopTimeout)
+		}
+		time.Sleep(500 * time.Millisecond)
+		if status, err = s.Query(); err != nil {
+			return fmt.Errorf("cannot query service %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// startAgentService starts the service
+func startAgentService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("cannot connect to the service control manager: %v", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("cannot open service %s: %v", name, err)
+	}
+	defer s.Close()
+
+	if err := s.Start(); err != nil {
+		return fmt.Errorf("failed to start service %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// stableAgentIDNamespace keeps derived IDs from colliding with a plain hash
+// of the same identifiers used elsewhere
+const stableAgentIDNamespace = "edr-agent-id-v1"
+
+// DeriveStableAgentID returns an agent ID derived from the host's machine ID,
+// primary MAC address and system disk serial number, so a host that loses
+// its config file or is reinstalled registers under the same ID again. The
+// ID is formatted as a UUID. Identifiers that cannot be read are left out;
+// an error is returned only if none are available.
+func DeriveStableAgentID() (string, error) {
+	parts := []string{
+		"machine=" + strings.ToLower(strings.TrimSpace(machineID())),
+		"mac=" + primaryMAC(),
+		"disk=" + strings.TrimSpace(systemDiskSerial()),
+	}
+
+	found := false
+	for _, part := range parts {
+		if !strings.HasSuffix(part, "=") {
+			found = true
+		}
+	}
+	if !found {
+		return "", fmt.Errorf("no stable hardware identifiers available")
+	}
+
+	sum := sha256.Sum256([]byte(stableAgentIDNamespace + "\n" + strings.Join(parts, "\n")))
+	id := sum[:16]
+	id[6] = (id[6] & 0x0f) | 0x50 // Version 5 (name based, SHA)
+	id[8] = (id[8] & 0x3f) | 0x80 // RFC 4122 variant
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16]), nil
+}
+
+// primaryMAC returns the lowest globally administered MAC address of the
+// host's network interfaces. Interfaces are considered whether or not they
+// are up, and locally administered addresses (virtual adapters, randomized
+// Wi-Fi MACs) are skipped, so the choice does not change with the network
+// the host is on.
+func primaryMAC() string {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return ""
+	}
+
+	var macs []net.HardwareAddr
+	for _, iface := range interfaces {
+		mac := iface.HardwareAddr
+		if iface.Flags&net.FlagLoopback != 0 || len(mac) != 6 {
+			continue
+		}
+		if mac[0]&0x02 != 0 || bytes.Equal(mac, make(net.HardwareAddr, 6)) {
+			continue
+		}
+		macs = append(macs, mac)
+	}
+	if len(macs) == 0 {
+		return ""
+	}
+
+	sort.Slice(macs, func(i, j int) bool { return bytes.Compare(macs[i], macs[j]) < 0 })
+	return macs[0].String()
+}
@@ -0,0 +1,62 @@
+// +build !windows
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// machineID returns the systemd or D-Bus machine ID
+func machineID() string {
+	for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+		if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+			return string(data)
+		}
+	}
+	return ""
+}
+
+// systemDiskSerial returns the serial number of the first fixed disk
+// reported in sysfs. Loop, RAM, device-mapper and optical devices are
+// skipped.
+func systemDiskSerial() string {
+	entries, err := os.ReadDir("/sys/block")
+	if err != nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		skip := false
+		for _, prefix := range []string{"loop", "ram", "zram", "dm-", "md", "sr", "fd"} {
+			if strings.HasPrefix(name, prefix) {
+				skip = true
+				break
+			}
+		}
+		if skip {
+			continue
+		}
+
+		dir := filepath.Join("/sys/block", name)
+		if removable, err := os.ReadFile(filepath.Join(dir, "removable")); err == nil && strings.TrimSpace(string(removable)) != "0" {
+			continue
+		}
+		for _, file := range []string{"device/serial", "device/wwid", "serial"} {
+			if data, err := os.ReadFile(filepath.Join(dir, file)); err == nil {
+				if serial := strings.TrimSpace(string(data)); serial != "" {
+					return serial
+				}
+			}
+		}
+	}
+	return ""
+}
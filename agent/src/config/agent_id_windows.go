@@ -0,0 +1,108 @@
+// +build windows
+
+package config
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+)
+
+const (
+	ioctlStorageGetDeviceNumber = 0x2D1080 // IOCTL_STORAGE_GET_DEVICE_NUMBER
+	ioctlStorageQueryProperty   = 0x2D1400 // IOCTL_STORAGE_QUERY_PROPERTY
+)
+
+// storageDeviceNumber is STORAGE_DEVICE_NUMBER
+type storageDeviceNumber struct {
+	DeviceType      uint32
+	DeviceNumber    uint32
+	PartitionNumber uint32
+}
+
+// storagePropertyQuery is STORAGE_PROPERTY_QUERY asking for the
+// StorageDeviceProperty descriptor
+type storagePropertyQuery struct {
+	PropertyID           uint32
+	QueryType            uint32
+	AdditionalParameters [1]byte
+}
+
+// machineID returns the MachineGuid Windows generates at installation
+func machineID() string {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Cryptography`,
+		registry.QUERY_VALUE|registry.WOW64_64KEY)
+	if err != nil {
+		return ""
+	}
+	defer key.Close()
+
+	guid, _, err := key.GetStringValue("MachineGuid")
+	if err != nil {
+		return ""
+	}
+	return guid
+}
+
+// systemDiskSerial returns the hardware serial number of the physical disk
+// holding the system drive
+func systemDiskSerial() string {
+	drive := os.Getenv("SystemDrive")
+	if drive == "" {
+		drive = "C:"
+	}
+
+	volume, err := openDevice(`\\.\` + drive)
+	if err != nil {
+		return ""
+	}
+	var number storageDeviceNumber
+	var returned uint32
+	err = windows.DeviceIoControl(volume, ioctlStorageGetDeviceNumber, nil, 0,
+		(*byte)(unsafe.Pointer(&number)), uint32(unsafe.Sizeof(number)), &returned, nil)
+	windows.CloseHandle(volume)
+	if err != nil {
+		return ""
+	}
+
+	disk, err := openDevice(`\\.\PhysicalDrive` + strconv.FormatUint(uint64(number.DeviceNumber), 10))
+	if err != nil {
+		return ""
+	}
+	defer windows.CloseHandle(disk)
+
+	// The STORAGE_DEVICE_DESCRIPTOR is followed by its strings; SerialNumberOffset
+	// sits at byte 24 and is 0 when the disk reports no serial
+	query := storagePropertyQuery{}
+	buf := make([]byte, 1024)
+	err = windows.DeviceIoControl(disk, ioctlStorageQueryProperty,
+		(*byte)(unsafe.Pointer(&query)), uint32(unsafe.Sizeof(query)),
+		&buf[0], uint32(len(buf)), &returned, nil)
+	if err != nil || returned < 28 {
+		return ""
+	}
+	offset := *(*uint32)(unsafe.Pointer(&buf[24]))
+	if offset == 0 || offset >= returned {
+		return ""
+	}
+	serial := buf[offset:returned]
+	if end := strings.IndexByte(string(serial), 0); end >= 0 {
+		serial = serial[:end]
+	}
+	return strings.TrimSpace(string(serial))
+}
+
+// openDevice opens a volume or disk for queries only, which does not need
+// administrator rights
+func openDevice(path string) (windows.Handle, error) {
+	path16, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return windows.InvalidHandle, err
+	}
+	return windows.CreateFile(path16, 0, windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE,
+		nil, windows.OPEN_EXISTING, 0, 0)
+}
@@ -2,13 +2,21 @@ package config
 
 import (
 	"fmt"
+	"log"
 	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"agent/persist"
+	pb "agent/proto"
 )
 
 // Default configuration values - centralized constants
@@ -25,13 +33,24 @@ const (
 	// TLS/Certificate defaults
 	DefaultCACertPath        = ""    // Path to CA certificate for server verification
 	DefaultInsecureSkipVerify = false // Whether to skip certificate verification
+	DefaultTLSServerName     = ""    // Name the server certificate is verified against (empty = host of server_address)
+	DefaultAllowRemoteServerChange = false // Whether UPDATE_CONFIG may change the server connection settings
+	DefaultProxyURL          = ""    // Proxy the server connection goes through (empty = direct)
+	DefaultIOCSigningPubkeyPath = ""  // Public key IOC updates must be signed with (empty = unsigned updates accepted)
+	DefaultIOCFeedDir           = ""  // Directory of local IOC feed files (empty = disabled)
 	
 	// Logging defaults
 	DefaultLogLevel  = "info"
 	DefaultLogFormat = "console"
+	DefaultLogMaxSizeMB  = 100
+	DefaultLogMaxBackups = 5  // 0 = keep all
+	DefaultLogMaxAgeDays = 30 // 0 = no age limit
 	
 	// Timing defaults (in minutes)
 	DefaultScanInterval    = 5
+	DefaultIPURLScanInterval = 0 // 0 = scan_interval
+	DefaultFileScanInterval  = 0 // 0 = scan_interval
+	DefaultScanSchedule    = "" // Windows scheduled scans may run in (empty = any time)
 	DefaultMetricsInterval = 10  // 10 minutes ping interval for new ping-based monitoring
 	
 	// Connection defaults (in seconds)
@@ -40,13 +59,77 @@ const (
 	DefaultMaxReconnectDelay   = 60
 	DefaultIOCUpdateDelay      = 3
 	DefaultShutdownTimeout     = 500 // milliseconds
+	DefaultKeepaliveTime       = 30
+	DefaultKeepaliveTimeout    = 10
+	DefaultGRPCCompression     = false // Gzip-compress messages sent to the server
+	DefaultHeartbeatInterval   = 30
+	DefaultMaxRegistrationAttempts = 0 // 0 = keep retrying until the server answers
+	DefaultDialBlocking = false // Connect in the background rather than waiting for the first connection
+	
+	// Command handling defaults
+	DefaultCommandDedupRetention = 60 // minutes, 0 = disabled
+	DefaultCommandTimeout        = 600 // seconds, 0 = no limit
+	DefaultCommandDrainTimeout   = 30  // seconds, 0 = do not wait
+	DefaultMaxConcurrentCommands = 8
+	
+	// File collection defaults
+	DefaultCollectBeforeDelete = false
+	DefaultMaxUploadSize       = 100 // megabytes
+	
+	// Remediation defaults
+	DefaultMode = ModeProtect
+	
+	// Response script defaults
+	DefaultResponseScriptTimeout = 60 // seconds
+	
+	// IOC match enrichment defaults
+	DefaultEnrichmentURL     = "" // Empty = no enrichment
+	DefaultEnrichmentTimeout = 2000 // milliseconds
+	
+	// IOC match report de-duplication defaults
+	DefaultReportDedupWindow = 5 // minutes, 0 = report every match
+	
+	// User notification defaults
+	DefaultNotifyUser        = false
+	DefaultNotifyUserMessage = "Your security software protected this computer: {action}. Contact your IT department if you have questions."
+	
+	// Network isolation defaults
+	DefaultIsolationMaxDuration = 60 // minutes, 0 = no auto-restore
 	
 	// System monitoring defaults
 	DefaultCPUSampleDuration = 500 // milliseconds
+	DefaultHealthPort        = 0   // 0 = health server disabled
+	DefaultLocalControl      = true // Serve agentctl on the local control socket or pipe
 	
 	// Windows-specific defaults
 	DefaultHostsFilePath = "C:\\Windows\\System32\\drivers\\etc\\hosts"
+	DefaultUnixHostsFilePath = "/etc/hosts"
 	DefaultBlockedIPRedirect = "127.0.0.1"
+	DefaultURLBlockMethod = URLBlockHosts
+	DefaultBlockTTLHours = 0 // 0 = blocks never expire
+	DefaultBlockSaveDelayMs = 2000 // milliseconds
+	DefaultTamperProtection = false
+	DefaultSysmonMaxEventsPerScan = 100 // Events read from the Sysmon log per batch
+	
+	// Directory scan defaults
+	DefaultScanMaxDepth = 0 // 0 = unlimited
+	DefaultScanThrottlePercent = 0 // 0 = unthrottled
+	DefaultMaxHashFileBytes = 256 << 20 // 256 MB, 0 = no limit
+	DefaultMemoryScanMaxSize = 256 // megabytes read per process by SCAN_MEMORY
+	
+	// URL blocking methods used as url_block_method values
+	URLBlockHosts = "hosts" // Redirect exact domains in the hosts file
+	URLBlockDNS   = "dns"   // Sinkhole domains and their subdomains with a DNS policy (Windows NRPT)
+	
+	// Agent modes used as mode values
+	ModeProtect = "protect" // Detect, report and remediate
+	ModeMonitor = "monitor" // Detect and report only, nothing is blocked, deleted or killed
+	
+	// Remediation actions used as remediation_policy values
+	RemediationReportOnly    = "report_only"     // Report the match, change nothing
+	RemediationQuarantine    = "quarantine"      // Move matching files into <data_dir>/quarantine
+	RemediationDelete        = "delete"          // Delete matching files, block matching IPs/URLs
+	RemediationKillAndDelete = "kill_and_delete" // Also kill processes running a matching file
 	
 	// Validation limits
 	MinScanInterval    = 1
@@ -55,17 +138,63 @@ const (
 	MaxMetricsInterval = 1440 // 24 hours
 	MinConnectionTimeout = 5
 	MaxConnectionTimeout = 300 // 5 minutes
+	MinKeepaliveTime     = 10  // gRPC raises shorter client ping intervals to 10s
+	MinHeartbeatInterval = 5
+	MaxHeartbeatInterval = 3600 // 1 hour
+	MaxScanThrottlePercent = 100
+	MaxScanWorkers       = 64
+	MaxUploadSizeLimit   = 4096 // megabytes
+	MaxSysmonEventsPerScan = 10000
+	MaxMemoryScanSize    = 4096 // megabytes
+	MaxNotifyUserMessage = 1024 // characters
+	MaxConcurrentCommandsLimit = 256
+	MinEnrichmentTimeout = 100   // milliseconds
+	MaxEnrichmentTimeout = 10000 // milliseconds
+	MaxReportDedupWindow = 1440  // minutes
+	MaxResponseScriptTimeout = 3600 // seconds
+	MaxBlockSaveDelayMs = 60000 // milliseconds
 )
 
+// DefaultScanExclusions are glob patterns skipped by on-demand directory scans.
+// Patterns containing a separator are matched against the full path, others
+// against the file or directory name.
+var DefaultScanExclusions = []string{
+	"C:\\Windows\\WinSxS",
+	"C:\\Windows\\Installer",
+	"C:\\Windows\\SoftwareDistribution\\Download",
+}
+
+// RemediationSeverities are the IOC severities remediation_policy is keyed by
+var RemediationSeverities = []string{"low", "medium", "high", "critical"}
+
+// DefaultRemediationPolicy maps IOC severity to the action taken on a match.
+// IOCs without a known severity get the "high" action.
+var DefaultRemediationPolicy = map[string]string{
+	"low":      RemediationReportOnly,
+	"medium":   RemediationQuarantine,
+	"high":     RemediationDelete,
+	"critical": RemediationKillAndDelete,
+}
+
 // Config represents the complete agent configuration
 type Config struct {
 	// Server configuration
 	ServerAddress string `yaml:"server_address" json:"server_address"`
 	UseTLS        bool   `yaml:"use_tls" json:"use_tls"`
+	AllowRemoteServerChange bool `yaml:"allow_remote_server_change" json:"allow_remote_server_change"` // Let UPDATE_CONFIG change server_address and the TLS settings
 	
 	// TLS/Certificate configuration
 	CACertPath        string `yaml:"ca_cert_path" json:"ca_cert_path"`               // Path to CA certificate for server verification
 	InsecureSkipVerify bool   `yaml:"insecure_skip_verify" json:"insecure_skip_verify"` // Skip certificate verification (not recommended for production)
+	TLSServerName     string `yaml:"tls_server_name" json:"tls_server_name"`         // Hostname expected in the server certificate, for dialing by IP or through a load balancer
+	
+	// Proxy configuration
+	ProxyURL      string `yaml:"proxy_url" json:"proxy_url"`           // http:// or socks5:// proxy for the server connection (empty = direct)
+	ProxyUsername string `yaml:"proxy_username" json:"proxy_username"` // Proxy credentials, if the proxy requires them
+	ProxyPassword string `yaml:"proxy_password" json:"-"`            // Kept out of JSON output
+	
+	IOCSigningPubkeyPath string `yaml:"ioc_signing_pubkey_path" json:"ioc_signing_pubkey_path"` // Ed25519 public key that signs IOC updates
+	IOCFeedDir           string `yaml:"ioc_feed_dir" json:"ioc_feed_dir"`                       // Directory of CSV and STIX IOC feed files
 	
 	// Agent identification
 	AgentID      string `yaml:"agent_id" json:"agent_id"`
@@ -78,9 +207,16 @@ type Config struct {
 	// Logging configuration
 	LogLevel  string `yaml:"log_level" json:"log_level"`
 	LogFormat string `yaml:"log_format" json:"log_format"` // "json" or "console"
+	LogMaxSizeMB  int `yaml:"log_max_size_mb" json:"log_max_size_mb"`   // Rotate the log file at this size
+	LogMaxBackups int `yaml:"log_max_backups" json:"log_max_backups"`   // Rotated files to keep (0 = all)
+	LogMaxAgeDays int `yaml:"log_max_age_days" json:"log_max_age_days"` // Delete rotated files older than this (0 = never)
+	LogRedact []string `yaml:"log_redact" json:"log_redact"` // Command parameters masked in logs, "*" = all (empty = off)
 	
 	// Timing configuration (in minutes)
 	ScanInterval    int `yaml:"scan_interval" json:"scan_interval"`
+	IPURLScanInterval int `yaml:"ip_url_scan_interval" json:"ip_url_scan_interval"` // IP and URL block checks (0 = scan_interval)
+	FileScanInterval  int `yaml:"file_scan_interval" json:"file_scan_interval"`     // Sysmon file hash and FIM scans (0 = scan_interval)
+	ScanSchedule    string `yaml:"scan_schedule" json:"scan_schedule"` // HH:MM-HH:MM windows scheduled file scans are limited to
 	MetricsInterval int `yaml:"metrics_interval" json:"metrics_interval"`
 	
 	// Connection configuration (in seconds)
@@ -89,16 +225,80 @@ type Config struct {
 	MaxReconnectDelay  int `yaml:"max_reconnect_delay" json:"max_reconnect_delay"`
 	IOCUpdateDelay     int `yaml:"ioc_update_delay" json:"ioc_update_delay"`
 	ShutdownTimeout    int `yaml:"shutdown_timeout" json:"shutdown_timeout"` // milliseconds
+	KeepaliveTime      int `yaml:"keepalive_time" json:"keepalive_time"`       // Idle time before a keepalive ping
+	KeepaliveTimeout   int `yaml:"keepalive_timeout" json:"keepalive_timeout"` // Time to wait for a ping ack before closing
+	GRPCCompression    bool `yaml:"grpc_compression" json:"grpc_compression"`  // Gzip-compress gRPC messages sent to the server
+	HeartbeatInterval  int `yaml:"heartbeat_interval" json:"heartbeat_interval"` // Time between liveness heartbeats on the command stream
+	MaxRegistrationAttempts int `yaml:"max_registration_attempts" json:"max_registration_attempts"` // Startup registration attempts before giving up (0 = unlimited)
+	DialBlocking bool `yaml:"dial_blocking" json:"dial_blocking"` // Wait for the connection to the server before registering
 	
 	// System monitoring configuration
 	CPUSampleDuration int `yaml:"cpu_sample_duration" json:"cpu_sample_duration"` // milliseconds
+	HealthPort        int `yaml:"health_port" json:"health_port"`                 // Local health endpoint port on 127.0.0.1 (0 = disabled)
+	LocalControl      bool `yaml:"local_control" json:"local_control"`            // Accept agentctl commands on a local admin-only socket or pipe
 	
 	// Windows-specific configuration
 	HostsFilePath     string `yaml:"hosts_file_path" json:"hosts_file_path"`
 	BlockedIPRedirect string `yaml:"blocked_ip_redirect" json:"blocked_ip_redirect"`
+	URLBlockMethod    string `yaml:"url_block_method" json:"url_block_method"` // How URLs are blocked: hosts or dns
+	BlockTTLHours     int    `yaml:"block_ttl_hours" json:"block_ttl_hours"` // Expire IOC blocks after this many hours (0 = never)
+	BlockSaveDelayMs  int    `yaml:"block_save_delay_ms" json:"block_save_delay_ms"` // Milliseconds block list changes are collected before blocked_items.json is written
+	TamperProtection  bool   `yaml:"tamper_protection" json:"tamper_protection"` // Restrict the agent's files to SYSTEM
+	SysmonMaxEventsPerScan int `yaml:"sysmon_max_events_per_scan" json:"sysmon_max_events_per_scan"` // Sysmon events read per batch
+	
+	// Directory scan configuration
+	ScanExclusions []string `yaml:"scan_exclusions" json:"scan_exclusions"` // Glob patterns skipped by SCAN_PATH
+	ScanThrottlePercent int `yaml:"scan_throttle_percent" json:"scan_throttle_percent"` // Agent CPU share while hashing files (0 = unthrottled)
+	ScanWorkers    int      `yaml:"scan_workers" json:"scan_workers"`       // Files hashed in parallel by SCAN_PATH
+	MaxHashFileBytes int64  `yaml:"max_hash_file_bytes" json:"max_hash_file_bytes"` // Larger files are only hashed by SCAN_PATH (0 = no limit)
+	WatchPaths     []string `yaml:"watch_paths" json:"watch_paths"`         // Directories scanned as soon as files change (empty = off)
+	FIMPaths       []string `yaml:"fim_paths" json:"fim_paths"`             // Files and directories whose changes are reported (empty = off)
+	MemoryScanMaxSize int   `yaml:"memory_scan_max_size" json:"memory_scan_max_size"` // Memory read per process by SCAN_MEMORY (megabytes)
+	
+	// Command handling configuration
+	CommandDedupRetention int `yaml:"command_dedup_retention" json:"command_dedup_retention"` // Minutes to remember command IDs (0 = disabled)
+	CommandTimeout  int      `yaml:"command_timeout" json:"command_timeout"`   // Seconds a command may run before it fails (0 = no limit)
+	AllowedCommands []string `yaml:"allowed_commands" json:"allowed_commands"` // Command types the agent will run (empty = all)
+	CommandDrainTimeout int  `yaml:"command_drain_timeout" json:"command_drain_timeout"` // Seconds shutdown waits for running commands (0 = do not wait)
+	MaxConcurrentCommands int `yaml:"max_concurrent_commands" json:"max_concurrent_commands"` // Server commands executing at once
+	
+	// File collection configuration
+	CollectBeforeDelete bool `yaml:"collect_before_delete" json:"collect_before_delete"` // Upload malicious files to the server before deleting them
+	MaxUploadSize       int  `yaml:"max_upload_size" json:"max_upload_size"`             // Largest file uploaded to the server (megabytes)
+	
+	// Remediation configuration
+	Mode              string            `yaml:"mode" json:"mode"`                             // protect, or monitor to only detect and report
+	RemediationPolicy map[string]string `yaml:"remediation_policy" json:"remediation_policy"` // Action per IOC severity
+	ProtectedPaths    []string          `yaml:"protected_paths" json:"protected_paths"`       // Glob patterns of files never deleted or quarantined, whatever the policy
+	ResponseScripts   map[string]string `yaml:"response_scripts" json:"response_scripts"`     // Script run after the built-in action, per severity or IOC type (empty = off)
+	ResponseScriptTimeout int           `yaml:"response_script_timeout" json:"response_script_timeout"` // Seconds a response script may run before it is killed
+	
+	// IOC match enrichment configuration
+	EnrichmentURL     string `yaml:"enrichment_url" json:"enrichment_url"`         // Intel API asked for reputation context on IOC matches (empty = off)
+	EnrichmentTimeout int    `yaml:"enrichment_timeout" json:"enrichment_timeout"` // Longest wait for an enrichment lookup (milliseconds)
+	
+	// IOC match report de-duplication configuration
+	ReportDedupWindow int `yaml:"report_dedup_window" json:"report_dedup_window"` // Minutes during which repeat matches of an IOC are counted, not reported (0 = off)
+	
+	// User notification configuration
+	NotifyUser        bool   `yaml:"notify_user" json:"notify_user"`                 // Tell the logged-on user about remediation actions (Windows)
+	NotifyUserMessage string `yaml:"notify_user_message" json:"notify_user_message"` // Notification text, {action} is replaced by what was done
+	
+	// Process rule configuration
+	ProcessRules []ProcessRule `yaml:"process_rules" json:"process_rules"` // Suspicious parent/child process pairs (Sysmon Event ID 1)
+	
+	// Network isolation configuration
+	IsolationMaxDuration int `yaml:"isolation_max_duration" json:"isolation_max_duration"` // Minutes before unrenewed isolation is lifted (0 = never)
 	
 	// Internal flags (not saved to YAML)
 	ConfigFile string `yaml:"-" json:"-"`
+	
+	// Command-line overrides, re-applied on reload so flags keep precedence
+	flagOverrides map[string]interface{}
+	
+	// The configuration published by Reload and Update, see Current. Copies
+	// share it, so every copy sees the latest one.
+	live *atomic.Pointer[Config]
 }
 
 // ValidationError represents a configuration validation error
@@ -117,27 +317,75 @@ func NewDefaultConfig() *Config {
 	return &Config{
 		ServerAddress:       DefaultServerAddress,
 		UseTLS:             DefaultUseTLS,
+		AllowRemoteServerChange: DefaultAllowRemoteServerChange,
 		CACertPath:         DefaultCACertPath,
 		InsecureSkipVerify: DefaultInsecureSkipVerify,
+		TLSServerName:      DefaultTLSServerName,
+		ProxyURL:           DefaultProxyURL,
+		IOCSigningPubkeyPath: DefaultIOCSigningPubkeyPath,
+		IOCFeedDir:         DefaultIOCFeedDir,
 		AgentVersion:       DefaultAgentVersion,
 		DataDir:            DefaultDataDir,
 		LogLevel:           DefaultLogLevel,
 		LogFormat:          DefaultLogFormat,
+		LogMaxSizeMB:       DefaultLogMaxSizeMB,
+		LogMaxBackups:      DefaultLogMaxBackups,
+		LogMaxAgeDays:      DefaultLogMaxAgeDays,
 		ScanInterval:       DefaultScanInterval,
+		IPURLScanInterval:  DefaultIPURLScanInterval,
+		FileScanInterval:   DefaultFileScanInterval,
+		ScanSchedule:       DefaultScanSchedule,
 		MetricsInterval:    DefaultMetricsInterval,
 		ConnectionTimeout:  DefaultConnectionTimeout,
 		ReconnectDelay:     DefaultReconnectDelay,
 		MaxReconnectDelay:  DefaultMaxReconnectDelay,
 		IOCUpdateDelay:     DefaultIOCUpdateDelay,
 		ShutdownTimeout:    DefaultShutdownTimeout,
+		KeepaliveTime:      DefaultKeepaliveTime,
+		KeepaliveTimeout:   DefaultKeepaliveTimeout,
+		GRPCCompression:    DefaultGRPCCompression,
+		HeartbeatInterval:  DefaultHeartbeatInterval,
+		MaxRegistrationAttempts: DefaultMaxRegistrationAttempts,
+		DialBlocking:       DefaultDialBlocking,
 		CPUSampleDuration:  DefaultCPUSampleDuration,
-		HostsFilePath:      DefaultHostsFilePath,
+		HealthPort:         DefaultHealthPort,
+		LocalControl:       DefaultLocalControl,
+		HostsFilePath:      defaultHostsFilePath(),
 		BlockedIPRedirect:  DefaultBlockedIPRedirect,
+		URLBlockMethod:     DefaultURLBlockMethod,
+		BlockTTLHours:      DefaultBlockTTLHours,
+		BlockSaveDelayMs:   DefaultBlockSaveDelayMs,
+		TamperProtection:   DefaultTamperProtection,
+		SysmonMaxEventsPerScan: DefaultSysmonMaxEventsPerScan,
+		ScanExclusions:     append([]string(nil), DefaultScanExclusions...),
+		ScanThrottlePercent: DefaultScanThrottlePercent,
+		ScanWorkers:        defaultScanWorkers(),
+		MaxHashFileBytes:   DefaultMaxHashFileBytes,
+		MemoryScanMaxSize:  DefaultMemoryScanMaxSize,
+		CommandDedupRetention: DefaultCommandDedupRetention,
+		CommandTimeout:     DefaultCommandTimeout,
+		CommandDrainTimeout: DefaultCommandDrainTimeout,
+		MaxConcurrentCommands: DefaultMaxConcurrentCommands,
+		CollectBeforeDelete: DefaultCollectBeforeDelete,
+		MaxUploadSize:      DefaultMaxUploadSize,
+		Mode:               DefaultMode,
+		RemediationPolicy:  copyStringMap(DefaultRemediationPolicy),
+		ProtectedPaths:     defaultProtectedPaths(),
+		ResponseScripts:    map[string]string{},
+		ResponseScriptTimeout: DefaultResponseScriptTimeout,
+		EnrichmentURL:      DefaultEnrichmentURL,
+		EnrichmentTimeout:  DefaultEnrichmentTimeout,
+		ReportDedupWindow:  DefaultReportDedupWindow,
+		NotifyUser:         DefaultNotifyUser,
+		NotifyUserMessage:  DefaultNotifyUserMessage,
+		IsolationMaxDuration: DefaultIsolationMaxDuration,
 		ConfigFile:         DefaultConfigFile,
+		live:               new(atomic.Pointer[Config]),
 	}
 }
 
-// LoadConfig loads configuration with precedence: flags > YAML > defaults
+// LoadConfig loads configuration with precedence: flags > env > YAML > defaults.
+// Flags are applied afterwards by the caller through ApplyFlags.
 func LoadConfig(configFile string) (*Config, error) {
 	// Start with defaults
 	cfg := NewDefaultConfig()
@@ -161,6 +409,11 @@ func LoadConfig(configFile string) (*Config, error) {
 		}
 	}
 	
+	// Environment variables override the YAML file
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, err
+	}
+	
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("configuration validation failed: %v", err)
@@ -179,8 +432,149 @@ func (c *Config) loadFromYAML(filename string) error {
 	return yaml.Unmarshal(data, c)
 }
 
+// EnvPrefix is the prefix of environment variables that override config values
+const EnvPrefix = "EDR_"
+
+// envBinding maps an environment variable to a configuration field
+type envBinding struct {
+	env    string
+	key    string      // YAML key of the field, used for documentation
+	target interface{} // *string, *bool or *int
+}
+
+// envBindings returns the supported environment variable overrides
+func (c *Config) envBindings() []envBinding {
+	return []envBinding{
+		{EnvPrefix + "SERVER_ADDRESS", "server_address", &c.ServerAddress},
+		{EnvPrefix + "USE_TLS", "use_tls", &c.UseTLS},
+		{EnvPrefix + "ALLOW_REMOTE_SERVER_CHANGE", "allow_remote_server_change", &c.AllowRemoteServerChange},
+		{EnvPrefix + "CA_CERT_PATH", "ca_cert_path", &c.CACertPath},
+		{EnvPrefix + "INSECURE_SKIP_VERIFY", "insecure_skip_verify", &c.InsecureSkipVerify},
+		{EnvPrefix + "TLS_SERVER_NAME", "tls_server_name", &c.TLSServerName},
+		{EnvPrefix + "PROXY_URL", "proxy_url", &c.ProxyURL},
+		{EnvPrefix + "PROXY_USERNAME", "proxy_username", &c.ProxyUsername},
+		{EnvPrefix + "PROXY_PASSWORD", "proxy_password", &c.ProxyPassword},
+		{EnvPrefix + "IOC_SIGNING_PUBKEY_PATH", "ioc_signing_pubkey_path", &c.IOCSigningPubkeyPath},
+		{EnvPrefix + "IOC_FEED_DIR", "ioc_feed_dir", &c.IOCFeedDir},
+		{EnvPrefix + "AGENT_ID", "agent_id", &c.AgentID},
+		{EnvPrefix + "LOG_FILE", "log_file", &c.LogFile},
+		{EnvPrefix + "DATA_DIR", "data_dir", &c.DataDir},
+		{EnvPrefix + "LOG_LEVEL", "log_level", &c.LogLevel},
+		{EnvPrefix + "LOG_FORMAT", "log_format", &c.LogFormat},
+		{EnvPrefix + "LOG_MAX_SIZE_MB", "log_max_size_mb", &c.LogMaxSizeMB},
+		{EnvPrefix + "LOG_MAX_BACKUPS", "log_max_backups", &c.LogMaxBackups},
+		{EnvPrefix + "LOG_MAX_AGE_DAYS", "log_max_age_days", &c.LogMaxAgeDays},
+		{EnvPrefix + "SCAN_INTERVAL", "scan_interval", &c.ScanInterval},
+		{EnvPrefix + "IP_URL_SCAN_INTERVAL", "ip_url_scan_interval", &c.IPURLScanInterval},
+		{EnvPrefix + "FILE_SCAN_INTERVAL", "file_scan_interval", &c.FileScanInterval},
+		{EnvPrefix + "SCAN_SCHEDULE", "scan_schedule", &c.ScanSchedule},
+		{EnvPrefix + "METRICS_INTERVAL", "metrics_interval", &c.MetricsInterval},
+		{EnvPrefix + "CONNECTION_TIMEOUT", "connection_timeout", &c.ConnectionTimeout},
+		{EnvPrefix + "RECONNECT_DELAY", "reconnect_delay", &c.ReconnectDelay},
+		{EnvPrefix + "MAX_RECONNECT_DELAY", "max_reconnect_delay", &c.MaxReconnectDelay},
+		{EnvPrefix + "KEEPALIVE_TIME", "keepalive_time", &c.KeepaliveTime},
+		{EnvPrefix + "KEEPALIVE_TIMEOUT", "keepalive_timeout", &c.KeepaliveTimeout},
+		{EnvPrefix + "GRPC_COMPRESSION", "grpc_compression", &c.GRPCCompression},
+		{EnvPrefix + "HEARTBEAT_INTERVAL", "heartbeat_interval", &c.HeartbeatInterval},
+		{EnvPrefix + "MAX_REGISTRATION_ATTEMPTS", "max_registration_attempts", &c.MaxRegistrationAttempts},
+		{EnvPrefix + "DIAL_BLOCKING", "dial_blocking", &c.DialBlocking},
+		{EnvPrefix + "HOSTS_FILE_PATH", "hosts_file_path", &c.HostsFilePath},
+		{EnvPrefix + "BLOCKED_IP_REDIRECT", "blocked_ip_redirect", &c.BlockedIPRedirect},
+		{EnvPrefix + "URL_BLOCK_METHOD", "url_block_method", &c.URLBlockMethod},
+		{EnvPrefix + "BLOCK_TTL_HOURS", "block_ttl_hours", &c.BlockTTLHours},
+		{EnvPrefix + "BLOCK_SAVE_DELAY_MS", "block_save_delay_ms", &c.BlockSaveDelayMs},
+		{EnvPrefix + "TAMPER_PROTECTION", "tamper_protection", &c.TamperProtection},
+		{EnvPrefix + "SYSMON_MAX_EVENTS_PER_SCAN", "sysmon_max_events_per_scan", &c.SysmonMaxEventsPerScan},
+		{EnvPrefix + "SCAN_THROTTLE_PERCENT", "scan_throttle_percent", &c.ScanThrottlePercent},
+		{EnvPrefix + "SCAN_WORKERS", "scan_workers", &c.ScanWorkers},
+		{EnvPrefix + "MAX_HASH_FILE_BYTES", "max_hash_file_bytes", &c.MaxHashFileBytes},
+		{EnvPrefix + "MEMORY_SCAN_MAX_SIZE", "memory_scan_max_size", &c.MemoryScanMaxSize},
+		{EnvPrefix + "COMMAND_DEDUP_RETENTION", "command_dedup_retention", &c.CommandDedupRetention},
+		{EnvPrefix + "COMMAND_TIMEOUT", "command_timeout", &c.CommandTimeout},
+		{EnvPrefix + "COMMAND_DRAIN_TIMEOUT", "command_drain_timeout", &c.CommandDrainTimeout},
+		{EnvPrefix + "MAX_CONCURRENT_COMMANDS", "max_concurrent_commands", &c.MaxConcurrentCommands},
+		{EnvPrefix + "COLLECT_BEFORE_DELETE", "collect_before_delete", &c.CollectBeforeDelete},
+		{EnvPrefix + "MAX_UPLOAD_SIZE", "max_upload_size", &c.MaxUploadSize},
+		{EnvPrefix + "MODE", "mode", &c.Mode},
+		{EnvPrefix + "RESPONSE_SCRIPT_TIMEOUT", "response_script_timeout", &c.ResponseScriptTimeout},
+		{EnvPrefix + "ENRICHMENT_URL", "enrichment_url", &c.EnrichmentURL},
+		{EnvPrefix + "ENRICHMENT_TIMEOUT", "enrichment_timeout", &c.EnrichmentTimeout},
+		{EnvPrefix + "REPORT_DEDUP_WINDOW", "report_dedup_window", &c.ReportDedupWindow},
+		{EnvPrefix + "NOTIFY_USER", "notify_user", &c.NotifyUser},
+		{EnvPrefix + "NOTIFY_USER_MESSAGE", "notify_user_message", &c.NotifyUserMessage},
+		{EnvPrefix + "ISOLATION_MAX_DURATION", "isolation_max_duration", &c.IsolationMaxDuration},
+		{EnvPrefix + "HEALTH_PORT", "health_port", &c.HealthPort},
+		{EnvPrefix + "LOCAL_CONTROL", "local_control", &c.LocalControl},
+	}
+}
+
+// ApplyEnv applies EDR_-prefixed environment variable overrides. It is applied
+// after the YAML file and before command-line flags. Malformed boolean or
+// integer values are reported as errors rather than silently ignored.
+func (c *Config) ApplyEnv() error {
+	for _, b := range c.envBindings() {
+		value, ok := os.LookupEnv(b.env)
+		if !ok {
+			continue
+		}
+		
+		if err := setFromString(b.target, value); err != nil {
+			return fmt.Errorf("invalid value for %s (%q): %v", b.env, value, err)
+		}
+	}
+	
+	return nil
+}
+
+// setFromString parses value into a *string, *bool, *int or *int64 field
+func setFromString(target interface{}, value string) error {
+	switch target := target.(type) {
+	case *string:
+		*target = value
+	case *bool:
+		v, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("expected true or false")
+		}
+		*target = v
+	case *int:
+		v, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		*target = v
+	case *int64:
+		v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return fmt.Errorf("expected an integer")
+		}
+		*target = v
+	}
+	return nil
+}
+
+// envOverridesComment documents the environment variable overrides for the generated YAML file
+func (c *Config) envOverridesComment() string {
+	var sb strings.Builder
+	sb.WriteString("# Environment Variable Overrides:\n")
+	sb.WriteString("# Precedence is: command-line flags > environment variables > this file > defaults\n")
+	for _, b := range c.envBindings() {
+		sb.WriteString(fmt.Sprintf("# - %-26s -> %s\n", b.env, b.key))
+	}
+	return sb.String()
+}
+
 // ApplyFlags applies command-line flag values with highest precedence
 func (c *Config) ApplyFlags(flags map[string]interface{}) error {
+	c.flagOverrides = flags
+	c.applyFlagValues(flags)
+	
+	// Validate after applying flags
+	return c.Validate()
+}
+
+// applyFlagValues copies flag override values onto the configuration
+func (c *Config) applyFlagValues(flags map[string]interface{}) {
 	for key, value := range flags {
 		if value == nil {
 			continue
@@ -221,9 +615,119 @@ func (c *Config) ApplyFlags(flags map[string]interface{}) error {
 			}
 		}
 	}
+}
+
+// Reload re-reads the YAML file, re-applies command-line overrides and
+// validates the result. Fields that can be changed on a running agent are
+// published as the new Current configuration; changes to any other field are
+// logged as requiring a restart and left untouched. On error nothing changes.
+func (c *Config) Reload(path string) error {
+	fresh := NewDefaultConfig()
+	fresh.ConfigFile = path
 	
-	// Validate after applying flags
-	return c.Validate()
+	if err := fresh.loadFromYAML(path); err != nil {
+		return fmt.Errorf("failed to load config from %s: %v", path, err)
+	}
+	if err := fresh.ApplyEnv(); err != nil {
+		return err
+	}
+	fresh.applyFlagValues(c.flagOverrides)
+	
+	if err := fresh.Validate(); err != nil {
+		return fmt.Errorf("configuration validation failed: %v", err)
+	}
+	
+	// Fields that are only read at startup
+	restartRequired := []struct {
+		field    string
+		old, new interface{}
+	}{
+		{"server_address", c.ServerAddress, fresh.ServerAddress},
+		{"use_tls", c.UseTLS, fresh.UseTLS},
+		{"ca_cert_path", c.CACertPath, fresh.CACertPath},
+		{"insecure_skip_verify", c.InsecureSkipVerify, fresh.InsecureSkipVerify},
+		{"tls_server_name", c.TLSServerName, fresh.TLSServerName},
+		{"proxy_url", c.ProxyURL, fresh.ProxyURL},
+		{"proxy_username", c.ProxyUsername, fresh.ProxyUsername},
+		{"ioc_signing_pubkey_path", c.IOCSigningPubkeyPath, fresh.IOCSigningPubkeyPath},
+		{"ioc_feed_dir", c.IOCFeedDir, fresh.IOCFeedDir},
+		{"agent_id", c.AgentID, fresh.AgentID},
+		{"log_file", c.LogFile, fresh.LogFile},
+		{"data_dir", c.DataDir, fresh.DataDir},
+		{"log_format", c.LogFormat, fresh.LogFormat},
+		{"log_max_size_mb", c.LogMaxSizeMB, fresh.LogMaxSizeMB},
+		{"log_max_backups", c.LogMaxBackups, fresh.LogMaxBackups},
+		{"log_max_age_days", c.LogMaxAgeDays, fresh.LogMaxAgeDays},
+		{"connection_timeout", c.ConnectionTimeout, fresh.ConnectionTimeout},
+		{"max_concurrent_commands", c.MaxConcurrentCommands, fresh.MaxConcurrentCommands},
+		{"enrichment_url", c.EnrichmentURL, fresh.EnrichmentURL},
+		{"keepalive_time", c.KeepaliveTime, fresh.KeepaliveTime},
+		{"keepalive_timeout", c.KeepaliveTimeout, fresh.KeepaliveTimeout},
+		{"max_registration_attempts", c.MaxRegistrationAttempts, fresh.MaxRegistrationAttempts},
+		{"dial_blocking", c.DialBlocking, fresh.DialBlocking},
+		{"grpc_compression", c.GRPCCompression, fresh.GRPCCompression},
+		{"hosts_file_path", c.HostsFilePath, fresh.HostsFilePath},
+		{"url_block_method", c.URLBlockMethod, fresh.URLBlockMethod},
+		{"command_dedup_retention", c.CommandDedupRetention, fresh.CommandDedupRetention},
+		{"tamper_protection", c.TamperProtection, fresh.TamperProtection},
+		{"health_port", c.HealthPort, fresh.HealthPort},
+		{"local_control", c.LocalControl, fresh.LocalControl},
+		{"watch_paths", c.WatchPaths, fresh.WatchPaths},
+	}
+	for _, f := range restartRequired {
+		if fmt.Sprint(f.old) != fmt.Sprint(f.new) {
+			log.Printf("Config reload: %s changed from %v to %v, restart required to apply", f.field, f.old, f.new)
+		}
+	}
+	if c.ProxyPassword != fresh.ProxyPassword {
+		log.Printf("Config reload: proxy_password changed, restart required to apply")
+	}
+	
+	// Fields that take effect on a running agent
+	c.Update(func(next *Config) {
+		next.ScanInterval = fresh.ScanInterval
+		next.IPURLScanInterval = fresh.IPURLScanInterval
+		next.FileScanInterval = fresh.FileScanInterval
+		next.AllowRemoteServerChange = fresh.AllowRemoteServerChange
+		next.LogLevel = fresh.LogLevel
+		next.ScanSchedule = fresh.ScanSchedule
+		next.MetricsInterval = fresh.MetricsInterval
+		next.ReconnectDelay = fresh.ReconnectDelay
+		next.MaxReconnectDelay = fresh.MaxReconnectDelay
+		next.HeartbeatInterval = fresh.HeartbeatInterval
+		next.IOCUpdateDelay = fresh.IOCUpdateDelay
+		next.ShutdownTimeout = fresh.ShutdownTimeout
+		next.CPUSampleDuration = fresh.CPUSampleDuration
+		next.BlockedIPRedirect = fresh.BlockedIPRedirect
+		next.BlockTTLHours = fresh.BlockTTLHours
+		next.BlockSaveDelayMs = fresh.BlockSaveDelayMs
+		next.ScanExclusions = fresh.ScanExclusions
+		next.AllowedCommands = fresh.AllowedCommands
+		next.LogRedact = fresh.LogRedact
+		next.CommandTimeout = fresh.CommandTimeout
+		next.CommandDrainTimeout = fresh.CommandDrainTimeout
+		next.ScanThrottlePercent = fresh.ScanThrottlePercent
+		next.ScanWorkers = fresh.ScanWorkers
+		next.MaxHashFileBytes = fresh.MaxHashFileBytes
+		next.FIMPaths = fresh.FIMPaths
+		next.MemoryScanMaxSize = fresh.MemoryScanMaxSize
+		next.SysmonMaxEventsPerScan = fresh.SysmonMaxEventsPerScan
+		next.CollectBeforeDelete = fresh.CollectBeforeDelete
+		next.MaxUploadSize = fresh.MaxUploadSize
+		next.Mode = fresh.Mode
+		next.RemediationPolicy = fresh.RemediationPolicy
+		next.ProtectedPaths = fresh.ProtectedPaths
+		next.ResponseScripts = fresh.ResponseScripts
+		next.ResponseScriptTimeout = fresh.ResponseScriptTimeout
+		next.EnrichmentTimeout = fresh.EnrichmentTimeout
+		next.ReportDedupWindow = fresh.ReportDedupWindow
+		next.NotifyUser = fresh.NotifyUser
+		next.NotifyUserMessage = fresh.NotifyUserMessage
+		next.ProcessRules = fresh.ProcessRules
+		next.IsolationMaxDuration = fresh.IsolationMaxDuration
+	})
+	
+	return nil
 }
 
 // Validate validates all configuration values
@@ -247,6 +751,31 @@ func (c *Config) Validate() error {
 		}
 	}
 	
+	// Validate log rotation
+	if c.LogMaxSizeMB < 1 {
+		errors = append(errors, ValidationError{
+			Field:   "log_max_size_mb",
+			Value:   c.LogMaxSizeMB,
+			Message: "must be at least 1",
+		})
+	}
+	
+	if c.LogMaxBackups < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "log_max_backups",
+			Value:   c.LogMaxBackups,
+			Message: "cannot be negative (use 0 to keep all rotated files)",
+		})
+	}
+	
+	if c.LogMaxAgeDays < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "log_max_age_days",
+			Value:   c.LogMaxAgeDays,
+			Message: "cannot be negative (use 0 to disable the age limit)",
+		})
+	}
+	
 	// Validate intervals
 	if c.ScanInterval < MinScanInterval || c.ScanInterval > MaxScanInterval {
 		errors = append(errors, ValidationError{
@@ -255,6 +784,29 @@ func (c *Config) Validate() error {
 			Message: fmt.Sprintf("must be between %d and %d minutes", MinScanInterval, MaxScanInterval),
 		})
 	}
+	for _, f := range []struct {
+		field string
+		value int
+	}{
+		{"ip_url_scan_interval", c.IPURLScanInterval},
+		{"file_scan_interval", c.FileScanInterval},
+	} {
+		if f.value != 0 && (f.value < MinScanInterval || f.value > MaxScanInterval) {
+			errors = append(errors, ValidationError{
+				Field:   f.field,
+				Value:   f.value,
+				Message: fmt.Sprintf("must be 0 (use scan_interval) or between %d and %d minutes", MinScanInterval, MaxScanInterval),
+			})
+		}
+	}
+	
+	if _, err := ParseScanSchedule(c.ScanSchedule); err != nil {
+		errors = append(errors, ValidationError{
+			Field:   "scan_schedule",
+			Value:   c.ScanSchedule,
+			Message: err.Error(),
+		})
+	}
 	
 	if c.MetricsInterval < MinMetricsInterval || c.MetricsInterval > MaxMetricsInterval {
 		errors = append(errors, ValidationError{
@@ -290,6 +842,49 @@ func (c *Config) Validate() error {
 		})
 	}
 	
+	// Validate keepalive settings
+	if c.KeepaliveTime < MinKeepaliveTime {
+		errors = append(errors, ValidationError{
+			Field:   "keepalive_time",
+			Value:   c.KeepaliveTime,
+			Message: fmt.Sprintf("must be at least %d seconds", MinKeepaliveTime),
+		})
+	}
+	
+	if c.KeepaliveTimeout <= 0 {
+		errors = append(errors, ValidationError{
+			Field:   "keepalive_timeout",
+			Value:   c.KeepaliveTimeout,
+			Message: "must be greater than 0",
+		})
+	}
+	
+	// Validate heartbeat interval
+	if c.HeartbeatInterval < MinHeartbeatInterval || c.HeartbeatInterval > MaxHeartbeatInterval {
+		errors = append(errors, ValidationError{
+			Field:   "heartbeat_interval",
+			Value:   c.HeartbeatInterval,
+			Message: fmt.Sprintf("must be between %d and %d seconds", MinHeartbeatInterval, MaxHeartbeatInterval),
+		})
+	}
+	
+	if c.MaxRegistrationAttempts < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "max_registration_attempts",
+			Value:   c.MaxRegistrationAttempts,
+			Message: "cannot be negative (use 0 to retry until registration succeeds)",
+		})
+	}
+	
+	// Validate health server port
+	if c.HealthPort < 0 || c.HealthPort > 65535 {
+		errors = append(errors, ValidationError{
+			Field:   "health_port",
+			Value:   c.HealthPort,
+			Message: "must be between 0 and 65535 (use 0 to disable the health server)",
+		})
+	}
+	
 	// Validate data directory
 	if c.DataDir == "" {
 		errors = append(errors, ValidationError{
@@ -317,6 +912,278 @@ func (c *Config) Validate() error {
 		})
 	}
 	
+	// Validate URL blocking method
+	if c.URLBlockMethod != URLBlockHosts && c.URLBlockMethod != URLBlockDNS {
+		errors = append(errors, ValidationError{
+			Field:   "url_block_method",
+			Value:   c.URLBlockMethod,
+			Message: fmt.Sprintf("must be %s or %s", URLBlockHosts, URLBlockDNS),
+		})
+	}
+	
+	// Validate block TTL
+	if c.BlockTTLHours < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "block_ttl_hours",
+			Value:   c.BlockTTLHours,
+			Message: "cannot be negative (use 0 to disable expiry)",
+		})
+	}
+	
+	// Validate block save delay
+	if c.BlockSaveDelayMs < 0 || c.BlockSaveDelayMs > MaxBlockSaveDelayMs {
+		errors = append(errors, ValidationError{
+			Field:   "block_save_delay_ms",
+			Value:   c.BlockSaveDelayMs,
+			Message: fmt.Sprintf("must be between 0 and %d milliseconds", MaxBlockSaveDelayMs),
+		})
+	}
+	
+	// Validate scan throttle
+	if c.ScanThrottlePercent < 0 || c.ScanThrottlePercent > MaxScanThrottlePercent {
+		errors = append(errors, ValidationError{
+			Field:   "scan_throttle_percent",
+			Value:   c.ScanThrottlePercent,
+			Message: fmt.Sprintf("must be between 0 and %d (use 0 to disable throttling)", MaxScanThrottlePercent),
+		})
+	}
+	
+	// Validate scan worker count
+	if c.ScanWorkers < 1 || c.ScanWorkers > MaxScanWorkers {
+		errors = append(errors, ValidationError{
+			Field:   "scan_workers",
+			Value:   c.ScanWorkers,
+			Message: fmt.Sprintf("must be between 1 and %d", MaxScanWorkers),
+		})
+	}
+	
+	// Validate hashing size limit
+	if c.MaxHashFileBytes < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "max_hash_file_bytes",
+			Value:   c.MaxHashFileBytes,
+			Message: "cannot be negative (use 0 for no limit)",
+		})
+	}
+	
+	// Validate Sysmon batch size
+	if c.SysmonMaxEventsPerScan < 1 || c.SysmonMaxEventsPerScan > MaxSysmonEventsPerScan {
+		errors = append(errors, ValidationError{
+			Field:   "sysmon_max_events_per_scan",
+			Value:   c.SysmonMaxEventsPerScan,
+			Message: fmt.Sprintf("must be between 1 and %d", MaxSysmonEventsPerScan),
+		})
+	}
+	
+	// Validate file integrity monitoring paths
+	for _, path := range c.FIMPaths {
+		if path == "" || !filepath.IsAbs(path) {
+			errors = append(errors, ValidationError{
+				Field:   "fim_paths",
+				Value:   path,
+				Message: "must be an absolute path",
+			})
+		}
+	}
+	
+	// Validate watched directories
+	for _, path := range c.WatchPaths {
+		if path == "" || !filepath.IsAbs(path) {
+			errors = append(errors, ValidationError{
+				Field:   "watch_paths",
+				Value:   path,
+				Message: "entries must be absolute directory paths",
+			})
+		}
+	}
+	
+	// Validate agent mode
+	if c.Mode != ModeProtect && c.Mode != ModeMonitor {
+		errors = append(errors, ValidationError{
+			Field:   "mode",
+			Value:   c.Mode,
+			Message: fmt.Sprintf("must be %s or %s", ModeProtect, ModeMonitor),
+		})
+	}
+	
+	// Validate remediation policy
+	for severity, action := range c.RemediationPolicy {
+		if !containsString(RemediationSeverities, severity) {
+			errors = append(errors, ValidationError{
+				Field:   "remediation_policy",
+				Value:   severity,
+				Message: fmt.Sprintf("unknown severity, must be one of: %s", strings.Join(RemediationSeverities, ", ")),
+			})
+		}
+		switch action {
+		case RemediationReportOnly, RemediationQuarantine, RemediationDelete, RemediationKillAndDelete:
+		default:
+			errors = append(errors, ValidationError{
+				Field:   "remediation_policy." + severity,
+				Value:   action,
+				Message: fmt.Sprintf("must be one of: %s, %s, %s, %s",
+					RemediationReportOnly, RemediationQuarantine, RemediationDelete, RemediationKillAndDelete),
+			})
+		}
+	}
+	
+	// Validate protected paths
+	errors = append(errors, validateProtectedPaths(c.ProtectedPaths)...)
+	
+	// Validate response scripts
+	errors = append(errors, validateResponseScripts(c.ResponseScripts)...)
+	if c.ResponseScriptTimeout < 1 || c.ResponseScriptTimeout > MaxResponseScriptTimeout {
+		errors = append(errors, ValidationError{
+			Field:   "response_script_timeout",
+			Value:   c.ResponseScriptTimeout,
+			Message: fmt.Sprintf("must be between 1 and %d seconds", MaxResponseScriptTimeout),
+		})
+	}
+	
+	// Validate IOC match enrichment
+	if c.EnrichmentURL != "" {
+		if u, err := url.Parse(c.EnrichmentURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errors = append(errors, ValidationError{
+				Field:   "enrichment_url",
+				Value:   c.EnrichmentURL,
+				Message: "must be an http or https URL such as https://intel.example.com/lookup",
+			})
+		}
+	}
+	if c.EnrichmentTimeout < MinEnrichmentTimeout || c.EnrichmentTimeout > MaxEnrichmentTimeout {
+		errors = append(errors, ValidationError{
+			Field:   "enrichment_timeout",
+			Value:   c.EnrichmentTimeout,
+			Message: fmt.Sprintf("must be between %d and %d milliseconds", MinEnrichmentTimeout, MaxEnrichmentTimeout),
+		})
+	}
+	if c.ReportDedupWindow < 0 || c.ReportDedupWindow > MaxReportDedupWindow {
+		errors = append(errors, ValidationError{
+			Field:   "report_dedup_window",
+			Value:   c.ReportDedupWindow,
+			Message: fmt.Sprintf("must be between 0 and %d minutes", MaxReportDedupWindow),
+		})
+	}
+	
+	// Validate user notification text
+	if strings.TrimSpace(c.NotifyUserMessage) == "" || len(c.NotifyUserMessage) > MaxNotifyUserMessage {
+		errors = append(errors, ValidationError{
+			Field:   "notify_user_message",
+			Value:   c.NotifyUserMessage,
+			Message: fmt.Sprintf("must be between 1 and %d characters", MaxNotifyUserMessage),
+		})
+	}
+	
+	// Validate process rules
+	errors = append(errors, validateProcessRules(c.ProcessRules)...)
+	
+	// Validate redacted parameter names
+	for _, key := range c.LogRedact {
+		if strings.TrimSpace(key) == "" {
+			errors = append(errors, ValidationError{
+				Field:   "log_redact",
+				Value:   key,
+				Message: "parameter name cannot be empty",
+			})
+		}
+	}
+	
+	// Validate allowed command types
+	for _, name := range c.AllowedCommands {
+		if _, ok := pb.CommandType_value[strings.ToUpper(strings.TrimSpace(name))]; !ok {
+			errors = append(errors, ValidationError{
+				Field:   "allowed_commands",
+				Value:   name,
+				Message: "unknown command type",
+			})
+		}
+	}
+	
+	// Validate command de-duplication window
+	if c.CommandDedupRetention < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "command_dedup_retention",
+			Value:   c.CommandDedupRetention,
+			Message: "cannot be negative (use 0 to disable de-duplication)",
+		})
+	}
+	
+	// Validate command timeout
+	if c.CommandTimeout < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "command_timeout",
+			Value:   c.CommandTimeout,
+			Message: "cannot be negative (use 0 for no limit)",
+		})
+	}
+	
+	// Validate shutdown command drain
+	if c.CommandDrainTimeout < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "command_drain_timeout",
+			Value:   c.CommandDrainTimeout,
+			Message: "cannot be negative (use 0 to not wait for running commands)",
+		})
+	}
+	
+	// Validate concurrent command limit
+	if c.MaxConcurrentCommands < 1 || c.MaxConcurrentCommands > MaxConcurrentCommandsLimit {
+		errors = append(errors, ValidationError{
+			Field:   "max_concurrent_commands",
+			Value:   c.MaxConcurrentCommands,
+			Message: fmt.Sprintf("must be between 1 and %d", MaxConcurrentCommandsLimit),
+		})
+	}
+	
+	// Validate memory scan size limit
+	if c.MemoryScanMaxSize < 1 || c.MemoryScanMaxSize > MaxMemoryScanSize {
+		errors = append(errors, ValidationError{
+			Field:   "memory_scan_max_size",
+			Value:   c.MemoryScanMaxSize,
+			Message: fmt.Sprintf("must be between 1 and %d megabytes", MaxMemoryScanSize),
+		})
+	}
+	
+	// Validate upload size limit
+	if c.MaxUploadSize < 1 || c.MaxUploadSize > MaxUploadSizeLimit {
+		errors = append(errors, ValidationError{
+			Field:   "max_upload_size",
+			Value:   c.MaxUploadSize,
+			Message: fmt.Sprintf("must be between 1 and %d megabytes", MaxUploadSizeLimit),
+		})
+	}
+	
+	// Validate isolation watchdog
+	if c.IsolationMaxDuration < 0 {
+		errors = append(errors, ValidationError{
+			Field:   "isolation_max_duration",
+			Value:   c.IsolationMaxDuration,
+			Message: "cannot be negative (use 0 to disable auto-restore)",
+		})
+	}
+	
+	// Validate IOC signing key path if specified
+	if c.IOCSigningPubkeyPath != "" {
+		if _, err := os.Stat(c.IOCSigningPubkeyPath); os.IsNotExist(err) {
+			errors = append(errors, ValidationError{
+				Field:   "ioc_signing_pubkey_path",
+				Value:   c.IOCSigningPubkeyPath,
+				Message: "IOC signing key file does not exist",
+			})
+		}
+	}
+	
+	// Validate IOC feed directory if specified
+	if c.IOCFeedDir != "" {
+		if info, err := os.Stat(c.IOCFeedDir); err != nil || !info.IsDir() {
+			errors = append(errors, ValidationError{
+				Field:   "ioc_feed_dir",
+				Value:   c.IOCFeedDir,
+				Message: "IOC feed directory does not exist",
+			})
+		}
+	}
+	
 	// Validate CA certificate path if TLS is enabled and path is specified
 	if c.UseTLS && c.CACertPath != "" {
 		if _, err := os.Stat(c.CACertPath); os.IsNotExist(err) {
@@ -328,6 +1195,33 @@ func (c *Config) Validate() error {
 		}
 	}
 	
+	// Validate the TLS server name override
+	if c.TLSServerName != "" && !validHostname(c.TLSServerName) {
+		errors = append(errors, ValidationError{
+			Field:   "tls_server_name",
+			Value:   c.TLSServerName,
+			Message: "must be a hostname such as edr.example.com or an IP address",
+		})
+	}
+	
+	// Validate the proxy URL
+	if c.ProxyURL != "" {
+		if msg := validateProxyURL(c.ProxyURL); msg != "" {
+			errors = append(errors, ValidationError{
+				Field:   "proxy_url",
+				Value:   c.ProxyURL,
+				Message: msg,
+			})
+		}
+	}
+	if c.ProxyPassword != "" && c.ProxyUsername == "" {
+		errors = append(errors, ValidationError{
+			Field:   "proxy_password",
+			Value:   "***",
+			Message: "requires proxy_username",
+		})
+	}
+	
 	// Return first error if any
 	if len(errors) > 0 {
 		return errors[0]
@@ -362,7 +1256,61 @@ func (c *Config) validateServerAddress() error {
 	return nil
 }
 
-// SaveConfig saves configuration to a YAML file with helpful comments
+// validHostname reports whether name can be a certificate's DNS name or IP
+// address: dot-separated labels of letters, digits and inner hyphens, at most
+// 63 characters each and 253 in total
+// validateProxyURL checks that proxy_url is an http or socks5 URL with a host
+// and port, returning a description of the problem or "" if it is valid.
+// Credentials are kept out of the URL so it can be logged safely.
+func validateProxyURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "must be a URL such as http://proxy.example.com:3128 or socks5://proxy.example.com:1080"
+	}
+	if u.Scheme != "http" && u.Scheme != "socks5" {
+		return "scheme must be http or socks5"
+	}
+	if u.User != nil {
+		return "must not contain credentials, use proxy_username and proxy_password"
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil || port == "" {
+		return "must include the proxy port, e.g. http://proxy.example.com:3128"
+	}
+	if !validHostname(host) {
+		return "proxy host must be a hostname or an IP address"
+	}
+	if u.Path != "" && u.Path != "/" {
+		return "must not contain a path"
+	}
+	return ""
+}
+
+func validHostname(name string) bool {
+	if net.ParseIP(name) != nil {
+		return true
+	}
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SaveConfig saves configuration to a YAML file with helpful comments. The
+// file is replaced atomically and readable only by its owner, since it can
+// hold proxy_password. To change a running agent's file use UpdateFile, which
+// leaves environment and flag overrides out of it.
 func (c *Config) SaveConfig(filename string) error {
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(filename)
@@ -374,13 +1322,33 @@ func (c *Config) SaveConfig(filename string) error {
 	yamlContent := c.generateYAMLWithComments()
 	
 	// Write to file
-	if err := os.WriteFile(filename, []byte(yamlContent), 0644); err != nil {
+	if err := persist.WriteFileAtomic(filename, []byte(yamlContent), 0600); err != nil {
 		return fmt.Errorf("failed to write config file: %v", err)
 	}
+	if err := os.Chmod(filename, 0600); err != nil {
+		return fmt.Errorf("failed to restrict config file permissions: %v", err)
+	}
 	
 	return nil
 }
 
+// UpdateFile changes the configuration file at path: the file is loaded as
+// written, without environment variables or flags, passed to update and
+// saved unless update returns an error. Overrides such as EDR_PROXY_PASSWORD
+// therefore never end up in the file.
+func UpdateFile(path string, update func(file *Config) error) error {
+	file := NewDefaultConfig()
+	file.ConfigFile = path
+	if err := file.loadFromYAML(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to load config from %s: %v", path, err)
+	}
+	
+	if err := update(file); err != nil {
+		return err
+	}
+	return file.SaveConfig(path)
+}
+
 // generateYAMLWithComments creates a YAML string with helpful comments
 func (c *Config) generateYAMLWithComments() string {
 	return fmt.Sprintf(`# EDR Agent Configuration File
@@ -390,25 +1358,43 @@ func (c *Config) generateYAMLWithComments() string {
 # Server Configuration
 server_address: "%s"  # EDR server address (host:port)
 use_tls: %t                      # Enable TLS encryption for server communication
+allow_remote_server_change: %t   # Let the server change server_address and the TLS settings with UPDATE_CONFIG
 
 # TLS/Certificate Configuration (only applies when use_tls is true)
-ca_cert_path: "%s"               # Path to CA certificate for server verification (leave empty to use system CA)
+ca_cert_path: %s               # Path to CA certificate for server verification (leave empty to use system CA)
 insecure_skip_verify: %t          # Skip certificate verification (not recommended for production)
+tls_server_name: %s            # Hostname to verify the server certificate against when dialing by IP or through a load balancer (empty = host of server_address)
+
+# Proxy Configuration
+proxy_url: %s                  # Reach the server through an http:// (CONNECT) or socks5:// proxy, e.g. "http://proxy.example.com:3128" (empty = direct)
+proxy_username: %s             # Proxy username (leave empty if the proxy needs no authentication)
+proxy_password: %s             # Proxy password
+
+# IOC Update Signing
+ioc_signing_pubkey_path: %s    # Ed25519 public key (PEM) IOC updates must be signed with (empty = accept unsigned updates)
+ioc_feed_dir: %s               # Directory of CSV and STIX feed files imported as IOCs (empty = disabled)
 
 # Agent Identification
 agent_id: "%s"                       # Agent ID (leave empty for auto-generation)
 agent_version: "%s"            # Agent version
 
 # File Paths
-log_file: "%s"                       # Log file path (leave empty for console output)
-data_dir: "%s"                   # Directory for agent data storage
+log_file: %s                       # Log file path (leave empty for console output)
+data_dir: %s                   # Directory for agent data storage
 
 # Logging Configuration
 log_level: "%s"                  # Log level: debug, info, warn, error
 log_format: "%s"              # Log format: console, json
+log_max_size_mb: %d              # Rotate the log file when it reaches this size (megabytes)
+log_max_backups: %d                # Rotated log files to keep (0 = keep all)
+log_max_age_days: %d              # Delete rotated log files older than this many days (0 = never)
+log_redact: %s                   # Command parameters masked in logs, e.g. ['path', 'user'] or ['*'] for all (empty = off)
 
 # Timing Configuration (in minutes)
 scan_interval: %d                   # IOC scan interval
+ip_url_scan_interval: %d            # Interval for blocking new IP and URL IOCs (0 = scan_interval)
+file_scan_interval: %d              # Interval for Sysmon file hash and FIM scans (0 = scan_interval)
+scan_schedule: %s                  # Limit scheduled file scans to HH:MM-HH:MM windows, e.g. "22:00-06:00" (empty = any time)
 metrics_interval: %d               # System metrics reporting interval
 
 # Connection Configuration (in seconds)
@@ -417,81 +1403,403 @@ reconnect_delay: %d                 # Delay between reconnection attempts
 max_reconnect_delay: %d            # Maximum reconnection delay
 ioc_update_delay: %d                # Delay before requesting IOC updates
 shutdown_timeout: %d              # Shutdown timeout (milliseconds)
+keepalive_time: %d                 # Idle time before sending a keepalive ping (minimum 10)
+keepalive_timeout: %d              # Time to wait for a keepalive ack before reconnecting
+grpc_compression: %t               # Gzip-compress messages sent to the server (the server may compress IOC updates either way)
+heartbeat_interval: %d             # Time between liveness heartbeats sent to the server
+max_registration_attempts: %d       # Registration attempts at startup before giving up (0 = retry forever)
+dial_blocking: %t                 # Wait up to connection_timeout for the connection to the server before registering
 
 # System Monitoring Configuration
 cpu_sample_duration: %d           # CPU sampling duration (milliseconds)
+health_port: %d                   # Serve /healthz, /metrics and /version on 127.0.0.1 (0 = disabled)
+local_control: %t                 # Accept agentctl commands on a local socket (named pipe on Windows) only administrators can open
 
 # Windows-specific Configuration
-hosts_file_path: "%s"
+hosts_file_path: %s
 blocked_ip_redirect: "%s"   # IP address to redirect blocked domains to
+url_block_method: %s              # hosts (exact domains in the hosts file) or dns (domains and subdomains via a Windows NRPT policy)
+block_ttl_hours: %d                 # Remove IOC blocks whose IOC was retired after this many hours (0 = never)
+block_save_delay_ms: %d          # Block list changes are collected this long before blocked_items.json is written
+tamper_protection: %v           # Allow only SYSTEM to modify or delete the agent binary, config and data directory
+sysmon_max_events_per_scan: %d    # Sysmon events read per batch; scans page through all new events
+
+# Directory Scan Configuration
+scan_exclusions: %s  # Glob patterns skipped by on-demand SCAN_PATH scans
+scan_throttle_percent: %d            # Keep the agent's CPU share below this percent while hashing files (0 = unthrottled)
+scan_workers: %d                     # Files hashed in parallel by SCAN_PATH
+max_hash_file_bytes: %d        # Files larger than this are only hashed by SCAN_PATH (0 = no limit)
+watch_paths: %s                      # Directories whose new and modified files are scanned immediately
+fim_paths: %s                        # Files and directories whose changes from a baseline are reported every scan interval
+memory_scan_max_size: %d             # Memory read from each process by SCAN_MEMORY (megabytes)
+
+# Command Handling Configuration
+command_dedup_retention: %d        # Minutes to remember executed command IDs so re-sent commands are not run twice (0 = disabled)
+allowed_commands: %s             # Command types this agent will run, e.g. ['BLOCK_IP', 'KILL_PROCESS'] (empty = all)
+command_timeout: %d                # Seconds a command may run before it is failed as timed out (0 = no limit)
+command_drain_timeout: %d           # Seconds shutdown waits for running commands to finish (0 = do not wait)
+max_concurrent_commands: %d          # Server commands executing at once; more wait in a queue of 64, then are refused as busy
+
+# File Collection Configuration
+collect_before_delete: %v       # Upload malicious files to the server before deleting them
+max_upload_size: %d               # Largest file uploaded to the server (megabytes)
+
+# Remediation Configuration
+mode: %s                     # protect, or monitor to detect and report without blocking, deleting or killing
+# Action per IOC severity: report_only, quarantine, delete or kill_and_delete
+remediation_policy: %s
+protected_paths: %s  # Never deleted or quarantined whatever the policy; a directory protects everything below it
+
+# Response Script Configuration
+# Scripts run after the built-in action, keyed by severity or IOC type (ip,
+# hash, url, yara, behavior), e.g. {critical: 'C:\EDR\cleanup.ps1'}. They run
+# with the agent's privileges; see CONFIG.md before enabling.
+response_scripts: %s
+response_script_timeout: %d          # Seconds a response script may run before it is killed
+
+# IOC Match Enrichment Configuration
+enrichment_url: %s                 # Intel API asked for reputation context added to IOC match reports (empty = off)
+enrichment_timeout: %d             # Longest wait for an enrichment lookup (milliseconds)
+
+# IOC Match Report De-duplication Configuration
+report_dedup_window: %d              # Minutes during which repeat matches of the same IOC are counted instead of reported (0 = report every match)
+
+# User Notification Configuration
+notify_user: %v                  # Show a message to the logged-on user after a remediation action (Windows)
+notify_user_message: %s  # {action} is replaced by what was done, e.g. "a malicious file was quarantined"
+
+# Process Rule Configuration
+# Sysmon Event ID 1 parent/child pairs reported as behavioral detections, e.g.
+# [{name: office-shell, parent_image: 'winword.exe', child_image: 'powershell.exe', severity: high, kill: true}]
+process_rules: %s
+
+# Network Isolation Configuration
+isolation_max_duration: %d         # Minutes before isolation is lifted unless renewed by the server (0 = never)
 
 # Certificate Verification Notes:
 # - If ca_cert_path is specified, the agent will use this CA certificate to verify the server
 # - If ca_cert_path is empty, the agent will use the system's default CA certificates
 # - Setting insecure_skip_verify to true bypasses all certificate verification (not recommended)
 # - For production environments, always use proper CA certificates and keep insecure_skip_verify false
-`,
+
+%s`,
 		c.ServerAddress,
 		c.UseTLS,
-		c.CACertPath,
+		c.AllowRemoteServerChange,
+		yamlString(c.CACertPath),
 		c.InsecureSkipVerify,
+		yamlString(c.TLSServerName),
+		yamlString(c.ProxyURL),
+		yamlString(c.ProxyUsername),
+		yamlString(c.ProxyPassword),
+		yamlString(c.IOCSigningPubkeyPath),
+		yamlString(c.IOCFeedDir),
 		c.AgentID,
 		c.AgentVersion,
-		c.LogFile,
-		c.DataDir,
+		yamlString(c.LogFile),
+		yamlString(c.DataDir),
 		c.LogLevel,
 		c.LogFormat,
+		c.LogMaxSizeMB,
+		c.LogMaxBackups,
+		c.LogMaxAgeDays,
+		yamlStringList(c.LogRedact),
 		c.ScanInterval,
+		c.IPURLScanInterval,
+		c.FileScanInterval,
+		yamlString(c.ScanSchedule),
 		c.MetricsInterval,
 		c.ConnectionTimeout,
 		c.ReconnectDelay,
 		c.MaxReconnectDelay,
 		c.IOCUpdateDelay,
 		c.ShutdownTimeout,
+		c.KeepaliveTime,
+		c.KeepaliveTimeout,
+		c.GRPCCompression,
+		c.HeartbeatInterval,
+		c.MaxRegistrationAttempts,
+		c.DialBlocking,
 		c.CPUSampleDuration,
-		c.HostsFilePath,
+		c.HealthPort,
+		c.LocalControl,
+		yamlString(c.HostsFilePath),
 		c.BlockedIPRedirect,
+		yamlString(c.URLBlockMethod),
+		c.BlockTTLHours,
+		c.BlockSaveDelayMs,
+		c.TamperProtection,
+		c.SysmonMaxEventsPerScan,
+		yamlStringList(c.ScanExclusions),
+		c.ScanThrottlePercent,
+		c.ScanWorkers,
+		c.MaxHashFileBytes,
+		yamlStringList(c.WatchPaths),
+		yamlStringList(c.FIMPaths),
+		c.MemoryScanMaxSize,
+		c.CommandDedupRetention,
+		yamlStringList(c.AllowedCommands),
+		c.CommandTimeout,
+		c.CommandDrainTimeout,
+		c.MaxConcurrentCommands,
+		c.CollectBeforeDelete,
+		c.MaxUploadSize,
+		yamlString(c.Mode),
+		yamlPolicy(c.RemediationPolicy),
+		yamlStringList(c.ProtectedPaths),
+		yamlResponseScripts(c.ResponseScripts),
+		c.ResponseScriptTimeout,
+		yamlString(c.EnrichmentURL),
+		c.EnrichmentTimeout,
+		c.ReportDedupWindow,
+		c.NotifyUser,
+		yamlString(c.NotifyUserMessage),
+		yamlProcessRules(c.ProcessRules),
+		c.IsolationMaxDuration,
+		c.envOverridesComment(),
 	)
 }
 
+// defaultHostsFilePath returns the hosts file location for the running OS
+func defaultHostsFilePath() string {
+	if runtime.GOOS == "windows" {
+		return DefaultHostsFilePath
+	}
+	return DefaultUnixHostsFilePath
+}
+
+// defaultScanWorkers uses half the CPUs for directory scans so the host
+// stays responsive while a scan runs
+func defaultScanWorkers() int {
+	workers := runtime.NumCPU() / 2
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > MaxScanWorkers {
+		workers = MaxScanWorkers
+	}
+	return workers
+}
+
+// yamlString formats a string as a single-quoted YAML scalar so Windows paths
+// keep their backslashes verbatim (double quotes would treat them as escapes)
+func yamlString(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// yamlStringList formats a string slice as a YAML flow sequence. Single-quoted
+// scalars are used so Windows paths keep their backslashes verbatim.
+func yamlStringList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = yamlString(v)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// yamlPolicy formats the remediation policy as a YAML flow mapping in
+// severity order
+func yamlPolicy(policy map[string]string) string {
+	entries := make([]string, 0, len(policy))
+	for _, severity := range RemediationSeverities {
+		if action, ok := policy[severity]; ok {
+			entries = append(entries, severity+": "+action)
+		}
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+// copyStringMap returns a copy of m so defaults are never shared between configs
+func copyStringMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// CommandAllowed reports whether allowed_commands permits the command type
+// name. An empty list allows every command.
+func (c *Config) CommandAllowed(commandType string) bool {
+	current := c.Current()
+	if len(current.AllowedCommands) == 0 {
+		return true
+	}
+	for _, name := range current.AllowedCommands {
+		if strings.EqualFold(strings.TrimSpace(name), commandType) {
+			return true
+		}
+	}
+	return false
+}
+
+// MonitorOnly reports whether the agent is in monitor mode, where matches
+// are reported but nothing is remediated
+func (c *Config) MonitorOnly() bool {
+	return c.Current().Mode == ModeMonitor
+}
+
+// RemediationAction returns the remediation_policy action for an IOC
+// severity. Severities are matched case-insensitively; an unknown or empty
+// severity gets the "high" action. In monitor mode every severity is report
+// only.
+func (c *Config) RemediationAction(severity string) string {
+	current := c.Current()
+	if current.MonitorOnly() {
+		return RemediationReportOnly
+	}
+	policy := current.RemediationPolicy
+	if action, ok := policy[strings.ToLower(strings.TrimSpace(severity))]; ok {
+		return action
+	}
+	if action, ok := policy["high"]; ok {
+		return action
+	}
+	return RemediationDelete
+}
+
 // GetConnectionTimeoutDuration returns connection timeout as time.Duration
 func (c *Config) GetConnectionTimeoutDuration() time.Duration {
-	return time.Duration(c.ConnectionTimeout) * time.Second
+	return time.Duration(c.Current().ConnectionTimeout) * time.Second
 }
 
 // GetReconnectDelayDuration returns reconnect delay as time.Duration
 func (c *Config) GetReconnectDelayDuration() time.Duration {
-	return time.Duration(c.ReconnectDelay) * time.Second
+	return time.Duration(c.Current().ReconnectDelay) * time.Second
 }
 
 // GetMaxReconnectDelayDuration returns max reconnect delay as time.Duration
 func (c *Config) GetMaxReconnectDelayDuration() time.Duration {
-	return time.Duration(c.MaxReconnectDelay) * time.Second
+	return time.Duration(c.Current().MaxReconnectDelay) * time.Second
 }
 
 // GetIOCUpdateDelayDuration returns IOC update delay as time.Duration
 func (c *Config) GetIOCUpdateDelayDuration() time.Duration {
-	return time.Duration(c.IOCUpdateDelay) * time.Second
+	return time.Duration(c.Current().IOCUpdateDelay) * time.Second
 }
 
 // GetShutdownTimeoutDuration returns shutdown timeout as time.Duration
 func (c *Config) GetShutdownTimeoutDuration() time.Duration {
-	return time.Duration(c.ShutdownTimeout) * time.Millisecond
+	return time.Duration(c.Current().ShutdownTimeout) * time.Millisecond
+}
+
+// GetKeepaliveTimeDuration returns keepalive time as time.Duration
+func (c *Config) GetKeepaliveTimeDuration() time.Duration {
+	return time.Duration(c.Current().KeepaliveTime) * time.Second
+}
+
+// GetKeepaliveTimeoutDuration returns keepalive timeout as time.Duration
+func (c *Config) GetKeepaliveTimeoutDuration() time.Duration {
+	return time.Duration(c.Current().KeepaliveTimeout) * time.Second
+}
+
+// GetReportDedupWindowDuration returns the IOC match report de-duplication
+// window as time.Duration
+func (c *Config) GetReportDedupWindowDuration() time.Duration {
+	return time.Duration(c.Current().ReportDedupWindow) * time.Minute
+}
+
+// GetEnrichmentTimeoutDuration returns the enrichment lookup timeout as
+// time.Duration
+func (c *Config) GetEnrichmentTimeoutDuration() time.Duration {
+	return time.Duration(c.Current().EnrichmentTimeout) * time.Millisecond
 }
 
 // GetCPUSampleDuration returns CPU sample duration as time.Duration
 func (c *Config) GetCPUSampleDuration() time.Duration {
-	return time.Duration(c.CPUSampleDuration) * time.Millisecond
+	return time.Duration(c.Current().CPUSampleDuration) * time.Millisecond
 }
 
 // GetScanIntervalDuration returns scan interval as time.Duration
 func (c *Config) GetScanIntervalDuration() time.Duration {
-	return time.Duration(c.ScanInterval) * time.Minute
+	return time.Duration(c.Current().ScanInterval) * time.Minute
+}
+
+// GetIPURLScanIntervalDuration returns the IP and URL block check interval,
+// which defaults to scan_interval
+func (c *Config) GetIPURLScanIntervalDuration() time.Duration {
+	current := c.Current()
+	if current.IPURLScanInterval > 0 {
+		return time.Duration(current.IPURLScanInterval) * time.Minute
+	}
+	return current.GetScanIntervalDuration()
+}
+
+// GetFileScanIntervalDuration returns the file hash scan interval, which
+// defaults to scan_interval
+func (c *Config) GetFileScanIntervalDuration() time.Duration {
+	current := c.Current()
+	if current.FileScanInterval > 0 {
+		return time.Duration(current.FileScanInterval) * time.Minute
+	}
+	return current.GetScanIntervalDuration()
 }
 
 // GetMetricsIntervalDuration returns metrics interval as time.Duration
 func (c *Config) GetMetricsIntervalDuration() time.Duration {
-	return time.Duration(c.MetricsInterval) * time.Minute
+	return time.Duration(c.Current().MetricsInterval) * time.Minute
+}
+
+// GetBlockTTLDuration returns the block TTL as time.Duration (0 = never expire)
+func (c *Config) GetBlockTTLDuration() time.Duration {
+	return time.Duration(c.Current().BlockTTLHours) * time.Hour
+}
+
+// GetBlockSaveDelayDuration returns the block list save delay as time.Duration
+func (c *Config) GetBlockSaveDelayDuration() time.Duration {
+	return time.Duration(c.Current().BlockSaveDelayMs) * time.Millisecond
+}
+
+// GetCommandDedupRetentionDuration returns the command de-duplication window as time.Duration
+func (c *Config) GetCommandDedupRetentionDuration() time.Duration {
+	return time.Duration(c.Current().CommandDedupRetention) * time.Minute
+}
+
+// GetCommandDrainTimeoutDuration returns how long shutdown waits for running
+// commands as time.Duration
+func (c *Config) GetCommandDrainTimeoutDuration() time.Duration {
+	return time.Duration(c.Current().CommandDrainTimeout) * time.Second
+}
+
+// GetResponseScriptTimeoutDuration returns the response script timeout as
+// time.Duration
+func (c *Config) GetResponseScriptTimeoutDuration() time.Duration {
+	return time.Duration(c.Current().ResponseScriptTimeout) * time.Second
+}
+
+// GetCommandTimeoutDuration returns the command timeout as time.Duration (0 = no limit)
+func (c *Config) GetCommandTimeoutDuration() time.Duration {
+	return time.Duration(c.Current().CommandTimeout) * time.Second
+}
+
+// GetMemoryScanMaxBytes returns the per-process memory scan limit in bytes
+func (c *Config) GetMemoryScanMaxBytes() int64 {
+	return int64(c.Current().MemoryScanMaxSize) << 20
+}
+
+// GetMaxUploadSizeBytes returns the upload size limit in bytes
+func (c *Config) GetMaxUploadSizeBytes() int64 {
+	return int64(c.Current().MaxUploadSize) << 20
+}
+
+// GetIsolationMaxDuration returns the isolation watchdog timeout as time.Duration (0 = disabled)
+func (c *Config) GetIsolationMaxDuration() time.Duration {
+	return time.Duration(c.Current().IsolationMaxDuration) * time.Minute
+}
+
+// GetHeartbeatIntervalDuration returns the heartbeat interval as time.Duration
+func (c *Config) GetHeartbeatIntervalDuration() time.Duration {
+	return time.Duration(c.Current().HeartbeatInterval) * time.Second
 }
 
 // String returns a string representation of the configuration
@@ -0,0 +1,37 @@
+package config
+
+// Reload and UPDATE_CONFIG change options while the agent runs. Instead of
+// writing fields that other goroutines are reading, a changed copy of the
+// configuration is published and readers pick it up with Current.
+
+// Current returns the configuration currently in effect. Its fields are never
+// modified once it is published, so options that can change on a running
+// agent must be read through it rather than from c. Options that need a
+// restart can be read from c directly.
+func (c *Config) Current() *Config {
+	if c.live != nil {
+		if current := c.live.Load(); current != nil {
+			return current
+		}
+	}
+	return c
+}
+
+// Update publishes a copy of the current configuration with the changes made
+// by change applied. change may be called more than once if another update
+// is published at the same time.
+func (c *Config) Update(change func(next *Config)) {
+	for {
+		published := c.live.Load()
+		current := published
+		if current == nil {
+			current = c
+		}
+		
+		next := *current
+		change(&next)
+		if c.live.CompareAndSwap(published, &next) {
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// ProcessRule flags a parent process starting a child process, such as an
+// Office application starting a shell. Images are matched case-insensitively
+// with glob patterns; a pattern without a path separator matches the file
+// name, one with a separator the full path.
+type ProcessRule struct {
+	Name        string `yaml:"name" json:"name"`
+	ParentImage string `yaml:"parent_image" json:"parent_image"`
+	ChildImage  string `yaml:"child_image" json:"child_image"`
+	Severity    string `yaml:"severity" json:"severity"` // Match severity (default high)
+	Kill        bool   `yaml:"kill" json:"kill"`         // Kill the child process on a match
+}
+
+// DefaultProcessRuleSeverity is the severity of rules that do not set one
+const DefaultProcessRuleSeverity = "high"
+
+// validateProcessRules checks the names, patterns and severities of rules
+func validateProcessRules(rules []ProcessRule) []ValidationError {
+	var errors []ValidationError
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		field := fmt.Sprintf("process_rules[%d]", i)
+		if strings.TrimSpace(rule.Name) == "" {
+			errors = append(errors, ValidationError{Field: field + ".name", Value: rule.Name, Message: "cannot be empty"})
+		} else if names[rule.Name] {
+			errors = append(errors, ValidationError{Field: field + ".name", Value: rule.Name, Message: "duplicate rule name"})
+		}
+		names[rule.Name] = true
+
+		for _, p := range []struct{ field, pattern string }{
+			{"parent_image", rule.ParentImage},
+			{"child_image", rule.ChildImage},
+		} {
+			if p.pattern == "" {
+				errors = append(errors, ValidationError{Field: field + "." + p.field, Value: p.pattern, Message: "cannot be empty (use * to match any image)"})
+			} else if _, err := path.Match(NormalizeImagePattern(p.pattern), ""); err != nil {
+				errors = append(errors, ValidationError{Field: field + "." + p.field, Value: p.pattern, Message: "invalid glob pattern"})
+			}
+		}
+
+		if rule.Severity != "" && !containsString(RemediationSeverities, rule.Severity) {
+			errors = append(errors, ValidationError{
+				Field:   field + ".severity",
+				Value:   rule.Severity,
+				Message: fmt.Sprintf("must be one of: %s", strings.Join(RemediationSeverities, ", ")),
+			})
+		}
+	}
+	return errors
+}
+
+// NormalizeImagePattern lower-cases an image or pattern and uses forward
+// slashes, so Windows paths match the same way on every platform
+func NormalizeImagePattern(value string) string {
+	return strings.ToLower(strings.ReplaceAll(value, `\`, "/"))
+}
+
+// yamlProcessRules formats process rules as a YAML flow sequence of mappings
+func yamlProcessRules(rules []ProcessRule) string {
+	entries := make([]string, len(rules))
+	for i, rule := range rules {
+		entries[i] = fmt.Sprintf("{name: %s, parent_image: %s, child_image: %s, severity: %s, kill: %t}",
+			yamlString(rule.Name), yamlString(rule.ParentImage), yamlString(rule.ChildImage), yamlString(rule.Severity), rule.Kill)
+	}
+	return "[" + strings.Join(entries, ", ") + "]"
+}
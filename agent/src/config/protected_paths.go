@@ -0,0 +1,110 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// DefaultWindowsProtectedPaths returns the core Windows files and
+// directories that remediation never deletes or quarantines, under
+// %SystemRoot% and %SystemDrive%
+func DefaultWindowsProtectedPaths() []string {
+	root := strings.TrimRight(os.Getenv("SystemRoot"), `\`)
+	if root == "" {
+		root = `C:\Windows`
+	}
+	drive := strings.TrimRight(os.Getenv("SystemDrive"), `\`)
+	if drive == "" {
+		drive = "C:"
+		if len(root) >= 2 && root[1] == ':' {
+			drive = root[:2]
+		}
+	}
+
+	return []string{
+		root + `\System32`,
+		root + `\SysWOW64`,
+		root + `\WinSxS`,
+		root + `\Boot`,
+		root + `\servicing`,
+		root + `\explorer.exe`,
+		drive + `\bootmgr`,
+	}
+}
+
+// DefaultUnixProtectedPaths are the core Linux and macOS directories that
+// remediation never deletes or quarantines
+var DefaultUnixProtectedPaths = []string{
+	"/bin",
+	"/sbin",
+	"/lib",
+	"/lib64",
+	"/usr/bin",
+	"/usr/sbin",
+	"/usr/lib",
+	"/usr/lib64",
+	"/usr/libexec",
+	"/boot",
+	"/etc",
+	"/System",
+}
+
+// defaultProtectedPaths returns the protected paths for the running OS
+func defaultProtectedPaths() []string {
+	if runtime.GOOS == "windows" {
+		return DefaultWindowsProtectedPaths()
+	}
+	return append([]string(nil), DefaultUnixProtectedPaths...)
+}
+
+// IsProtectedPath reports whether path matches protected_paths, so that
+// remediation must leave it in place. Patterns containing a path separator
+// match the path or any directory above it, so a directory protects
+// everything below it; other patterns match the file name. Matching ignores
+// case on Windows.
+func (c *Config) IsProtectedPath(path string) bool {
+	path = filepath.Clean(path)
+	name := filepath.Base(path)
+	if runtime.GOOS == "windows" {
+		path = strings.ToLower(path)
+		name = strings.ToLower(name)
+	}
+
+	for _, pattern := range c.Current().ProtectedPaths {
+		if runtime.GOOS == "windows" {
+			pattern = strings.ToLower(pattern)
+		}
+		if !strings.ContainsAny(pattern, `/\`) {
+			if matched, err := filepath.Match(pattern, name); err == nil && matched {
+				return true
+			}
+			continue
+		}
+
+		pattern = filepath.Clean(pattern)
+		for dir := path; ; dir = filepath.Dir(dir) {
+			if matched, err := filepath.Match(pattern, dir); err == nil && matched {
+				return true
+			}
+			if filepath.Dir(dir) == dir {
+				break
+			}
+		}
+	}
+	return false
+}
+
+// validateProtectedPaths checks that every protected path is a valid glob
+func validateProtectedPaths(patterns []string) []ValidationError {
+	var errors []ValidationError
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			errors = append(errors, ValidationError{Field: "protected_paths", Value: pattern, Message: "entries cannot be empty"})
+		} else if _, err := filepath.Match(pattern, ""); err != nil {
+			errors = append(errors, ValidationError{Field: "protected_paths", Value: pattern, Message: "invalid glob pattern"})
+		}
+	}
+	return errors
+}
@@ -0,0 +1,38 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDefaultWindowsProtectedPaths(t *testing.T) {
+	tests := []struct {
+		root, drive string
+		want        []string
+	}{
+		{`C:\Windows`, `C:`, []string{
+			`C:\Windows\System32`, `C:\Windows\SysWOW64`, `C:\Windows\WinSxS`, `C:\Windows\Boot`,
+			`C:\Windows\servicing`, `C:\Windows\explorer.exe`, `C:\bootmgr`,
+		}},
+		{`D:\WINNT\`, `E:\`, []string{
+			`D:\WINNT\System32`, `D:\WINNT\SysWOW64`, `D:\WINNT\WinSxS`, `D:\WINNT\Boot`,
+			`D:\WINNT\servicing`, `D:\WINNT\explorer.exe`, `E:\bootmgr`,
+		}},
+		{`D:\Windows`, ``, []string{
+			`D:\Windows\System32`, `D:\Windows\SysWOW64`, `D:\Windows\WinSxS`, `D:\Windows\Boot`,
+			`D:\Windows\servicing`, `D:\Windows\explorer.exe`, `D:\bootmgr`,
+		}},
+		{``, ``, []string{
+			`C:\Windows\System32`, `C:\Windows\SysWOW64`, `C:\Windows\WinSxS`, `C:\Windows\Boot`,
+			`C:\Windows\servicing`, `C:\Windows\explorer.exe`, `C:\bootmgr`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("SystemRoot", tt.root)
+		t.Setenv("SystemDrive", tt.drive)
+		if got := DefaultWindowsProtectedPaths(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SystemRoot=%q SystemDrive=%q: got %q, want %q", tt.root, tt.drive, got, tt.want)
+		}
+	}
+}
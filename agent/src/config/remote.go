@@ -0,0 +1,140 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// remoteServerKeys are the connection settings UPDATE_CONFIG may only change
+// when allow_remote_server_change is set. They take effect after a restart.
+var remoteServerKeys = map[string]bool{
+	"server_address":       true,
+	"use_tls":              true,
+	"ca_cert_path":         true,
+	"insecure_skip_verify": true,
+	"tls_server_name":      true,
+}
+
+// remoteBindings returns the fields UPDATE_CONFIG may change, by YAML key.
+// Apart from remoteServerKeys they all take effect on a running agent.
+func (c *Config) remoteBindings() map[string]interface{} {
+	return map[string]interface{}{
+		"server_address":         &c.ServerAddress,
+		"use_tls":                &c.UseTLS,
+		"ca_cert_path":           &c.CACertPath,
+		"insecure_skip_verify":   &c.InsecureSkipVerify,
+		"tls_server_name":        &c.TLSServerName,
+		"log_level":              &c.LogLevel,
+		"scan_interval":          &c.ScanInterval,
+		"ip_url_scan_interval":   &c.IPURLScanInterval,
+		"file_scan_interval":     &c.FileScanInterval,
+		"scan_schedule":          &c.ScanSchedule,
+		"metrics_interval":       &c.MetricsInterval,
+		"reconnect_delay":        &c.ReconnectDelay,
+		"max_reconnect_delay":    &c.MaxReconnectDelay,
+		"heartbeat_interval":     &c.HeartbeatInterval,
+		"ioc_update_delay":       &c.IOCUpdateDelay,
+		"cpu_sample_duration":    &c.CPUSampleDuration,
+		"block_ttl_hours":        &c.BlockTTLHours,
+		"scan_exclusions":        &c.ScanExclusions,
+		"scan_throttle_percent":  &c.ScanThrottlePercent,
+		"scan_workers":           &c.ScanWorkers,
+		"max_hash_file_bytes":    &c.MaxHashFileBytes,
+		"memory_scan_max_size":   &c.MemoryScanMaxSize,
+		"collect_before_delete":  &c.CollectBeforeDelete,
+		"max_upload_size":        &c.MaxUploadSize,
+		"mode":                   &c.Mode,
+		"remediation_policy":     &c.RemediationPolicy,
+		"enrichment_timeout":     &c.EnrichmentTimeout,
+		"report_dedup_window":    &c.ReportDedupWindow,
+		"notify_user":            &c.NotifyUser,
+		"notify_user_message":    &c.NotifyUserMessage,
+		"command_timeout":        &c.CommandTimeout,
+		"isolation_max_duration": &c.IsolationMaxDuration,
+	}
+}
+
+// ApplyRemote validates configuration changes pushed by the server with
+// UPDATE_CONFIG and saves them to the configuration file. Keys are YAML field
+// names from remoteBindings; the server connection settings are refused
+// unless allow_remote_server_change is set. The changes are merged into the
+// file as it is on disk, and validated together with the environment and
+// flag overrides that will apply on top of it. c itself is not modified: the
+// caller reloads the file, which applies the changes as SIGHUP does. It
+// returns the changed keys that only take effect after a restart.
+func (c *Config) ApplyRemote(changes map[string]string) ([]string, error) {
+	if len(changes) == 0 {
+		return nil, fmt.Errorf("no configuration changes given")
+	}
+	
+	keys := make([]string, 0, len(changes))
+	for key := range changes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	
+	var restart []string
+	err := UpdateFile(c.ConfigFile, func(file *Config) error {
+		bindings := file.remoteBindings()
+		for _, key := range keys {
+			target, ok := bindings[key]
+			if !ok {
+				return fmt.Errorf("%s cannot be changed remotely", key)
+			}
+			if remoteServerKeys[key] {
+				if !c.Current().AllowRemoteServerChange {
+					return fmt.Errorf("%s can only be changed remotely when allow_remote_server_change is set", key)
+				}
+				restart = append(restart, key)
+			}
+			if err := setRemoteValue(target, changes[key]); err != nil {
+				return fmt.Errorf("invalid value for %s (%q): %v", key, changes[key], err)
+			}
+		}
+		
+		// Validate what the agent will run with after reloading the file
+		effective := *file
+		if err := effective.ApplyEnv(); err != nil {
+			return err
+		}
+		effective.applyFlagValues(c.flagOverrides)
+		if err := effective.Validate(); err != nil {
+			return fmt.Errorf("configuration validation failed: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restart, nil
+}
+
+// setRemoteValue parses an UPDATE_CONFIG value into a field. Lists are
+// comma-separated; remediation_policy takes severity=action pairs that
+// replace the actions of those severities only.
+func setRemoteValue(target interface{}, value string) error {
+	switch target := target.(type) {
+	case *[]string:
+		var list []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*target = list
+	case *map[string]string:
+		policy := copyStringMap(*target)
+		for _, pair := range strings.Split(value, ",") {
+			severity, action, ok := strings.Cut(pair, "=")
+			if !ok {
+				return fmt.Errorf("expected severity=action pairs")
+			}
+			policy[strings.ToLower(strings.TrimSpace(severity))] = strings.ToLower(strings.TrimSpace(action))
+		}
+		*target = policy
+	default:
+		return setFromString(target, value)
+	}
+	return nil
+}
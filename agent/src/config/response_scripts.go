@@ -0,0 +1,69 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// ResponseScriptTypes are the IOC types response_scripts can be keyed by,
+// besides the severities in RemediationSeverities
+var ResponseScriptTypes = []string{"ip", "hash", "url", "yara", "behavior"}
+
+// ResponseScript returns the script to run for a match of iocType (ip, hash,
+// url, yara or behavior) with severity, or "" if none is configured. An
+// entry for the IOC type takes precedence over one for the severity.
+func (c *Config) ResponseScript(iocType, severity string) string {
+	current := c.Current()
+	if script := current.ResponseScripts[strings.ToLower(iocType)]; script != "" {
+		return script
+	}
+	return current.ResponseScripts[strings.ToLower(severity)]
+}
+
+// validateResponseScripts checks that every key is a severity or IOC type
+// and every script an absolute path. Batch files are refused because cmd.exe
+// would re-parse the IOC values passed as arguments.
+func validateResponseScripts(scripts map[string]string) []ValidationError {
+	var errors []ValidationError
+	for key, script := range scripts {
+		if !containsString(RemediationSeverities, key) && !containsString(ResponseScriptTypes, key) {
+			errors = append(errors, ValidationError{
+				Field:   "response_scripts",
+				Value:   key,
+				Message: fmt.Sprintf("unknown key, must be a severity (%s) or IOC type (%s)",
+					strings.Join(RemediationSeverities, ", "), strings.Join(ResponseScriptTypes, ", ")),
+			})
+		}
+		if !filepath.IsAbs(script) {
+			errors = append(errors, ValidationError{
+				Field:   "response_scripts." + key,
+				Value:   script,
+				Message: "must be an absolute path",
+			})
+		}
+		switch strings.ToLower(filepath.Ext(script)) {
+		case ".bat", ".cmd":
+			errors = append(errors, ValidationError{
+				Field:   "response_scripts." + key,
+				Value:   script,
+				Message: "batch files are not supported, use a PowerShell script or an executable",
+			})
+		}
+	}
+	return errors
+}
+
+// yamlResponseScripts formats response scripts as a YAML flow mapping,
+// severities first, then IOC types
+func yamlResponseScripts(scripts map[string]string) string {
+	var entries []string
+	for _, keys := range [][]string{RemediationSeverities, ResponseScriptTypes} {
+		for _, key := range keys {
+			if script, ok := scripts[key]; ok {
+				entries = append(entries, key+": "+yamlString(script))
+			}
+		}
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
@@ -0,0 +1,83 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ScanWindow is a daily time range in which scheduled scans may run, in
+// minutes since local midnight. A window whose end is before its start runs
+// past midnight.
+type ScanWindow struct {
+	Start int
+	End   int
+}
+
+// contains reports whether the minute of the day falls inside the window.
+// The start is inclusive and the end exclusive.
+func (w ScanWindow) contains(minute int) bool {
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+	return minute >= w.Start || minute < w.End
+}
+
+// ParseScanSchedule parses a scan_schedule value: a comma separated list of
+// HH:MM-HH:MM windows such as "22:00-06:00" or "12:00-13:00, 20:00-23:59".
+// An empty schedule returns no windows.
+func ParseScanSchedule(schedule string) ([]ScanWindow, error) {
+	var windows []ScanWindow
+	for _, part := range strings.Split(schedule, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		startStr, endStr, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("window %q must be HH:MM-HH:MM", part)
+		}
+		start, err := parseClock(startStr)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %v", part, err)
+		}
+		end, err := parseClock(endStr)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %v", part, err)
+		}
+		if start == end {
+			return nil, fmt.Errorf("window %q is empty, start and end must differ", part)
+		}
+		windows = append(windows, ScanWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// parseClock parses an HH:MM time of day into minutes since midnight
+func parseClock(value string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", strings.TrimSpace(value))
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// ScanAllowedAt reports whether scan_schedule lets a scheduled scan run at t
+// (local time). With no schedule scans may run at any time.
+func (c *Config) ScanAllowedAt(t time.Time) bool {
+	windows, err := ParseScanSchedule(c.Current().ScanSchedule)
+	if err != nil || len(windows) == 0 {
+		// Validate rejects bad schedules, so this only keeps scanning going
+		// if one slips through
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	for _, w := range windows {
+		if w.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
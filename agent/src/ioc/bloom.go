@@ -0,0 +1,91 @@
+package ioc
+
+import (
+	"hash/maphash"
+	"math"
+	"sync/atomic"
+)
+
+const (
+	// bloomFalsePositiveRate is the target share of lookups for hashes that
+	// are not IOCs that still fall through to the map
+	bloomFalsePositiveRate = 0.01
+	// bloomMinBits keeps the filter useful for small IOC sets
+	bloomMinBits = 1024
+)
+
+// bloomFilter answers "definitely not present" for most file hashes without
+// touching the IOC map. Bits are set and read atomically so lookups need no
+// lock while AddFileHash inserts.
+type bloomFilter struct {
+	count    int64  // Entries added, first for 64-bit atomic alignment on 32-bit platforms
+	bits     []uint64
+	size     uint64 // Number of bits
+	hashes   uint64 // Number of bit positions per value
+	capacity int    // Entry count the filter was sized for
+	seed     maphash.Seed
+}
+
+// newBloomFilter creates a filter sized for n entries at bloomFalsePositiveRate
+func newBloomFilter(n int) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+	size := uint64(math.Ceil(-float64(n) * math.Log(bloomFalsePositiveRate) / (math.Ln2 * math.Ln2)))
+	if size < bloomMinBits {
+		size = bloomMinBits
+	}
+	hashes := uint64(math.Round(float64(size) / float64(n) * math.Ln2))
+	if hashes < 1 {
+		hashes = 1
+	}
+
+	return &bloomFilter{
+		bits:     make([]uint64, (size+63)/64),
+		size:     size,
+		hashes:   hashes,
+		capacity: n,
+		seed:     maphash.MakeSeed(),
+	}
+}
+
+// positions returns the two base hashes used for double hashing
+func (f *bloomFilter) positions(value string) (uint64, uint64) {
+	sum := maphash.String(f.seed, value)
+	return sum, (sum >> 33) | 1
+}
+
+// add records value in the filter
+func (f *bloomFilter) add(value string) {
+	h1, h2 := f.positions(value)
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.size
+		word := &f.bits[bit/64]
+		mask := uint64(1) << (bit % 64)
+		for {
+			old := atomic.LoadUint64(word)
+			if old&mask != 0 || atomic.CompareAndSwapUint64(word, old, old|mask) {
+				break
+			}
+		}
+	}
+	atomic.AddInt64(&f.count, 1)
+}
+
+// mayContain reports false only if value was never added
+func (f *bloomFilter) mayContain(value string) bool {
+	h1, h2 := f.positions(value)
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % f.size
+		if atomic.LoadUint64(&f.bits[bit/64])&(uint64(1)<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// full reports whether more entries were added than the filter was sized for,
+// which pushes the false positive rate above the target
+func (f *bloomFilter) full() bool {
+	return atomic.LoadInt64(&f.count) > int64(f.capacity)
+}
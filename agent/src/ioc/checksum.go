@@ -0,0 +1,67 @@
+package ioc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	pb "agent/proto"
+)
+
+// ErrChecksumMismatch is returned by UpdateFromProto and ApplyDelta when the
+// indicators received do not match the update's indicator_count or
+// indicator_checksum, as happens when a transfer is cut short
+var ErrChecksumMismatch = errors.New("IOC update indicator count or checksum does not match")
+
+// IndicatorCount returns the number of indicators an update carries: the
+// entries of ip_addresses, file_hashes and urls plus the removed_* values
+func IndicatorCount(response *pb.IOCResponse) int64 {
+	return int64(len(response.IpAddresses) + len(response.FileHashes) + len(response.Urls) +
+		len(response.RemovedIpAddresses) + len(response.RemovedFileHashes) + len(response.RemovedUrls))
+}
+
+// IndicatorChecksum returns the hex SHA-256 of an update's indicators. Each
+// indicator is written as its section name, a colon, the key or removed
+// value and a newline; the lines are sorted before hashing. Sections are
+// ip_addresses, file_hashes, urls, removed_ip_addresses, removed_file_hashes
+// and removed_urls.
+func IndicatorChecksum(response *pb.IOCResponse) string {
+	lines := make([]string, 0, IndicatorCount(response))
+	add := func(section string, values []string) {
+		for _, value := range values {
+			lines = append(lines, section+":"+value+"\n")
+		}
+	}
+	add("ip_addresses", sortedKeys(response.IpAddresses))
+	add("file_hashes", sortedKeys(response.FileHashes))
+	add("urls", sortedKeys(response.Urls))
+	add("removed_ip_addresses", response.RemovedIpAddresses)
+	add("removed_file_hashes", response.RemovedFileHashes)
+	add("removed_urls", response.RemovedUrls)
+	sort.Strings(lines)
+
+	h := sha256.New()
+	for _, line := range lines {
+		h.Write([]byte(line))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// verifyChecksum checks the indicators received against the count and
+// checksum the server sent. Updates without a checksum are accepted.
+func verifyChecksum(response *pb.IOCResponse) error {
+	if response.IndicatorChecksum == "" {
+		return nil
+	}
+	if count := IndicatorCount(response); count != response.IndicatorCount {
+		return fmt.Errorf("%w: IOC version %d has %d indicators, expected %d",
+			ErrChecksumMismatch, response.Version, count, response.IndicatorCount)
+	}
+	if sum := IndicatorChecksum(response); sum != response.IndicatorChecksum {
+		return fmt.Errorf("%w: IOC version %d checksum is %s, expected %s",
+			ErrChecksumMismatch, response.Version, sum, response.IndicatorChecksum)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DirectoryScanResult summarizes an on-demand directory scan
+type DirectoryScanResult struct {
+	Root         string
+	FilesScanned int
+	Matches      int
+	YaraMatches  int
+	Skipped      int
+	Errors       int
+	Duration     time.Duration
+}
+
+// ScanDirectory walks root, hashes every regular file and handles any file
+// whose hash matches a file hash IOC. Other files are matched against the
+// loaded YARA rules. maxDepth limits how deep files may be below root: 1 scans
+// only files directly in root, 0 means unlimited. Paths matching the
+// configured scan exclusions are skipped. Files are hashed by scan_workers
+// goroutines fed from the walk; cancelling ctx stops both the walk and the
+// workers.
+func (s *Scanner) ScanDirectory(ctx context.Context, root string, maxDepth int) (*DirectoryScanResult, error) {
+	start := time.Now()
+	root = filepath.Clean(root)
+	result := &DirectoryScanResult{Root: root}
+	var mu sync.Mutex // Guards result while workers run
+	
+	workers := s.config.Current().ScanWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	
+	log.Printf("Starting directory scan of %s (max depth: %d, workers: %d)", root, maxDepth, workers)
+	
+	// Matches are reported together once the workers are done
+	reportCtx, flush := s.startReportBatch(ctx)
+	
+	paths := make(chan string, workers*4)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				// Drain without hashing once the scan is cancelled
+				if ctx.Err() != nil {
+					continue
+				}
+				s.scanDirectoryFile(reportCtx, path, result, &mu)
+			}
+		}()
+	}
+	
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, walkErr error) error {
+		// Abort the walk if the scan was cancelled
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		
+		if walkErr != nil {
+			// Unreadable entries (permissions, races with deletion) are counted and skipped
+			mu.Lock()
+			result.Errors++
+			mu.Unlock()
+			if d != nil && d.IsDir() && path != root {
+				return filepath.SkipDir
+			}
+			if path == root {
+				return walkErr
+			}
+			return nil
+		}
+		
+		if path != root && s.isExcluded(path) {
+			mu.Lock()
+			result.Skipped++
+			mu.Unlock()
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		if d.IsDir() {
+			// Files inside a directory at depth N are at depth N+1
+			if maxDepth > 0 && path != root && pathDepth(root, path) >= maxDepth {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		
+		// Only hash regular files (skip symlinks, devices, pipes)
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		
+		select {
+		case paths <- path:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+	
+	close(paths)
+	wg.Wait()
+	flush()
+	
+	result.Duration = time.Since(start)
+	
+	if err != nil {
+		return result, fmt.Errorf("directory scan of %s stopped: %v", root, err)
+	}
+	
+	log.Printf("Directory scan of %s completed in %v: %d files scanned, %d matches, %d YARA matches, %d skipped, %d errors",
+		root, result.Duration, result.FilesScanned, result.Matches, result.YaraMatches, result.Skipped, result.Errors)
+	
+	return result, nil
+}
+
+// scanDirectoryFile hashes one file found by ScanDirectory and handles a
+// match, reporting it with ctx
+func (s *Scanner) scanDirectoryFile(ctx context.Context, path string, result *DirectoryScanResult, mu *sync.Mutex) {
+	// SCAN_PATH targets these files explicitly, so max_hash_file_bytes does not apply
+	hashValue, ioc, matched, err := s.matchFileHashAnySize(path)
+	if err != nil {
+		mu.Lock()
+		result.Errors++
+		mu.Unlock()
+		return
+	}
+	
+	yaraMatches := 0
+	if matched {
+		s.handleMaliciousFile(ctx, path, hashValue, &ioc, nil)
+	} else {
+		yaraMatches = s.scanFileWithYara(ctx, path)
+	}
+	
+	mu.Lock()
+	result.FilesScanned++
+	if matched {
+		result.Matches++
+	}
+	result.YaraMatches += yaraMatches
+	mu.Unlock()
+}
+
+// isExcluded checks a path against the configured scan exclusion globs.
+// Patterns containing a path separator are matched against the full path,
+// other patterns against the base name.
+func (s *Scanner) isExcluded(path string) bool {
+	name := filepath.Base(path)
+	if runtime.GOOS == "windows" {
+		path = strings.ToLower(path)
+		name = strings.ToLower(name)
+	}
+	
+	for _, pattern := range s.config.Current().ScanExclusions {
+		if runtime.GOOS == "windows" {
+			pattern = strings.ToLower(pattern)
+		}
+		
+		target := path
+		if !strings.ContainsAny(pattern, `/\`) {
+			target = name
+		}
+		
+		if matched, err := filepath.Match(pattern, target); err == nil && matched {
+			return true
+		}
+	}
+	
+	return false
+}
+
+// pathDepth returns how many directory levels path is below root
+func pathDepth(root, path string) int {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." {
+		return 0
+	}
+	return strings.Count(rel, string(filepath.Separator)) + 1
+}
@@ -0,0 +1,78 @@
+package ioc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// maxEventDataLength is the longest EventData value kept per field of a
+// collected event; longer values are cut and the event marked truncated
+const maxEventDataLength = 1024
+
+// LogEvent is an event collected from a Windows event log
+type LogEvent struct {
+	RecordID    uint64            `json:"record_id"`
+	EventID     uint32            `json:"event_id"`
+	Level       uint8             `json:"level"`
+	Provider    string            `json:"provider,omitempty"`
+	Channel     string            `json:"channel,omitempty"`
+	Computer    string            `json:"computer,omitempty"`
+	TimeCreated time.Time         `json:"time_created"`
+	Data        map[string]string `json:"data,omitempty"`
+	Truncated   bool              `json:"truncated,omitempty"` // A Data value was cut to maxEventDataLength
+}
+
+// parseLogEventXML builds a LogEvent from a rendered event XML document.
+// Unnamed Data fields, used by classic event sources, are keyed Data1,
+// Data2 and so on.
+func parseLogEventXML(data []byte) (*LogEvent, error) {
+	var doc eventXML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse event XML: %v", err)
+	}
+
+	event := &LogEvent{
+		RecordID: doc.System.EventRecordID,
+		EventID:  doc.System.EventID,
+		Level:    doc.System.Level,
+		Provider: doc.System.Provider.Name,
+		Channel:  doc.System.Channel,
+		Computer: doc.System.Computer,
+	}
+	if t, err := time.Parse(time.RFC3339Nano, doc.System.TimeCreated.SystemTime); err == nil {
+		event.TimeCreated = t.UTC()
+	}
+
+	if len(doc.EventData.Data) > 0 {
+		event.Data = make(map[string]string, len(doc.EventData.Data))
+	}
+	for i, d := range doc.EventData.Data {
+		name := d.Name
+		if name == "" {
+			name = fmt.Sprintf("Data%d", i+1)
+		}
+		value := strings.TrimSpace(d.Value)
+		if len(value) > maxEventDataLength {
+			value = value[:maxEventDataLength] + "..."
+			event.Truncated = true
+		}
+		event.Data[name] = value
+	}
+
+	return event, nil
+}
+
+// eventIDQuery builds the XPath query selecting events with one of eventIDs,
+// or every event when eventIDs is empty
+func eventIDQuery(eventIDs []uint32) string {
+	if len(eventIDs) == 0 {
+		return "*"
+	}
+	ids := make([]string, len(eventIDs))
+	for i, id := range eventIDs {
+		ids[i] = fmt.Sprintf("EventID=%d", id)
+	}
+	return fmt.Sprintf("*[System[(%s)]]", strings.Join(ids, " or "))
+}
@@ -0,0 +1,136 @@
+package ioc
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"agent/persist"
+)
+
+// ipBlockRetryDelay is how long startup waits before retrying the IPs whose
+// firewall rules could not be created, giving transient errors time to clear
+const ipBlockRetryDelay = 10 * time.Second
+
+// failedIPBlocks is the persisted set of IOC IPs that could not be blocked
+type failedIPBlocks struct {
+	IPs []string `json:"ips"`
+}
+
+// SetBlockReporter sets the function told how many IOC IPs were blocked at
+// startup out of those that needed blocking, and which ones failed
+func (s *Scanner) SetBlockReporter(report func(blocked, total int, failed []string)) {
+	s.reportBlocks = report
+}
+
+// failedIPsPath returns the path of the failed IP blocks file
+func (s *Scanner) failedIPsPath() string {
+	return filepath.Join(s.config.DataDir, "failed_ip_blocks.json")
+}
+
+// loadFailedIPs restores the IPs that could not be blocked by a previous run
+func (s *Scanner) loadFailedIPs() {
+	s.failedIPs = make(map[string]bool)
+
+	data, err := os.ReadFile(s.failedIPsPath())
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Printf("Failed to read failed IP blocks: %v", err)
+		return
+	}
+
+	var failed failedIPBlocks
+	if err := json.Unmarshal(data, &failed); err != nil {
+		log.Printf("Failed to parse failed IP blocks: %v", err)
+		return
+	}
+	for _, ip := range failed.IPs {
+		s.failedIPs[ip] = true
+	}
+	if len(s.failedIPs) > 0 {
+		log.Printf("Loaded %d IPs that could not be blocked previously", len(s.failedIPs))
+	}
+}
+
+// saveFailedIPs persists the failed IP set, removing the file once it is
+// empty (caller must hold failedIPsMu)
+func (s *Scanner) saveFailedIPs() {
+	if len(s.failedIPs) == 0 {
+		if err := os.Remove(s.failedIPsPath()); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove failed IP blocks: %v", err)
+		}
+		return
+	}
+
+	data, err := json.MarshalIndent(failedIPBlocks{IPs: sortedKeys(s.failedIPs)}, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal failed IP blocks: %v", err)
+		return
+	}
+	if err := persist.WriteFileAtomic(s.failedIPsPath(), data, 0644); err != nil {
+		log.Printf("Failed to write failed IP blocks: %v", err)
+	}
+}
+
+// failedIPList returns the IPs that could not be blocked so far
+func (s *Scanner) failedIPList() []string {
+	s.failedIPsMu.Lock()
+	defer s.failedIPsMu.Unlock()
+	return sortedKeys(s.failedIPs)
+}
+
+// updateFailedIPs adds failed to the failed IP set and removes cleared from
+// it, persisting the set if it changed
+func (s *Scanner) updateFailedIPs(failed, cleared []string) {
+	s.failedIPsMu.Lock()
+	defer s.failedIPsMu.Unlock()
+
+	changed := false
+	for _, ip := range cleared {
+		if s.failedIPs[ip] {
+			delete(s.failedIPs, ip)
+			changed = true
+		}
+	}
+	for _, ip := range failed {
+		if !s.failedIPs[ip] {
+			s.failedIPs[ip] = true
+			changed = true
+		}
+	}
+	if changed {
+		s.saveFailedIPs()
+	}
+}
+
+// retryFailedIPs re-attempts the IPs that could not be blocked before, on
+// their own so they are not lost in the general sweep, and forgets those
+// that are blocked or no longer need blocking (caller must hold the
+// manager's read lock). It returns the IPs it attempted.
+func (s *Scanner) retryFailedIPs() map[string]bool {
+	attempted := make(map[string]bool)
+	var pending, dropped []string
+	for _, ip := range s.failedIPList() {
+		ioc, exists := s.manager.IPAddresses[ip]
+		if !exists || !s.shouldBlock(ioc) || s.blocker.IsIPBlocked(ip) || s.blocker.IsIPSuppressed(ip) {
+			dropped = append(dropped, ip)
+			continue
+		}
+		pending = append(pending, ip)
+		attempted[ip] = true
+	}
+	s.updateFailedIPs(nil, dropped)
+
+	if len(pending) > 0 {
+		log.Printf("Retrying %d IPs that could not be blocked previously", len(pending))
+		blocked, failed := s.blockIPs(pending)
+		log.Printf("Retried previously failed IP blocks: %d/%d IPs successfully blocked", blocked, len(pending))
+		if len(failed) > 0 {
+			log.Printf("WARNING: %d IPs still could not be blocked: %v", len(failed), failed)
+		}
+	}
+	return attempted
+}
@@ -0,0 +1,396 @@
+package ioc
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"agent/config"
+)
+
+// defaultFeedSeverity is used for feed indicators that carry no severity
+const defaultFeedSeverity = "medium"
+
+// feedDebounce is how long a feed file must go unchanged before it is
+// imported, so a file still being copied in is not read half-written
+const feedDebounce = 2 * time.Second
+
+// ImportResult summarizes a feed file import. Errors holds one message per
+// rejected line or STIX object; the rest of the file is still imported.
+type ImportResult struct {
+	Path      string
+	Added     int
+	Updated   int
+	Unchanged int
+	Errors    []string
+}
+
+// ImportFromCSV merges indicators from a CSV feed with the columns
+// type,value,severity and an optional description. Type is ip, hash (or md5,
+// sha1, sha256, sha512) or url (or domain). A header row, blank lines and
+// lines starting with # are skipped. Indicators already present with the same
+// details are left alone, so importing a file again changes nothing.
+func (m *Manager) ImportFromCSV(path string) (*ImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open IOC feed: %v", err)
+	}
+	defer file.Close()
+
+	result := &ImportResult{Path: path}
+	var iocs []IOC
+
+	reader := csv.NewReader(file)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*csv.ParseError); ok {
+				result.Errors = append(result.Errors, err.Error())
+				continue
+			}
+			return nil, fmt.Errorf("failed to read IOC feed: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		if len(record) > 0 && strings.EqualFold(strings.TrimSpace(record[0]), "type") {
+			continue // Header row
+		}
+		if len(record) < 3 {
+			result.Errors = append(result.Errors, fmt.Sprintf("line %d: expected type,value,severity[,description], got %d fields", line, len(record)))
+			continue
+		}
+
+		description := ""
+		if len(record) > 3 {
+			description = strings.TrimSpace(record[3])
+		}
+		ioc, err := feedIOC(record[0], record[1], record[2], description)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("line %d: %v", line, err))
+			continue
+		}
+		iocs = append(iocs, ioc)
+	}
+
+	m.mergeFeed(result, iocs, "csv:"+filepath.Base(path))
+	return result, nil
+}
+
+// stixBundle is the part of a STIX 2.1 bundle the importer reads
+type stixBundle struct {
+	Type    string       `json:"type"`
+	Objects []stixObject `json:"objects"`
+}
+
+// stixObject is a STIX 2.1 object; only indicators are imported
+type stixObject struct {
+	Type        string   `json:"type"`
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Pattern     string   `json:"pattern"`
+	PatternType string   `json:"pattern_type"`
+	Revoked     bool     `json:"revoked"`
+	Labels      []string `json:"labels"`
+	Severity    string   `json:"x_severity"`
+}
+
+// stixComparison matches one comparison of a STIX pattern, such as
+// [ipv4-addr:value = '10.0.0.1'] or [file:hashes.'SHA-256' = '...']
+var stixComparison = regexp.MustCompile(`([a-z0-9-]+):([A-Za-z0-9_.'-]+)\s*=\s*'((?:[^'\\]|\\.)*)'`)
+
+// ImportFromSTIX merges the indicators of a STIX 2.1 JSON bundle. Patterns
+// comparing ipv4-addr, ipv6-addr, domain-name, url values or file hashes are
+// imported; each comparison joined by OR becomes its own IOC. The severity
+// comes from x_severity or a low/medium/high/critical label. Revoked
+// indicators and other pattern types are skipped with an error entry.
+func (m *Manager) ImportFromSTIX(path string) (*ImportResult, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IOC feed: %v", err)
+	}
+
+	var bundle stixBundle
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		return nil, fmt.Errorf("failed to parse STIX bundle: %v", err)
+	}
+	if bundle.Type != "bundle" {
+		return nil, fmt.Errorf("not a STIX bundle (type %q)", bundle.Type)
+	}
+
+	result := &ImportResult{Path: path}
+	var iocs []IOC
+
+	for i, obj := range bundle.Objects {
+		if obj.Type != "indicator" {
+			continue
+		}
+		where := fmt.Sprintf("object %d (%s)", i, obj.ID)
+		if obj.Revoked {
+			result.Errors = append(result.Errors, where+": indicator is revoked, skipped")
+			continue
+		}
+		if obj.PatternType != "" && obj.PatternType != "stix" {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: unsupported pattern_type %q", where, obj.PatternType))
+			continue
+		}
+
+		severity := obj.Severity
+		if severity == "" {
+			for _, label := range obj.Labels {
+				if containsSeverity(strings.ToLower(label)) {
+					severity = label
+					break
+				}
+			}
+		}
+		description := obj.Description
+		if description == "" {
+			description = obj.Name
+		}
+
+		comparisons := stixComparison.FindAllStringSubmatch(obj.Pattern, -1)
+		if len(comparisons) == 0 {
+			result.Errors = append(result.Errors, fmt.Sprintf("%s: no supported comparison in pattern %q", where, obj.Pattern))
+			continue
+		}
+		for _, c := range comparisons {
+			iocType, err := stixIOCType(c[1], c[2])
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", where, err))
+				continue
+			}
+			value := strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(c[3])
+			ioc, err := feedIOC(iocType, value, severity, description)
+			if err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", where, err))
+				continue
+			}
+			iocs = append(iocs, ioc)
+		}
+	}
+
+	m.mergeFeed(result, iocs, "stix:"+filepath.Base(path))
+	return result, nil
+}
+
+// stixIOCType maps a STIX object path to a feed indicator type
+func stixIOCType(object, property string) (string, error) {
+	switch {
+	case (object == "ipv4-addr" || object == "ipv6-addr") && property == "value":
+		return "ip", nil
+	case (object == "domain-name" || object == "url") && property == "value":
+		return "url", nil
+	case object == "file" && strings.HasPrefix(property, "hashes."):
+		return "hash", nil
+	}
+	return "", fmt.Errorf("unsupported comparison %s:%s", object, property)
+}
+
+// feedIOC validates one feed indicator and builds its IOC
+func feedIOC(iocType, value, severity, description string) (IOC, error) {
+	iocType = strings.ToLower(strings.TrimSpace(iocType))
+	value = strings.TrimSpace(value)
+	severity = strings.ToLower(strings.TrimSpace(severity))
+
+	if value == "" {
+		return IOC{}, fmt.Errorf("empty value")
+	}
+	if severity == "" {
+		severity = defaultFeedSeverity
+	}
+	if !containsSeverity(severity) {
+		return IOC{}, fmt.Errorf("unknown severity %q, must be one of: %s", severity, strings.Join(config.RemediationSeverities, ", "))
+	}
+
+	ioc := IOC{Description: description, Severity: severity}
+	switch iocType {
+	case "ip":
+		if net.ParseIP(strings.Trim(value, "[]")) == nil {
+			return IOC{}, fmt.Errorf("invalid IP address %q", value)
+		}
+		ioc.Type = TypeIP
+		ioc.Value = NormalizeIP(value)
+	case "hash", "file_hash", HashMD5, HashSHA1, HashSHA256, HashSHA512:
+		ioc.Type = TypeFileHash
+		ioc.Value = strings.ToLower(value)
+		hashType := hashTypeOf(ioc)
+		if _, err := hex.DecodeString(ioc.Value); err != nil || hashType == "" {
+			return IOC{}, fmt.Errorf("%q is not an MD5, SHA1, SHA256 or SHA512 hash", value)
+		}
+		ioc.Metadata = map[string]string{"hash_type": hashType}
+	case "url", "domain":
+		ioc.Type = TypeURL
+		ioc.Value = strings.ToLower(value)
+		if _, err := compileURLMatcher(ioc); err != nil {
+			return IOC{}, err
+		}
+	default:
+		return IOC{}, fmt.Errorf("unknown type %q, must be ip, hash or url", iocType)
+	}
+	return ioc, nil
+}
+
+// containsSeverity reports whether s is one of the remediation severities
+func containsSeverity(s string) bool {
+	for _, severity := range config.RemediationSeverities {
+		if s == severity {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeFeed adds feed indicators to the IOC set, bumping the version and
+// saving only when something changed
+func (m *Manager) mergeFeed(result *ImportResult, iocs []IOC, source string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ioc := range iocs {
+		if ioc.Metadata == nil {
+			ioc.Metadata = make(map[string]string)
+		}
+		ioc.Metadata["source"] = source
+
+		var set map[string]IOC
+		switch ioc.Type {
+		case TypeIP:
+			set = m.IPAddresses
+		case TypeFileHash:
+			set = m.FileHashes
+		case TypeURL:
+			set = m.URLs
+		}
+
+		existing, ok := set[ioc.Value]
+		switch {
+		case !ok:
+			result.Added++
+		case sameIOC(existing, ioc):
+			result.Unchanged++
+			continue
+		default:
+			result.Updated++
+		}
+		set[ioc.Value] = ioc
+	}
+
+	if result.Added == 0 && result.Updated == 0 {
+		return
+	}
+
+	m.rebuildURLMatchersUnlocked()
+	m.rebuildHashFilterUnlocked()
+	m.Version++
+	if err := m.saveToFileUnlocked(); err != nil {
+		log.Printf("WARNING: Failed to save IOCs imported from %s: %v", result.Path, err)
+	}
+}
+
+// sameIOC reports whether two IOCs have identical details
+func sameIOC(a, b IOC) bool {
+	if a.Value != b.Value || a.Type != b.Type || a.Description != b.Description || a.Severity != b.Severity {
+		return false
+	}
+	if len(a.Metadata) != len(b.Metadata) {
+		return false
+	}
+	for k, v := range a.Metadata {
+		if b.Metadata[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ImportFeedFile imports a .csv or STIX .json feed file by its extension and
+// logs the outcome, including each rejected line. ok is false for files that
+// are not feeds or failed to import.
+func (m *Manager) ImportFeedFile(path string) (result *ImportResult, ok bool) {
+	var err error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		result, err = m.ImportFromCSV(path)
+	case ".json":
+		result, err = m.ImportFromSTIX(path)
+	default:
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("ERROR: Failed to import IOC feed %s: %v", path, err)
+		return nil, false
+	}
+
+	for _, msg := range result.Errors {
+		log.Printf("IOC feed %s: %s", path, msg)
+	}
+	log.Printf("Imported IOC feed %s: %d added, %d updated, %d unchanged, %d rejected",
+		path, result.Added, result.Updated, result.Unchanged, len(result.Errors))
+	return result, true
+}
+
+// WatchFeedDir imports every feed file in dir, then re-imports files as they
+// are added or changed until ctx is cancelled. Subdirectories are ignored. onChange is called after each
+// import that added or updated indicators.
+func (m *Manager) WatchFeedDir(ctx context.Context, dir string, onChange func()) {
+	imported := func(path string) {
+		if result, ok := m.ImportFeedFile(path); ok && result.Added+result.Updated > 0 {
+			onChange()
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("ERROR: Failed to read IOC feed directory %s: %v", dir, err)
+	} else {
+		for _, entry := range entries {
+			if entry.Type().IsRegular() {
+				imported(filepath.Join(dir, entry.Name()))
+			}
+		}
+	}
+
+	var mu sync.Mutex
+	pending := make(map[string]*time.Timer)
+	err = watchTree(ctx, dir, func(string) bool { return true }, func(path string) {
+		if filepath.Dir(path) != filepath.Clean(dir) {
+			return
+		}
+		
+		mu.Lock()
+		defer mu.Unlock()
+		if timer, ok := pending[path]; ok {
+			timer.Reset(feedDebounce)
+			return
+		}
+		pending[path] = time.AfterFunc(feedDebounce, func() {
+			mu.Lock()
+			delete(pending, path)
+			mu.Unlock()
+			if ctx.Err() == nil {
+				imported(path)
+			}
+		})
+	})
+	if err != nil && ctx.Err() == nil {
+		log.Printf("ERROR: Failed to watch IOC feed directory %s, feeds are only imported at startup: %v", dir, err)
+	}
+}
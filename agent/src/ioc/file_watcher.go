@@ -0,0 +1,195 @@
+package ioc
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// watchDebounce is how long a file must go without changes before it is
+	// hashed, so a file written in many chunks is only scanned once
+	watchDebounce = 2 * time.Second
+
+	// maxPendingWatchFiles caps the files waiting out their debounce delay;
+	// changes beyond it are dropped and left to the periodic scans
+	maxPendingWatchFiles = 10000
+)
+
+// FileWatcher scans files under watch_paths as soon as they are created or
+// written, in addition to the periodic Sysmon scans. Changes are debounced
+// per file and handled like files found by SCAN_PATH.
+type FileWatcher struct {
+	scanner *Scanner
+	paths   []string
+	ignore  []string // Agent files whose writes would trigger their own scans
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	mu      sync.Mutex // Guards pending and dropped
+	pending map[string]*time.Timer
+	dropped int
+	sem     chan struct{} // Bounds files hashed at once to scan_workers
+}
+
+// NewFileWatcher creates a watcher for the given directory trees
+func NewFileWatcher(scanner *Scanner, paths []string) *FileWatcher {
+	ctx, cancel := context.WithCancel(scanner.ctx)
+
+	workers := scanner.config.Current().ScanWorkers
+	if workers < 1 {
+		workers = 1
+	}
+
+	var ignore []string
+	for _, p := range []string{scanner.config.DataDir, scanner.config.LogFile} {
+		if p == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(p); err == nil {
+			ignore = append(ignore, abs)
+		}
+	}
+
+	return &FileWatcher{
+		scanner: scanner,
+		paths:   paths,
+		ignore:  ignore,
+		ctx:     ctx,
+		cancel:  cancel,
+		pending: make(map[string]*time.Timer),
+		sem:     make(chan struct{}, workers),
+	}
+}
+
+// Start begins watching each path in the background. A path that cannot be
+// watched is logged and skipped.
+func (w *FileWatcher) Start() {
+	for _, root := range w.paths {
+		root = filepath.Clean(root)
+		log.Printf("Watching %s for new and modified files", root)
+
+		w.wg.Add(1)
+		go func(root string) {
+			defer w.wg.Done()
+			err := watchTree(w.ctx, root, func(dir string) bool {
+				return w.skipped(root, dir)
+			}, func(path string) {
+				w.fileChanged(root, path)
+			})
+			if err != nil && w.ctx.Err() == nil {
+				log.Printf("Failed to watch %s: %v", root, err)
+			}
+		}(root)
+	}
+}
+
+// Stop stops watching and discards changes still waiting to be scanned
+func (w *FileWatcher) Stop() {
+	w.cancel()
+	w.wg.Wait()
+
+	w.mu.Lock()
+	for path, timer := range w.pending {
+		timer.Stop()
+		delete(w.pending, path)
+	}
+	w.mu.Unlock()
+}
+
+// skipped reports whether a path below root is excluded by scan_exclusions,
+// directly or through one of its parent directories, or belongs to the agent
+func (w *FileWatcher) skipped(root, path string) bool {
+	for _, ignored := range w.ignore {
+		if samePathOrBelow(path, ignored) {
+			return true
+		}
+	}
+
+	for p := path; p != root && len(p) > len(root); p = filepath.Dir(p) {
+		if w.scanner.isExcluded(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileChanged schedules a scan of path once it has stopped changing
+func (w *FileWatcher) fileChanged(root, path string) {
+	if w.skipped(root, path) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if timer, ok := w.pending[path]; ok {
+		timer.Reset(watchDebounce)
+		return
+	}
+
+	if len(w.pending) >= maxPendingWatchFiles {
+		w.dropped++
+		if w.dropped == 1 || w.dropped%1000 == 0 {
+			log.Printf("File watcher has %d files waiting, dropped %d changes", len(w.pending), w.dropped)
+		}
+		return
+	}
+
+	w.pending[path] = time.AfterFunc(watchDebounce, func() {
+		w.mu.Lock()
+		delete(w.pending, path)
+		w.mu.Unlock()
+		w.scanFile(path)
+	})
+}
+
+// scanFile hashes a changed file and handles it like a SCAN_PATH match
+func (w *FileWatcher) scanFile(path string) {
+	select {
+	case w.sem <- struct{}{}:
+		defer func() { <-w.sem }()
+	case <-w.ctx.Done():
+		return
+	}
+
+	// The file may have been removed or replaced by a directory or link
+	// while the change was debounced
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return
+	}
+
+	hashValue, ioc, matched, err := w.scanner.matchFileHash(path)
+	if errors.Is(err, errFileTooLarge) {
+		return
+	} else if err != nil {
+		log.Printf("File watcher could not hash %s: %v", path, err)
+		return
+	}
+
+	if matched {
+		log.Printf("File watcher found malicious file %s", path)
+		w.scanner.handleMaliciousFile(w.scanner.ctx, path, hashValue, &ioc, nil)
+	} else {
+		w.scanner.scanFileWithYara(w.scanner.ctx, path)
+	}
+}
+
+// samePathOrBelow reports whether path is dir or inside it
+func samePathOrBelow(path, dir string) bool {
+	if runtime.GOOS == "windows" {
+		path = strings.ToLower(path)
+		dir = strings.ToLower(dir)
+	}
+	if path == dir {
+		return true
+	}
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
@@ -0,0 +1,124 @@
+// +build linux
+
+package ioc
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// watchMask selects the inotify events that mean a file has new contents
+	watchMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE
+
+	// watchPollMillis is how often the read loop checks for cancellation
+	watchPollMillis = 500
+)
+
+// watchTree reports files created, written or moved in anywhere under root
+// until ctx is cancelled. inotify watches single directories, so every
+// directory below root that skipDir allows gets its own watch, including
+// ones created later.
+func watchTree(ctx context.Context, root string, skipDir func(string) bool, onChange func(string)) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	dirs := make(map[int]string) // Watch descriptor to directory
+	addTree := func(dir string, report bool) {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if !d.IsDir() {
+				// Files created before the directory was watched
+				if report {
+					onChange(path)
+				}
+				return nil
+			}
+			if path != root && skipDir(path) {
+				return filepath.SkipDir
+			}
+			wd, err := unix.InotifyAddWatch(fd, path, watchMask)
+			if err != nil {
+				log.Printf("Failed to watch %s: %v", path, err)
+				return filepath.SkipDir
+			}
+			dirs[wd] = path
+			return nil
+		})
+	}
+
+	if _, err := os.Stat(root); err != nil {
+		return err
+	}
+	addTree(root, false)
+
+	buf := make([]byte, 64*1024)
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		ready, err := unix.Poll(fds, watchPollMillis)
+		if err == unix.EINTR || ready == 0 {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		n, err := unix.Read(fd, buf)
+		if err == unix.EAGAIN || err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				log.Printf("File watcher for %s missed changes (queue overflow), they are left to the periodic scans", root)
+				continue
+			}
+			if event.Mask&unix.IN_IGNORED != 0 {
+				delete(dirs, int(event.Wd))
+				continue
+			}
+
+			dir, ok := dirs[int(event.Wd)]
+			if !ok || event.Len == 0 {
+				continue
+			}
+			name := string(buf[nameStart : nameStart+int(event.Len)])
+			for len(name) > 0 && name[len(name)-1] == 0 {
+				name = name[:len(name)-1]
+			}
+			path := filepath.Join(dir, name)
+
+			switch {
+			case event.Mask&unix.IN_ISDIR != 0:
+				if event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+					addTree(path, true)
+				}
+			case event.Mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO) != 0:
+				// IN_CREATE alone is skipped, the file is reported once it
+				// is closed after writing
+				onChange(path)
+			}
+		}
+	}
+}
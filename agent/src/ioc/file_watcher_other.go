@@ -0,0 +1,14 @@
+// +build !windows,!linux
+
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// watchTree is only implemented for Windows and Linux
+func watchTree(ctx context.Context, root string, skipDir func(string) bool, onChange func(string)) error {
+	return fmt.Errorf("file watching is not supported on %s", runtime.GOOS)
+}
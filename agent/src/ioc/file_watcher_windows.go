@@ -0,0 +1,80 @@
+// +build windows
+
+package ioc
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// watchBufferSize is the ReadDirectoryChangesW buffer. Changes that do not
+// fit before the next read are lost and reported as an overflow.
+const watchBufferSize = 64 * 1024
+
+// watchTree reports files created, written or renamed anywhere under root
+// until ctx is cancelled. ReadDirectoryChangesW watches the whole tree with
+// one handle, so skipDir is not used; onChange filters excluded paths.
+func watchTree(ctx context.Context, root string, skipDir func(string) bool, onChange func(string)) error {
+	root16, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return err
+	}
+	handle, err := windows.CreateFile(root16,
+		windows.FILE_LIST_DIRECTORY,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil,
+		windows.OPEN_EXISTING,
+		windows.FILE_FLAG_BACKUP_SEMANTICS,
+		0)
+	if err != nil {
+		return err
+	}
+
+	// The read below blocks, so cancellation aborts it from here
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			windows.CancelIoEx(handle, nil)
+		case <-done:
+		}
+	}()
+	defer windows.CloseHandle(handle)
+
+	buf := make([]byte, watchBufferSize)
+	for {
+		var n uint32
+		err := windows.ReadDirectoryChanges(handle, &buf[0], uint32(len(buf)), true,
+			windows.FILE_NOTIFY_CHANGE_FILE_NAME|windows.FILE_NOTIFY_CHANGE_LAST_WRITE, &n, nil, 0)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			log.Printf("File watcher for %s missed changes (buffer overflow), they are left to the periodic scans", root)
+			continue
+		}
+
+		for offset := uint32(0); ; {
+			info := (*windows.FileNotifyInformation)(unsafe.Pointer(&buf[offset]))
+			name := windows.UTF16ToString(unsafe.Slice(&info.FileName, info.FileNameLength/2))
+
+			switch info.Action {
+			case windows.FILE_ACTION_ADDED, windows.FILE_ACTION_MODIFIED, windows.FILE_ACTION_RENAMED_NEW_NAME:
+				onChange(filepath.Join(root, name))
+			}
+
+			if info.NextEntryOffset == 0 {
+				break
+			}
+			offset += info.NextEntryOffset
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package ioc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"agent/config"
+	"agent/persist"
+)
+
+// FIM change kinds
+const (
+	FIMAdded    = "added"
+	FIMModified = "modified"
+	FIMDeleted  = "deleted"
+)
+
+// FIMChange is a difference between a watched file and its baseline
+type FIMChange struct {
+	Path      string
+	Change    string // FIMAdded, FIMModified or FIMDeleted
+	OldSHA256 string
+	NewSHA256 string
+}
+
+// fimBaseline is the persisted state of the FIM watchlist. Roots are the
+// fim_paths entries that were baselined, Files the hash of every file under
+// them.
+type fimBaseline struct {
+	Roots     []string          `json:"roots"`
+	Files     map[string]string `json:"files"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// FIMMonitor reports changes to the files listed in fim_paths. A path is a
+// file, or a directory whose files (not subdirectories) are watched. The
+// first check of a path records its baseline; later checks report files
+// added, modified or deleted since then until the path is re-baselined.
+type FIMMonitor struct {
+	config   *config.Config
+	path     string
+	mu       sync.Mutex
+	baseline fimBaseline
+	reported map[string]string // Last reported hash per path, so a change is reported once
+	report   func(FIMChange)
+}
+
+// NewFIMMonitor creates a monitor whose baseline is kept in
+// <dataDir>/fim_baseline.json
+func NewFIMMonitor(cfg *config.Config) *FIMMonitor {
+	m := &FIMMonitor{
+		config:   cfg,
+		path:     filepath.Join(cfg.DataDir, "fim_baseline.json"),
+		baseline: fimBaseline{Files: make(map[string]string)},
+		reported: make(map[string]string),
+	}
+	m.load()
+	return m
+}
+
+// SetReporter sets the function told about each change
+func (m *FIMMonitor) SetReporter(report func(FIMChange)) {
+	m.report = report
+}
+
+// load restores the baseline from disk
+func (m *FIMMonitor) load() {
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return
+	} else if err != nil {
+		log.Printf("Failed to read FIM baseline: %v", err)
+		return
+	}
+
+	var baseline fimBaseline
+	if err := json.Unmarshal(data, &baseline); err != nil {
+		log.Printf("Failed to parse FIM baseline, re-baselining: %v", err)
+		return
+	}
+	if baseline.Files == nil {
+		baseline.Files = make(map[string]string)
+	}
+	m.baseline = baseline
+	log.Printf("Loaded FIM baseline of %d files", len(baseline.Files))
+}
+
+// save writes the baseline to disk. The caller must hold m.mu.
+func (m *FIMMonitor) save() error {
+	data, err := json.MarshalIndent(m.baseline, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode FIM baseline: %v", err)
+	}
+	if err := persist.WriteFileAtomic(m.path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write FIM baseline: %v", err)
+	}
+	return nil
+}
+
+// Check hashes every watched file and reports differences from the baseline.
+// Paths that are not in the baseline yet are baselined without reporting.
+func (m *FIMMonitor) Check() []FIMChange {
+	roots := m.config.Current().FIMPaths
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(roots) == 0 && len(m.baseline.Roots) == 0 {
+		return nil
+	}
+
+	// Baseline new roots and forget the ones removed from fim_paths
+	known := make(map[string]bool, len(m.baseline.Roots))
+	for _, root := range m.baseline.Roots {
+		known[root] = true
+	}
+	changedRoots := len(roots) != len(m.baseline.Roots)
+	current := make(map[string]string)
+	for _, root := range roots {
+		files := hashFIMRoot(root)
+		if !known[root] {
+			log.Printf("FIM: baselined %d files under %s", len(files), root)
+			for path, hash := range files {
+				m.baseline.Files[path] = hash
+			}
+			changedRoots = true
+		}
+		for path, hash := range files {
+			current[path] = hash
+		}
+	}
+	if changedRoots {
+		m.baseline.Roots = append([]string(nil), roots...)
+		for path := range m.baseline.Files {
+			if !underFIMRoots(path, roots) {
+				delete(m.baseline.Files, path)
+			}
+		}
+		if m.baseline.CreatedAt.IsZero() {
+			m.baseline.CreatedAt = time.Now()
+		}
+		if err := m.save(); err != nil {
+			log.Printf("FIM: %v", err)
+		}
+	}
+
+	var changes []FIMChange
+	for path, hash := range current {
+		old, ok := m.baseline.Files[path]
+		switch {
+		case !ok:
+			changes = append(changes, FIMChange{Path: path, Change: FIMAdded, NewSHA256: hash})
+		case old != hash:
+			changes = append(changes, FIMChange{Path: path, Change: FIMModified, OldSHA256: old, NewSHA256: hash})
+		}
+	}
+	for path, old := range m.baseline.Files {
+		if _, ok := current[path]; !ok {
+			changes = append(changes, FIMChange{Path: path, Change: FIMDeleted, OldSHA256: old})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+
+	// Report each state once; a file changed back to its baseline is quiet again
+	var fresh []FIMChange
+	for _, change := range changes {
+		if m.reported[change.Path] == change.NewSHA256+change.Change {
+			continue
+		}
+		m.reported[change.Path] = change.NewSHA256 + change.Change
+		fresh = append(fresh, change)
+	}
+	for path := range m.reported {
+		if current[path] == m.baseline.Files[path] {
+			delete(m.reported, path)
+		}
+	}
+
+	for _, change := range fresh {
+		log.Printf("FIM: %s %s", change.Path, change.Change)
+		if m.report != nil {
+			m.report(change)
+		}
+	}
+	return fresh
+}
+
+// Rebaseline records the current hashes of the files under path, or of all
+// watched files when path is empty, as approved. It returns the number of
+// files baselined.
+func (m *FIMMonitor) Rebaseline(path string) (int, error) {
+	roots := m.config.Current().FIMPaths
+	if path != "" {
+		path = filepath.Clean(path)
+		if !underFIMRoots(path, roots) {
+			return 0, fmt.Errorf("%s is not under fim_paths", path)
+		}
+		roots = []string{path}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if path == "" {
+		m.baseline.Roots = append([]string(nil), roots...)
+		m.baseline.Files = make(map[string]string)
+		m.reported = make(map[string]string)
+	}
+	count := 0
+	for _, root := range roots {
+		for file := range m.baseline.Files {
+			if underFIMRoots(file, []string{root}) {
+				delete(m.baseline.Files, file)
+			}
+		}
+		for file, hash := range hashFIMRoot(root) {
+			m.baseline.Files[file] = hash
+			count++
+		}
+		for file := range m.reported {
+			if underFIMRoots(file, []string{root}) {
+				delete(m.reported, file)
+			}
+		}
+	}
+	m.baseline.CreatedAt = time.Now()
+
+	if err := m.save(); err != nil {
+		return 0, err
+	}
+	log.Printf("FIM: re-baselined %d files", count)
+	return count, nil
+}
+
+// hashFIMRoot returns the SHA256 of root, or of each regular file directly
+// inside root when it is a directory. A missing root has no files.
+func hashFIMRoot(root string) map[string]string {
+	files := make(map[string]string)
+	info, err := os.Stat(root)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("FIM: cannot stat %s: %v", root, err)
+		}
+		return files
+	}
+
+	if !info.IsDir() {
+		if hash, err := hashFIMFile(root); err == nil {
+			files[root] = hash
+		} else {
+			log.Printf("FIM: cannot hash %s: %v", root, err)
+		}
+		return files
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		log.Printf("FIM: cannot list %s: %v", root, err)
+		return files
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		path := filepath.Join(root, entry.Name())
+		if hash, err := hashFIMFile(path); err == nil {
+			files[path] = hash
+		} else {
+			log.Printf("FIM: cannot hash %s: %v", path, err)
+		}
+	}
+	return files
+}
+
+// hashFIMFile returns the SHA256 of a file
+func hashFIMFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// underFIMRoots reports whether path is one of roots or a file directly
+// inside one of them
+func underFIMRoots(path string, roots []string) bool {
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if path == root || filepath.Dir(path) == root {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"agent/config"
+	pb "agent/proto"
+)
+
+// HashKill is a running process whose executable image matched a hash
+type HashKill struct {
+	PID     int32  `json:"pid"`
+	Image   string `json:"image"`
+	Hash    string `json:"hash"`
+	Process string `json:"process"`         // DescribeProcess, taken before the kill
+	Error   string `json:"error,omitempty"` // Why the process could not be killed
+}
+
+// HashKillResult is the outcome of KillProcessesByHash
+type HashKillResult struct {
+	Scanned int        `json:"scanned"` // Processes whose image was hashed
+	Skipped int        `json:"skipped"` // Processes that exited or whose image could not be read
+	Killed  []HashKill `json:"killed"`
+	Failed  []HashKill `json:"failed,omitempty"` // Matching processes that are still running
+}
+
+// KillProcessesByHash hashes the executable image of every running process
+// and kills the ones whose MD5, SHA1, SHA256 or SHA512 is in hashes. Each
+// image is hashed once however many processes run it. Processes that exit
+// or cannot be opened during the scan are counted as skipped.
+func KillProcessesByHash(ctx context.Context, hashes []string) (*HashKillResult, error) {
+	wanted := make(map[string]bool)
+	var types []string
+	for _, hash := range hashes {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		t := hashTypeOf(IOC{Value: hash})
+		if t == "" {
+			return nil, fmt.Errorf("unsupported hash %q, expected an MD5, SHA1, SHA256 or SHA512 digest", hash)
+		}
+		if !containsString(types, t) {
+			types = append(types, t)
+		}
+		wanted[hash] = true
+	}
+	if len(wanted) == 0 {
+		return nil, fmt.Errorf("no hashes given")
+	}
+
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate processes: %v", err)
+	}
+
+	result := &HashKillResult{Killed: []HashKill{}}
+	digests := make(map[string]map[string]string) // Image path -> digest per algorithm
+	self := int32(os.Getpid())
+	for _, p := range procs {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if p.Pid == self {
+			continue
+		}
+
+		exe, err := p.ExeWithContext(ctx)
+		if err != nil || exe == "" {
+			result.Skipped++
+			continue
+		}
+		d, seen := digests[exe]
+		if !seen {
+			if d, err = processImageDigests(p.Pid, exe, types); err != nil {
+				result.Skipped++
+				continue
+			}
+			digests[exe] = d
+		}
+		result.Scanned++
+
+		match := ""
+		for _, digest := range d {
+			if wanted[digest] {
+				match = digest
+				break
+			}
+		}
+		if match == "" {
+			continue
+		}
+
+		// Describe it first, its command line and user are gone once it exits
+		kill := HashKill{PID: p.Pid, Image: exe, Hash: match, Process: DescribeProcess(p)}
+		if err := p.KillWithContext(ctx); err != nil {
+			if running, _ := p.IsRunningWithContext(ctx); !running {
+				result.Skipped++
+				continue
+			}
+			log.Printf("Failed to kill process %s matching hash %s: %v", kill.Process, match, err)
+			kill.Error = err.Error()
+			result.Failed = append(result.Failed, kill)
+			continue
+		}
+		log.Printf("Killed process %s matching hash %s", kill.Process, match)
+		result.Killed = append(result.Killed, kill)
+	}
+	return result, nil
+}
+
+// processImageDigests hashes the executable a process runs. On Linux the
+// image is read through /proc, which still works after the file on disk has
+// been deleted or replaced.
+func processImageDigests(pid int32, exe string, types []string) (map[string]string, error) {
+	path := exe
+	if runtime.GOOS == "linux" {
+		path = fmt.Sprintf("/proc/%d/exe", pid)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return fileDigests(file, types)
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// KillRunningHashMatches kills running processes whose image matches a file
+// hash IOC with the kill_and_delete remediation action, which catches copies
+// still running after their file was deleted. It is run after IOC updates.
+func (s *Scanner) KillRunningHashMatches() {
+	s.manager.mu.RLock()
+	var hashes []string
+	for hash, ioc := range s.manager.FileHashes {
+		if s.config.RemediationAction(ioc.Severity) == config.RemediationKillAndDelete && hashTypeOf(ioc) != "" {
+			hashes = append(hashes, hash)
+		}
+	}
+	s.manager.mu.RUnlock()
+	if len(hashes) == 0 {
+		return
+	}
+
+	s.remediationMu.Lock()
+	result, err := KillProcessesByHash(s.ctx, hashes)
+	s.remediationMu.Unlock()
+	if err != nil {
+		log.Printf("Failed to check running processes against file hash IOCs: %v", err)
+		return
+	}
+	log.Printf("Checked %d running processes against %d file hash IOCs, killed %d", result.Scanned, len(hashes), len(result.Killed))
+
+	for _, kill := range append(result.Killed, result.Failed...) {
+		_, ioc := s.manager.CheckFileHash(kill.Hash)
+		s.recordMatches(1)
+		if s.reportCallback != nil {
+			s.reportCallback(
+				s.ctx,
+				pb.IOCType_IOC_HASH,
+				ioc.Value,
+				kill.Hash,
+				fmt.Sprintf("Malicious process: %s (action: %s, killed: %v)", kill.Process, config.RemediationKillAndDelete, kill.Error == ""),
+				ioc.Severity,
+			)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package ioc
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"strings"
+
+	"agent/logging"
+)
+
+// errFileTooLarge is returned by matchFileHash for files above max_hash_file_bytes
+var errFileTooLarge = errors.New("file exceeds max_hash_file_bytes")
+
+// Hash algorithms file hash IOCs can use
+const (
+	HashMD5    = "md5"
+	HashSHA1   = "sha1"
+	HashSHA256 = "sha256"
+	HashSHA512 = "sha512"
+)
+
+// supportedHashTypes maps each algorithm to its hex digest length
+var supportedHashTypes = []struct {
+	name   string
+	hexLen int
+	newFn  func() hash.Hash
+}{
+	{HashMD5, 32, md5.New},
+	{HashSHA1, 40, sha1.New},
+	{HashSHA256, 64, sha256.New},
+	{HashSHA512, 128, sha512.New},
+}
+
+// hashTypeOf returns the algorithm of a file hash IOC. The digest length
+// decides, since feeds often leave hash_type at its sha256 default for MD5
+// values; the metadata is only used for lengths no algorithm has.
+func hashTypeOf(ioc IOC) string {
+	for _, t := range supportedHashTypes {
+		if len(ioc.Value) == t.hexLen {
+			return t.name
+		}
+	}
+	name := strings.ReplaceAll(strings.ToLower(ioc.Metadata["hash_type"]), "-", "")
+	for _, t := range supportedHashTypes {
+		if name == t.name {
+			return t.name
+		}
+	}
+	return ""
+}
+
+// ActiveHashTypes returns the hash algorithms used by the loaded file hash
+// IOCs, so the scanner only computes digests that can match
+func (m *Manager) ActiveHashTypes() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]string(nil), m.hashTypes...)
+}
+
+// rebuildHashTypesUnlocked recomputes the active hash algorithms from the file
+// hash IOCs (caller must hold the write lock)
+func (m *Manager) rebuildHashTypesUnlocked() {
+	present := make(map[string]bool)
+	for _, ioc := range m.FileHashes {
+		if t := hashTypeOf(ioc); t != "" {
+			present[t] = true
+		}
+	}
+
+	m.hashTypes = nil
+	for _, t := range supportedHashTypes {
+		if present[t.name] {
+			m.hashTypes = append(m.hashTypes, t.name)
+		}
+	}
+}
+
+// addHashTypeUnlocked adds the algorithm of ioc to the active set (caller must
+// hold the write lock)
+func (m *Manager) addHashTypeUnlocked(ioc IOC) {
+	t := hashTypeOf(ioc)
+	if t == "" {
+		return
+	}
+	for _, existing := range m.hashTypes {
+		if existing == t {
+			return
+		}
+	}
+	m.hashTypes = append(m.hashTypes, t)
+}
+
+// fileDigests reads a file once and returns its hex digest for each of the
+// named algorithms
+func fileDigests(r io.Reader, types []string) (map[string]string, error) {
+	hashers := make(map[string]hash.Hash, len(types))
+	writers := make([]io.Writer, 0, len(types))
+	for _, name := range types {
+		for _, t := range supportedHashTypes {
+			if t.name == name {
+				h := t.newFn()
+				hashers[name] = h
+				writers = append(writers, h)
+			}
+		}
+	}
+	if len(writers) == 0 {
+		return nil, nil
+	}
+
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string, len(hashers))
+	for name, h := range hashers {
+		digests[name] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests, nil
+}
+
+// matchFileHash hashes a file with each algorithm in use by the file hash
+// IOCs and checks the digests. It returns the matching digest and its IOC;
+// when there are no file hash IOCs the file is not read at all. Files larger
+// than max_hash_file_bytes are skipped with errFileTooLarge.
+func (s *Scanner) matchFileHash(filePath string) (string, IOC, bool, error) {
+	return s.hashAndMatch(filePath, s.config.Current().MaxHashFileBytes)
+}
+
+// matchFileHashAnySize is matchFileHash without the size limit, for files an
+// operator asked to scan
+func (s *Scanner) matchFileHashAnySize(filePath string) (string, IOC, bool, error) {
+	return s.hashAndMatch(filePath, 0)
+}
+
+// hashAndMatch implements matchFileHash for files up to maxBytes (0 = no limit)
+func (s *Scanner) hashAndMatch(filePath string, maxBytes int64) (string, IOC, bool, error) {
+	types := s.manager.ActiveHashTypes()
+	if len(types) == 0 {
+		return "", IOC{}, false, nil
+	}
+	
+	// Check the size before opening so large files cost a stat, not a read
+	if maxBytes > 0 {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return "", IOC{}, false, err
+		}
+		if info.Size() > maxBytes {
+			logging.Debug().
+				Str("path", filePath).
+				Int64("size", info.Size()).
+				Int64("max_hash_file_bytes", maxBytes).
+				Msg("Skipping hash of large file")
+			return "", IOC{}, false, errFileTooLarge
+		}
+	}
+
+	// Pace file opens so bursts of Sysmon events or large directory scans
+	// stay within the configured CPU share
+	s.throttle.wait()
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", IOC{}, false, err
+	}
+	defer file.Close()
+
+	digests, err := fileDigests(s.throttle.reader(file), types)
+	if err != nil {
+		return "", IOC{}, false, err
+	}
+	s.recordFileHashed()
+
+	for _, name := range types {
+		if match, ioc := s.manager.CheckFileHash(digests[name]); match {
+			return digests[name], ioc, true, nil
+		}
+	}
+	return "", IOC{}, false, nil
+}
@@ -1,15 +1,20 @@
 package ioc
 
 import (
+	"crypto/ed25519"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	pb "agent/proto"
+	"agent/persist"
 )
 
 // IOCType represents the type of IOC
@@ -30,6 +35,24 @@ type IOC struct {
 	Metadata    map[string]string `json:"metadata,omitempty"`
 }
 
+// iocSchemaVersion is the layout of iocs.json written by this agent. Files
+// from before schema_version was added have no version and are treated as
+// version 1.
+const iocSchemaVersion = 2
+
+// ErrUnsupportedSchema is returned by LoadFromFile when iocs.json was
+// written by a newer agent whose layout this one does not understand
+var ErrUnsupportedSchema = errors.New("IOC database schema is newer than this agent supports")
+
+// iocFile is the on-disk layout of iocs.json
+type iocFile struct {
+	SchemaVersion int            `json:"schema_version"`
+	IPAddresses   map[string]IOC `json:"ip_addresses"`
+	FileHashes    map[string]IOC `json:"file_hashes"`
+	URLs          map[string]IOC `json:"urls"`
+	Version       int64          `json:"version"`
+}
+
 // Manager manages IOCs locally on the agent
 type Manager struct {
 	IPAddresses  map[string]IOC `json:"ip_addresses"`
@@ -37,11 +60,28 @@ type Manager struct {
 	URLs         map[string]IOC `json:"urls"`
 	Version      int64          `json:"version"`
 	StoragePath  string         `json:"-"`
+	Integrity    *persist.Integrity `json:"-"` // Signs and verifies the checksum of iocs.json
 	mu           sync.RWMutex   `json:"-"`
+	
+	// urlIndex holds the compiled URL IOCs, nil when there are none
+	urlIndex     *urlIndex
+	
+	// hashFilter screens file hash lookups before the map, nil when there
+	// are no file hash IOCs
+	hashFilter   atomic.Pointer[bloomFilter]
+	
+	// hashTypes lists the hash algorithms used by the file hash IOCs
+	hashTypes    []string
+	
+	// signingKey verifies IOC updates from the server, nil if not configured
+	signingKey   ed25519.PublicKey
+	
+	// agentID returns the ID signed updates must be addressed to
+	agentID      func() string
 }
 
 // NewManager creates a new IOC manager
-func NewManager(storagePath string) *Manager {
+func NewManager(storagePath string, integrity *persist.Integrity) *Manager {
 	// Create storage directory if it doesn't exist
 	if err := os.MkdirAll(storagePath, 0755); err != nil {
 		log.Printf("WARNING: Failed to create IOC storage directory: %v", err)
@@ -53,6 +93,7 @@ func NewManager(storagePath string) *Manager {
 		URLs:         make(map[string]IOC),
 		Version:      0,
 		StoragePath:  storagePath,
+		Integrity:    integrity,
 	}
 
 	// Load existing IOCs from file
@@ -61,13 +102,21 @@ func NewManager(storagePath string) *Manager {
 	return manager
 }
 
-// LoadFromFile loads IOCs from a JSON file
+// LoadFromFile loads IOCs from a JSON file. A file that does not parse or
+// fails Verify is replaced by its backup; if that fails too the database
+// starts empty and an error wrapping persist.ErrCorrupt is returned.
 func (m *Manager) LoadFromFile() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	filePath := filepath.Join(m.StoragePath, "iocs.json")
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
+		// A leftover checksum means the file was deleted behind our back
+		if err := persist.CheckMissing(filePath); err != nil {
+			log.Printf("SECURITY WARNING: IOC database tampering detected: %v", err)
+			m.resetUnlocked()
+			return err
+		}
 		log.Printf("No existing IOC file found at %s, starting with empty database", filePath)
 		return nil
 	}
@@ -77,48 +126,170 @@ func (m *Manager) LoadFromFile() error {
 		return fmt.Errorf("failed to read IOC file: %v", err)
 	}
 
-	type savedData struct {
-		IPAddresses  map[string]IOC `json:"ip_addresses"`
-		FileHashes   map[string]IOC `json:"file_hashes"`
-		URLs         map[string]IOC `json:"urls"`
-		Version      int64          `json:"version"`
+	var sd iocFile
+	if err := json.Unmarshal(data, &sd); err != nil {
+		// A file that does not parse is corrupt rather than tampered with;
+		// fall back to the copy kept from the previous successful save
+		log.Printf("IOC file %s is corrupt, trying backup: %v", filePath, err)
+		return m.recoverFromBackupUnlocked(filePath, fmt.Errorf("failed to unmarshal IOC data: %v", err))
+	}
+
+	// Refuse to load a file that was modified outside of the agent
+	hasChecksum, err := m.Integrity.VerifyChecksum(filePath, data)
+	if err != nil {
+		log.Printf("SECURITY WARNING: IOC database tampering detected, refusing to load: %v", err)
+		m.resetUnlocked()
+		return err
+	}
+	if !hasChecksum {
+		log.Printf("IOC file %s has no checksum yet, recording one now", filePath)
+		if err := m.Integrity.WriteChecksum(filePath, data); err != nil {
+			log.Printf("WARNING: %v", err)
+		}
+	}
+
+	// Bring older layouts up to date; a newer one is left untouched on disk
+	if err := m.migrate(&sd); err != nil {
+		log.Printf("WARNING: %v, starting with an empty IOC database", err)
+		m.resetUnlocked()
+		return err
 	}
 
-	var sd savedData
+	// A file that parses can still hold entries the agent cannot use
+	if err := verifyIOCFile(&sd); err != nil {
+		log.Printf("IOC file %s failed verification, trying backup: %v", filePath, err)
+		return m.recoverFromBackupUnlocked(filePath, err)
+	}
+
+	m.loadUnlocked(&sd)
+	return nil
+}
+
+// recoverFromBackupUnlocked loads the backup of a corrupt iocs.json and
+// restores the file from it. If the backup is missing or fails verification
+// too, the database is emptied and an error wrapping persist.ErrCorrupt is
+// returned; the version is then 0 so the server sends a full snapshot.
+func (m *Manager) recoverFromBackupUnlocked(filePath string, cause error) error {
+	sd, data, err := m.readBackup(filePath)
+	if err != nil {
+		log.Printf("WARNING: IOC database is corrupt and could not be recovered, starting with an empty database: %v", err)
+		m.resetUnlocked()
+		if errors.Is(cause, persist.ErrCorrupt) {
+			return fmt.Errorf("%w (no usable backup: %v)", cause, err)
+		}
+		return fmt.Errorf("%w: %v (no usable backup: %v)", persist.ErrCorrupt, cause, err)
+	}
+
+	log.Printf("Recovered IOC database from %s", persist.BackupPath(filePath))
+	if err := m.Integrity.RestoreBackup(filePath, data, 0644); err != nil {
+		log.Printf("WARNING: failed to restore IOC file from backup: %v", err)
+	}
+	m.loadUnlocked(sd)
+	return nil
+}
+
+// readBackup reads, migrates and verifies the backup of iocs.json
+func (m *Manager) readBackup(filePath string) (*iocFile, []byte, error) {
+	data, err := m.Integrity.ReadBackup(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sd iocFile
 	if err := json.Unmarshal(data, &sd); err != nil {
-		return fmt.Errorf("failed to unmarshal IOC data: %v", err)
+		return nil, nil, fmt.Errorf("failed to unmarshal IOC backup data: %v", err)
 	}
+	if err := m.migrate(&sd); err != nil {
+		return nil, nil, err
+	}
+	if err := verifyIOCFile(&sd); err != nil {
+		return nil, nil, err
+	}
+	return &sd, data, nil
+}
 
-	m.IPAddresses = sd.IPAddresses
+// loadUnlocked replaces the IOCs with a verified iocs.json (caller must hold
+// the write lock)
+func (m *Manager) loadUnlocked(sd *iocFile) {
+	m.IPAddresses = normalizeIPKeys(sd.IPAddresses)
 	m.FileHashes = sd.FileHashes
 	m.URLs = sd.URLs
 	m.Version = sd.Version
+	if m.URLs == nil {
+		m.URLs = make(map[string]IOC)
+	}
+	m.rebuildURLMatchersUnlocked()
+	m.rebuildHashFilterUnlocked()
 
 	log.Printf("Loaded IOCs from file: %d IPs, %d file hashes, %d URLs, version %d",
 		len(m.IPAddresses), len(m.FileHashes), len(m.URLs), m.Version)
+}
 
+// migrate upgrades an iocs.json read from disk to iocSchemaVersion, one
+// version at a time. It fails without changing anything if the file is from
+// a newer agent, since loading it could silently drop fields.
+func (m *Manager) migrate(sd *iocFile) error {
+	if sd.SchemaVersion == 0 {
+		sd.SchemaVersion = 1
+	}
+	if sd.SchemaVersion > iocSchemaVersion {
+		return fmt.Errorf("%w: file has schema version %d, agent supports up to %d",
+			ErrUnsupportedSchema, sd.SchemaVersion, iocSchemaVersion)
+	}
+	
+	from := sd.SchemaVersion
+	for sd.SchemaVersion < iocSchemaVersion {
+		switch sd.SchemaVersion {
+		case 1:
+			// Version 2 records how each IP and URL IOC is matched, so IP
+			// ranges and URL patterns are explicit rather than implied
+			for ip, ioc := range sd.IPAddresses {
+				sd.IPAddresses[ip] = withDefaultMatchType(ioc, ipMatchType(ip))
+			}
+			for url, ioc := range sd.URLs {
+				sd.URLs[url] = withDefaultMatchType(ioc, MatchExact)
+			}
+		}
+		sd.SchemaVersion++
+	}
+	
+	if from != sd.SchemaVersion {
+		log.Printf("Migrated IOC database from schema version %d to %d", from, sd.SchemaVersion)
+	}
 	return nil
 }
 
-// SaveToFile saves IOCs to a JSON file
-func (m *Manager) SaveToFile() error {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// MatchCIDR is the match type of an IP IOC that covers a CIDR range
+const MatchCIDR = "cidr"
 
-	filePath := filepath.Join(m.StoragePath, "iocs.json")
-	data, err := json.MarshalIndent(m, "", "  ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal IOC data: %v", err)
+// ipMatchType returns the match type of an IP IOC: cidr for ranges, exact
+// for single addresses
+func ipMatchType(ip string) string {
+	if strings.Contains(ip, "/") {
+		return MatchCIDR
 	}
+	return MatchExact
+}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write IOC file: %v", err)
+// withDefaultMatchType sets the match_type metadata of an IOC that has none
+func withDefaultMatchType(ioc IOC, matchType string) IOC {
+	if ioc.Metadata["match_type"] != "" {
+		return ioc
 	}
+	metadata := make(map[string]string, len(ioc.Metadata)+1)
+	for k, v := range ioc.Metadata {
+		metadata[k] = v
+	}
+	metadata["match_type"] = matchType
+	ioc.Metadata = metadata
+	return ioc
+}
 
-	log.Printf("Saved IOCs to file: %d IPs, %d file hashes, %d URLs, version %d",
-		len(m.IPAddresses), len(m.FileHashes), len(m.URLs), m.Version)
+// SaveToFile saves IOCs to a JSON file
+func (m *Manager) SaveToFile() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	return nil
+	return m.saveToFileUnlocked()
 }
 
 // AddIP adds an IP address IOC
@@ -126,6 +297,7 @@ func (m *Manager) AddIP(ip, description, severity string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	ip = NormalizeIP(ip)
 	m.IPAddresses[ip] = IOC{
 		Value:       ip,
 		Type:        TypeIP,
@@ -148,19 +320,53 @@ func (m *Manager) AddFileHash(hash, hashType, description, severity string) {
 			"hash_type": hashType,
 		},
 	}
+	
+	if filter := m.hashFilter.Load(); filter != nil && !filter.full() {
+		filter.add(strings.ToLower(hash))
+		m.addHashTypeUnlocked(m.FileHashes[strings.ToLower(hash)])
+	} else {
+		m.rebuildHashFilterUnlocked()
+	}
 }
 
-// AddURL adds a URL IOC
-func (m *Manager) AddURL(url, description, severity string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	m.URLs[strings.ToLower(url)] = IOC{
-		Value:       strings.ToLower(url),
+// AddURL adds a URL IOC. matchType is one of exact, suffix, wildcard or regex
+// (empty means exact); invalid patterns are rejected.
+func (m *Manager) AddURL(url, matchType, description, severity string) error {
+	ioc := IOC{
+		Value:       url,
 		Type:        TypeURL,
 		Description: description,
 		Severity:    severity,
 	}
+	if matchType != "" {
+		ioc.Metadata = map[string]string{"match_type": matchType}
+	}
+	if ioc.URLMatchType() != MatchRegex {
+		ioc.Value = strings.ToLower(url)
+	}
+
+	if _, err := compileURLMatcher(ioc); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.URLs[strings.ToLower(url)] = ioc
+	m.rebuildURLMatchersUnlocked()
+	return nil
+}
+
+// resetUnlocked drops all IOCs and the version so the next server push is
+// accepted as a full snapshot (caller must hold the write lock)
+func (m *Manager) resetUnlocked() {
+	m.IPAddresses = make(map[string]IOC)
+	m.FileHashes = make(map[string]IOC)
+	m.URLs = make(map[string]IOC)
+	m.urlIndex = nil
+	m.hashFilter.Store(nil)
+	m.hashTypes = nil
+	m.Version = 0
 }
 
 // ClearAll clears all IOCs
@@ -171,6 +377,9 @@ func (m *Manager) ClearAll() {
 	m.IPAddresses = make(map[string]IOC)
 	m.FileHashes = make(map[string]IOC)
 	m.URLs = make(map[string]IOC)
+	m.urlIndex = nil
+	m.hashFilter.Store(nil)
+	m.hashTypes = nil
 }
 
 // GetVersion returns the current IOC version
@@ -192,51 +401,103 @@ func (m *Manager) CheckIP(ip string) (bool, IOC) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	if ioc, ok := m.IPAddresses[ip]; ok {
+	if ioc, ok := m.IPAddresses[NormalizeIP(ip)]; ok {
 		return true, ioc
 	}
 	return false, IOC{}
 }
 
-// CheckFileHash checks if a file hash matches any IOC
+// NormalizeIP returns the canonical form of an IP address so equivalent
+// spellings (::1 and 0:0:0:0:0:0:0:1, ::ffff:10.0.0.1 and 10.0.0.1) compare
+// equal. CIDR ranges are reduced to their network address (10.0.0.7/24
+// becomes 10.0.0.0/24) and single-host prefixes to the bare address. Values
+// that are neither are returned trimmed but unchanged.
+func NormalizeIP(ip string) string {
+	ip = strings.TrimSpace(ip)
+	if _, network, err := net.ParseCIDR(ip); err == nil {
+		if ones, bits := network.Mask.Size(); ones == bits {
+			return network.IP.String()
+		}
+		return network.String()
+	}
+	addr := strings.Trim(ip, "[]")
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i] // Drop the IPv6 zone (fe80::1%eth0)
+	}
+	if parsed := net.ParseIP(addr); parsed != nil {
+		return parsed.String()
+	}
+	return ip
+}
+
+// normalizeIPKeys re-keys IP IOCs loaded from disk by their canonical form
+func normalizeIPKeys(iocs map[string]IOC) map[string]IOC {
+	normalized := make(map[string]IOC, len(iocs))
+	for ip, ioc := range iocs {
+		ip = NormalizeIP(ip)
+		ioc.Value = ip
+		normalized[ip] = ioc
+	}
+	return normalized
+}
+
+// CheckFileHash checks if a file hash matches any IOC. The bloom filter is
+// consulted first, without the lock, so most misses never touch the map.
 func (m *Manager) CheckFileHash(hash string) (bool, IOC) {
+	hash = strings.ToLower(hash)
+	filter := m.hashFilter.Load()
+	if filter == nil || !filter.mayContain(hash) {
+		return false, IOC{}
+	}
+
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	hash = strings.ToLower(hash)
 	if ioc, ok := m.FileHashes[hash]; ok {
 		return true, ioc
 	}
 	return false, IOC{}
 }
 
-// CheckURL checks if a URL matches any IOC
+// CheckURL checks if a URL or domain matches any IOC. The host is normalized
+// and matchers are evaluated from most to least specific (exact, suffix,
+// wildcard, regex; longer patterns first), so the most specific match wins.
 func (m *Manager) CheckURL(url string) (bool, IOC) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	url = strings.ToLower(url)
-
-	// Exact match check
-	if ioc, ok := m.URLs[url]; ok {
-		return true, ioc
+	host, full := normalizeURL(url)
+	if host == "" || m.urlIndex == nil {
+		return false, IOC{}
 	}
 
-	// Partial match check (URL contains IOC)
-	for iocURL, ioc := range m.URLs {
-		if strings.Contains(url, iocURL) {
-			return true, ioc
-		}
+	if matcher := m.urlIndex.lookup(host, full); matcher != nil {
+		return true, matcher.ioc
 	}
-
 	return false, IOC{}
 }
 
-// UpdateFromProto updates IOCs from a protobuf IOCResponse
+// UpdateFromProto updates IOCs from a protobuf IOCResponse. If a signing key
+// is set the update is rejected with ErrInvalidSignature unless it is signed.
+// An update whose indicators do not match its count and checksum is rejected
+// with ErrChecksumMismatch, and one whose version is not above the current
+// version with ErrStaleUpdate; the current IOCs are kept.
 func (m *Manager) UpdateFromProto(response *pb.IOCResponse) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if err := m.verifySignatureUnlocked(response); err != nil {
+		return err
+	}
+	if err := verifyChecksum(response); err != nil {
+		return err
+	}
+
+	// An older signed update replayed later must not roll the IOCs back
+	if response.Version <= m.Version {
+		return fmt.Errorf("%w: update to version %d, current version %d", ErrStaleUpdate, response.Version, m.Version)
+	}
+
 	// Clear existing IOCs
 	m.IPAddresses = make(map[string]IOC)
 	m.FileHashes = make(map[string]IOC)
@@ -244,50 +505,21 @@ func (m *Manager) UpdateFromProto(response *pb.IOCResponse) error {
 
 	// Add IP addresses
 	for ip, iocData := range response.IpAddresses {
-		m.IPAddresses[ip] = IOC{
-			Value:       ip,
-			Type:        TypeIP,
-			Description: iocData.Description,
-			Severity:    iocData.Severity,
-			Metadata:    iocData.Metadata,
-		}
+		m.IPAddresses[NormalizeIP(ip)] = ipIOCFromProto(ip, iocData)
 	}
 
 	// Add file hashes
 	for hash, iocData := range response.FileHashes {
-		hashType := "sha256" // Default
-		if val, ok := iocData.Metadata["hash_type"]; ok {
-			hashType = val
-		}
-		
-		m.FileHashes[strings.ToLower(hash)] = IOC{
-			Value:       strings.ToLower(hash),
-			Type:        TypeFileHash,
-			Description: iocData.Description,
-			Severity:    iocData.Severity,
-			Metadata: map[string]string{
-				"hash_type": hashType,
-			},
-		}
-		
-		// Copy additional metadata
-		for k, v := range iocData.Metadata {
-			if k != "hash_type" {
-				m.FileHashes[strings.ToLower(hash)].Metadata[k] = v
-			}
-		}
+		m.FileHashes[strings.ToLower(hash)] = hashIOCFromProto(hash, iocData)
 	}
 
 	// Add URLs
 	for url, iocData := range response.Urls {
-		m.URLs[strings.ToLower(url)] = IOC{
-			Value:       strings.ToLower(url),
-			Type:        TypeURL,
-			Description: iocData.Description,
-			Severity:    iocData.Severity,
-			Metadata:    iocData.Metadata,
-		}
+		m.URLs[strings.ToLower(url)] = urlIOCFromProto(url, iocData)
 	}
+	m.dropInvalidUnlocked()
+	m.rebuildURLMatchersUnlocked()
+	m.rebuildHashFilterUnlocked()
 
 	// Update version
 	m.Version = response.Version
@@ -296,15 +528,183 @@ func (m *Manager) UpdateFromProto(response *pb.IOCResponse) error {
 	return m.saveToFileUnlocked()
 }
 
+// ErrStaleUpdate is returned by UpdateFromProto and ApplyDelta for an update
+// that would not move the IOC version forward
+var ErrStaleUpdate = errors.New("IOC update is not newer than the current version")
+
+// ErrDeltaBaseMismatch is returned by ApplyDelta when the delta was computed
+// against a different version than the one currently loaded
+var ErrDeltaBaseMismatch = errors.New("IOC delta base version does not match current version")
+
+// NeedsFullSync reports whether a delta computed against baseVersion cannot be
+// applied on top of the current IOC set and a full sync is required instead
+func (m *Manager) NeedsFullSync(baseVersion int64) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.Version != baseVersion
+}
+
+// ApplyDelta applies an incremental IOC update in place. The maps in the delta
+// carry added or changed indicators and the removed_* lists carry indicators
+// to drop. If the delta's base version does not match the current version the
+// delta is rejected with ErrDeltaBaseMismatch and nothing is changed. Like
+// UpdateFromProto it returns ErrInvalidSignature for an unsigned delta when a
+// signing key is set, ErrChecksumMismatch for a corrupted delta and
+// ErrStaleUpdate for a delta that does not move the version forward.
+func (m *Manager) ApplyDelta(delta *pb.IOCResponse) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.verifySignatureUnlocked(delta); err != nil {
+		return err
+	}
+	if err := verifyChecksum(delta); err != nil {
+		return err
+	}
+
+	if m.Version != delta.BaseVersion {
+		return fmt.Errorf("%w: current %d, delta base %d", ErrDeltaBaseMismatch, m.Version, delta.BaseVersion)
+	}
+	if delta.Version <= delta.BaseVersion {
+		return fmt.Errorf("%w: delta to version %d, current version %d", ErrStaleUpdate, delta.Version, m.Version)
+	}
+
+	if m.IPAddresses == nil {
+		m.IPAddresses = make(map[string]IOC)
+	}
+	if m.FileHashes == nil {
+		m.FileHashes = make(map[string]IOC)
+	}
+	if m.URLs == nil {
+		m.URLs = make(map[string]IOC)
+	}
+
+	// Apply removals first so a value that is removed and re-added in the
+	// same delta ends up present
+	for _, ip := range delta.RemovedIpAddresses {
+		delete(m.IPAddresses, NormalizeIP(ip))
+	}
+	for _, hash := range delta.RemovedFileHashes {
+		delete(m.FileHashes, strings.ToLower(hash))
+	}
+	for _, url := range delta.RemovedUrls {
+		delete(m.URLs, strings.ToLower(url))
+	}
+
+	// Apply additions
+	for ip, iocData := range delta.IpAddresses {
+		m.IPAddresses[NormalizeIP(ip)] = ipIOCFromProto(ip, iocData)
+	}
+	for hash, iocData := range delta.FileHashes {
+		m.FileHashes[strings.ToLower(hash)] = hashIOCFromProto(hash, iocData)
+	}
+	for url, iocData := range delta.Urls {
+		m.URLs[strings.ToLower(url)] = urlIOCFromProto(url, iocData)
+	}
+	m.dropInvalidUnlocked()
+	m.rebuildURLMatchersUnlocked()
+	m.rebuildHashFilterUnlocked()
+
+	log.Printf("Applied IOC delta %d -> %d: +%d/-%d IPs, +%d/-%d file hashes, +%d/-%d URLs",
+		m.Version, delta.Version,
+		len(delta.IpAddresses), len(delta.RemovedIpAddresses),
+		len(delta.FileHashes), len(delta.RemovedFileHashes),
+		len(delta.Urls), len(delta.RemovedUrls))
+
+	m.Version = delta.Version
+
+	return m.saveToFileUnlocked()
+}
+
+// rebuildHashFilterUnlocked sizes a new bloom filter to the current file hash
+// count and fills it, and recomputes the active hash algorithms (caller must
+// hold the write lock)
+func (m *Manager) rebuildHashFilterUnlocked() {
+	m.rebuildHashTypesUnlocked()
+	
+	if len(m.FileHashes) == 0 {
+		m.hashFilter.Store(nil)
+		return
+	}
+
+	filter := newBloomFilter(len(m.FileHashes))
+	for hash := range m.FileHashes {
+		filter.add(hash)
+	}
+	m.hashFilter.Store(filter)
+}
+
+// ipIOCFromProto converts a protobuf IP indicator to an IOC
+func ipIOCFromProto(ip string, iocData *pb.IOCData) IOC {
+	return IOC{
+		Value:       NormalizeIP(ip),
+		Type:        TypeIP,
+		Description: iocData.Description,
+		Severity:    iocData.Severity,
+		Metadata:    iocData.Metadata,
+	}
+}
+
+// hashIOCFromProto converts a protobuf file hash indicator to an IOC
+func hashIOCFromProto(hash string, iocData *pb.IOCData) IOC {
+	hashType := "sha256" // Default
+	if val, ok := iocData.Metadata["hash_type"]; ok {
+		hashType = val
+	}
+
+	ioc := IOC{
+		Value:       strings.ToLower(hash),
+		Type:        TypeFileHash,
+		Description: iocData.Description,
+		Severity:    iocData.Severity,
+		Metadata: map[string]string{
+			"hash_type": hashType,
+		},
+	}
+
+	// Copy additional metadata
+	for k, v := range iocData.Metadata {
+		if k != "hash_type" {
+			ioc.Metadata[k] = v
+		}
+	}
+
+	return ioc
+}
+
+// urlIOCFromProto converts a protobuf URL indicator to an IOC. Regex
+// patterns keep their case since it is significant for escapes like \D.
+func urlIOCFromProto(url string, iocData *pb.IOCData) IOC {
+	ioc := IOC{
+		Value:       url,
+		Type:        TypeURL,
+		Description: iocData.Description,
+		Severity:    iocData.Severity,
+		Metadata:    iocData.Metadata,
+	}
+	if ioc.URLMatchType() != MatchRegex {
+		ioc.Value = strings.ToLower(url)
+	}
+	return ioc
+}
+
 // saveToFileUnlocked saves IOCs to file without acquiring lock (internal use)
 func (m *Manager) saveToFileUnlocked() error {
 	filePath := filepath.Join(m.StoragePath, "iocs.json")
-	data, err := json.MarshalIndent(m, "", "  ")
+	data, err := json.MarshalIndent(iocFile{
+		SchemaVersion: iocSchemaVersion,
+		IPAddresses:   m.IPAddresses,
+		FileHashes:    m.FileHashes,
+		URLs:          m.URLs,
+		Version:       m.Version,
+	}, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal IOC data: %v", err)
 	}
 
-	if err := os.WriteFile(filePath, data, 0644); err != nil {
+	// Write atomically, keeping the previous version as a backup, and record
+	// the checksum so tampering can be detected on next load
+	if err := m.Integrity.SaveWithBackup(filePath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write IOC file: %v", err)
 	}
 
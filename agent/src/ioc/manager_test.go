@@ -0,0 +1,286 @@
+package ioc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"agent/persist"
+)
+
+// installIOCFile copies a fixture to iocs.json in a new storage directory
+func installIOCFile(t *testing.T, fixture string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "iocs.json"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// newTestIntegrity returns an Integrity with a key generated for the test,
+// so IOC files installed without a checksum are accepted
+func newTestIntegrity(t *testing.T) *persist.Integrity {
+	t.Helper()
+	integrity, err := persist.NewIntegrity(persist.NewKeyStore(t.TempDir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return integrity
+}
+
+func TestLoadV1IOCFile(t *testing.T) {
+	dir := installIOCFile(t, "iocs_v1.json")
+	m := NewManager(dir, newTestIntegrity(t))
+
+	if m.Version != 42 {
+		t.Errorf("version = %d, want 42", m.Version)
+	}
+
+	tests := []struct {
+		name      string
+		iocs      map[string]IOC
+		key       string
+		matchType string // Expected match_type, "" if none
+		severity  string
+	}{
+		{"single IP", m.IPAddresses, "203.0.113.5", MatchExact, "high"},
+		{"IP range", m.IPAddresses, "10.0.0.0/8", MatchCIDR, "low"},
+		{"expanded IPv6", m.IPAddresses, "2001:db8::1", MatchExact, "medium"},
+		{"URL", m.URLs, "phish.example.com", MatchExact, "high"},
+		{"URL with match type", m.URLs, "evil.example.net", MatchSuffix, "high"},
+		{"file hash", m.FileHashes, "44d88612fea8a8f36de82e1278abb02f", "", "critical"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ioc, ok := tt.iocs[tt.key]
+			if !ok {
+				t.Fatalf("IOC %s not loaded", tt.key)
+			}
+			if got := ioc.Metadata["match_type"]; got != tt.matchType {
+				t.Errorf("match_type = %q, want %q", got, tt.matchType)
+			}
+			if ioc.Severity != tt.severity {
+				t.Errorf("severity = %q, want %q", ioc.Severity, tt.severity)
+			}
+		})
+	}
+
+	// Metadata the migration does not own is kept
+	if source := m.URLs["evil.example.net"].Metadata["source"]; source != "feed" {
+		t.Errorf("source metadata = %q, want feed", source)
+	}
+	if hashType := m.FileHashes["44d88612fea8a8f36de82e1278abb02f"].Metadata["hash_type"]; hashType != "md5" {
+		t.Errorf("hash_type metadata = %q, want md5", hashType)
+	}
+
+	// The migrated IOCs match as before
+	if ok, _ := m.CheckIP("2001:0db8::0001"); !ok {
+		t.Error("CheckIP(2001:0db8::0001) did not match 2001:db8::1")
+	}
+	if ok, _ := m.CheckURL("https://cdn.evil.example.net/payload"); !ok {
+		t.Error("CheckURL did not match a subdomain of a suffix IOC")
+	}
+	if ok, _ := m.CheckFileHash("44D88612FEA8A8F36DE82E1278ABB02F"); !ok {
+		t.Error("CheckFileHash did not match the EICAR hash")
+	}
+
+	// Saving writes the current schema, which loads unchanged
+	if err := m.SaveToFile(); err != nil {
+		t.Fatalf("SaveToFile: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "iocs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved iocFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	if saved.SchemaVersion != iocSchemaVersion {
+		t.Errorf("saved schema_version = %d, want %d", saved.SchemaVersion, iocSchemaVersion)
+	}
+	reloaded := NewManager(dir, m.Integrity)
+	if len(reloaded.IPAddresses) != 3 || len(reloaded.URLs) != 2 || len(reloaded.FileHashes) != 1 || reloaded.Version != 42 {
+		t.Errorf("reloaded %d IPs, %d URLs, %d hashes, version %d; want 3, 2, 1, 42",
+			len(reloaded.IPAddresses), len(reloaded.URLs), len(reloaded.FileHashes), reloaded.Version)
+	}
+}
+
+func TestLoadNewerSchemaRefused(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "iocs.json")
+	data := []byte(`{"schema_version": 3, "ip_addresses": {"203.0.113.5": {"value": "203.0.113.5"}}, "version": 7}`)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := &Manager{StoragePath: dir, Integrity: newTestIntegrity(t), IPAddresses: map[string]IOC{}, FileHashes: map[string]IOC{}, URLs: map[string]IOC{}}
+	if err := m.LoadFromFile(); !errors.Is(err, ErrUnsupportedSchema) {
+		t.Fatalf("LoadFromFile error = %v, want ErrUnsupportedSchema", err)
+	}
+	if len(m.IPAddresses) != 0 || m.Version != 0 {
+		t.Errorf("loaded %d IPs at version %d from a newer schema", len(m.IPAddresses), m.Version)
+	}
+
+	// The newer agent's file is left for it
+	if current, err := os.ReadFile(path); err != nil || string(current) != string(data) {
+		t.Errorf("iocs.json was changed: %s (%v)", current, err)
+	}
+}
+
+func TestNormalizeIP(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"::1", "::1"},
+		{"0:0:0:0:0:0:0:1", "::1"},
+		{"0000:0000:0000:0000:0000:0000:0000:0001", "::1"},
+		{"2001:0db8:0000:0000:0000:ff00:0042:8329", "2001:db8::ff00:42:8329"},
+		{"2001:DB8::FF00:42:8329", "2001:db8::ff00:42:8329"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"::ffff:10.0.0.1", "10.0.0.1"},
+		{"0:0:0:0:0:ffff:0a00:0001", "10.0.0.1"},
+		{"::ffff:0a00:1", "10.0.0.1"},
+		{" 10.0.0.1 ", "10.0.0.1"},
+		{"2001:db8::1/128", "2001:db8::1"},
+		{"2001:0db8:0000::/32", "2001:db8::/32"},
+		{"10.0.0.7/24", "10.0.0.0/24"},
+		{"not-an-ip", "not-an-ip"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeIP(tt.in); got != tt.want {
+			t.Errorf("NormalizeIP(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCheckIPEquivalentForms(t *testing.T) {
+	tests := []struct {
+		name  string
+		add   string
+		check []string
+	}{
+		{"compressed IPv6", "2001:db8::1", []string{"2001:0db8:0000:0000:0000:0000:0000:0001", "2001:DB8:0:0::1"}},
+		{"expanded IPv6", "0:0:0:0:0:0:0:1", []string{"::1", "0000::0001"}},
+		{"v4-mapped", "::ffff:192.0.2.10", []string{"192.0.2.10", "::ffff:c000:20a"}},
+		{"IPv4 as v4-mapped", "192.0.2.10", []string{"::ffff:192.0.2.10", "0:0:0:0:0:ffff:c000:020a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{IPAddresses: map[string]IOC{}}
+			m.AddIP(tt.add, "test", "high")
+			for _, ip := range tt.check {
+				if ok, ioc := m.CheckIP(ip); !ok {
+					t.Errorf("CheckIP(%q) did not match %q", ip, tt.add)
+				} else if ioc.Value != NormalizeIP(tt.add) {
+					t.Errorf("CheckIP(%q) returned IOC %q, want %q", ip, ioc.Value, NormalizeIP(tt.add))
+				}
+			}
+		})
+	}
+}
+
+// benchHashCount is the size of the IOC set BenchmarkCheckFileHash uses,
+// that of a large hash feed
+const benchHashCount = 1000000
+
+var (
+	benchOnce    sync.Once
+	benchManager *Manager
+	benchHits    []string // Hashes in the IOC set
+	benchMisses  []string // Hashes that are not
+)
+
+// benchHash returns a SHA256-sized hex hash derived from prefix and i
+func benchHash(prefix string, i int) string {
+	sum := sha256.Sum256([]byte(prefix + strconv.Itoa(i)))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadBenchManager builds the benchmark IOC set once for all sub-benchmarks
+func loadBenchManager() {
+	benchManager = &Manager{FileHashes: make(map[string]IOC, benchHashCount)}
+	for i := 0; i < benchHashCount; i++ {
+		hash := benchHash("ioc", i)
+		benchManager.FileHashes[hash] = IOC{Value: hash, Type: TypeFileHash, Severity: "high"}
+	}
+	benchManager.rebuildHashFilterUnlocked()
+
+	for i := 0; i < 4096; i++ {
+		benchHits = append(benchHits, benchHash("ioc", i*(benchHashCount/4096)))
+		benchMisses = append(benchMisses, benchHash("clean", i))
+	}
+}
+
+// checkFileHashNoFilter is CheckFileHash as it was before the bloom filter:
+// every lookup takes the read lock and probes the map
+func (m *Manager) checkFileHashNoFilter(hash string) (bool, IOC) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hash = strings.ToLower(hash)
+	if ioc, ok := m.FileHashes[hash]; ok {
+		return true, ioc
+	}
+	return false, IOC{}
+}
+
+// BenchmarkCheckFileHash compares lookups in a 1M entry IOC set with and
+// without the bloom filter, from parallel goroutines as Sysmon events are
+// checked. Almost all scanned files are clean, so misses dominate.
+func BenchmarkCheckFileHash(b *testing.B) {
+	benchOnce.Do(loadBenchManager)
+	m := benchManager
+
+	lookups := []struct {
+		name  string
+		check func(hash string) (bool, IOC)
+	}{
+		{"bloom", m.CheckFileHash},
+		{"map", m.checkFileHashNoFilter},
+	}
+	sets := []struct {
+		name   string
+		hashes []string
+		want   bool
+	}{
+		{"miss", benchMisses, false},
+		{"hit", benchHits, true},
+	}
+
+	for _, lookup := range lookups {
+		for _, set := range sets {
+			b.Run(lookup.name+"/"+set.name, func(b *testing.B) {
+				var next uint64
+				b.ReportAllocs()
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					i := int(atomic.AddUint64(&next, 1)) * 997
+					for pb.Next() {
+						hash := set.hashes[i%len(set.hashes)]
+						if found, _ := lookup.check(hash); found != set.want {
+							b.Errorf("%s lookup of %s = %v, want %v", lookup.name, hash, found, set.want)
+							return
+						}
+						i++
+					}
+				})
+			})
+		}
+	}
+}
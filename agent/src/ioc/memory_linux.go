@@ -0,0 +1,84 @@
+// +build linux
+
+package ioc
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// procMemory reads a process's memory through /proc/<pid>/mem
+type procMemory struct {
+	pid int32
+	mem *os.File
+}
+
+// openProcessMemory opens the memory of process pid for reading, which
+// needs root or CAP_SYS_PTRACE
+func openProcessMemory(pid int32) (processMemory, error) {
+	mem, err := os.Open(fmt.Sprintf("/proc/%d/mem", pid))
+	if err != nil {
+		return nil, err
+	}
+	return &procMemory{pid: pid, mem: mem}, nil
+}
+
+// Regions lists the readable mappings in /proc/<pid>/maps. [vvar] and
+// [vsyscall] cannot be read through /proc/<pid>/mem and are left out.
+func (m *procMemory) Regions() ([]MemoryRegion, error) {
+	file, err := os.Open(fmt.Sprintf("/proc/%d/maps", m.pid))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var regions []MemoryRegion
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// start-end perms offset dev inode [path]
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasPrefix(fields[1], "r") {
+			continue
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			continue
+		}
+		start, err1 := strconv.ParseUint(bounds[0], 16, 64)
+		end, err2 := strconv.ParseUint(bounds[1], 16, 64)
+		if err1 != nil || err2 != nil || end <= start {
+			continue
+		}
+
+		path := ""
+		if len(fields) > 5 {
+			path = strings.Join(fields[5:], " ")
+		}
+		if path == "[vvar]" || path == "[vsyscall]" {
+			continue
+		}
+		regions = append(regions, MemoryRegion{
+			Start:  start,
+			End:    end,
+			Perms:  fields[1][:3],
+			Mapped: fields[4] != "0",
+			Path:   path,
+		})
+	}
+	return regions, scanner.Err()
+}
+
+func (m *procMemory) ReadAt(p []byte, addr uint64) (int, error) {
+	if addr > math.MaxInt64 {
+		return 0, fmt.Errorf("address 0x%x out of range", addr)
+	}
+	return m.mem.ReadAt(p, int64(addr))
+}
+
+func (m *procMemory) Close() error {
+	return m.mem.Close()
+}
@@ -0,0 +1,13 @@
+// +build !windows,!linux
+
+package ioc
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// openProcessMemory is only implemented for Windows and Linux
+func openProcessMemory(pid int32) (processMemory, error) {
+	return nil, fmt.Errorf("memory scanning is not supported on %s", runtime.GOOS)
+}
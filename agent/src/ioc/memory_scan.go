@@ -0,0 +1,212 @@
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// memoryChunkSize is how much of a memory region is read and matched at once
+const memoryChunkSize = 4 << 20
+
+// memoryChunkOverlap is read again at the start of the next chunk, so strings
+// shorter than this that cross a chunk boundary still match
+const memoryChunkOverlap = 4 << 10
+
+// MemoryRegion is a readable range of a process's address space
+type MemoryRegion struct {
+	Start  uint64
+	End    uint64
+	Perms  string // Protection, such as r-x or rw-
+	Mapped bool   // Backed by an image or file rather than private memory
+	Path   string // Mapped file or pseudo-path such as [heap], if known
+}
+
+// String describes the region as address range, protection and path
+func (r MemoryRegion) String() string {
+	s := fmt.Sprintf("0x%x-0x%x %s", r.Start, r.End, r.Perms)
+	if r.Path != "" {
+		s += " " + r.Path
+	}
+	return s
+}
+
+// processMemory reads the address space of another process
+type processMemory interface {
+	Regions() ([]MemoryRegion, error)
+	ReadAt(p []byte, addr uint64) (int, error)
+	Close() error
+}
+
+// MemoryMatch is a rule that matched a region of a process's memory
+type MemoryMatch struct {
+	PID      int32    `json:"pid"`
+	Image    string   `json:"image"`
+	Process  string   `json:"process"` // DescribeProcess
+	Region   string   `json:"region"`
+	Rule     string   `json:"rule"`
+	Strings  []string `json:"strings,omitempty"`
+	Severity string   `json:"severity"`
+}
+
+// MemoryScanResult is the outcome of ScanProcessMemory
+type MemoryScanResult struct {
+	Scanned      int           `json:"scanned"`   // Processes whose memory was read
+	Skipped      int           `json:"skipped"`   // Processes that exited or whose memory could not be read
+	Truncated    int           `json:"truncated"` // Processes with more memory than the size limit
+	BytesScanned int64         `json:"bytes_scanned"`
+	Matches      []MemoryMatch `json:"matches"`
+}
+
+// NewSignatureEngine builds a YARA engine with one rule per hex byte
+// signature, such as "4d 5a 90 ?? 03". Rules are named signature_1,
+// signature_2 and so on in the order given.
+func NewSignatureEngine(signatures []string) (*YaraEngine, error) {
+	var source strings.Builder
+	for i, signature := range signatures {
+		signature = strings.TrimSpace(signature)
+		if signature == "" || strings.Trim(signature, "0123456789abcdefABCDEF? ") != "" {
+			return nil, fmt.Errorf("invalid byte signature %q, expected hex bytes with optional ?? wildcards", signature)
+		}
+		fmt.Fprintf(&source, "rule signature_%d { strings: $s = { %s } condition: $s }\n", i+1, signature)
+	}
+
+	rules, err := CompileYaraRules(source.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid byte signature: %v", err)
+	}
+	return &YaraEngine{rules: rules}, nil
+}
+
+// ScanProcessMemory matches the readable memory of the processes in pids, or
+// of every process but the agent if pids is empty, against the rules of
+// engine. At most maxBytes are read from each process; private memory, where
+// code injected or unpacked at runtime lives, is read before mapped files.
+// Processes that exit or cannot be opened are counted as skipped, except
+// when a single PID was requested. The scan stops when ctx is done.
+func ScanProcessMemory(ctx context.Context, pids []int32, engine *YaraEngine, maxBytes int64) (*MemoryScanResult, error) {
+	if engine.RuleCount() == 0 {
+		return nil, fmt.Errorf("no YARA rules or byte signatures to scan with")
+	}
+
+	all := len(pids) == 0
+	if all {
+		var err error
+		if pids, err = process.PidsWithContext(ctx); err != nil {
+			return nil, fmt.Errorf("failed to enumerate processes: %v", err)
+		}
+	}
+
+	result := &MemoryScanResult{Matches: []MemoryMatch{}}
+	self := int32(os.Getpid())
+	for _, pid := range pids {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		if all && pid == self {
+			continue // The agent's memory holds the rules themselves
+		}
+
+		err := scanProcessMemory(ctx, pid, engine, maxBytes, result)
+		if err == nil {
+			result.Scanned++
+			continue
+		}
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		if len(pids) == 1 {
+			return nil, fmt.Errorf("failed to scan memory of process %d: %v", pid, err)
+		}
+		result.Skipped++
+	}
+
+	log.Printf("Scanned %d MB of memory in %d processes, %d matches", result.BytesScanned>>20, result.Scanned, len(result.Matches))
+	return result, nil
+}
+
+// scanProcessMemory reads up to maxBytes of one process's memory in chunks
+// and adds each rule matching a region to result once
+func scanProcessMemory(ctx context.Context, pid int32, engine *YaraEngine, maxBytes int64, result *MemoryScanResult) error {
+	mem, err := openProcessMemory(pid)
+	if err != nil {
+		return err
+	}
+	defer mem.Close()
+
+	regions, err := mem.Regions()
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(regions, func(i, j int) bool { return !regions[i].Mapped && regions[j].Mapped })
+
+	var p *process.Process
+	var image, description string
+	buf := make([]byte, memoryChunkSize)
+	remaining := maxBytes
+	for _, region := range regions {
+		seen := make(map[string]bool)
+		for addr := region.Start; addr < region.End; addr += memoryChunkSize - memoryChunkOverlap {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if remaining <= 0 {
+				result.Truncated++
+				return nil
+			}
+
+			size := region.End - addr
+			if size > memoryChunkSize {
+				size = memoryChunkSize
+			}
+			if int64(size) > remaining {
+				size = uint64(remaining)
+			}
+			n, _ := mem.ReadAt(buf[:size], addr)
+			if n <= 0 {
+				break // Pages that cannot be read; skip the rest of the region
+			}
+			remaining -= int64(n)
+			result.BytesScanned += int64(n)
+
+			for _, match := range engine.ScanBytes(buf[:n]) {
+				if seen[match.Rule] {
+					continue
+				}
+				seen[match.Rule] = true
+
+				if p == nil {
+					if p, err = process.NewProcessWithContext(ctx, pid); err == nil {
+						image, _ = p.ExeWithContext(ctx)
+						description = DescribeProcess(p)
+					} else {
+						description = fmt.Sprintf("PID %d", pid)
+					}
+				}
+				severity := match.Meta["severity"]
+				if severity == "" {
+					severity = "medium"
+				}
+				log.Printf("Found YARA rule match in memory of %s: %s (%s)", description, match.Rule, region)
+				result.Matches = append(result.Matches, MemoryMatch{
+					PID:      pid,
+					Image:    image,
+					Process:  description,
+					Region:   region.String(),
+					Rule:     match.Rule,
+					Strings:  match.Strings,
+					Severity: severity,
+				})
+			}
+			if uint64(n) < size || addr+size >= region.End {
+				break
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// +build windows
+
+package ioc
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// memPrivate is the MEM_PRIVATE region type, missing from x/sys/windows
+const memPrivate = 0x20000
+
+// windowsProcessMemory reads a process's memory with ReadProcessMemory
+type windowsProcessMemory struct {
+	handle windows.Handle
+}
+
+// openProcessMemory opens the memory of process pid for reading, which for
+// other users' processes needs an elevated agent
+func openProcessMemory(pid int32) (processMemory, error) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_INFORMATION|windows.PROCESS_VM_READ, false, uint32(pid))
+	if err != nil {
+		return nil, err
+	}
+	return &windowsProcessMemory{handle: handle}, nil
+}
+
+// Regions walks the address space with VirtualQueryEx and returns the
+// committed regions that are neither no-access nor guard pages
+func (m *windowsProcessMemory) Regions() ([]MemoryRegion, error) {
+	var regions []MemoryRegion
+	var addr uintptr
+	for {
+		var info windows.MemoryBasicInformation
+		if err := windows.VirtualQueryEx(m.handle, addr, &info, unsafe.Sizeof(info)); err != nil {
+			break // Past the end of the user address space
+		}
+		if info.State == windows.MEM_COMMIT && info.Protect&(windows.PAGE_NOACCESS|windows.PAGE_GUARD) == 0 {
+			regions = append(regions, MemoryRegion{
+				Start:  uint64(info.BaseAddress),
+				End:    uint64(info.BaseAddress + info.RegionSize),
+				Perms:  pageProtection(info.Protect),
+				Mapped: info.Type != memPrivate,
+			})
+		}
+
+		next := info.BaseAddress + info.RegionSize
+		if next <= addr {
+			break
+		}
+		addr = next
+	}
+	return regions, nil
+}
+
+func (m *windowsProcessMemory) ReadAt(p []byte, addr uint64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	var n uintptr
+	err := windows.ReadProcessMemory(m.handle, uintptr(addr), &p[0], uintptr(len(p)), &n)
+	return int(n), err
+}
+
+func (m *windowsProcessMemory) Close() error {
+	return windows.CloseHandle(m.handle)
+}
+
+// pageProtection formats a PAGE_* protection value like a Linux permission string
+func pageProtection(protect uint32) string {
+	switch protect &^ 0x700 { // Ignore PAGE_GUARD, PAGE_NOCACHE and PAGE_WRITECOMBINE
+	case windows.PAGE_READONLY:
+		return "r--"
+	case windows.PAGE_READWRITE, windows.PAGE_WRITECOPY:
+		return "rw-"
+	case windows.PAGE_EXECUTE:
+		return "--x"
+	case windows.PAGE_EXECUTE_READ:
+		return "r-x"
+	case windows.PAGE_EXECUTE_READWRITE, windows.PAGE_EXECUTE_WRITECOPY:
+		return "rwx"
+	default:
+		return "---"
+	}
+}
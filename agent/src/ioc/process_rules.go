@@ -0,0 +1,88 @@
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"agent/config"
+	"agent/notify"
+	pb "agent/proto"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// checkProcessRules reports a Sysmon process creation event whose parent and
+// child images match a configured process rule, killing the child if the
+// rule asks for it. Only the first matching rule is applied.
+func (s *Scanner) checkProcessRules(ctx context.Context, event *SysmonEvent) {
+	if event.Image == "" || event.ParentImage == "" {
+		return
+	}
+
+	for _, rule := range s.config.Current().ProcessRules {
+		if !matchImage(rule.ParentImage, event.ParentImage) || !matchImage(rule.ChildImage, event.Image) {
+			continue
+		}
+
+		severity := rule.Severity
+		if severity == "" {
+			severity = config.DefaultProcessRuleSeverity
+		}
+		log.Printf("Process rule %s matched: %s started %s (PID %d)", rule.Name, event.ParentImage, event.Image, event.ProcessID)
+
+		action := ""
+		if rule.Kill && s.config.MonitorOnly() {
+			log.Printf("Agent is in monitor mode, not killing process %d matching rule %s", event.ProcessID, rule.Name)
+			action = ", not killed (monitor mode)"
+		} else if rule.Kill {
+			err := killRuleProcess(event)
+			if err != nil {
+				log.Printf("Failed to kill process %d matching rule %s: %v", event.ProcessID, rule.Name, err)
+			} else {
+				s.notifier.Notify(notify.ProcessStopped)
+			}
+			action = fmt.Sprintf(", killed: %v", err == nil)
+		}
+
+		context := fmt.Sprintf("Suspicious process: %s started %s (rule: %s%s)\nCommand line: %q\nParent command line: %q",
+			event.ParentImage, event.Image, rule.Name, action, event.CommandLine, event.ParentCommandLine)
+		if tree := formatProcessTree(sysmonProcessTree(event)); tree != "" {
+			context += "\n" + tree
+		}
+
+		s.recordMatches(1)
+		if s.reportCallback != nil {
+			s.reportCallback(ctx, pb.IOCType_IOC_BEHAVIOR, rule.Name, event.Image, context, severity)
+		}
+		return
+	}
+}
+
+// killRuleProcess kills the process created by event, after checking that
+// its PID has not been reused by another image
+func killRuleProcess(event *SysmonEvent) error {
+	p, err := process.NewProcess(int32(event.ProcessID))
+	if err != nil {
+		return err
+	}
+	if exe, err := p.Exe(); err == nil && exe != "" && !samePath(exe, event.Image) {
+		return fmt.Errorf("PID now runs %s", exe)
+	}
+	log.Printf("Killing %s", DescribeProcess(p))
+	return p.Kill()
+}
+
+// matchImage reports whether an image path matches a process rule pattern.
+// Patterns without a path separator are matched against the file name.
+func matchImage(pattern, image string) bool {
+	pattern = config.NormalizeImagePattern(pattern)
+	image = config.NormalizeImagePattern(image)
+	if !strings.Contains(pattern, "/") {
+		image = path.Base(image)
+	}
+	matched, _ := path.Match(pattern, image)
+	return matched
+}
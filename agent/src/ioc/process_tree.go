@@ -0,0 +1,154 @@
+package ioc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// maxProcessTreeDepth bounds how many processes are walked up from a match,
+// in case of a parent loop left by PID reuse
+const maxProcessTreeDepth = 16
+
+// maxProcessTreeCmdline is the longest command line kept per process
+const maxProcessTreeCmdline = 512
+
+// ProcessTreeHeader starts the process tree section appended to a match
+// report's context. Everything before it describes the match and the action
+// taken.
+const ProcessTreeHeader = "\nProcess tree:"
+
+// processInfo is one process in an ancestry chain
+type processInfo struct {
+	PID         int32
+	Name        string
+	CommandLine string
+}
+
+// imageProcessTree returns the ancestry of the first running process whose
+// executable is path, or nil if none is running
+func imageProcessTree(path string) []processInfo {
+	procs, err := process.Processes()
+	if err != nil {
+		return nil
+	}
+	for _, p := range procs {
+		if exe, err := p.Exe(); err == nil && samePath(exe, path) {
+			return walkProcessTree(p, nil)
+		}
+	}
+	return nil
+}
+
+// sysmonProcessTree returns the ancestry of the process in a Sysmon process
+// creation event. The process and its parent come from the event, so they
+// are known even if they have exited; older ancestors are read from the live
+// process list.
+func sysmonProcessTree(event *SysmonEvent) []processInfo {
+	chain := []processInfo{{
+		PID:         int32(event.ProcessID),
+		Name:        event.ProcessName,
+		CommandLine: event.CommandLine,
+	}}
+	if event.ParentImage == "" {
+		return chain
+	}
+	chain = append(chain, processInfo{
+		PID:         int32(event.ParentProcessID),
+		Name:        event.ParentImage[strings.LastIndexAny(event.ParentImage, `\/`)+1:],
+		CommandLine: event.ParentCommandLine,
+	})
+
+	// Only trust the live process if it still runs the parent's image; the
+	// PID may have been reused since the event was logged
+	parent, err := process.NewProcess(int32(event.ParentProcessID))
+	if err != nil {
+		return chain
+	}
+	if exe, err := parent.Exe(); err != nil || !samePath(exe, event.ParentImage) {
+		return chain
+	}
+	grandparent, err := parent.Parent()
+	if err != nil {
+		return chain
+	}
+	return walkProcessTree(grandparent, chain)
+}
+
+// walkProcessTree appends p and its ancestors to chain, stopping at the root,
+// at a PID already in the chain or after maxProcessTreeDepth processes
+func walkProcessTree(p *process.Process, chain []processInfo) []processInfo {
+	for p != nil && len(chain) < maxProcessTreeDepth {
+		for _, seen := range chain {
+			if seen.PID == p.Pid {
+				return chain
+			}
+		}
+
+		info := processInfo{PID: p.Pid}
+		info.Name, _ = p.Name()
+		info.CommandLine, _ = p.Cmdline()
+		chain = append(chain, info)
+
+		parent, err := p.Parent()
+		if err != nil || parent.Pid == p.Pid {
+			break
+		}
+		// A parent started after its child is a new process that reused the
+		// real parent's PID
+		if created, err := p.CreateTime(); err == nil {
+			if parentCreated, err := parent.CreateTime(); err == nil && parentCreated > created {
+				break
+			}
+		}
+		p = parent
+	}
+	return chain
+}
+
+// formatProcessTree renders chain as a ProcessTreeHeader section, one process
+// per line from the matched process up to its oldest known ancestor. Command
+// lines are quoted so they cannot add lines of their own.
+func formatProcessTree(chain []processInfo) string {
+	if len(chain) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString(ProcessTreeHeader)
+	for _, p := range chain {
+		cmdline := p.CommandLine
+		if len(cmdline) > maxProcessTreeCmdline {
+			cmdline = cmdline[:maxProcessTreeCmdline] + "..."
+		}
+		name := p.Name
+		if name == "" {
+			name = "unknown"
+		}
+		fmt.Fprintf(&b, "\n  PID %d %s %q", p.PID, name, cmdline)
+	}
+	return b.String()
+}
+
+// DescribeProcess summarizes a process for action reports as its PID,
+// executable path, quoted command line and owning user. Details that cannot
+// be read, for example because the process has exited, are left out.
+func DescribeProcess(p *process.Process) string {
+	parts := []string{fmt.Sprintf("PID %d", p.Pid)}
+	if exe, err := p.Exe(); err == nil && exe != "" {
+		parts = append(parts, exe)
+	} else if name, err := p.Name(); err == nil && name != "" {
+		parts = append(parts, name)
+	}
+	if cmdline, err := p.Cmdline(); err == nil && cmdline != "" {
+		if len(cmdline) > maxProcessTreeCmdline {
+			cmdline = cmdline[:maxProcessTreeCmdline] + "..."
+		}
+		parts = append(parts, fmt.Sprintf("%q", cmdline))
+	}
+	if user, err := p.Username(); err == nil && user != "" {
+		parts = append(parts, "user "+user)
+	}
+	return strings.Join(parts, " ")
+}
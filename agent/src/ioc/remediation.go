@@ -0,0 +1,226 @@
+package ioc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+
+	"agent/config"
+	"agent/notify"
+	"agent/persist"
+)
+
+// quarantineRecord is written next to each quarantined file so it can be
+// traced back to where it came from and restored
+type quarantineRecord struct {
+	OriginalPath  string    `json:"original_path"`
+	Hash          string    `json:"hash"`
+	HashType      string    `json:"hash_type"`
+	IOC           string    `json:"ioc"`
+	Severity      string    `json:"severity"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
+}
+
+// shouldBlock reports whether the remediation policy allows blocking an IP
+// or URL IOC, rather than only reporting it
+func (s *Scanner) shouldBlock(ioc IOC) bool {
+	return s.config.RemediationAction(ioc.Severity) != config.RemediationReportOnly
+}
+
+// reportOnlyReason says why a match of ioc is only reported, for the log
+func (s *Scanner) reportOnlyReason(ioc IOC) string {
+	if s.config.MonitorOnly() {
+		return "Agent is in monitor mode"
+	}
+	return fmt.Sprintf("Remediation policy for severity %q is report only", ioc.Severity)
+}
+
+// remediateFile applies the remediation policy action to a file that matched
+// a file hash IOC and returns a summary of what was done for the match report.
+// Files under protected_paths are never killed, quarantined or deleted.
+func (s *Scanner) remediateFile(action, filePath, hashValue string, ioc *IOC) string {
+	if action != config.RemediationReportOnly && s.config.IsProtectedPath(filePath) {
+		log.Printf("WARNING: %s matched file hash IOC %s but is in protected_paths, blocked remediation (%s)", filePath, ioc.Value, action)
+		return "blocked remediation on protected path"
+	}
+	
+	switch action {
+	case config.RemediationReportOnly:
+		log.Printf("%s, leaving %s in place", s.reportOnlyReason(*ioc), filePath)
+		if s.config.MonitorOnly() {
+			return "report only (monitor mode)"
+		}
+		return "report only"
+
+	case config.RemediationQuarantine:
+		dest, err := s.quarantineFile(filePath, hashValue, ioc)
+		if err != nil {
+			log.Printf("Failed to quarantine malicious file %s: %v", filePath, err)
+			return "quarantined: false"
+		}
+		log.Printf("Quarantined malicious file %s as %s", filePath, dest)
+		s.notifier.Notify(notify.FileQuarantined)
+		return "quarantined: true"
+	}
+
+	killed := ""
+	if action == config.RemediationKillAndDelete {
+		// List what was killed so the report says more than a count
+		procs := killProcessesByImage(filePath)
+		killed = fmt.Sprintf("killed: %d, ", len(procs))
+		if len(procs) > 0 {
+			killed = fmt.Sprintf("killed: %d [%s], ", len(procs), strings.Join(procs, "; "))
+		}
+	}
+
+	// Keep the sample for analysts before it is destroyed. The file is
+	// deleted even if the upload fails.
+	collected := ""
+	if s.config.Current().CollectBeforeDelete && s.collectFile != nil {
+		if err := s.collectFile(s.ctx, filePath, fmt.Sprintf("Matched file hash IOC %s", ioc.Value)); err != nil {
+			log.Printf("Failed to collect malicious file %s before deletion: %v", filePath, err)
+			collected = ", collected: false"
+		} else {
+			collected = ", collected: true"
+		}
+	}
+
+	fileDeleted := false
+	if err := removeFile(filePath); err != nil {
+		log.Printf("Failed to delete malicious file %s: %v", filePath, err)
+	} else {
+		log.Printf("Successfully deleted malicious file: %s", filePath)
+		fileDeleted = true
+		s.notifier.Notify(notify.FileRemoved)
+	}
+
+	return fmt.Sprintf("%sdeleted: %v%s", killed, fileDeleted, collected)
+}
+
+// quarantineFile moves a malicious file into <data_dir>/quarantine and
+// records where it came from. It returns the quarantined file's path.
+func (s *Scanner) quarantineFile(filePath, hashValue string, ioc *IOC) (string, error) {
+	dir := filepath.Join(s.config.DataDir, "quarantine")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %v", err)
+	}
+
+	// The extension keeps the file from being run by double-clicking it
+	dest := filepath.Join(dir, fmt.Sprintf("%s_%s.quarantine", time.Now().Format("20060102T150405.000"), filepath.Base(filePath)))
+	if err := moveFile(filePath, dest); err != nil {
+		return "", err
+	}
+	os.Chmod(dest, 0600)
+
+	record := quarantineRecord{
+		OriginalPath:  filePath,
+		Hash:          hashValue,
+		HashType:      hashTypeOf(*ioc),
+		IOC:           ioc.Value,
+		Severity:      ioc.Severity,
+		QuarantinedAt: time.Now(),
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err == nil {
+		err = persist.WriteFileAtomic(dest+".json", data, 0600)
+	}
+	if err != nil {
+		log.Printf("Warning: failed to write quarantine record for %s: %v", dest, err)
+	}
+
+	return dest, nil
+}
+
+// moveFile renames src to dest, falling back to copy and delete when they are
+// on different volumes
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %v", err)
+	}
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		in.Close()
+		return fmt.Errorf("failed to create quarantine file: %v", err)
+	}
+
+	_, copyErr := io.Copy(out, in)
+	in.Close()
+	if closeErr := out.Close(); copyErr == nil {
+		copyErr = closeErr
+	}
+	if copyErr != nil {
+		os.Remove(dest)
+		return fmt.Errorf("failed to copy file to quarantine: %v", copyErr)
+	}
+
+	if err := os.Remove(src); err != nil {
+		os.Remove(dest)
+		return fmt.Errorf("failed to remove original file: %v", err)
+	}
+	return nil
+}
+
+// removeFile deletes a file, retrying briefly because Windows keeps the image
+// of a just-killed process locked for a moment
+func removeFile(path string) error {
+	var err error
+	for attempt := 0; attempt < 5; attempt++ {
+		if err = os.Remove(path); err == nil || os.IsNotExist(err) {
+			return err
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	return err
+}
+
+// killProcessesByImage kills every process running the executable at path and
+// returns a description of each one killed
+func killProcessesByImage(path string) []string {
+	procs, err := process.Processes()
+	if err != nil {
+		log.Printf("Failed to enumerate processes: %v", err)
+		return nil
+	}
+
+	var killed []string
+	for _, p := range procs {
+		if int(p.Pid) == os.Getpid() {
+			continue
+		}
+		exe, err := p.Exe()
+		if err != nil || !samePath(exe, path) {
+			continue
+		}
+		// Describe it first, its command line and user are gone once it exits
+		description := DescribeProcess(p)
+		if err := p.Kill(); err != nil {
+			log.Printf("Failed to kill process %d running %s: %v", p.Pid, path, err)
+			continue
+		}
+		log.Printf("Killed process running malicious file %s: %s", path, description)
+		killed = append(killed, description)
+	}
+	return killed
+}
+
+// samePath compares two file paths, ignoring case on Windows
+func samePath(a, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
@@ -0,0 +1,52 @@
+package ioc
+
+import (
+	"time"
+)
+
+// ScanStats summarizes scanner activity. The counters are totals since the
+// agent started; LastScanDuration and LastScanTime describe the most recent
+// periodic scan.
+type ScanStats struct {
+	LastScanDuration time.Duration // How long the most recent periodic scan took
+	LastScanTime     time.Time     // When the most recent periodic scan started, zero before the first
+	FilesHashed      uint64        // Files read and hashed against the file hash IOCs
+	EventsProcessed  uint64        // Sysmon events checked against the IOCs
+	MatchesFound     uint64        // IOC and YARA matches reported
+}
+
+// Stats returns a snapshot of the scanner's statistics
+func (s *Scanner) Stats() ScanStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	return s.stats
+}
+
+// recordScan records the start time and duration of a periodic scan
+func (s *Scanner) recordScan(start time.Time, duration time.Duration) {
+	s.statsMu.Lock()
+	s.stats.LastScanTime = start
+	s.stats.LastScanDuration = duration
+	s.statsMu.Unlock()
+}
+
+// recordFileHashed counts a file hashed for IOC matching
+func (s *Scanner) recordFileHashed() {
+	s.statsMu.Lock()
+	s.stats.FilesHashed++
+	s.statsMu.Unlock()
+}
+
+// recordEventsProcessed counts n Sysmon events processed
+func (s *Scanner) recordEventsProcessed(n int) {
+	s.statsMu.Lock()
+	s.stats.EventsProcessed += uint64(n)
+	s.statsMu.Unlock()
+}
+
+// recordMatches counts n reported matches
+func (s *Scanner) recordMatches(n int) {
+	s.statsMu.Lock()
+	s.stats.MatchesFound += uint64(n)
+	s.statsMu.Unlock()
+}
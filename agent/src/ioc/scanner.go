@@ -6,31 +6,54 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	pb "agent/proto"
 	"agent/config"
 	"agent/blocker"
+	"agent/persist"
+	"agent/notify"
 )
 
 // Scanner scans the system for IOCs
 type Scanner struct {
 	manager         *Manager
 	reportCallback  func(context.Context, pb.IOCType, string, string, string, string) error
-	intervalMinutes int
 	ctx             context.Context
 	cancel          context.CancelFunc
 	blocker         *blocker.Blocker
 	config          *config.Config
 	triggerScan     chan struct{}
+	intervalUpdate  chan struct{} // Signals that the scan intervals changed
+	fileScanMu      sync.Mutex // Held for a whole file scan; guards lastScanTime, lastRecordRead and the bookmark file
+	expireMu        sync.Mutex // Held while stale blocks are expired
 	lastScanTime    time.Time // Track when the last scan was performed
 	lastRecordRead  uint32    // Track last Windows Event Log record read for efficient scanning
+	yara            *YaraEngine
+	throttle        *scanThrottle // Limits CPU use while hashing files
+	collectFile     func(ctx context.Context, path, reason string) error // Uploads a sample before deletion
+	batchReports    func(ctx context.Context) (context.Context, func()) // Groups a scan's match reports, nil to send each alone
+	reportSensor    func(degraded bool, reason string) // Told when Sysmon telemetry is lost or back
+	reportBlocks    func(blocked, total int, failed []string) // Told how many IOC IPs were blocked at startup
+	fim             *FIMMonitor   // Reports changes to fim_paths
+	notifier        *notify.Notifier // Tells the logged-on user about remediation actions, nil if unset
+	sysmonMu        sync.Mutex // Guards sysmonChecked and sysmonDegraded
+	sysmonChecked   bool
+	sysmonDegraded  bool
+	failedIPsMu     sync.Mutex    // Guards failedIPs
+	failedIPs       map[string]bool // IOC IPs that could not be blocked, retried on the next scan
+	remediationMu   sync.Mutex    // Serializes response actions taken by concurrent scan workers
+	statsMu         sync.Mutex    // Guards stats
+	stats           ScanStats
 }
 
 
@@ -41,29 +64,147 @@ func NewScanner(manager *Manager, reportCallback func(context.Context, pb.IOCTyp
 	cfg := config.NewDefaultConfig()
 	cfg.ScanInterval = intervalMinutes
 	
-	return NewScannerWithConfig(manager, reportCallback, cfg)
+	return NewScannerWithConfig(manager, reportCallback, cfg, nil)
 }
 
-// NewScannerWithConfig creates a new IOC scanner with configuration
-func NewScannerWithConfig(manager *Manager, reportCallback func(context.Context, pb.IOCType, string, string, string, string) error, cfg *config.Config) *Scanner {
+// NewScannerWithConfig creates a new IOC scanner with configuration. IOCs are
+// blocked with blk, which should be the Blocker that BLOCK_IP and UNBLOCK_IP
+// commands use, so both see the same block list; if it is nil the scanner
+// keeps its own under the IOC storage directory.
+func NewScannerWithConfig(manager *Manager, reportCallback func(context.Context, pb.IOCType, string, string, string, string) error, cfg *config.Config, blk *blocker.Blocker) *Scanner {
 	ctx, cancel := context.WithCancel(context.Background())
+	if blk == nil {
+		blk = blocker.NewBlocker(cfg, manager.StoragePath, manager.Integrity)
+	}
 	
-	return &Scanner{
+	s := &Scanner{
 		manager:         manager,
 		reportCallback:  reportCallback,
-		intervalMinutes: cfg.ScanInterval,
 		ctx:             ctx,
 		cancel:          cancel,
-		blocker:         blocker.NewBlocker(cfg, manager.StoragePath),
+		blocker:         blk,
 		config:          cfg,
 		triggerScan:     make(chan struct{}, 1),
+		intervalUpdate:  make(chan struct{}, 1),
 		lastScanTime:    time.Now(), // Start with current time since we skip first scan
+		yara:            NewYaraEngine(filepath.Join(cfg.DataDir, "yara")),
+		throttle:        newScanThrottle(func() int { return cfg.Current().ScanThrottlePercent }),
+		fim:             NewFIMMonitor(cfg),
+	}
+	
+	// Load YARA rules from <dataDir>/yara
+	if err := s.yara.Load(); err != nil {
+		log.Printf("Failed to load YARA rules: %v", err)
+	}
+	
+	// Resume from where the previous run stopped reading events
+	s.loadBookmark()
+	
+	// Retry the IPs a previous run could not block
+	s.loadFailedIPs()
+	
+	return s
+}
+
+// SetFileCollector sets the function used to upload malicious files to the
+// server before they are deleted when collect_before_delete is enabled
+func (s *Scanner) SetFileCollector(collect func(ctx context.Context, path, reason string) error) {
+	s.collectFile = collect
+}
+
+// SetReportBatching sets the function that starts a batch of match reports
+// for a scan. Matches reported with the context it returns are sent together
+// when the returned function is called at the end of the scan.
+func (s *Scanner) SetReportBatching(batch func(ctx context.Context) (context.Context, func())) {
+	s.batchReports = batch
+}
+
+// startReportBatch returns the context to report a scan's matches with and
+// the function that sends them once the scan is done
+func (s *Scanner) startReportBatch(ctx context.Context) (context.Context, func()) {
+	if s.batchReports == nil {
+		return ctx, func() {}
+	}
+	return s.batchReports(ctx)
+}
+
+// SetNotifier sets the notifier that tells the logged-on user when a file
+// is removed or a process is stopped and notify_user is enabled
+func (s *Scanner) SetNotifier(notifier *notify.Notifier) {
+	s.notifier = notifier
+}
+
+// FIM returns the file integrity monitor checked on every scan
+func (s *Scanner) FIM() *FIMMonitor {
+	return s.fim
+}
+
+// Yara returns the engine holding the loaded YARA rules
+func (s *Scanner) Yara() *YaraEngine {
+	return s.yara
+}
+
+// scanBookmark is the persisted position of the event log scan
+type scanBookmark struct {
+	LastRecordRead uint32    `json:"last_record_read"`
+	LastScanTime   time.Time `json:"last_scan_time"`
+}
+
+// bookmarkPath returns the path of the scan bookmark file
+func (s *Scanner) bookmarkPath() string {
+	return filepath.Join(s.config.DataDir, "scan_bookmark.json")
+}
+
+// loadBookmark restores the last read event record and scan time from disk
+func (s *Scanner) loadBookmark() {
+	data, err := os.ReadFile(s.bookmarkPath())
+	if os.IsNotExist(err) {
+		log.Printf("No scan bookmark found, starting from recent events")
+		return
+	} else if err != nil {
+		log.Printf("Failed to read scan bookmark: %v", err)
+		return
+	}
+	
+	var bookmark scanBookmark
+	if err := json.Unmarshal(data, &bookmark); err != nil {
+		log.Printf("Failed to parse scan bookmark, starting from recent events: %v", err)
+		return
+	}
+	
+	s.lastRecordRead = bookmark.LastRecordRead
+	if !bookmark.LastScanTime.IsZero() {
+		s.lastScanTime = bookmark.LastScanTime
+	}
+	
+	log.Printf("Loaded scan bookmark: last record %d, last scan %s",
+		s.lastRecordRead, s.lastScanTime.Format(time.RFC3339))
+}
+
+// saveBookmark persists the last read event record and scan time to disk.
+// The caller holds fileScanMu.
+func (s *Scanner) saveBookmark() {
+	data, err := json.MarshalIndent(scanBookmark{
+		LastRecordRead: s.lastRecordRead,
+		LastScanTime:   s.lastScanTime,
+	}, "", "  ")
+	if err != nil {
+		log.Printf("Failed to marshal scan bookmark: %v", err)
+		return
+	}
+	
+	if err := persist.WriteFileAtomic(s.bookmarkPath(), data, 0644); err != nil {
+		log.Printf("Failed to write scan bookmark: %v", err)
 	}
 }
 
 // Start starts the scanner
 func (s *Scanner) Start() {
-	log.Printf("Starting IOC scanner with interval %d minutes", s.intervalMinutes)
+	networkInterval, fileInterval := s.scanIntervals()
+	log.Printf("Starting IOC scanner: IP/URL checks every %v, file scans every %v", networkInterval, fileInterval)
+	if s.config.Current().ScanSchedule != "" {
+		log.Printf("Scheduled scans limited to %s (local time)", s.config.Current().ScanSchedule)
+	}
 	
 	// Initialize IP blockers on startup to ensure protection after restart
 	s.initializeIPBlocking()
@@ -77,29 +218,40 @@ func (s *Scanner) Start() {
 	// Run initial scan
 	go s.runScan(isFirstRun)
 	
-	// Start periodic scans only if interval is positive
+	// Cheap IP/URL block checks and expensive file scans run on
+	// independent timers
 	go func() {
-		// Use default interval of 5 minutes if intervalMinutes is non-positive
-		interval := s.intervalMinutes
-		if interval <= 0 {
-			interval = 5
-			log.Printf("WARNING: Scanner interval was %d minutes, defaulting to %d minutes", s.intervalMinutes, interval)
-		}
-		
-		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
-		defer ticker.Stop()
+		networkTicker := time.NewTicker(networkInterval)
+		defer networkTicker.Stop()
+		fileTicker := time.NewTicker(fileInterval)
+		defer fileTicker.Stop()
 		
 		for {
 			select {
-			case <-ticker.C:
-				go s.runScan(false) // Not first run
+			case <-networkTicker.C:
+				go s.expireStaleBlocks()
+				go s.runNetworkScan()
+			case <-fileTicker.C:
+				// Scheduled file scans only run inside scan_schedule
+				// windows; TriggerScan still scans at any time
+				if !s.config.ScanAllowedAt(time.Now()) {
+					log.Printf("Skipping scheduled file scan outside scan_schedule %q", s.config.Current().ScanSchedule)
+					continue
+				}
+				go s.runFileScan()
 			case <-s.triggerScan:
 				// Perform immediate scan
 				log.Printf("Triggering immediate IOC scan")
 				go s.runScan(false) // Not first run
 				
-				// Reset the timer
-				ticker.Reset(time.Duration(interval) * time.Minute)
+				// Reset the timers
+				networkTicker.Reset(networkInterval)
+				fileTicker.Reset(fileInterval)
+			case <-s.intervalUpdate:
+				networkInterval, fileInterval = s.scanIntervals()
+				log.Printf("IOC scan intervals changed: IP/URL checks every %v, file scans every %v", networkInterval, fileInterval)
+				networkTicker.Reset(networkInterval)
+				fileTicker.Reset(fileInterval)
 			case <-s.ctx.Done():
 				log.Printf("IOC scanner stopped")
 				return
@@ -108,6 +260,22 @@ func (s *Scanner) Start() {
 	}()
 }
 
+// scanIntervals returns the IP/URL check and file scan intervals, falling
+// back to 5 minutes if the configuration holds a non-positive value
+func (s *Scanner) scanIntervals() (time.Duration, time.Duration) {
+	network := s.config.GetIPURLScanIntervalDuration()
+	file := s.config.GetFileScanIntervalDuration()
+	if network <= 0 {
+		log.Printf("WARNING: IP/URL scan interval was %v, defaulting to 5 minutes", network)
+		network = 5 * time.Minute
+	}
+	if file <= 0 {
+		log.Printf("WARNING: File scan interval was %v, defaulting to 5 minutes", file)
+		file = 5 * time.Minute
+	}
+	return network, file
+}
+
 // TriggerScan triggers an immediate scan and resets the timer
 func (s *Scanner) TriggerScan() {
 	// Use non-blocking send to avoid hanging if channel is full
@@ -119,6 +287,17 @@ func (s *Scanner) TriggerScan() {
 	}
 }
 
+// UpdateIntervals restarts the scan timers with the intervals currently in
+// the configuration (scan_interval, ip_url_scan_interval and
+// file_scan_interval)
+func (s *Scanner) UpdateIntervals() {
+	// A pending update already makes the loop re-read the configuration
+	select {
+	case s.intervalUpdate <- struct{}{}:
+	default:
+	}
+}
+
 // Stop stops the scanner
 func (s *Scanner) Stop() {
 	s.cancel()
@@ -128,23 +307,44 @@ func (s *Scanner) Stop() {
 func (s *Scanner) initializeIPBlocking() {
 	log.Printf("Initializing IP blocking for all IOC IPs")
 	
-	// Count new blocks only
-	newBlocks := 0
-	
 	s.manager.mu.RLock()
-	for ip := range s.manager.IPAddresses {
-		if !s.blocker.IsIPBlocked(ip) {
-			s.blockIP(ip)
-			newBlocks++
-		} else {
-			log.Printf("Skipping already blocked IP: %s", ip)
+	var ips []string
+	for ip, ioc := range s.manager.IPAddresses {
+		// Report-only IOCs are only reported when they are seen in traffic
+		if s.shouldBlock(ioc) && !s.blocker.IsIPBlocked(ip) {
+			ips = append(ips, ip)
 		}
 	}
+	
+	// Block them in bulk so a large IOC set becomes a few firewall rules
+	newBlocks, failed := s.blockIPs(ips)
 	s.manager.mu.RUnlock()
 	
+	// Give transient firewall errors a moment to clear and try the
+	// failures once more
+	if len(failed) > 0 {
+		log.Printf("Failed to block %d of %d IPs, retrying in %v", len(failed), len(ips), ipBlockRetryDelay)
+		select {
+		case <-time.After(ipBlockRetryDelay):
+			s.manager.mu.RLock()
+			retried, stillFailed := s.blockIPs(failed)
+			s.manager.mu.RUnlock()
+			newBlocks += retried
+			failed = stillFailed
+		case <-s.ctx.Done():
+		}
+	}
+	
 	ipCount, _ := s.blocker.GetBlockedCount()
-	log.Printf("IP blocking initialized: %d new blocks, %d total blocked IPs", 
-		newBlocks, ipCount)
+	log.Printf("IP blocking initialized: %d/%d IPs successfully blocked, %d total blocked IPs", 
+		newBlocks, len(ips), ipCount)
+	if len(failed) > 0 {
+		log.Printf("WARNING: %d IPs could not be blocked and will be retried on the next scan: %v", len(failed), failed)
+	}
+	
+	if s.reportBlocks != nil && len(ips) > 0 {
+		s.reportBlocks(newBlocks, len(ips), failed)
+	}
 }
 
 // initializeURLBlocking initializes blocking of all malicious URLs immediately on startup
@@ -155,7 +355,10 @@ func (s *Scanner) initializeURLBlocking() {
 	newBlocks := 0
 	
 	s.manager.mu.RLock()
-	for url := range s.manager.URLs {
+	for url, ioc := range s.manager.URLs {
+		if !ioc.hostsBlockable() || !s.shouldBlock(ioc) {
+			continue
+		}
 		if !s.blocker.IsURLBlocked(url) {
 			s.blockURL(url)
 			newBlocks++
@@ -170,18 +373,32 @@ func (s *Scanner) initializeURLBlocking() {
 		newBlocks, urlCount)
 }
 
-// blockIP blocks an IP immediately using Windows Firewall
-func (s *Scanner) blockIP(ip string) {
-	// Use the centralized blocker
-	err := s.blocker.BlockIP(ip)
+// blockIPs blocks IPs in bulk and reports each one blocked (caller must hold
+// the manager's read lock). It returns the number blocked and the IPs that
+// are still not blocked, which are kept for retrying on the next scan.
+func (s *Scanner) blockIPs(ips []string) (int, []string) {
+	if len(ips) == 0 {
+		return 0, nil
+	}
 	
+	blocked, err := s.blocker.BlockIPs(s.ctx, ips, blocker.SourceScanner)
 	if err != nil {
-		log.Printf("Failed to block IP %s: %v", ip, err)
-	} else {
-		// Report the action
-		if s.reportCallback != nil {
-			ioc, exists := s.manager.IPAddresses[ip]
-			if exists {
+		log.Printf("Failed to block %d of %d IPs: %v", len(ips)-len(blocked), len(ips), err)
+	}
+	
+	var failed, cleared []string
+	for _, ip := range ips {
+		if s.blocker.IsIPBlocked(ip) {
+			cleared = append(cleared, ip)
+		} else {
+			failed = append(failed, ip)
+		}
+	}
+	s.updateFailedIPs(failed, cleared)
+	
+	if s.reportCallback != nil {
+		for _, ip := range blocked {
+			if ioc, exists := s.manager.IPAddresses[ip]; exists {
 				s.reportCallback(
 					s.ctx,
 					pb.IOCType_IOC_IP,
@@ -193,12 +410,13 @@ func (s *Scanner) blockIP(ip string) {
 			}
 		}
 	}
+	return len(blocked), failed
 }
 
-// blockURL blocks a URL by adding it to the hosts file
+// blockURL blocks a URL in the hosts file or DNS policy
 func (s *Scanner) blockURL(url string) {
 	// Use the centralized blocker
-	err := s.blocker.BlockURL(url)
+	err := s.blocker.BlockURL(url, blocker.SourceScanner)
 	
 	if err != nil {
 		log.Printf("Failed to block URL %s: %v", url, err)
@@ -212,7 +430,7 @@ func (s *Scanner) blockURL(url string) {
 					pb.IOCType_IOC_URL,
 					url,
 					url,
-					"URL blocked by adding domain to hosts file",
+					fmt.Sprintf("URL blocked by adding domain to %s", s.blocker.URLBlockTarget()),
 					ioc.Severity,
 				)
 			}
@@ -220,14 +438,49 @@ func (s *Scanner) blockURL(url string) {
 	}
 }
 
+// expireStaleBlocks refreshes blocks for IOCs that are still in the database
+// and removes blocks older than the configured block TTL. A run started while
+// the previous one, slowed by a large IOC set or firewall, is still going is
+// skipped, so runs do not pile up on every network tick.
+func (s *Scanner) expireStaleBlocks() {
+	ttl := s.config.GetBlockTTLDuration()
+	if ttl <= 0 {
+		return
+	}
+	if !s.expireMu.TryLock() {
+		log.Printf("Block expiry already running, skipping")
+		return
+	}
+	defer s.expireMu.Unlock()
+	
+	s.manager.mu.RLock()
+	for ip := range s.manager.IPAddresses {
+		s.blocker.RefreshIP(ip)
+	}
+	for url := range s.manager.URLs {
+		s.blocker.RefreshURL(url)
+	}
+	s.manager.mu.RUnlock()
+	
+	expiredIPs, expiredURLs := s.blocker.ExpireStaleBlocks(ttl)
+	if expiredIPs > 0 || expiredURLs > 0 {
+		log.Printf("Expired %d IP blocks and %d URL blocks older than %v", expiredIPs, expiredURLs, ttl)
+	}
+}
+
 // checkAndBlockNewURLs checks for any new URLs in the IOC database that need blocking
 func (s *Scanner) checkAndBlockNewURLs() {
 	log.Printf("Checking for new malicious URLs to block")
 	
 	s.manager.mu.RLock()
 	for url, ioc := range s.manager.URLs {
-		// If not already blocked, block it now
-		if !s.blocker.IsURLBlocked(url) {
+		// Wildcard and regex IOCs cannot be expressed in the hosts file
+		if !ioc.hostsBlockable() || !s.shouldBlock(ioc) {
+			continue
+		}
+		
+		// If not already blocked or unblocked by a command, block it now
+		if !s.blocker.IsURLBlocked(url) && !s.blocker.IsURLSuppressed(url) {
 			log.Printf("Found new malicious URL to block: %s (severity: %s)", url, ioc.Severity)
 			s.blockURL(url)
 		}
@@ -240,36 +493,86 @@ func (s *Scanner) runScan(isFirstRun bool) {
 	log.Printf("Starting IOC scan")
 	start := time.Now()
 	
-	// Check for new IPs to block
-	s.checkAndBlockNewIPs()
+	// Make sure there is Sysmon telemetry to scan
+	s.checkSysmon()
 	
-	// Check for new URLs to block
-	s.checkAndBlockNewURLs()
+	// Compare fim_paths with their baseline
+	s.fim.Check()
+	
+	s.runNetworkScan()
 	
 	// Skip file hash scanning on first run to improve startup performance
 	if isFirstRun {
 		log.Printf("Skipping file hash scanning on first run for better performance")
 	} else {
-		// Scan sysmon logs for file hash matches
-		s.scanSysmonLogs()
+		s.scanFiles(start)
 	}
 	
 	duration := time.Since(start)
+	s.recordScan(start, duration)
 	log.Printf("IOC scan completed in %v", duration)
 }
 
+// runNetworkScan blocks IP and URL IOCs that are not blocked yet
+func (s *Scanner) runNetworkScan() {
+	// Check for new IPs to block
+	s.checkAndBlockNewIPs()
+	
+	// Check for new URLs to block
+	s.checkAndBlockNewURLs()
+}
+
+// runFileScan performs the periodic file scans: FIM and Sysmon file hashes
+func (s *Scanner) runFileScan() {
+	log.Printf("Starting file scan")
+	start := time.Now()
+	
+	s.checkSysmon()
+	s.fim.Check()
+	s.scanFiles(start)
+	
+	duration := time.Since(start)
+	s.recordScan(start, duration)
+	log.Printf("File scan completed in %v", duration)
+}
+
+// scanFiles scans the Sysmon logs for file hash matches and records the scan
+// position. Only one file scan runs at a time; a scan started while another
+// is running is skipped, since the running one reads the same events.
+func (s *Scanner) scanFiles(start time.Time) {
+	if !s.fileScanMu.TryLock() {
+		log.Printf("File scan already running, skipping")
+		return
+	}
+	defer s.fileScanMu.Unlock()
+	
+	s.scanSysmonLogs()
+	
+	s.lastScanTime = start
+	s.saveBookmark()
+}
+
 // checkAndBlockNewIPs checks for any new IPs in the IOC database that need blocking
 func (s *Scanner) checkAndBlockNewIPs() {
 	log.Printf("Checking for new malicious IPs to block")
 	
 	s.manager.mu.RLock()
+	// IPs that failed before are retried first, by themselves
+	retried := s.retryFailedIPs()
+	
+	var ips []string
 	for ip, ioc := range s.manager.IPAddresses {
-		// If not already blocked, block it now
-		if !s.blocker.IsIPBlocked(ip) {
+		if !s.shouldBlock(ioc) || retried[ip] {
+			continue
+		}
+		
+		// If not already blocked or unblocked by a command, block it now
+		if !s.blocker.IsIPBlocked(ip) && !s.blocker.IsIPSuppressed(ip) {
 			log.Printf("Found new malicious IP to block: %s (severity: %s)", ip, ioc.Severity)
-			s.blockIP(ip)
+			ips = append(ips, ip)
 		}
 	}
+	s.blockIPs(ips)
 	s.manager.mu.RUnlock()
 }
 
@@ -277,8 +580,12 @@ func (s *Scanner) checkAndBlockNewIPs() {
 func (s *Scanner) scanSysmonLogs() {
 	log.Printf("Scanning Windows sysmon logs for file hash matches using efficient API method")
 	
+	// Matches found in the log are reported together at the end of the scan
+	ctx, flush := s.startReportBatch(s.ctx)
+	defer flush()
+	
 	// Use only the efficient API-based scanning, no file export
-	if err := s.scanWindowsSysmonLogsEfficient(); err != nil {
+	if err := s.scanWindowsSysmonLogsEfficient(ctx); err != nil {
 		log.Printf("Efficient API-based scanning failed: %v", err)
 		log.Printf("File export method has been removed for security and performance reasons")
 	}
@@ -286,8 +593,9 @@ func (s *Scanner) scanSysmonLogs() {
 
 
 
-// processHashesData processes hash data in format SHA256=X,MD5=Y,SHA1=Z
-func (s *Scanner) processHashesData(hashData string, filePath string) {
+// processHashesData processes hash data in format SHA256=X,MD5=Y,SHA1=Z.
+// tree is the ancestry of the process running filePath, if known.
+func (s *Scanner) processHashesData(ctx context.Context, hashData string, filePath string, tree []processInfo) {
 	// Hash data might contain multiple hash algorithms
 	hashes := strings.Split(hashData, ",")
 	
@@ -299,60 +607,115 @@ func (s *Scanner) processHashesData(hashData string, filePath string) {
 			// Check if hash matches IOCs
 			match, ioc := s.manager.CheckFileHash(hashValue)
 			if match {
-				s.handleMaliciousFile(filePath, hashValue, &ioc)
+				s.handleMaliciousFile(ctx, filePath, hashValue, &ioc, tree)
 			}
 		}
 	}
 }
 
-// handleMaliciousFile takes action on a malicious file
-func (s *Scanner) handleMaliciousFile(filePath string, hashValue string, ioc *IOC) {
-	log.Printf("Found file hash IOC match: %s (%s)", filePath, hashValue)
+// handleMaliciousFile takes action on a malicious file and reports it with
+// ctx. The report includes tree, or when it is nil the ancestry of a running
+// process of the file.
+func (s *Scanner) handleMaliciousFile(ctx context.Context, filePath string, hashValue string, ioc *IOC, tree []processInfo) {
+	s.remediationMu.Lock()
+	
+	// Look up the process tree before remediation can kill the processes
+	if tree == nil {
+		tree = imageProcessTree(filePath)
+	}
 	
-	fileDeleted := false
+	// The action depends on the IOC's severity (remediation_policy)
+	action := s.config.RemediationAction(ioc.Severity)
+	log.Printf("Found file hash IOC match: %s (%s), severity %q, action %s", filePath, hashValue, ioc.Severity, action)
 	
-	// Delete the malicious file
-	if err := os.Remove(filePath); err != nil {
-		log.Printf("Failed to delete malicious file %s: %v", filePath, err)
-	} else {
-		log.Printf("Successfully deleted malicious file: %s", filePath)
-		fileDeleted = true
-	}
+	outcome := s.remediateFile(action, filePath, hashValue, ioc)
+	s.remediationMu.Unlock()
+	s.recordMatches(1)
 	
-	// Report the match
+	// Report the match without holding up other workers' remediation
 	if s.reportCallback != nil {
 		s.reportCallback(
-			s.ctx,
+			ctx,
 			pb.IOCType_IOC_HASH,
 			ioc.Value,
 			hashValue,
-			fmt.Sprintf("Malicious file: %s (deleted: %v)", filePath, fileDeleted),
+			fmt.Sprintf("Malicious file: %s (action: %s, %s)%s", filePath, action, outcome, formatProcessTree(tree)),
 			ioc.Severity,
 		)
 	}
 }
 
-// calculateFileHash calculates SHA256 hash of a file
-func (s *Scanner) calculateFileHash(filePath string) (string, error) {
-	file, err := os.Open(filePath)
+// scanFileWithYara matches a file against the loaded YARA rules and reports
+// every matching rule with ctx. It returns the number of matching rules.
+func (s *Scanner) scanFileWithYara(ctx context.Context, filePath string) int {
+	if s.yara == nil || s.yara.RuleCount() == 0 || filePath == "" {
+		return 0
+	}
+	
+	s.throttle.wait()
+	matches, err := s.yara.ScanFile(filePath)
 	if err != nil {
-		return "", err
+		return 0
 	}
-	defer file.Close()
 	
-	// Calculate multiple hash types
-	md5Hash := md5.New()
-	sha1Hash := sha1.New()
-	sha256Hash := sha256.New()
+	s.recordMatches(len(matches))
+	for _, match := range matches {
+		log.Printf("Found YARA rule match: %s (%s)", filePath, match.Rule)
+		
+		severity := match.Meta["severity"]
+		if severity == "" {
+			severity = "medium"
+		}
+		
+		if s.reportCallback != nil {
+			s.reportCallback(
+				ctx,
+				pb.IOCType_IOC_YARA,
+				match.Rule,
+				filePath,
+				fmt.Sprintf("YARA rule %s matched file: %s (strings: %s)", match.Rule, filePath, strings.Join(match.Strings, ", ")),
+				severity,
+			)
+		}
+	}
 	
-	multiWriter := io.MultiWriter(md5Hash, sha1Hash, sha256Hash)
+	return len(matches)
+}
+
+// UpdateYaraRules writes YARA rule files pushed by the server into the rules
+// directory and reloads the rule set. Files that fail to compile are rejected.
+func (s *Scanner) UpdateYaraRules(rules map[string]string) error {
+	dir := s.yara.RulesDir()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create YARA rules directory: %v", err)
+	}
 	
-	if _, err := io.Copy(multiWriter, file); err != nil {
-		return "", err
+	written := 0
+	for name, source := range rules {
+		// Only plain file names are accepted so rules cannot be written elsewhere
+		name = filepath.Base(name)
+		if name == "." || name == string(filepath.Separator) {
+			continue
+		}
+		if ext := filepath.Ext(name); ext != ".yar" && ext != ".yara" {
+			name += ".yar"
+		}
+		
+		if _, err := CompileYaraRules(source); err != nil {
+			log.Printf("Rejecting YARA rule file %s: %v", name, err)
+			continue
+		}
+		
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			log.Printf("Failed to write YARA rule file %s: %v", name, err)
+			continue
+		}
+		written++
 	}
 	
-	// Return SHA256 hash by default
-	return hex.EncodeToString(sha256Hash.Sum(nil)), nil
+	log.Printf("Updated %d of %d YARA rule files", written, len(rules))
+	
+	return s.yara.Load()
 }
 
 // GetMD5 calculates MD5 hash of a file
@@ -403,19 +766,24 @@ func GetSHA256(filePath string) (string, error) {
 	return hex.EncodeToString(hash.Sum(nil)), nil
 }
 
-// GetLocalIP returns the non-loopback local IP of the host
+// GetLocalIP returns the non-loopback local IP of the host, preferring IPv4
+// and falling back to a global IPv6 address on IPv6-only hosts
 func GetLocalIP() string {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return ""
 	}
+	ipv6 := ""
 	for _, address := range addrs {
 		// Check the address type and make sure it's not a loopback
 		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 			if ipnet.IP.To4() != nil {
 				return ipnet.IP.String()
 			}
+			if ipv6 == "" && ipnet.IP.IsGlobalUnicast() {
+				ipv6 = ipnet.IP.String()
+			}
 		}
 	}
-	return ""
+	return ipv6
 } 
\ No newline at end of file
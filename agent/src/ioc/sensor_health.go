@@ -0,0 +1,42 @@
+package ioc
+
+import (
+	"log"
+	"runtime"
+)
+
+// SetSensorReporter sets the function told when Sysmon stops or resumes
+// providing telemetry, so the server can flag endpoints without coverage
+func (s *Scanner) SetSensorReporter(report func(degraded bool, reason string)) {
+	s.reportSensor = report
+}
+
+// checkSysmon checks that Sysmon is available and reports changes. The first
+// check only reports a missing Sysmon, later ones every change.
+func (s *Scanner) checkSysmon() {
+	if runtime.GOOS != "windows" {
+		return
+	}
+	available, reason := IsSysmonAvailable()
+	
+	s.sysmonMu.Lock()
+	changed := s.sysmonDegraded == available
+	if !s.sysmonChecked {
+		changed = !available
+	}
+	s.sysmonChecked = true
+	s.sysmonDegraded = !available
+	s.sysmonMu.Unlock()
+	
+	if !changed {
+		return
+	}
+	if available {
+		log.Printf("Sysmon is available again, file hash telemetry restored")
+	} else {
+		log.Printf("WARNING: Sensor degraded, no file hash telemetry is collected: %s", reason)
+	}
+	if s.reportSensor != nil {
+		s.reportSensor(!available, reason)
+	}
+}
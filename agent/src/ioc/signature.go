@@ -0,0 +1,167 @@
+package ioc
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+
+	pb "agent/proto"
+)
+
+// signaturePayloadHeader starts every signed IOC payload so a signature made
+// for another purpose with the same key is never accepted
+const signaturePayloadHeader = "edr-ioc-update-v2\n"
+
+// ErrInvalidSignature is returned by UpdateFromProto and ApplyDelta when a
+// signing key is configured and the update's signature is missing or wrong,
+// or the update was signed for another agent
+var ErrInvalidSignature = errors.New("IOC update signature is missing or invalid")
+
+// LoadSigningKey reads the Ed25519 public key IOC updates are signed with from
+// a PEM file (a PKIX "PUBLIC KEY" block, as written by openssl pkey -pubout)
+func LoadSigningKey(path string) (ed25519.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IOC signing key %s: %v", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("IOC signing key %s is not a PEM encoded public key", path)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse IOC signing key %s: %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("IOC signing key %s is a %T, an Ed25519 key is required", path, key)
+	}
+	return edKey, nil
+}
+
+// SignedPayload returns the bytes an IOC update's signature covers. Each
+// element is written as its decimal byte length, a colon, the bytes and a
+// newline, in this order:
+//
+//	version, is_delta ("1" or "0"), base_version, agent_id
+//	for ip_addresses, file_hashes and urls: the section name, the entry count,
+//	  then per entry sorted by key: key, description, severity, the metadata
+//	  count and the metadata keys and values sorted by key
+//	for removed_ip_addresses, removed_file_hashes and removed_urls: the
+//	  section name, the count and the sorted values
+//	yara_rules: the section name, the count, then the file names and contents
+//	  sorted by file name
+//
+// The timestamp and the signature itself are not covered.
+func SignedPayload(response *pb.IOCResponse) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(signaturePayloadHeader)
+
+	field := func(s string) {
+		buf.WriteString(strconv.Itoa(len(s)))
+		buf.WriteByte(':')
+		buf.WriteString(s)
+		buf.WriteByte('\n')
+	}
+
+	field(strconv.FormatInt(response.Version, 10))
+	if response.IsDelta {
+		field("1")
+	} else {
+		field("0")
+	}
+	field(strconv.FormatInt(response.BaseVersion, 10))
+	field(response.AgentId)
+
+	indicators := func(name string, entries map[string]*pb.IOCData) {
+		field(name)
+		field(strconv.Itoa(len(entries)))
+		for _, key := range sortedKeys(entries) {
+			data := entries[key]
+			if data == nil {
+				data = &pb.IOCData{}
+			}
+			field(key)
+			field(data.Description)
+			field(data.Severity)
+			field(strconv.Itoa(len(data.Metadata)))
+			for _, metaKey := range sortedKeys(data.Metadata) {
+				field(metaKey)
+				field(data.Metadata[metaKey])
+			}
+		}
+	}
+	indicators("ip_addresses", response.IpAddresses)
+	indicators("file_hashes", response.FileHashes)
+	indicators("urls", response.Urls)
+
+	removed := func(name string, values []string) {
+		sorted := append([]string(nil), values...)
+		sort.Strings(sorted)
+		field(name)
+		field(strconv.Itoa(len(sorted)))
+		for _, value := range sorted {
+			field(value)
+		}
+	}
+	removed("removed_ip_addresses", response.RemovedIpAddresses)
+	removed("removed_file_hashes", response.RemovedFileHashes)
+	removed("removed_urls", response.RemovedUrls)
+
+	field("yara_rules")
+	field(strconv.Itoa(len(response.YaraRules)))
+	for _, name := range sortedKeys(response.YaraRules) {
+		field(name)
+		field(response.YaraRules[name])
+	}
+
+	return buf.Bytes()
+}
+
+// SetSigningKey pins the key IOC updates must be signed with. agentID returns
+// this agent's current ID, which a signed update must be addressed to. With a
+// nil key updates are applied without verification.
+func (m *Manager) SetSigningKey(key ed25519.PublicKey, agentID func() string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.signingKey = key
+	m.agentID = agentID
+}
+
+// verifySignatureUnlocked checks response against the pinned signing key
+// (caller must hold the lock)
+func (m *Manager) verifySignatureUnlocked(response *pb.IOCResponse) error {
+	if m.signingKey == nil {
+		return nil
+	}
+	if len(response.Signature) == 0 {
+		return fmt.Errorf("%w: IOC version %d is not signed", ErrInvalidSignature, response.Version)
+	}
+	if !ed25519.Verify(m.signingKey, SignedPayload(response), response.Signature) {
+		return fmt.Errorf("%w: signature on IOC version %d does not match the pinned key", ErrInvalidSignature, response.Version)
+	}
+	
+	// A valid signature for another agent is a replay
+	if agentID := m.agentID(); response.AgentId != agentID {
+		return fmt.Errorf("%w: IOC version %d was signed for agent %q, not %q", ErrInvalidSignature, response.Version, response.AgentId, agentID)
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
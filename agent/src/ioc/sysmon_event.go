@@ -0,0 +1,143 @@
+package ioc
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sysmonEventIDs are the Sysmon event IDs the scanner processes
+var sysmonEventIDs = []uint32{
+	1,  // Process creation
+	3,  // Network connection
+	11, // File creation
+	15, // File create stream hash
+	22, // DNS query
+	23, // File delete
+	29, // Remote thread creation
+}
+
+// SysmonEvent represents a parsed Sysmon event
+type SysmonEvent struct {
+	RecordNumber  uint32
+	EventID       uint32
+	TimeGenerated time.Time
+	ProcessName   string
+	ProcessID     uint32
+	Image         string
+	Hashes        string
+	TargetFilename string
+	SourceImage   string
+	TargetImage   string
+	CommandLine   string
+	ParentProcessID   uint32
+	ParentImage       string
+	ParentCommandLine string
+	DestinationIp       string
+	DestinationHostname string
+	DestinationPort     string
+	QueryName           string
+
+	// Data holds every EventData field by name, including ones without a
+	// dedicated struct field
+	Data map[string]string
+}
+
+// eventXML mirrors the parts of the Windows event XML schema we use
+type eventXML struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID       uint32 `xml:"EventID"`
+		Level         uint8  `xml:"Level"`
+		EventRecordID uint64 `xml:"EventRecordID"`
+		TimeCreated   struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		Channel  string `xml:"Channel"`
+		Computer string `xml:"Computer"`
+	} `xml:"System"`
+	EventData struct {
+		Data []struct {
+			Name  string `xml:"Name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"Data"`
+	} `xml:"EventData"`
+}
+
+// parseSysmonEventXML builds a SysmonEvent from a rendered event XML document.
+// Fields are looked up by name so the result does not depend on the field
+// order of a particular Sysmon schema version.
+func parseSysmonEventXML(data []byte) (*SysmonEvent, error) {
+	var doc eventXML
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to parse event XML: %v", err)
+	}
+
+	event := &SysmonEvent{
+		RecordNumber: uint32(doc.System.EventRecordID),
+		EventID:      doc.System.EventID,
+		Data:         make(map[string]string, len(doc.EventData.Data)),
+	}
+
+	if t, err := time.Parse(time.RFC3339Nano, doc.System.TimeCreated.SystemTime); err == nil {
+		event.TimeGenerated = t
+	}
+
+	for _, d := range doc.EventData.Data {
+		event.Data[d.Name] = strings.TrimSpace(d.Value)
+	}
+
+	event.Image = event.Data["Image"]
+	event.CommandLine = event.Data["CommandLine"]
+	event.ParentImage = event.Data["ParentImage"]
+	event.ParentCommandLine = event.Data["ParentCommandLine"]
+	event.TargetFilename = event.Data["TargetFilename"]
+	event.SourceImage = event.Data["SourceImage"]
+	event.TargetImage = event.Data["TargetImage"]
+	event.DestinationIp = event.Data["DestinationIp"]
+	event.DestinationHostname = event.Data["DestinationHostname"]
+	event.DestinationPort = event.Data["DestinationPort"]
+	event.QueryName = strings.TrimSuffix(event.Data["QueryName"], ".")
+
+	// Event 15 calls the field "Hash", the others "Hashes"
+	event.Hashes = event.Data["Hashes"]
+	if event.Hashes == "" {
+		event.Hashes = event.Data["Hash"]
+	}
+
+	if pid, err := strconv.ParseUint(event.Data["ProcessId"], 10, 32); err == nil {
+		event.ProcessID = uint32(pid)
+	}
+	if pid, err := strconv.ParseUint(event.Data["ParentProcessId"], 10, 32); err == nil {
+		event.ParentProcessID = uint32(pid)
+	}
+	if event.Image != "" {
+		event.ProcessName = event.Image[strings.LastIndexAny(event.Image, `\/`)+1:]
+	}
+
+	return event, nil
+}
+
+// isSysmonEventOfInterest checks if the event ID is one we care about
+func isSysmonEventOfInterest(eventID uint32) bool {
+	for _, id := range sysmonEventIDs {
+		if id == eventID {
+			return true
+		}
+	}
+	return false
+}
+
+// sysmonQuery builds the XPath query selecting events of interest with a
+// record ID of at least startRecord
+func sysmonQuery(startRecord uint32) string {
+	ids := make([]string, len(sysmonEventIDs))
+	for i, id := range sysmonEventIDs {
+		ids[i] = fmt.Sprintf("EventID=%d", id)
+	}
+	return fmt.Sprintf("*[System[(%s) and EventRecordID>=%d]]", strings.Join(ids, " or "), startRecord)
+}
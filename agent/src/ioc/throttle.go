@@ -0,0 +1,118 @@
+package ioc
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+const (
+	// throttleInterval is how often the agent's CPU use is sampled
+	throttleInterval = 100 * time.Millisecond
+	// maxThrottleSleep bounds a single pause so scans keep making progress
+	maxThrottleSleep = time.Second
+	// throttleChunkSize is how much of a file is read between throttle checks
+	throttleChunkSize = 1 << 20
+)
+
+// scanThrottle keeps the agent's CPU share below a target while files are
+// hashed. Callers invoke wait between units of work; when the agent used
+// more CPU than allowed since the last sample, wait sleeps long enough to
+// bring the average back to the target.
+type scanThrottle struct {
+	mu         sync.Mutex
+	percent    func() int // Current target, 0 disables throttling
+	proc       *process.Process
+	lastSample time.Time
+	lastCPU    float64 // Agent CPU seconds (user + system) at lastSample
+}
+
+// newScanThrottle creates a throttle for the current process. percent is read
+// on every check so a configuration reload takes effect immediately.
+func newScanThrottle(percent func() int) *scanThrottle {
+	t := &scanThrottle{percent: percent}
+	if proc, err := process.NewProcess(int32(os.Getpid())); err == nil {
+		t.proc = proc
+	}
+	return t
+}
+
+// wait pauses if the agent is above its CPU target
+func (t *scanThrottle) wait() {
+	if t == nil || t.proc == nil {
+		return
+	}
+	target := t.percent()
+	if target <= 0 || target >= 100 {
+		return
+	}
+
+	t.mu.Lock()
+	now := time.Now()
+	elapsed := now.Sub(t.lastSample)
+	if elapsed < throttleInterval {
+		t.mu.Unlock()
+		return
+	}
+
+	times, err := t.proc.Times()
+	if err != nil {
+		t.mu.Unlock()
+		return
+	}
+	cpu := times.User + times.System
+	used := cpu - t.lastCPU
+	first := t.lastSample.IsZero()
+	t.lastSample = now
+	t.lastCPU = cpu
+	t.mu.Unlock()
+
+	if first {
+		return
+	}
+
+	// Wall time over which the CPU just used would average out to the
+	// target share of all cores
+	capacity := float64(runtime.NumCPU()) * float64(target) / 100
+	needed := time.Duration(used / capacity * float64(time.Second))
+	sleep := needed - elapsed
+	if sleep <= 0 {
+		return
+	}
+	if sleep > maxThrottleSleep {
+		sleep = maxThrottleSleep
+	}
+	time.Sleep(sleep)
+}
+
+// reader wraps r so the throttle is checked after every chunk read, which
+// keeps hashing a single large file from monopolizing the CPU
+func (t *scanThrottle) reader(r io.Reader) io.Reader {
+	if t == nil {
+		return r
+	}
+	return &throttledReader{r: r, t: t}
+}
+
+type throttledReader struct {
+	r    io.Reader
+	t    *scanThrottle
+	read int
+}
+
+func (tr *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := tr.r.Read(p)
+	tr.read += n
+	if tr.read >= throttleChunkSize {
+		tr.read = 0
+		tr.t.wait()
+	}
+	return n, err
+}
@@ -0,0 +1,243 @@
+package ioc
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// URL IOC match types, set through the "match_type" metadata field
+const (
+	MatchExact    = "exact"    // Whole URL, or host when the IOC is a bare domain
+	MatchSuffix   = "suffix"   // Domain and all of its subdomains
+	MatchWildcard = "wildcard" // Glob with * and ?, against the host or the whole URL
+	MatchRegex    = "regex"    // Regular expression against the whole URL
+)
+
+// maxURLRegexLength caps the size of regex URL IOCs
+const maxURLRegexLength = 1024
+
+// matchPriority orders match types from most to least specific
+var matchPriority = map[string]int{
+	MatchExact:    4,
+	MatchSuffix:   3,
+	MatchWildcard: 2,
+	MatchRegex:    1,
+}
+
+// urlMatcher is a compiled URL IOC
+type urlMatcher struct {
+	matchType string
+	pattern   string         // Normalized pattern (exact, suffix, wildcard)
+	hostOnly  bool           // Pattern has no path and is matched against the host
+	re        *regexp.Regexp // Compiled pattern (wildcard, regex)
+	ioc       IOC
+}
+
+// URLMatchType returns the match type of a URL IOC, defaulting to exact
+func (ioc IOC) URLMatchType() string {
+	if t := strings.ToLower(ioc.Metadata["match_type"]); t != "" {
+		return t
+	}
+	return MatchExact
+}
+
+// hostsBlockable reports whether a URL IOC names a single domain that can be
+// blocked through the hosts file. Wildcard and regex IOCs are detection only.
+func (ioc IOC) hostsBlockable() bool {
+	switch ioc.URLMatchType() {
+	case MatchExact:
+		return true
+	case MatchSuffix:
+		return !strings.Contains(ioc.Value, "*")
+	}
+	return false
+}
+
+// compileURLMatcher validates a URL IOC and prepares it for matching
+func compileURLMatcher(ioc IOC) (*urlMatcher, error) {
+	m := &urlMatcher{matchType: ioc.URLMatchType(), ioc: ioc}
+
+	switch m.matchType {
+	case MatchExact:
+		host, full := normalizeURL(ioc.Value)
+		m.pattern = full
+		m.hostOnly = host == full
+	case MatchSuffix:
+		host, _ := normalizeURL(ioc.Value)
+		m.pattern = strings.TrimPrefix(host, "*.")
+		m.pattern = strings.TrimPrefix(m.pattern, ".")
+		m.hostOnly = true
+	case MatchWildcard:
+		pattern := strings.ToLower(strings.TrimSpace(ioc.Value))
+		if i := strings.Index(pattern, "://"); i >= 0 {
+			pattern = pattern[i+3:]
+		}
+		m.pattern = strings.TrimSuffix(pattern, "/")
+		m.hostOnly = !strings.Contains(m.pattern, "/")
+		expr := regexp.QuoteMeta(m.pattern)
+		expr = strings.ReplaceAll(expr, `\*`, `.*`)
+		expr = strings.ReplaceAll(expr, `\?`, `.`)
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid wildcard pattern %q: %v", ioc.Value, err)
+		}
+		m.re = re
+	case MatchRegex:
+		if len(ioc.Value) > maxURLRegexLength {
+			return nil, fmt.Errorf("regex pattern longer than %d characters", maxURLRegexLength)
+		}
+		// Go regexps run in linear time, so compiling is the only validation needed
+		re, err := regexp.Compile("(?i)" + ioc.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %q: %v", ioc.Value, err)
+		}
+		m.pattern = ioc.Value
+		m.re = re
+	default:
+		return nil, fmt.Errorf("unknown match_type %q", m.matchType)
+	}
+
+	if m.pattern == "" {
+		return nil, fmt.Errorf("empty URL pattern")
+	}
+
+	return m, nil
+}
+
+// match checks a normalized host and URL against the matcher
+func (m *urlMatcher) match(host, full string) bool {
+	switch m.matchType {
+	case MatchExact:
+		if m.hostOnly {
+			return host == m.pattern
+		}
+		return full == m.pattern
+	case MatchSuffix:
+		return host == m.pattern || strings.HasSuffix(host, "."+m.pattern)
+	case MatchWildcard:
+		if m.hostOnly {
+			return m.re.MatchString(host)
+		}
+		return m.re.MatchString(full)
+	case MatchRegex:
+		return m.re.MatchString(full)
+	}
+	return false
+}
+
+// normalizeURL lowercases a URL or domain and returns its host and the URL
+// without scheme, user info, port, fragment or trailing slash
+func normalizeURL(raw string) (host, full string) {
+	full = strings.ToLower(strings.TrimSpace(raw))
+	if i := strings.Index(full, "://"); i >= 0 {
+		full = full[i+3:]
+	}
+	if i := strings.IndexByte(full, '#'); i >= 0 {
+		full = full[:i]
+	}
+
+	host = full
+	rest := ""
+	if i := strings.IndexAny(full, "/?"); i >= 0 {
+		host, rest = full[:i], full[i:]
+	}
+	if i := strings.LastIndexByte(host, '@'); i >= 0 {
+		host = host[i+1:]
+	}
+	// Strip the port, leaving bracketed IPv6 literals intact
+	if i := strings.LastIndexByte(host, ':'); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	host = strings.TrimSuffix(host, ".")
+
+	full = strings.TrimSuffix(host+rest, "/")
+	return host, full
+}
+
+// urlIndex finds the URL IOC matching a host or URL. Exact and suffix IOCs,
+// the bulk of a feed, are looked up in maps; only wildcard and regex IOCs
+// are tried one by one.
+type urlIndex struct {
+	exactURLs  map[string]*urlMatcher // Exact IOCs with a path, by URL
+	exactHosts map[string]*urlMatcher // Exact IOCs without a path, by host
+	suffixes   map[string]*urlMatcher // Suffix IOCs, by domain
+	patterns   []*urlMatcher          // Wildcard and regex IOCs, most specific first
+}
+
+// lookup returns the most specific matcher for a normalized host and URL,
+// or nil. An exact URL is longer than its host, so it is tried first, and
+// suffixes are tried from the whole host down to its last label, so the
+// longest matching domain wins.
+func (x *urlIndex) lookup(host, full string) *urlMatcher {
+	if matcher := x.exactURLs[full]; matcher != nil {
+		return matcher
+	}
+	if matcher := x.exactHosts[host]; matcher != nil {
+		return matcher
+	}
+
+	if len(x.suffixes) > 0 {
+		for domain := host; domain != ""; {
+			if matcher := x.suffixes[domain]; matcher != nil {
+				return matcher
+			}
+			i := strings.IndexByte(domain, '.')
+			if i < 0 {
+				break
+			}
+			domain = domain[i+1:]
+		}
+	}
+
+	for _, matcher := range x.patterns {
+		if matcher.match(host, full) {
+			return matcher
+		}
+	}
+	return nil
+}
+
+// rebuildURLMatchersUnlocked compiles all URL IOCs, dropping invalid ones,
+// and indexes them by match type (caller must hold the write lock)
+func (m *Manager) rebuildURLMatchersUnlocked() {
+	index := &urlIndex{
+		exactURLs:  make(map[string]*urlMatcher),
+		exactHosts: make(map[string]*urlMatcher),
+		suffixes:   make(map[string]*urlMatcher),
+	}
+	for key, ioc := range m.URLs {
+		matcher, err := compileURLMatcher(ioc)
+		if err != nil {
+			log.Printf("Rejecting URL IOC %s: %v", ioc.Value, err)
+			delete(m.URLs, key)
+			continue
+		}
+
+		switch {
+		case matcher.matchType == MatchExact && matcher.hostOnly:
+			index.exactHosts[matcher.pattern] = matcher
+		case matcher.matchType == MatchExact:
+			index.exactURLs[matcher.pattern] = matcher
+		case matcher.matchType == MatchSuffix:
+			index.suffixes[matcher.pattern] = matcher
+		default:
+			index.patterns = append(index.patterns, matcher)
+		}
+	}
+
+	sort.Slice(index.patterns, func(i, j int) bool {
+		pi, pj := matchPriority[index.patterns[i].matchType], matchPriority[index.patterns[j].matchType]
+		if pi != pj {
+			return pi > pj
+		}
+		return len(index.patterns[i].pattern) > len(index.patterns[j].pattern)
+	})
+
+	if len(m.URLs) == 0 {
+		index = nil
+	}
+	m.urlIndex = index
+}
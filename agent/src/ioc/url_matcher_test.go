@@ -0,0 +1,61 @@
+package ioc
+
+import "testing"
+
+func TestCheckURL(t *testing.T) {
+	m := &Manager{URLs: map[string]IOC{}}
+	for value, matchType := range map[string]string{
+		"evil.com":                     MatchExact,
+		"evil.com/payload":             MatchExact,
+		"example.org":                  MatchSuffix,
+		"cdn.example.org":              MatchSuffix,
+		"*.tracker.net":                MatchSuffix,
+		"*.bad-*.io":                   MatchWildcard,
+		"files.sample.net/*.exe":       MatchWildcard,
+		`^[a-z0-9]{20}\.top/gate\.php`: MatchRegex,
+	} {
+		m.URLs[value] = IOC{Value: value, Type: TypeURL, Metadata: map[string]string{"match_type": matchType}}
+	}
+	m.rebuildURLMatchersUnlocked()
+
+	tests := []struct {
+		url  string
+		want string // Value of the matching IOC, "" for no match
+	}{
+		{"evil.com", "evil.com"},
+		{"https://EVIL.com:8443/", "evil.com"},
+		{"http://user@evil.com/other", "evil.com"},
+		{"http://evil.com/payload", "evil.com/payload"},
+		{"www.evil.com", ""},
+		{"notevil.com", ""},
+		{"example.org", "example.org"},
+		{"a.b.example.org", "example.org"},
+		{"img.cdn.example.org", "cdn.example.org"},
+		{"cdn.example.org", "cdn.example.org"},
+		{"badexample.org", ""},
+		{"tracker.net", "*.tracker.net"},
+		{"x.tracker.net.", "*.tracker.net"},
+		{"api.bad-actor.io", "*.bad-*.io"},
+		{"bad-actor.io", ""},
+		{"https://files.sample.net/setup.exe", "files.sample.net/*.exe"},
+		{"https://files.sample.net/readme.txt", ""},
+		{"https://files.example.org/readme.txt", "example.org"},
+		{"abcdefghij0123456789.top/gate.php?id=1", `^[a-z0-9]{20}\.top/gate\.php`},
+		{"short.top/gate.php", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		matched, ioc := m.CheckURL(tt.url)
+		if got := ioc.Value; matched != (tt.want != "") || got != tt.want {
+			t.Errorf("CheckURL(%q) = %v %q, want %q", tt.url, matched, got, tt.want)
+		}
+	}
+}
+
+func TestCheckURLNoIOCs(t *testing.T) {
+	m := &Manager{URLs: map[string]IOC{}}
+	m.rebuildURLMatchersUnlocked()
+	if matched, _ := m.CheckURL("evil.com"); matched {
+		t.Error("CheckURL matched with no URL IOCs")
+	}
+}
@@ -0,0 +1,111 @@
+package ioc
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+
+	"agent/persist"
+)
+
+// maxReportedProblems caps how many bad entries a verification error lists
+const maxReportedProblems = 5
+
+// Verify checks the structure of the loaded IOC database: the maps exist,
+// the version is not negative, IP keys are addresses or CIDR ranges, file
+// hash keys are hex and URL IOCs compile. It returns an error wrapping
+// persist.ErrCorrupt describing the first problems found.
+func (m *Manager) Verify() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return verifyIOCFile(&iocFile{
+		IPAddresses: m.IPAddresses,
+		FileHashes:  m.FileHashes,
+		URLs:        m.URLs,
+		Version:     m.Version,
+	})
+}
+
+// verifyIOCFile checks an iocs.json read from disk before it is loaded. A
+// missing urls map is accepted since files from older agents have none.
+func verifyIOCFile(sd *iocFile) error {
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if sd.IPAddresses == nil {
+		report("ip_addresses missing")
+	}
+	if sd.FileHashes == nil {
+		report("file_hashes missing")
+	}
+	if sd.Version < 0 {
+		report("invalid version %d", sd.Version)
+	}
+	for ip := range sd.IPAddresses {
+		if !validIPKey(ip) {
+			report("invalid IP address %q", ip)
+		}
+	}
+	for hash := range sd.FileHashes {
+		if !validHashKey(hash) {
+			report("invalid file hash %q", hash)
+		}
+	}
+	for url, ioc := range sd.URLs {
+		if _, err := compileURLMatcher(ioc); err != nil {
+			report("invalid URL %q: %v", url, err)
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	more := ""
+	if len(problems) > maxReportedProblems {
+		more = fmt.Sprintf(" and %d more", len(problems)-maxReportedProblems)
+		problems = problems[:maxReportedProblems]
+	}
+	return fmt.Errorf("%w: %s%s", persist.ErrCorrupt, strings.Join(problems, "; "), more)
+}
+
+// validIPKey reports whether an IP IOC key is an address or a CIDR range
+func validIPKey(ip string) bool {
+	ip = NormalizeIP(ip)
+	if _, _, err := net.ParseCIDR(ip); err == nil {
+		return true
+	}
+	return net.ParseIP(ip) != nil
+}
+
+// validHashKey reports whether a file hash IOC key is a hex digest
+func validHashKey(hash string) bool {
+	if hash == "" {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// dropInvalidUnlocked removes IP and file hash IOCs from a server update that
+// would fail Verify, so they are never saved and the next load is clean. URL
+// IOCs are checked by rebuildURLMatchersUnlocked. Caller must hold the write
+// lock.
+func (m *Manager) dropInvalidUnlocked() {
+	for ip := range m.IPAddresses {
+		if !validIPKey(ip) {
+			log.Printf("Rejecting IP IOC %s: not an IP address or CIDR range", ip)
+			delete(m.IPAddresses, ip)
+		}
+	}
+	for hash := range m.FileHashes {
+		if !validHashKey(hash) {
+			log.Printf("Rejecting file hash IOC %s: not a hex digest", hash)
+			delete(m.FileHashes, hash)
+		}
+	}
+}
@@ -3,55 +3,60 @@
 package ioc
 
 import (
+	"context"
 	"fmt"
 	"log"
-	"time"
+	"strings"
+	"syscall"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
+
+	"agent/blocker"
+	"agent/config"
+	pb "agent/proto"
 )
 
-// Windows Event Log API constants
+// Windows Event Log (wevtapi) constants
 const (
-	EVENTLOG_SEQUENTIAL_READ = 0x0001
-	EVENTLOG_FORWARDS_READ   = 0x0004
-	EVENTLOG_BACKWARDS_READ  = 0x0008
-	EVENTLOG_SEEK_READ       = 0x0002
+	evtQueryChannelPath      = 0x1
+	evtQueryForwardDirection = 0x100
+	evtQueryReverseDirection = 0x200
+	evtOpenChannelPath       = 0x1
+	evtRenderEventXml        = 1
+	evtLogNumberOfLogRecords = 5
+	evtLogOldestRecordNumber = 6
+	evtNextTimeoutMs         = 1000
+	errorNoMoreItems         = syscall.Errno(259)
 )
 
-// EVENTLOGRECORD structure for Windows Event Log
-type EVENTLOGRECORD struct {
-	Length              uint32
-	Reserved            uint32
-	RecordNumber        uint32
-	TimeGenerated       uint32
-	TimeWritten         uint32
-	EventID             uint32
-	EventType           uint16
-	NumStrings          uint16
-	EventCategory       uint16
-	ReservedFlags       uint16
-	ClosingRecordNumber uint32
-	StringOffset        uint32
-	UserSidLength       uint32
-	UserSidOffset       uint32
-	DataLength          uint32
-	DataOffset          uint32
-}
+// sysmonLogName is the event log Sysmon writes to
+const sysmonLogName = "Microsoft-Windows-Sysmon/Operational"
+
+// sysmonServiceNames are the service names of the 64-bit and 32-bit Sysmon
+var sysmonServiceNames = []string{"Sysmon64", "Sysmon"}
 
 // Windows API functions
 var (
-	advapi32                = windows.NewLazySystemDLL("advapi32.dll")
-	procOpenEventLogW       = advapi32.NewProc("OpenEventLogW")
-	procReadEventLogW       = advapi32.NewProc("ReadEventLogW")
-	procCloseEventLog       = advapi32.NewProc("CloseEventLog")
-	procGetNumberOfEventLogRecords = advapi32.NewProc("GetNumberOfEventLogRecords")
-	procGetOldestEventLogRecord    = advapi32.NewProc("GetOldestEventLogRecord")
+	wevtapi           = windows.NewLazySystemDLL("wevtapi.dll")
+	procEvtQuery      = wevtapi.NewProc("EvtQuery")
+	procEvtNext       = wevtapi.NewProc("EvtNext")
+	procEvtRender     = wevtapi.NewProc("EvtRender")
+	procEvtClose      = wevtapi.NewProc("EvtClose")
+	procEvtOpenLog    = wevtapi.NewProc("EvtOpenLog")
+	procEvtGetLogInfo = wevtapi.NewProc("EvtGetLogInfo")
 )
 
+// evtVariant is the EVT_VARIANT structure returned by EvtGetLogInfo
+type evtVariant struct {
+	Value uint64
+	Count uint32
+	Type  uint32
+}
+
 // WindowsEventLogReader provides efficient access to Windows Event Logs
 type WindowsEventLogReader struct {
-	handle          windows.Handle
+	handle          windows.Handle // Log handle from EvtOpenLog
 	lastRecordRead  uint32
 	logName         string
 }
@@ -63,9 +68,10 @@ func NewWindowsEventLogReader(logName string) (*WindowsEventLogReader, error) {
 		return nil, fmt.Errorf("failed to convert log name: %v", err)
 	}
 	
-	ret, _, err := procOpenEventLogW.Call(
-		0, // lpUNCServerName (local machine)
+	ret, _, err := procEvtOpenLog.Call(
+		0, // Session (local machine)
 		uintptr(unsafe.Pointer(logNamePtr)),
+		evtOpenChannelPath,
 	)
 	
 	if ret == 0 {
@@ -82,7 +88,7 @@ func NewWindowsEventLogReader(logName string) (*WindowsEventLogReader, error) {
 // Close closes the event log handle
 func (r *WindowsEventLogReader) Close() error {
 	if r.handle != 0 {
-		ret, _, err := procCloseEventLog.Call(uintptr(r.handle))
+		ret, _, err := procEvtClose.Call(uintptr(r.handle))
 		if ret == 0 {
 			return fmt.Errorf("failed to close event log: %v", err)
 		}
@@ -91,255 +97,226 @@ func (r *WindowsEventLogReader) Close() error {
 	return nil
 }
 
-// GetEventCount returns the total number of events in the log
-func (r *WindowsEventLogReader) GetEventCount() (uint32, error) {
-	var count uint32
-	ret, _, err := procGetNumberOfEventLogRecords.Call(
+// getLogInfo reads a numeric property of the open log
+func (r *WindowsEventLogReader) getLogInfo(propertyID uint32) (uint64, error) {
+	var value evtVariant
+	var used uint32
+	ret, _, err := procEvtGetLogInfo.Call(
 		uintptr(r.handle),
-		uintptr(unsafe.Pointer(&count)),
+		uintptr(propertyID),
+		unsafe.Sizeof(value),
+		uintptr(unsafe.Pointer(&value)),
+		uintptr(unsafe.Pointer(&used)),
 	)
 	
 	if ret == 0 {
+		return 0, err
+	}
+	
+	return value.Value, nil
+}
+
+// GetEventCount returns the total number of events in the log
+func (r *WindowsEventLogReader) GetEventCount() (uint32, error) {
+	count, err := r.getLogInfo(evtLogNumberOfLogRecords)
+	if err != nil {
 		return 0, fmt.Errorf("failed to get event count: %v", err)
 	}
 	
-	return count, nil
+	return uint32(count), nil
 }
 
 // GetOldestRecordNumber returns the record number of the oldest event
 func (r *WindowsEventLogReader) GetOldestRecordNumber() (uint32, error) {
-	var oldest uint32
-	ret, _, err := procGetOldestEventLogRecord.Call(
-		uintptr(r.handle),
-		uintptr(unsafe.Pointer(&oldest)),
-	)
-	
-	if ret == 0 {
+	oldest, err := r.getLogInfo(evtLogOldestRecordNumber)
+	if err != nil {
 		return 0, fmt.Errorf("failed to get oldest record number: %v", err)
 	}
 	
-	return oldest, nil
+	return uint32(oldest), nil
 }
 
-// ReadEvents reads events from the log starting from a specific record number
-func (r *WindowsEventLogReader) ReadEvents(startRecord uint32, maxEvents int) ([]SysmonEvent, error) {
-	var events []SysmonEvent
-	buffer := make([]byte, 64*1024) // 64KB buffer
-	
-	var bytesRead uint32
-	var minBytesNeeded uint32
+// queryEvents runs an XPath query against the log and calls visit with each
+// matching event, fetching up to batch events at a time, until visit returns
+// false or no events are left. Event handles are closed after visit returns.
+func (r *WindowsEventLogReader) queryEvents(query string, flags uintptr, batch int, visit func(h windows.Handle) bool) error {
+	channelPtr, err := windows.UTF16PtrFromString(r.logName)
+	if err != nil {
+		return fmt.Errorf("failed to convert log name: %v", err)
+	}
+	queryPtr, err := windows.UTF16PtrFromString(query)
+	if err != nil {
+		return fmt.Errorf("failed to convert query: %v", err)
+	}
 	
-	// Read events in chunks
-	for len(events) < maxEvents {
-			ret, _, _ := procReadEventLogW.Call(
-		uintptr(r.handle),
-		EVENTLOG_SEEK_READ|EVENTLOG_FORWARDS_READ,
-		uintptr(startRecord),
-		uintptr(unsafe.Pointer(&buffer[0])),
-		uintptr(len(buffer)),
-		uintptr(unsafe.Pointer(&bytesRead)),
-		uintptr(unsafe.Pointer(&minBytesNeeded)),
+	handle, _, err := procEvtQuery.Call(
+		0, // Session (local machine)
+		uintptr(unsafe.Pointer(channelPtr)),
+		uintptr(unsafe.Pointer(queryPtr)),
+		flags,
 	)
+	if handle == 0 {
+		return fmt.Errorf("failed to query event log: %v", err)
+	}
+	defer procEvtClose.Call(handle)
 	
-	if ret == 0 {
-		// Check if we need a larger buffer
-		if windows.GetLastError() == windows.ERROR_INSUFFICIENT_BUFFER {
-			buffer = make([]byte, minBytesNeeded)
-			continue
+	handles := make([]windows.Handle, batch)
+	for {
+		var returned uint32
+		ret, _, err := procEvtNext.Call(
+			handle,
+			uintptr(batch),
+			uintptr(unsafe.Pointer(&handles[0])),
+			evtNextTimeoutMs,
+			0,
+			uintptr(unsafe.Pointer(&returned)),
+		)
+		if ret == 0 {
+			if err == errorNoMoreItems {
+				return nil
+			}
+			return fmt.Errorf("failed to read events: %v", err)
 		}
-		// No more events or other error
-		break
-	}
-		
-		// Parse events from buffer
-		parsedEvents := r.parseEventsFromBuffer(buffer[:bytesRead])
-		events = append(events, parsedEvents...)
 		
-		if len(parsedEvents) == 0 {
-			break
+		more := true
+		for _, h := range handles[:returned] {
+			if more {
+				more = visit(h)
+			}
+			procEvtClose.Call(uintptr(h))
+		}
+		if !more {
+			return nil
 		}
-		
-		// Update start record for next iteration
-		lastEvent := parsedEvents[len(parsedEvents)-1]
-		startRecord = lastEvent.RecordNumber + 1
 	}
-	
-	return events, nil
-}
-
-// SysmonEvent represents a parsed Sysmon event
-type SysmonEvent struct {
-	RecordNumber  uint32
-	EventID       uint32
-	TimeGenerated time.Time
-	ProcessName   string
-	ProcessID     uint32
-	Image         string
-	Hashes        string
-	TargetFilename string
-	SourceImage   string
-	TargetImage   string
-	CommandLine   string
 }
 
-// parseEventsFromBuffer parses EVENTLOGRECORD structures from buffer
-func (r *WindowsEventLogReader) parseEventsFromBuffer(buffer []byte) []SysmonEvent {
+// ReadEvents reads up to maxEvents Sysmon events of interest with a record
+// number of at least startRecord, rendering each one as XML
+func (r *WindowsEventLogReader) ReadEvents(startRecord uint32, maxEvents int) ([]SysmonEvent, error) {
 	var events []SysmonEvent
-	offset := 0
-	
-	for offset < len(buffer) {
-		if offset+int(unsafe.Sizeof(EVENTLOGRECORD{})) > len(buffer) {
-			break
-		}
-		
-		// Parse EVENTLOGRECORD header
-		record := (*EVENTLOGRECORD)(unsafe.Pointer(&buffer[offset]))
-		
-		if record.Length == 0 || offset+int(record.Length) > len(buffer) {
-			break
-		}
-		
-		// Check if this is a Sysmon event (EventID 1, 11, 15, 23, 29)
-		eventID := record.EventID & 0xFFFF // Lower 16 bits contain the actual event ID
-		if r.isSysmonEventOfInterest(eventID) {
-			event := r.parseSysmonEvent(record, buffer[offset:offset+int(record.Length)])
-			if event != nil {
+	err := r.queryEvents(sysmonQuery(startRecord), evtQueryChannelPath|evtQueryForwardDirection, maxEvents,
+		func(h windows.Handle) bool {
+			event, err := renderSysmonEvent(h)
+			if err != nil {
+				log.Printf("Skipping unreadable event: %v", err)
+				return true
+			}
+			if isSysmonEventOfInterest(event.EventID) {
 				events = append(events, *event)
 			}
-		}
-		
-		offset += int(record.Length)
-	}
+			return len(events) < maxEvents
+		})
 	
-	return events
+	return events, err
 }
 
-// isSysmonEventOfInterest checks if the event ID is one we care about
-func (r *WindowsEventLogReader) isSysmonEventOfInterest(eventID uint32) bool {
-	switch eventID {
-	case 1:  // Process creation
-		return true
-	case 11: // File creation
-		return true
-	case 15: // File create stream hash
-		return true
-	case 23: // File delete
-		return true
-	case 29: // Remote thread creation
-		return true
-	default:
-		return false
+// CollectEvents returns up to maxEvents of the most recent events in any
+// event log, newest first. When eventIDs is not empty only events with one of
+// those IDs are returned.
+func CollectEvents(logName string, eventIDs []uint32, maxEvents int) ([]LogEvent, error) {
+	reader, err := NewWindowsEventLogReader(logName)
+	if err != nil {
+		return nil, err
 	}
+	defer reader.Close()
+	
+	var events []LogEvent
+	err = reader.queryEvents(eventIDQuery(eventIDs), evtQueryChannelPath|evtQueryReverseDirection, maxEvents,
+		func(h windows.Handle) bool {
+			data, err := renderEventXML(h)
+			if err == nil {
+				var event *LogEvent
+				if event, err = parseLogEventXML(data); err == nil {
+					events = append(events, *event)
+				}
+			}
+			if err != nil {
+				log.Printf("Skipping unreadable event in %s: %v", logName, err)
+			}
+			return len(events) < maxEvents
+		})
+	
+	return events, err
 }
 
-// parseSysmonEvent parses a Sysmon event from the raw event data
-func (r *WindowsEventLogReader) parseSysmonEvent(record *EVENTLOGRECORD, eventData []byte) *SysmonEvent {
-	event := &SysmonEvent{
-		RecordNumber:  record.RecordNumber,
-		EventID:       record.EventID & 0xFFFF,
-		TimeGenerated: time.Unix(int64(record.TimeGenerated), 0),
+// renderEventXML renders an event handle as an XML document
+func renderEventXML(h windows.Handle) ([]byte, error) {
+	var used, propertyCount uint32
+	
+	// First call reports the required buffer size in bytes
+	procEvtRender.Call(0, uintptr(h), evtRenderEventXml, 0, 0,
+		uintptr(unsafe.Pointer(&used)), uintptr(unsafe.Pointer(&propertyCount)))
+	if used == 0 {
+		return nil, fmt.Errorf("failed to size event XML")
 	}
 	
-	// Parse strings from the event data
-	// The strings start at StringOffset and there are NumStrings of them
-	if record.StringOffset > 0 && int(record.StringOffset) < len(eventData) {
-		stringData := eventData[record.StringOffset:]
-		strings := r.parseEventStrings(stringData, int(record.NumStrings))
-		
-		// Map strings to event fields based on EventID
-		r.mapStringsToEvent(event, strings)
+	buf := make([]uint16, used/2+1)
+	ret, _, err := procEvtRender.Call(0, uintptr(h), evtRenderEventXml,
+		uintptr(len(buf)*2), uintptr(unsafe.Pointer(&buf[0])),
+		uintptr(unsafe.Pointer(&used)), uintptr(unsafe.Pointer(&propertyCount)))
+	if ret == 0 {
+		return nil, fmt.Errorf("failed to render event XML: %v", err)
 	}
 	
-	return event
+	return []byte(windows.UTF16ToString(buf)), nil
 }
 
-// parseEventStrings parses null-terminated UTF-16 strings from event data
-func (r *WindowsEventLogReader) parseEventStrings(data []byte, numStrings int) []string {
-	var strings []string
-	offset := 0
-	
-	for i := 0; i < numStrings && offset < len(data); i++ {
-		// Find the end of the current string (null terminator)
-		end := offset
-		for end+1 < len(data) && (data[end] != 0 || data[end+1] != 0) {
-			end += 2
-		}
-		
-		if end > offset {
-			// Convert UTF-16 to string
-			utf16Data := make([]uint16, (end-offset)/2)
-			for j := 0; j < len(utf16Data); j++ {
-				utf16Data[j] = uint16(data[offset+j*2]) | uint16(data[offset+j*2+1])<<8
-			}
-			str := windows.UTF16ToString(utf16Data)
-			strings = append(strings, str)
-		}
-		
-		offset = end + 2 // Skip null terminator
+// renderSysmonEvent renders an event handle as XML and parses it
+func renderSysmonEvent(h windows.Handle) (*SysmonEvent, error) {
+	data, err := renderEventXML(h)
+	if err != nil {
+		return nil, err
 	}
-	
-	return strings
+	return parseSysmonEventXML(data)
 }
 
-// mapStringsToEvent maps parsed strings to event fields based on EventID
-func (r *WindowsEventLogReader) mapStringsToEvent(event *SysmonEvent, strings []string) {
-	// This is a simplified mapping - in reality, Sysmon events have complex XML structure
-	// For production use, you'd need to parse the actual XML content or use a more sophisticated approach
+// IsSysmonAvailable reports whether Sysmon telemetry can be collected: its
+// event log must exist and its service must be running. Otherwise the
+// string says what is missing.
+func IsSysmonAvailable() (bool, string) {
+	reader, err := NewWindowsEventLogReader(sysmonLogName)
+	if err != nil {
+		return false, fmt.Sprintf("Sysmon is not installed, event log %s cannot be opened: %v", sysmonLogName, err)
+	}
+	reader.Close()
 	
-	switch event.EventID {
-	case 1: // Process creation
-		if len(strings) > 4 {
-			event.Image = strings[4]
-		}
-		if len(strings) > 2 {
-			event.CommandLine = strings[2]
-		}
-		// Look for Hashes field in strings
-		for _, str := range strings {
-			if len(str) > 7 && str[:7] == "Hashes=" {
-				event.Hashes = str[7:]
-				break
-			}
-		}
-		
-	case 11: // File creation
-		if len(strings) > 2 {
-			event.TargetFilename = strings[2]
-		}
-		
-	case 15: // File create stream hash
-		if len(strings) > 2 {
-			event.TargetFilename = strings[2]
-		}
-		// Look for Hash field
-		for _, str := range strings {
-			if len(str) > 5 && str[:5] == "Hash=" {
-				event.Hashes = str[5:]
-				break
-			}
+	scm, err := windows.OpenSCManager(nil, nil, windows.SC_MANAGER_CONNECT)
+	if err != nil {
+		return false, fmt.Sprintf("cannot check the Sysmon service: %v", err)
+	}
+	defer windows.CloseServiceHandle(scm)
+	
+	for _, name := range sysmonServiceNames {
+		namePtr, err := windows.UTF16PtrFromString(name)
+		if err != nil {
+			continue
 		}
-		
-	case 23: // File delete
-		if len(strings) > 4 {
-			event.Image = strings[4]
+		service, err := windows.OpenService(scm, namePtr, windows.SERVICE_QUERY_STATUS)
+		if err != nil {
+			continue
 		}
-		
-	case 29: // Remote thread creation
-		if len(strings) > 4 {
-			event.SourceImage = strings[4]
+		var status windows.SERVICE_STATUS
+		err = windows.QueryServiceStatus(service, &status)
+		windows.CloseServiceHandle(service)
+		if err != nil {
+			return false, fmt.Sprintf("cannot check the %s service: %v", name, err)
 		}
-		if len(strings) > 5 {
-			event.TargetImage = strings[5]
+		if status.CurrentState != windows.SERVICE_RUNNING {
+			return false, fmt.Sprintf("the %s service is installed but not running", name)
 		}
+		return true, ""
 	}
+	return false, fmt.Sprintf("the Sysmon event log exists but no %s service is installed", strings.Join(sysmonServiceNames, " or "))
 }
 
-// scanWindowsSysmonLogsEfficient is the new efficient implementation
-func (s *Scanner) scanWindowsSysmonLogsEfficient() error {
+// scanWindowsSysmonLogsEfficient is the new efficient implementation. Matches
+// are reported with ctx.
+func (s *Scanner) scanWindowsSysmonLogsEfficient(ctx context.Context) error {
 	log.Printf("Starting efficient Sysmon log scan using Windows Event Log API")
 	
 	// Open Sysmon event log
-	reader, err := NewWindowsEventLogReader("Microsoft-Windows-Sysmon/Operational")
+	reader, err := NewWindowsEventLogReader(sysmonLogName)
 	if err != nil {
 		return fmt.Errorf("failed to open Sysmon log: %v", err)
 	}
@@ -357,6 +334,9 @@ func (s *Scanner) scanWindowsSysmonLogsEfficient() error {
 	}
 	
 	log.Printf("Sysmon log contains %d events, oldest record: %d", totalEvents, oldestRecord)
+	if totalEvents == 0 {
+		return nil
+	}
 	
 	// Calculate which record to start from based on last scan time
 	// For simplicity, we'll read the last 1000 events or events since last scan
@@ -364,19 +344,30 @@ func (s *Scanner) scanWindowsSysmonLogsEfficient() error {
 	if s.lastRecordRead > 0 && s.lastRecordRead >= oldestRecord {
 		startRecord = s.lastRecordRead + 1
 	} else {
+		if s.lastRecordRead > 0 {
+			log.Printf("Bookmarked record %d is older than oldest available record %d, events were lost while the log wrapped",
+				s.lastRecordRead, oldestRecord)
+		}
 		// First run - start from recent events to avoid processing entire log
 		if totalEvents > 1000 {
 			startRecord = oldestRecord + totalEvents - 1000
 		}
 	}
 	
-	log.Printf("Reading events starting from record %d", startRecord)
+	// Page through everything written before the scan started, using the
+	// record number as the cursor. Events that arrive while paging are left
+	// for the next scan so a busy log cannot keep one scan running forever.
+	newestRecord := oldestRecord + totalEvents - 1
+	batchSize := s.config.Current().SysmonMaxEventsPerScan
+	if batchSize < 1 {
+		batchSize = config.DefaultSysmonMaxEventsPerScan
+	}
+	
+	log.Printf("Reading events from record %d to %d in batches of %d", startRecord, newestRecord, batchSize)
 	
-	// Read events in batches
-	const batchSize = 100
 	eventsProcessed := 0
 	
-	for {
+	for startRecord <= newestRecord {
 		events, err := reader.ReadEvents(startRecord, batchSize)
 		if err != nil {
 			log.Printf("Error reading events: %v", err)
@@ -389,7 +380,7 @@ func (s *Scanner) scanWindowsSysmonLogsEfficient() error {
 		
 		// Process each event
 		for _, event := range events {
-			s.processSysmonEvent(&event)
+			s.processSysmonEvent(ctx, &event)
 			eventsProcessed++
 			
 			// Update last record read
@@ -398,10 +389,15 @@ func (s *Scanner) scanWindowsSysmonLogsEfficient() error {
 			}
 		}
 		
+		s.recordEventsProcessed(len(events))
+		
 		// Update start record for next batch
 		lastEvent := events[len(events)-1]
 		startRecord = lastEvent.RecordNumber + 1
 		
+		// Persist progress so a restart resumes after this batch
+		s.saveBookmark()
+		
 		log.Printf("Processed batch of %d events, total processed: %d", len(events), eventsProcessed)
 	}
 	
@@ -409,60 +405,158 @@ func (s *Scanner) scanWindowsSysmonLogsEfficient() error {
 	return nil
 }
 
-// processSysmonEvent processes a single Sysmon event
-func (s *Scanner) processSysmonEvent(event *SysmonEvent) {
+// processSysmonEvent processes a single Sysmon event, reporting matches with ctx
+func (s *Scanner) processSysmonEvent(ctx context.Context, event *SysmonEvent) {
 	switch event.EventID {
 	case 1: // Process creation
 		if event.Hashes != "" {
-			s.processHashesData(event.Hashes, event.Image)
+			s.processHashesData(ctx, event.Hashes, event.Image, sysmonProcessTree(event))
+		}
+		s.scanFileWithYara(ctx, event.Image)
+		s.checkProcessRules(ctx, event)
+		
+	case 3: // Network connection
+		if event.DestinationIp != "" {
+			if match, ioc := s.manager.CheckIP(event.DestinationIp); match {
+				s.handleMaliciousConnection(ctx, pb.IOCType_IOC_IP, event.DestinationIp, event, &ioc)
+			}
+		}
+		if event.DestinationHostname != "" {
+			if match, ioc := s.manager.CheckURL(event.DestinationHostname); match {
+				s.handleMaliciousConnection(ctx, pb.IOCType_IOC_URL, event.DestinationHostname, event, &ioc)
+			}
 		}
 		
 	case 11: // File creation
 		if event.TargetFilename != "" {
-			// Calculate hash for the created file
-			hashValue, err := s.calculateFileHash(event.TargetFilename)
+			// Hash the created file with the algorithms the IOCs use
+			hashValue, ioc, match, err := s.matchFileHash(event.TargetFilename)
 			if err == nil {
-				match, ioc := s.manager.CheckFileHash(hashValue)
 				if match {
-					s.handleMaliciousFile(event.TargetFilename, hashValue, &ioc)
+					s.handleMaliciousFile(ctx, event.TargetFilename, hashValue, &ioc, nil)
+				} else {
+					s.scanFileWithYara(ctx, event.TargetFilename)
 				}
 			}
 		}
 		
 	case 15: // File create stream hash
 		if event.Hashes != "" && event.TargetFilename != "" {
-			s.processHashesData(event.Hashes, event.TargetFilename)
+			s.processHashesData(ctx, event.Hashes, event.TargetFilename, nil)
+		}
+		
+	case 22: // DNS query
+		// Catches a lookup of a known-bad domain before any connection is made
+		if event.QueryName != "" {
+			if match, ioc := s.manager.CheckURL(event.QueryName); match {
+				s.handleMaliciousDNSQuery(ctx, event, &ioc)
+			}
 		}
 		
 	case 23: // File delete
 		if event.Hashes != "" {
-			s.processHashesData(event.Hashes, event.Image)
+			s.processHashesData(ctx, event.Hashes, event.Image, nil)
 		}
 		
 	case 29: // Remote thread creation
 		// Check both source and target processes
 		if event.SourceImage != "" {
-			sourceHash, err := s.calculateFileHash(event.SourceImage)
+			sourceHash, ioc, match, err := s.matchFileHash(event.SourceImage)
 			if err == nil {
-				match, ioc := s.manager.CheckFileHash(sourceHash)
 				if match {
 					log.Printf("Malicious process creating remote thread: %s (%s)", event.SourceImage, sourceHash)
-					s.handleMaliciousFile(event.SourceImage, sourceHash, &ioc)
+					s.handleMaliciousFile(ctx, event.SourceImage, sourceHash, &ioc, nil)
+				} else {
+					s.scanFileWithYara(ctx, event.SourceImage)
 				}
 			}
 		}
 		
 		if event.TargetImage != "" {
-			targetHash, err := s.calculateFileHash(event.TargetImage)
+			targetHash, ioc, match, err := s.matchFileHash(event.TargetImage)
 			if err == nil {
-				match, ioc := s.manager.CheckFileHash(targetHash)
 				if match {
 					log.Printf("Remote thread created in malicious process: %s (%s)", event.TargetImage, targetHash)
-					s.handleMaliciousFile(event.TargetImage, targetHash, &ioc)
+					s.handleMaliciousFile(ctx, event.TargetImage, targetHash, &ioc, nil)
 				}
 			}
 		}
 		
 		log.Printf("Remote thread created from %s to %s", event.SourceImage, event.TargetImage)
 	}
-} 
\ No newline at end of file
+}
+
+// handleMaliciousConnection blocks a destination seen in a Sysmon network
+// connection event and reports it with the process that made the connection
+func (s *Scanner) handleMaliciousConnection(ctx context.Context, iocType pb.IOCType, destination string, event *SysmonEvent, ioc *IOC) {
+	log.Printf("Found network connection IOC match: %s connected to %s", event.Image, destination)
+	s.recordMatches(1)
+	
+	if iocType == pb.IOCType_IOC_IP {
+		destination = NormalizeIP(destination)
+	}
+	
+	var err error
+	blocked := false
+	switch {
+	case !s.shouldBlock(*ioc):
+		log.Printf("%s, not blocking %s", s.reportOnlyReason(*ioc), destination)
+	case iocType == pb.IOCType_IOC_IP:
+		if s.blocker.IsIPBlocked(destination) {
+			blocked = true
+		} else if err = s.blocker.BlockIP(s.ctx, destination, blocker.SourceScanner); err == nil {
+			blocked = true
+		}
+	case iocType == pb.IOCType_IOC_URL:
+		if s.blocker.IsURLBlocked(destination) {
+			blocked = true
+		} else if err = s.blocker.BlockURL(destination, blocker.SourceScanner); err == nil {
+			blocked = true
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to block %s: %v", destination, err)
+	}
+	
+	if s.reportCallback != nil {
+		s.reportCallback(
+			ctx,
+			iocType,
+			ioc.Value,
+			destination,
+			fmt.Sprintf("Network connection from %s (PID %d) to %s port %s (blocked: %v)",
+				event.Image, event.ProcessID, destination, event.DestinationPort, blocked),
+			ioc.Severity,
+		)
+	}
+}
+
+// handleMaliciousDNSQuery blocks a domain seen in a Sysmon DNS query event and
+// reports it with the process that resolved it
+func (s *Scanner) handleMaliciousDNSQuery(ctx context.Context, event *SysmonEvent, ioc *IOC) {
+	log.Printf("Found DNS query IOC match: %s resolved %s", event.Image, event.QueryName)
+	s.recordMatches(1)
+	
+	blocked := s.blocker.IsURLBlocked(event.QueryName)
+	if !blocked && !s.shouldBlock(*ioc) {
+		log.Printf("%s, not blocking %s", s.reportOnlyReason(*ioc), event.QueryName)
+	} else if !blocked {
+		if err := s.blocker.BlockURL(event.QueryName, blocker.SourceScanner); err != nil {
+			log.Printf("Failed to block %s: %v", event.QueryName, err)
+		} else {
+			blocked = true
+		}
+	}
+	
+	if s.reportCallback != nil {
+		s.reportCallback(
+			ctx,
+			pb.IOCType_IOC_URL,
+			ioc.Value,
+			event.QueryName,
+			fmt.Sprintf("DNS query for %s by %s (PID %d) (blocked: %v)",
+				event.QueryName, event.Image, event.ProcessID, blocked),
+			ioc.Severity,
+		)
+	}
+}
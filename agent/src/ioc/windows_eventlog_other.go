@@ -0,0 +1,25 @@
+// +build !windows
+
+package ioc
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// scanWindowsSysmonLogsEfficient is only implemented on Windows; there is no
+// Sysmon event log to read on other platforms
+func (s *Scanner) scanWindowsSysmonLogsEfficient(ctx context.Context) error {
+	return nil
+}
+
+// IsSysmonAvailable always reports false; Sysmon is only used on Windows
+func IsSysmonAvailable() (bool, string) {
+	return false, fmt.Sprintf("Sysmon is not supported on %s", runtime.GOOS)
+}
+
+// CollectEvents is only implemented on Windows
+func CollectEvents(logName string, eventIDs []uint32, maxEvents int) ([]LogEvent, error) {
+	return nil, fmt.Errorf("event logs are not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,1024 @@
+package ioc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// maxYaraScanBytes caps how much of a file is read for YARA matching
+const maxYaraScanBytes = 64 << 20 // 64MB
+
+// yaraChunkSize is how much of a file is read and matched at once
+const yaraChunkSize = 1 << 20
+
+// YaraEngine matches files against YARA rules loaded from a directory.
+//
+// The engine is a pure-Go matcher for the commonly used subset of the YARA
+// language so the agent does not need cgo or libyara:
+//   - text strings with the nocase, ascii, wide and private modifiers
+//   - hex strings with ?? wildcard bytes
+//   - conditions built from $id, and, or, not, parentheses, true/false and
+//     "any/all/N of them" or "of ($a, $b*)"
+//
+// Rules using anything else (regular expressions, jumps, modules, counts,
+// offsets, filesize) fail to compile and are skipped with a log message.
+type YaraEngine struct {
+	rulesDir string
+	mu       sync.RWMutex
+	rules    []*yaraRule
+}
+
+// YaraMatch describes a rule that matched scanned data
+type YaraMatch struct {
+	Rule    string
+	Tags    []string
+	Meta    map[string]string
+	Strings []string // Identifiers of the strings that matched
+}
+
+// NewYaraEngine creates a YARA engine reading rules from rulesDir
+func NewYaraEngine(rulesDir string) *YaraEngine {
+	return &YaraEngine{rulesDir: rulesDir}
+}
+
+// RulesDir returns the directory rules are loaded from
+func (e *YaraEngine) RulesDir() string {
+	return e.rulesDir
+}
+
+// Load compiles every .yar/.yara file in the rules directory and replaces the
+// active rule set. Files that fail to compile are logged and skipped.
+func (e *YaraEngine) Load() error {
+	var files []string
+	for _, pattern := range []string{"*.yar", "*.yara"} {
+		matches, err := filepath.Glob(filepath.Join(e.rulesDir, pattern))
+		if err != nil {
+			return fmt.Errorf("failed to list YARA rules: %v", err)
+		}
+		files = append(files, matches...)
+	}
+
+	var rules []*yaraRule
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			log.Printf("Failed to read YARA rule file %s: %v", file, err)
+			continue
+		}
+
+		compiled, err := CompileYaraRules(string(data))
+		if err != nil {
+			log.Printf("Skipping YARA rule file %s: %v", file, err)
+			continue
+		}
+		rules = append(rules, compiled...)
+	}
+
+	e.mu.Lock()
+	e.rules = rules
+	e.mu.Unlock()
+
+	if len(files) > 0 {
+		log.Printf("Loaded %d YARA rules from %d files in %s", len(rules), len(files), e.rulesDir)
+	}
+
+	return nil
+}
+
+// RuleCount returns the number of active rules
+func (e *YaraEngine) RuleCount() int {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return len(e.rules)
+}
+
+// ScanFile matches the contents of a file against the active rules. The file
+// is read in chunks; the tail of each chunk is matched again with the next
+// one so strings crossing a chunk boundary are still found.
+func (e *YaraEngine) ScanFile(path string) ([]YaraMatch, error) {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scan := newYaraScan(rules)
+	overlap := scan.longest - 1
+	buf := make([]byte, overlap+yaraChunkSize)
+	reader := io.LimitReader(file, maxYaraScanBytes)
+	kept := 0
+	for {
+		n, err := io.ReadFull(reader, buf[kept:kept+yaraChunkSize])
+		if n > 0 {
+			data := buf[:kept+n]
+			scan.feed(data)
+
+			kept = overlap
+			if kept > len(data) {
+				kept = len(data)
+			}
+			copy(buf, data[len(data)-kept:])
+		}
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return scan.results(), nil
+}
+
+// ScanBytes matches data against the active rules
+func (e *YaraEngine) ScanBytes(data []byte) []YaraMatch {
+	e.mu.RLock()
+	rules := e.rules
+	e.mu.RUnlock()
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	scan := newYaraScan(rules)
+	scan.feed(data)
+	return scan.results()
+}
+
+// yaraScan collects the strings of each rule found in the chunks of one
+// scan, so conditions are evaluated against the data as a whole
+type yaraScan struct {
+	rules   []*yaraRule
+	matched []map[string]bool
+	fold    bool // Some pattern is nocase, so chunks need a lowercase copy
+	longest int  // Length of the longest pattern
+	lower   []byte
+}
+
+func newYaraScan(rules []*yaraRule) *yaraScan {
+	s := &yaraScan{rules: rules, matched: make([]map[string]bool, len(rules)), longest: 1}
+	for i, rule := range rules {
+		s.matched[i] = make(map[string]bool, len(rule.strings))
+		for _, str := range rule.strings {
+			for _, p := range str.patterns {
+				if p.nocase {
+					s.fold = true
+				}
+				if len(p.data) > s.longest {
+					s.longest = len(p.data)
+				}
+			}
+		}
+	}
+	return s
+}
+
+// feed matches the strings not found yet against the next chunk of data
+func (s *yaraScan) feed(data []byte) {
+	var lower []byte
+	if s.fold {
+		if cap(s.lower) < len(data) {
+			s.lower = make([]byte, len(data))
+		}
+		lower = asciiLower(s.lower[:len(data)], data)
+	}
+
+	for i, rule := range s.rules {
+		for _, str := range rule.strings {
+			if !s.matched[i][str.id] && str.matches(data, lower) {
+				s.matched[i][str.id] = true
+			}
+		}
+	}
+}
+
+// results evaluates each rule's condition against the strings found
+func (s *yaraScan) results() []YaraMatch {
+	var matches []YaraMatch
+	for i, rule := range s.rules {
+		if !rule.condition.eval(rule, s.matched[i]) {
+			continue
+		}
+
+		var matchedIDs []string
+		for _, str := range rule.strings {
+			if s.matched[i][str.id] && !str.private {
+				matchedIDs = append(matchedIDs, str.id)
+			}
+		}
+		matches = append(matches, YaraMatch{
+			Rule:    rule.name,
+			Tags:    rule.tags,
+			Meta:    rule.meta,
+			Strings: matchedIDs,
+		})
+	}
+	return matches
+}
+
+// asciiLower writes src to dst with A-Z folded to lowercase. Unlike
+// bytes.ToLower it leaves every other byte alone, so binary data keeps its
+// length and offsets.
+func asciiLower(dst, src []byte) []byte {
+	for i, c := range src {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		dst[i] = c
+	}
+	return dst
+}
+
+// yaraRule is a compiled rule
+type yaraRule struct {
+	name      string
+	tags      []string
+	meta      map[string]string
+	strings   []*yaraString
+	condition yaraCond
+}
+
+// yaraString is a compiled string definition; it matches if any of its
+// patterns (e.g. the ascii and wide forms) is found
+type yaraString struct {
+	id       string
+	private  bool
+	patterns []yaraPattern
+}
+
+// yaraPattern is a byte sequence; mask marks wildcard positions for hex strings
+type yaraPattern struct {
+	data   []byte
+	mask   []bool
+	nocase bool
+}
+
+func (s *yaraString) matches(data, lower []byte) bool {
+	for _, p := range s.patterns {
+		if p.matches(data, lower) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p yaraPattern) matches(data, lower []byte) bool {
+	if p.mask == nil {
+		if p.nocase {
+			return bytes.Contains(lower, p.data)
+		}
+		return bytes.Contains(data, p.data)
+	}
+
+	// Hex pattern with wildcards: anchor on the first concrete byte
+	anchor := -1
+	for i, wild := range p.mask {
+		if !wild {
+			anchor = i
+			break
+		}
+	}
+	if anchor < 0 {
+		return len(data) >= len(p.data)
+	}
+
+	for offset := 0; offset+len(p.data) <= len(data); {
+		idx := bytes.IndexByte(data[offset+anchor:], p.data[anchor])
+		if idx < 0 {
+			return false
+		}
+		start := offset + idx
+		if start+len(p.data) > len(data) {
+			return false
+		}
+		if p.matchAt(data[start:]) {
+			return true
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+func (p yaraPattern) matchAt(data []byte) bool {
+	for i, b := range p.data {
+		if !p.mask[i] && data[i] != b {
+			return false
+		}
+	}
+	return true
+}
+
+// yaraCond is a compiled rule condition
+type yaraCond interface {
+	eval(rule *yaraRule, matched map[string]bool) bool
+}
+
+type condBool bool
+type condString string
+type condNot struct{ x yaraCond }
+type condAnd struct{ l, r yaraCond }
+type condOr struct{ l, r yaraCond }
+
+// condOf implements "<quantifier> of <set>"; count < 0 means "all"
+type condOf struct {
+	count int
+	set   []string // nil means "them"
+}
+
+func (c condBool) eval(*yaraRule, map[string]bool) bool { return bool(c) }
+func (c condString) eval(_ *yaraRule, m map[string]bool) bool { return m[string(c)] }
+func (c condNot) eval(r *yaraRule, m map[string]bool) bool { return !c.x.eval(r, m) }
+func (c condAnd) eval(r *yaraRule, m map[string]bool) bool { return c.l.eval(r, m) && c.r.eval(r, m) }
+func (c condOr) eval(r *yaraRule, m map[string]bool) bool  { return c.l.eval(r, m) || c.r.eval(r, m) }
+
+func (c condOf) eval(r *yaraRule, m map[string]bool) bool {
+	total, hits := 0, 0
+	for _, str := range r.strings {
+		if !c.inSet(str.id) {
+			continue
+		}
+		total++
+		if m[str.id] {
+			hits++
+		}
+	}
+	if c.count < 0 {
+		return total > 0 && hits == total
+	}
+	return hits >= c.count
+}
+
+func (c condOf) inSet(id string) bool {
+	if c.set == nil {
+		return true
+	}
+	for _, pattern := range c.set {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(id, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if id == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// CompileYaraRules compiles YARA rule source into rules usable by a YaraEngine
+func CompileYaraRules(source string) ([]*yaraRule, error) {
+	p := &yaraParser{lex: &yaraLexer{src: source, line: 1}}
+
+	var rules []*yaraRule
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case tok.kind == tokEOF:
+			return rules, nil
+		case tok.is(tokIdent, "import"):
+			// Modules are not supported; rules that use them fail in their condition
+			if _, err := p.expect(tokString, ""); err != nil {
+				return nil, err
+			}
+		case tok.is(tokIdent, "include"):
+			return nil, p.errorf(tok, "include statements are not supported")
+		case tok.is(tokIdent, "private"), tok.is(tokIdent, "global"):
+			// Rule modifiers do not change matching here
+		case tok.is(tokIdent, "rule"):
+			rule, err := p.parseRule()
+			if err != nil {
+				return nil, err
+			}
+			rules = append(rules, rule)
+		default:
+			return nil, p.errorf(tok, "unexpected %q", tok.text)
+		}
+	}
+}
+
+// Lexer
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokStringID
+	tokPunct
+)
+
+type yaraToken struct {
+	kind tokKind
+	text string
+	line int
+}
+
+func (t yaraToken) is(kind tokKind, text string) bool {
+	return t.kind == kind && t.text == text
+}
+
+type yaraLexer struct {
+	src  string
+	pos  int
+	line int
+}
+
+func (l *yaraLexer) skipSpaceAndComments() {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == '\n':
+			l.line++
+			l.pos++
+		case c == ' ' || c == '\t' || c == '\r':
+			l.pos++
+		case strings.HasPrefix(l.src[l.pos:], "//"):
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		case strings.HasPrefix(l.src[l.pos:], "/*"):
+			end := strings.Index(l.src[l.pos+2:], "*/")
+			if end < 0 {
+				l.pos = len(l.src)
+				return
+			}
+			l.line += strings.Count(l.src[l.pos:l.pos+2+end], "\n")
+			l.pos += end + 4
+		default:
+			return
+		}
+	}
+}
+
+func (l *yaraLexer) next() (yaraToken, error) {
+	l.skipSpaceAndComments()
+	if l.pos >= len(l.src) {
+		return yaraToken{kind: tokEOF, line: l.line}, nil
+	}
+
+	start := l.pos
+	c := l.src[l.pos]
+
+	switch {
+	case c == '"':
+		return l.readString()
+	case c == '$':
+		l.pos++
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos]) {
+			l.pos++
+		}
+		if l.pos < len(l.src) && l.src[l.pos] == '*' {
+			l.pos++
+		}
+		return yaraToken{kind: tokStringID, text: l.src[start:l.pos], line: l.line}, nil
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos]) {
+			l.pos++
+		}
+		return yaraToken{kind: tokNumber, text: l.src[start:l.pos], line: l.line}, nil
+	case isIdentChar(c):
+		for l.pos < len(l.src) && (isIdentChar(l.src[l.pos]) || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return yaraToken{kind: tokIdent, text: l.src[start:l.pos], line: l.line}, nil
+	default:
+		l.pos++
+		return yaraToken{kind: tokPunct, text: string(c), line: l.line}, nil
+	}
+}
+
+func (l *yaraLexer) readString() (yaraToken, error) {
+	line := l.line
+	l.pos++ // opening quote
+
+	var sb strings.Builder
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch c {
+		case '"':
+			l.pos++
+			return yaraToken{kind: tokString, text: sb.String(), line: line}, nil
+		case '\n':
+			return yaraToken{}, fmt.Errorf("line %d: unterminated string", line)
+		case '\\':
+			if l.pos+1 >= len(l.src) {
+				return yaraToken{}, fmt.Errorf("line %d: unterminated string", line)
+			}
+			esc := l.src[l.pos+1]
+			l.pos += 2
+			switch esc {
+			case 'n':
+				sb.WriteByte('\n')
+			case 't':
+				sb.WriteByte('\t')
+			case 'r':
+				sb.WriteByte('\r')
+			case '"', '\\':
+				sb.WriteByte(esc)
+			case 'x':
+				if l.pos+2 > len(l.src) {
+					return yaraToken{}, fmt.Errorf("line %d: invalid \\x escape", line)
+				}
+				v, err := strconv.ParseUint(l.src[l.pos:l.pos+2], 16, 8)
+				if err != nil {
+					return yaraToken{}, fmt.Errorf("line %d: invalid \\x escape", line)
+				}
+				sb.WriteByte(byte(v))
+				l.pos += 2
+			default:
+				return yaraToken{}, fmt.Errorf("line %d: unknown escape \\%c", line, esc)
+			}
+		default:
+			sb.WriteByte(c)
+			l.pos++
+		}
+	}
+	return yaraToken{}, fmt.Errorf("line %d: unterminated string", line)
+}
+
+// readHex reads the body of a hex string up to the closing brace; the opening
+// brace has already been consumed
+func (l *yaraLexer) readHex() (string, error) {
+	line := l.line
+	end := strings.IndexByte(l.src[l.pos:], '}')
+	if end < 0 {
+		return "", fmt.Errorf("line %d: unterminated hex string", line)
+	}
+	body := l.src[l.pos : l.pos+end]
+	l.line += strings.Count(body, "\n")
+	l.pos += end + 1
+	return body, nil
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// Parser
+
+type yaraParser struct {
+	lex    *yaraLexer
+	peeked *yaraToken
+}
+
+func (p *yaraParser) next() (yaraToken, error) {
+	if p.peeked != nil {
+		tok := *p.peeked
+		p.peeked = nil
+		return tok, nil
+	}
+	return p.lex.next()
+}
+
+func (p *yaraParser) peek() (yaraToken, error) {
+	if p.peeked == nil {
+		tok, err := p.lex.next()
+		if err != nil {
+			return tok, err
+		}
+		p.peeked = &tok
+	}
+	return *p.peeked, nil
+}
+
+// expect reads the next token and checks its kind (and text, if not empty)
+func (p *yaraParser) expect(kind tokKind, text string) (yaraToken, error) {
+	tok, err := p.next()
+	if err != nil {
+		return tok, err
+	}
+	if tok.kind != kind || (text != "" && tok.text != text) {
+		want := text
+		if want == "" {
+			want = map[tokKind]string{tokIdent: "identifier", tokString: "string", tokNumber: "number", tokStringID: "string identifier"}[kind]
+		}
+		return tok, p.errorf(tok, "expected %s, found %q", want, tok.text)
+	}
+	return tok, nil
+}
+
+func (p *yaraParser) errorf(tok yaraToken, format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", tok.line, fmt.Sprintf(format, args...))
+}
+
+func (p *yaraParser) parseRule() (*yaraRule, error) {
+	nameTok, err := p.expect(tokIdent, "")
+	if err != nil {
+		return nil, err
+	}
+	rule := &yaraRule{name: nameTok.text, meta: make(map[string]string)}
+
+	// Optional tags
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.is(tokPunct, ":") {
+		for {
+			tok, err = p.next()
+			if err != nil {
+				return nil, err
+			}
+			if tok.kind != tokIdent {
+				break
+			}
+			rule.tags = append(rule.tags, tok.text)
+		}
+	}
+	if !tok.is(tokPunct, "{") {
+		return nil, p.errorf(tok, "expected '{' after rule %s", rule.name)
+	}
+
+	for {
+		section, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if section.kind != tokIdent {
+			return nil, p.errorf(section, "expected meta, strings or condition section in rule %s", rule.name)
+		}
+		if _, err := p.expect(tokPunct, ":"); err != nil {
+			return nil, err
+		}
+
+		switch section.text {
+		case "meta":
+			if err := p.parseMeta(rule); err != nil {
+				return nil, err
+			}
+		case "strings":
+			if err := p.parseStrings(rule); err != nil {
+				return nil, err
+			}
+		case "condition":
+			cond, err := p.parseOr(rule)
+			if err != nil {
+				return nil, err
+			}
+			rule.condition = cond
+			if _, err := p.expect(tokPunct, "}"); err != nil {
+				return nil, err
+			}
+			return rule, nil
+		default:
+			return nil, p.errorf(section, "unknown section %q in rule %s", section.text, rule.name)
+		}
+	}
+}
+
+func (p *yaraParser) parseMeta(rule *yaraRule) error {
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if tok.kind != tokIdent || tok.text == "strings" || tok.text == "condition" {
+			return nil
+		}
+		p.next()
+
+		if _, err := p.expect(tokPunct, "="); err != nil {
+			return err
+		}
+		value, err := p.next()
+		if err != nil {
+			return err
+		}
+		if value.is(tokPunct, "-") {
+			num, err := p.expect(tokNumber, "")
+			if err != nil {
+				return err
+			}
+			value.text = "-" + num.text
+		} else if value.kind != tokString && value.kind != tokNumber && value.kind != tokIdent {
+			return p.errorf(value, "invalid meta value for %s", tok.text)
+		}
+		rule.meta[tok.text] = value.text
+	}
+}
+
+func (p *yaraParser) parseStrings(rule *yaraRule) error {
+	anonymous := 0
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if tok.kind != tokStringID {
+			return nil
+		}
+		p.next()
+
+		id := tok.text
+		if id == "$" {
+			anonymous++
+			id = fmt.Sprintf("$anon%d", anonymous)
+		}
+		if strings.HasSuffix(id, "*") {
+			return p.errorf(tok, "invalid string identifier %s", id)
+		}
+		if _, err := p.expect(tokPunct, "="); err != nil {
+			return err
+		}
+
+		value, err := p.next()
+		if err != nil {
+			return err
+		}
+
+		str := &yaraString{id: id}
+		switch {
+		case value.kind == tokString:
+			if err := p.parseTextModifiers(str, []byte(value.text)); err != nil {
+				return err
+			}
+		case value.is(tokPunct, "{"):
+			body, err := p.lex.readHex()
+			if err != nil {
+				return err
+			}
+			pattern, err := parseHexPattern(body)
+			if err != nil {
+				return p.errorf(value, "string %s: %v", id, err)
+			}
+			str.patterns = []yaraPattern{pattern}
+			if err := p.parseHexModifiers(str); err != nil {
+				return err
+			}
+		case value.is(tokPunct, "/"):
+			return p.errorf(value, "string %s: regular expressions are not supported", id)
+		default:
+			return p.errorf(value, "string %s: expected text or hex string", id)
+		}
+
+		rule.strings = append(rule.strings, str)
+	}
+}
+
+func (p *yaraParser) parseTextModifiers(str *yaraString, text []byte) error {
+	ascii, wide, nocase := false, false, false
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if tok.kind != tokIdent {
+			break
+		}
+		switch tok.text {
+		case "ascii":
+			ascii = true
+		case "wide":
+			wide = true
+		case "nocase":
+			nocase = true
+		case "private":
+			str.private = true
+		case "fullword", "xor", "base64", "base64wide":
+			return p.errorf(tok, "string %s: modifier %q is not supported", str.id, tok.text)
+		default:
+			// Next section or statement
+			goto done
+		}
+		p.next()
+	}
+done:
+	if len(text) == 0 {
+		return fmt.Errorf("string %s is empty", str.id)
+	}
+	if !wide {
+		ascii = true
+	}
+
+	if nocase {
+		text = asciiLower(make([]byte, len(text)), text)
+	}
+	if ascii {
+		str.patterns = append(str.patterns, yaraPattern{data: text, nocase: nocase})
+	}
+	if wide {
+		wideText := make([]byte, 0, len(text)*2)
+		for _, b := range text {
+			wideText = append(wideText, b, 0)
+		}
+		str.patterns = append(str.patterns, yaraPattern{data: wideText, nocase: nocase})
+	}
+	return nil
+}
+
+func (p *yaraParser) parseHexModifiers(str *yaraString) error {
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return err
+		}
+		if !tok.is(tokIdent, "private") {
+			return nil
+		}
+		str.private = true
+		p.next()
+	}
+}
+
+// parseHexPattern parses the body of a hex string such as "4D 5A ?? 00"
+func parseHexPattern(body string) (yaraPattern, error) {
+	compact := strings.Map(func(r rune) rune {
+		if unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, body)
+
+	if strings.ContainsAny(compact, "[]()|") {
+		return yaraPattern{}, fmt.Errorf("hex jumps and alternatives are not supported")
+	}
+	if len(compact) == 0 || len(compact)%2 != 0 {
+		return yaraPattern{}, fmt.Errorf("invalid hex string {%s}", body)
+	}
+
+	pattern := yaraPattern{mask: make([]bool, 0, len(compact)/2)}
+	for i := 0; i < len(compact); i += 2 {
+		pair := compact[i : i+2]
+		if pair == "??" {
+			pattern.data = append(pattern.data, 0)
+			pattern.mask = append(pattern.mask, true)
+			continue
+		}
+		v, err := strconv.ParseUint(pair, 16, 8)
+		if err != nil {
+			return yaraPattern{}, fmt.Errorf("invalid hex byte %q", pair)
+		}
+		pattern.data = append(pattern.data, byte(v))
+		pattern.mask = append(pattern.mask, false)
+	}
+	return pattern, nil
+}
+
+func (p *yaraParser) parseOr(rule *yaraRule) (yaraCond, error) {
+	left, err := p.parseAnd(rule)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !tok.is(tokIdent, "or") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseAnd(rule)
+		if err != nil {
+			return nil, err
+		}
+		left = condOr{left, right}
+	}
+}
+
+func (p *yaraParser) parseAnd(rule *yaraRule) (yaraCond, error) {
+	left, err := p.parseNot(rule)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok, err := p.peek()
+		if err != nil {
+			return nil, err
+		}
+		if !tok.is(tokIdent, "and") {
+			return left, nil
+		}
+		p.next()
+		right, err := p.parseNot(rule)
+		if err != nil {
+			return nil, err
+		}
+		left = condAnd{left, right}
+	}
+}
+
+func (p *yaraParser) parseNot(rule *yaraRule) (yaraCond, error) {
+	tok, err := p.peek()
+	if err != nil {
+		return nil, err
+	}
+	if tok.is(tokIdent, "not") {
+		p.next()
+		x, err := p.parseNot(rule)
+		if err != nil {
+			return nil, err
+		}
+		return condNot{x}, nil
+	}
+	return p.parsePrimary(rule)
+}
+
+func (p *yaraParser) parsePrimary(rule *yaraRule) (yaraCond, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case tok.is(tokPunct, "("):
+		cond, err := p.parseOr(rule)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokPunct, ")"); err != nil {
+			return nil, err
+		}
+		return cond, nil
+	case tok.kind == tokStringID && !strings.HasSuffix(tok.text, "*"):
+		if !ruleHasString(rule, tok.text) {
+			return nil, p.errorf(tok, "undefined string %s in rule %s", tok.text, rule.name)
+		}
+		return condString(tok.text), nil
+	case tok.is(tokIdent, "true"):
+		return condBool(true), nil
+	case tok.is(tokIdent, "false"):
+		return condBool(false), nil
+	case tok.is(tokIdent, "any"):
+		return p.parseOf(1)
+	case tok.is(tokIdent, "all"):
+		return p.parseOf(-1)
+	case tok.kind == tokNumber:
+		n, err := strconv.Atoi(tok.text)
+		if err != nil || n < 0 {
+			return nil, p.errorf(tok, "invalid count %q", tok.text)
+		}
+		return p.parseOf(n)
+	default:
+		return nil, p.errorf(tok, "unsupported condition expression near %q", tok.text)
+	}
+}
+
+// parseOf parses the remainder of "<quantifier> of them" or "<quantifier> of ($a, $b*)"
+func (p *yaraParser) parseOf(count int) (yaraCond, error) {
+	if _, err := p.expect(tokIdent, "of"); err != nil {
+		return nil, err
+	}
+
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.is(tokIdent, "them") {
+		return condOf{count: count}, nil
+	}
+	if !tok.is(tokPunct, "(") {
+		return nil, p.errorf(tok, "expected 'them' or a string set")
+	}
+
+	var set []string
+	for {
+		id, err := p.expect(tokStringID, "")
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, id.text)
+
+		sep, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if sep.is(tokPunct, ")") {
+			return condOf{count: count, set: set}, nil
+		}
+		if !sep.is(tokPunct, ",") {
+			return nil, p.errorf(sep, "expected ',' or ')' in string set")
+		}
+	}
+}
+
+func ruleHasString(rule *yaraRule, id string) bool {
+	for _, str := range rule.strings {
+		if str.id == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package ioc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func newTestYaraEngine(t *testing.T, source string) *YaraEngine {
+	t.Helper()
+	rules, err := CompileYaraRules(source)
+	if err != nil {
+		t.Fatalf("CompileYaraRules: %v", err)
+	}
+	return &YaraEngine{rules: rules}
+}
+
+func matchedRules(matches []YaraMatch) []string {
+	var names []string
+	for _, match := range matches {
+		names = append(names, match.Rule)
+	}
+	return names
+}
+
+func TestScanFileAcrossChunks(t *testing.T) {
+	engine := newTestYaraEngine(t, `
+rule boundary { strings: $s = "crosses-the-boundary" condition: $s }
+rule split { strings: $head = "HEADMARK" $tail = "TAILMARK" condition: $head and $tail }
+rule hex { strings: $h = { 4D 5A ?? 00 } condition: $h }
+rule absent { strings: $s = "not-in-file" condition: $s }
+`)
+
+	data := make([]byte, 3*yaraChunkSize)
+	copy(data, "HEADMARK")
+	copy(data[yaraChunkSize-7:], "crosses-the-boundary")
+	copy(data[2*yaraChunkSize-2:], []byte{0x4d, 0x5a, 0x90, 0x00})
+	copy(data[len(data)-8:], "TAILMARK")
+
+	path := filepath.Join(t.TempDir(), "sample.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := engine.ScanFile(path)
+	if err != nil {
+		t.Fatalf("ScanFile: %v", err)
+	}
+	if got, want := matchedRules(matches), []string{"boundary", "split", "hex"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("ScanFile matched %v, want %v", got, want)
+	}
+	if got := matches[1].Strings; !reflect.DeepEqual(got, []string{"$head", "$tail"}) {
+		t.Errorf("split rule strings = %v", got)
+	}
+}
+
+func TestScanBytesNocase(t *testing.T) {
+	engine := newTestYaraEngine(t, `
+rule nocase { strings: $s = "EvilPayload" nocase condition: $s }
+rule wide { strings: $s = "CMD" nocase wide condition: $s }
+rule exact { strings: $s = "EvilPayload" condition: $s }
+`)
+
+	// Invalid UTF-8 before the string must not shift it or break the match
+	data := append([]byte{0xff, 0xfe, 0xc3}, "xxEVILpayloadxx"...)
+	data = append(data, 'c', 0, 'M', 0, 'd', 0)
+	original := bytes.Clone(data)
+
+	if got, want := matchedRules(engine.ScanBytes(data)), []string{"nocase", "wide"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanBytes matched %v, want %v", got, want)
+	}
+	if !bytes.Equal(data, original) {
+		t.Error("ScanBytes modified its input")
+	}
+}
+
+func TestAsciiLower(t *testing.T) {
+	src := []byte("ABC\xff\xc3\x89Zz09")
+	got := asciiLower(make([]byte, len(src)), src)
+	if want := []byte("abc\xff\xc3\x89zz09"); !bytes.Equal(got, want) {
+		t.Errorf("asciiLower(%q) = %q, want %q", src, got, want)
+	}
+}
@@ -1,9 +1,11 @@
 package logging
 
 import (
+	"fmt"
 	"io"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -15,14 +17,19 @@ import (
 // Global logger instance
 var Logger zerolog.Logger
 
+var (
+	levelMu    sync.Mutex
+	configured *config.Config // Holds the log_level temporary levels revert to
+	revert     *time.Timer    // Pending revert of a temporary level
+)
+
 // InitLogger initializes the global logger based on configuration
 func InitLogger(cfg *config.Config) error {
-	// Set global log level
-	level, err := zerolog.ParseLevel(strings.ToLower(cfg.LogLevel))
-	if err != nil {
-		level = zerolog.InfoLevel // Default to info if invalid level
-	}
-	zerolog.SetGlobalLevel(level)
+	levelMu.Lock()
+	configured = cfg
+	levelMu.Unlock()
+	ResetLevel()
+	SetRedactedParams(cfg.LogRedact)
 
 	// Configure output writers
 	var writers []io.Writer
@@ -42,7 +49,7 @@ func InitLogger(cfg *config.Config) error {
 
 	// File output (if specified)
 	if cfg.LogFile != "" {
-		file, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		file, err := NewRotatingFile(cfg.LogFile, cfg.LogMaxSizeMB, cfg.LogMaxBackups, cfg.LogMaxAgeDays)
 		if err != nil {
 			return err
 		}
@@ -82,6 +89,101 @@ func InitLogger(cfg *config.Config) error {
 	return nil
 }
 
+// SetLevel parses a level name such as debug or warn and sets the global
+// log level to it, cancelling any temporary level from SetLevelFor. The
+// change is logged. An invalid name leaves the level unchanged.
+func SetLevel(name string) error {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+	if revert != nil {
+		revert.Stop()
+		revert = nil
+	}
+	return setLevel(name)
+}
+
+// SetLevelFor sets the global log level like SetLevel and puts log_level
+// back once d has passed, so an agent is not left logging at debug level.
+// d of 0 keeps the level until the next SetLevel, configuration reload or
+// restart.
+func SetLevelFor(name string, d time.Duration) error {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+	if err := setLevel(name); err != nil {
+		return err
+	}
+	if revert != nil {
+		revert.Stop()
+		revert = nil
+	}
+	if d <= 0 {
+		return nil
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(d, func() {
+		levelMu.Lock()
+		defer levelMu.Unlock()
+		// A later change replaced this timer
+		if revert != timer {
+			return
+		}
+		revert = nil
+		Logger.Log().Dur("after", d).Msg("Temporary log level expired")
+		resetLevel()
+	})
+	revert = timer
+	return nil
+}
+
+// ResetLevel sets the global log level back to log_level, or info if
+// log_level is not a valid level, cancelling any temporary level
+func ResetLevel() {
+	levelMu.Lock()
+	defer levelMu.Unlock()
+	if revert != nil {
+		revert.Stop()
+		revert = nil
+	}
+	resetLevel()
+}
+
+// resetLevel applies log_level (caller must hold levelMu)
+func resetLevel() {
+	name := ""
+	if configured != nil {
+		name = configured.Current().LogLevel
+	}
+	if setLevel(name) != nil {
+		setLevel("info")
+	}
+}
+
+// setLevel applies a level by name and logs the change (caller must hold
+// levelMu)
+func setLevel(name string) error {
+	name = strings.ToLower(strings.TrimSpace(name))
+	level, err := zerolog.ParseLevel(name)
+	if err != nil || name == "" {
+		return fmt.Errorf("invalid log level %q, must be one of: trace, debug, info, warn, error", name)
+	}
+	previous := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(level)
+	if level != previous {
+		// Logged without a level so the change shows at any level
+		Logger.Log().
+			Str("from", previous.String()).
+			Str("to", level.String()).
+			Msg("Log level changed")
+	}
+	return nil
+}
+
+// Level returns the name of the current global log level
+func Level() string {
+	return zerolog.GlobalLevel().String()
+}
+
 // GetLogger returns the global logger instance
 func GetLogger() zerolog.Logger {
 	return Logger
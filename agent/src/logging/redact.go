@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// RedactAll in log_redact masks every command parameter
+const RedactAll = "*"
+
+var (
+	redactMu   sync.RWMutex
+	redactKeys map[string]bool // Lower-cased parameter names to mask; nil = redaction off
+)
+
+// SetRedactedParams sets the command parameter names whose values are masked
+// by RedactParam and RedactParams. RedactAll masks every parameter; an empty
+// list turns redaction off.
+func SetRedactedParams(keys []string) {
+	var set map[string]bool
+	for _, key := range keys {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if key == "" {
+			continue
+		}
+		if set == nil {
+			set = make(map[string]bool)
+		}
+		set[key] = true
+	}
+
+	redactMu.Lock()
+	redactKeys = set
+	redactMu.Unlock()
+}
+
+// RedactString masks a value for logging. The first bytes of its SHA256 are
+// kept so the same value can still be followed across log lines.
+func RedactString(value string) string {
+	if value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(value))
+	return "***" + hex.EncodeToString(sum[:4])
+}
+
+// redacted reports whether values of the parameter key are masked
+func redacted(key string) bool {
+	redactMu.RLock()
+	defer redactMu.RUnlock()
+	return redactKeys[RedactAll] || redactKeys[strings.ToLower(key)]
+}
+
+// RedactParam returns value as it may be logged for the parameter key:
+// masked if log_redact covers key, unchanged otherwise
+func RedactParam(key, value string) string {
+	if redacted(key) {
+		return RedactString(value)
+	}
+	return value
+}
+
+// RedactParams returns a copy of command parameters safe to log
+func RedactParams(params map[string]string) map[string]string {
+	safe := make(map[string]string, len(params))
+	for key, value := range params {
+		safe[key] = RedactParam(key, value)
+	}
+	return safe
+}
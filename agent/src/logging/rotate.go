@@ -0,0 +1,176 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp added to rotated log file names
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// RotatingFile is an append-only log file that is rotated once it reaches
+// MaxSize. Rotated files are renamed to <name>-<timestamp><ext> next to the
+// log file; at most MaxBackups of them are kept (0 = no limit) and ones older
+// than MaxAge are removed (0 = no limit).
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64         // bytes
+	MaxBackups int
+	MaxAge     time.Duration
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingFile opens the log file at path for appending
+func NewRotatingFile(path string, maxSizeMB, maxBackups, maxAgeDays int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		Path:       path,
+		MaxSize:    int64(maxSizeMB) << 20,
+		MaxBackups: maxBackups,
+		MaxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	// Apply the limits to backups left by earlier runs
+	r.removeOldBackups()
+	return r, nil
+}
+
+// Write appends p to the log, rotating first if p would push the file past
+// MaxSize. A single write is never split across files.
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	if r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	if dir := filepath.Dir(r.Path); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create log directory: %v", err)
+		}
+	}
+
+	file, err := os.OpenFile(r.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file to a timestamped backup and starts a new one
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	if err := os.Rename(r.Path, r.backupName(time.Now())); err != nil {
+		// Keep logging to the current file rather than losing messages
+		fmt.Fprintf(os.Stderr, "failed to rotate log file %s: %v\n", r.Path, err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+
+	go r.removeOldBackups()
+	return nil
+}
+
+// backupName returns the rotated file name for time t
+func (r *RotatingFile) backupName(t time.Time) string {
+	dir, name := filepath.Split(r.Path)
+	ext := filepath.Ext(name)
+	return filepath.Join(dir, strings.TrimSuffix(name, ext)+"-"+t.Format(backupTimeFormat)+ext)
+}
+
+// removeOldBackups deletes backups beyond MaxBackups or older than MaxAge
+func (r *RotatingFile) removeOldBackups() {
+	if r.MaxBackups <= 0 && r.MaxAge <= 0 {
+		return
+	}
+
+	dir, name := filepath.Split(r.Path)
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(name)
+	prefix := strings.TrimSuffix(name, ext) + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	type backup struct {
+		path string
+		time time.Time
+	}
+	var backups []backup
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), prefix) || !strings.HasSuffix(entry.Name(), ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(entry.Name(), prefix), ext)
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, entry.Name()), time: t})
+	}
+
+	// Newest first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.After(backups[j].time)
+	})
+
+	cutoff := time.Now().Add(-r.MaxAge)
+	for i, b := range backups {
+		if (r.MaxBackups > 0 && i >= r.MaxBackups) || (r.MaxAge > 0 && b.time.Before(cutoff)) {
+			os.Remove(b.path)
+		}
+	}
+}
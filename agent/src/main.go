@@ -11,10 +11,13 @@ import (
 	"syscall"
 	"time"
 
+	"agent/audit"
 	"agent/client"
 	"agent/config"
 	"agent/ioc"
 	"agent/logging"
+	"agent/persist"
+	"agent/privilege"
 )
 
 // Command-line flags
@@ -28,12 +31,19 @@ var (
 	metricsMinutes  = flag.Int("metrics-interval", 0, "Metrics update interval in minutes (overrides config)")
 	useTLS          = flag.Bool("tls", false, "Use TLS for server connection (overrides config)")
 	connectionTimeout = flag.Int("timeout", 0, "Connection timeout in seconds (overrides config)")
+	verifyAudit     = flag.String("verify-audit", "", "Verify the integrity of an audit log file and exit")
+	updateWatchdog  = flag.String(client.UpdateWatchdogFlag, "", "Run as the self-update watchdog for the given update state file")
 )
 
 // Track if TLS flag was explicitly set
 var tlsFlagSet bool
 
 func main() {
+	// agentctl talks to an already running agent and exits
+	if len(os.Args) > 1 && os.Args[1] == "agentctl" {
+		os.Exit(runAgentctl(os.Args[2:]))
+	}
+
 	// Check if TLS flag was explicitly set before parsing
 	for _, arg := range os.Args[1:] {
 		if arg == "-tls" || arg == "--tls" || arg == "-tls=true" || arg == "--tls=true" || arg == "-tls=false" || arg == "--tls=false" {
@@ -45,7 +55,23 @@ func main() {
 	// Parse command-line flags
 	flag.Parse()
 
-	// Load configuration with precedence: flags > YAML > defaults
+	// A self-update runs the previous agent as its watchdog
+	if *updateWatchdog != "" {
+		os.Exit(client.RunUpdateWatchdog(*updateWatchdog))
+	}
+
+	// Audit log verification is a standalone operator tool
+	if *verifyAudit != "" {
+		count, err := audit.VerifyAuditChain(*verifyAudit, persist.DefaultKeyStore())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Audit log verification failed after %d valid entries: %v\n", count, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Audit log %s is intact: %d entries verified\n", *verifyAudit, count)
+		return
+	}
+
+	// Load configuration with precedence: flags > env > YAML > defaults
 	cfg, err := config.LoadConfig(*configFile)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
@@ -110,24 +136,53 @@ func main() {
 		Str("version", cfg.AgentVersion).
 		Str("server", cfg.ServerAddress).
 		Str("data_dir", cfg.DataDir).
+		Str("mode", cfg.Mode).
 		Msg("Starting EDR Agent")
+	if cfg.MonitorOnly() {
+		logging.Warn().Msg("EDR Agent is in monitor mode; IOC matches are reported but nothing is blocked, deleted or killed")
+	}
 
-	// Create and start the EDR client
-	edrClient, err := client.NewEDRClientWithConfig(cfg)
-	if err != nil {
-		log.Fatalf("Failed to create EDR client: %v", err)
+	// Without elevation firewall, hosts file and process commands cannot
+	// work, so say so at startup rather than when a block does not take
+	if !privilege.IsElevated() {
+		logging.Warn().
+			Str("requirement", privilege.Requirement()).
+			Msg("EDR Agent is not running with elevated privileges; blocking, isolation and process control commands will be refused")
 	}
 
 	// Start agent connection
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Let SIGINT and SIGTERM stop the agent while startup is still waiting
+	// for the server; the main loop takes over the signals once it is done
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	startupDone := make(chan struct{})
+	startupSignal := make(chan os.Signal, 1)
+	go watchStartupSignals(sigChan, startupDone, startupSignal, cancel)
+
+	// Create and start the EDR client
+	edrClient, err := client.NewEDRClientWithContext(ctx, cfg)
+	if err != nil {
+		exitIfCancelled(ctx)
+		log.Fatalf("Failed to create EDR client: %v", err)
+	}
+	
+	// Report config changes made while the agent was not running, then lock
+	// down the agent's files if tamper protection is enabled
+	edrClient.CheckConfigIntegrity(*configFile)
+	if cfg.TamperProtection {
+		edrClient.ApplyTamperProtection(*configFile)
+	}
+
 	// Store original agent ID before registration (to check if we need to save config)
 	originalAgentID := cfg.AgentID
 
-	// Register with server
-	agentInfo, err := edrClient.Register(ctx)
+	// Register with server, waiting for it to become reachable
+	agentInfo, err := edrClient.RegisterWithRetry(ctx, cfg.MaxRegistrationAttempts)
 	if err != nil {
+		exitIfCancelled(ctx)
 		log.Fatalf("Failed to register with server: %v", err)
 	}
 
@@ -139,10 +194,15 @@ func main() {
 	if originalAgentID == "" || originalAgentID != agentInfo.AgentID {
 		log.Printf("DEBUG: Condition met, saving config...")
 		cfg.AgentID = agentInfo.AgentID
-		if err := cfg.SaveConfig(*configFile); err != nil {
+		err := config.UpdateFile(*configFile, func(file *config.Config) error {
+			file.AgentID = agentInfo.AgentID
+			return nil
+		})
+		if err != nil {
 			log.Printf("Failed to save updated config: %v", err)
 		} else {
 			log.Printf("Updated configuration with assigned agent ID: %s", agentInfo.AgentID)
+			edrClient.RecordConfigHash(*configFile)
 		}
 	} else {
 		log.Printf("DEBUG: Condition NOT met, skipping config save")
@@ -174,6 +234,14 @@ func main() {
 	// Give time for the command stream to establish before sending ONLINE status
 	time.Sleep(2 * time.Second)
 
+	// Replay journaled IOC match reports the server has not acknowledged,
+	// including ones recorded before a restart
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		commandHandler.RunReportQueue(ctx)
+	}()
+
 	// Request IOC updates on startup with configured delay
 	wg.Add(1)
 	go func() {
@@ -186,6 +254,7 @@ func main() {
 		commandHandler.GetIOCManager(),
 		commandHandler.ReportIOCMatch,
 		cfg,
+		commandHandler.GetBlocker(),
 	)
 
 	// Set scanner in command handler
@@ -194,6 +263,43 @@ func main() {
 	// Start IOC scanning
 	scanner.Start()
 
+	// Import IOC feed files dropped into ioc_feed_dir, scanning after
+	// each import that changes the IOC set
+	if cfg.IOCFeedDir != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			commandHandler.GetIOCManager().WatchFeedDir(ctx, cfg.IOCFeedDir, scanner.TriggerScan)
+		}()
+	}
+
+	// Scan files under watch_paths as soon as they change
+	var fileWatcher *ioc.FileWatcher
+	if len(cfg.WatchPaths) > 0 {
+		fileWatcher = ioc.NewFileWatcher(scanner, cfg.WatchPaths)
+		fileWatcher.Start()
+	}
+
+	// Start the local health endpoint for monitoring tools
+	var healthServer *client.HealthServer
+	if cfg.HealthPort > 0 {
+		healthServer = client.NewHealthServer(edrClient, cfg.HealthPort)
+		if err := healthServer.Start(); err != nil {
+			logging.Error().Err(err).Int("port", cfg.HealthPort).Msg("Health server not started")
+			healthServer = nil
+		}
+	}
+
+	// Answer agentctl on the local control socket or pipe
+	var controlServer *client.ControlServer
+	if cfg.LocalControl {
+		controlServer = client.NewControlServer(edrClient)
+		if err := controlServer.Start(); err != nil {
+			logging.Error().Err(err).Msg("Control server not started, agentctl is unavailable")
+			controlServer = nil
+		}
+	}
+
 	logging.Info().
 		Str("agent_id", agentInfo.AgentID).
 		Str("server", cfg.ServerAddress).
@@ -201,31 +307,84 @@ func main() {
 		Int("metrics_interval", cfg.MetricsInterval).
 		Msg("EDR agent started successfully")
 
-	// Handle graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	sig := <-sigChan
+	// Handle graceful shutdown, reloading configuration on SIGHUP
+	close(startupDone)
+	var sig os.Signal
+	if s, ok := <-startupSignal; ok {
+		sig = s
+	}
+	for sig == nil {
+		select {
+		case s := <-sigChan:
+			if s == syscall.SIGHUP {
+				logging.Info().Msg("SIGHUP received")
+				reloadConfig(cfg, *configFile, edrClient, scanner)
+			} else {
+				sig = s
+			}
+		case <-edrClient.ReloadRequested():
+			logging.Info().Msg("Configuration updated by the server")
+			reloadConfig(cfg, *configFile, edrClient, scanner)
+		}
+	}
 
-	logging.Info().Str("signal", sig.String()).Msg("Shutdown signal received")
+	if edrClient.UpdateInProgress() {
+		// The service manager is restarting the agent on the updated
+		// executable, so do not report it as offline
+		logging.Info().Str("signal", sig.String()).Msg("Self-update installed, handing over to the new agent")
+	} else {
+		logging.Info().Str("signal", sig.String()).Msg("Shutdown signal received")
+
+		// Send explicit OFFLINE status to server
+		log.Printf("Sending OFFLINE status to server...")
+		offlineMetrics := map[string]float64{
+			"cpu_usage":    0.0,
+			"memory_usage": 0.0,
+			"uptime":       0.0,
+		}
+		edrClient.SendStatusUpdate("OFFLINE", offlineMetrics)
 
-	// Send explicit OFFLINE status to server
-	log.Printf("Sending OFFLINE status to server...")
-	offlineMetrics := map[string]float64{
-		"cpu_usage":    0.0,
-		"memory_usage": 0.0,
-		"uptime":       0.0,
+		// Send shutdown signal to server (legacy)
+		shutdownReason := fmt.Sprintf("Graceful shutdown due to signal: %s", sig.String())
+		edrClient.SendShutdownSignal(ctx, shutdownReason)
 	}
-	edrClient.SendStatusUpdate("OFFLINE", offlineMetrics)
-
-	// Send shutdown signal to server (legacy)
-	shutdownReason := fmt.Sprintf("Graceful shutdown due to signal: %s", sig.String())
-	edrClient.SendShutdownSignal(ctx, shutdownReason)
 
 	logging.Info().Msg("Shutting down agent...")
 
-	// Stop the IOC scanner
+	// Refuse new commands and let running ones finish before anything is
+	// cancelled, so a block or isolation is not left half-applied
+	if remaining := commandHandler.Drain(cfg.GetCommandDrainTimeoutDuration()); remaining > 0 {
+		logging.Warn().
+			Int("running_commands", remaining).
+			Dur("timeout", cfg.GetCommandDrainTimeoutDuration()).
+			Msg("Command drain timeout reached, interrupting running commands")
+	} else {
+		logging.Info().Msg("No commands running at shutdown")
+	}
+
+	// Stop the file watcher and the IOC scanner
+	if fileWatcher != nil {
+		fileWatcher.Stop()
+	}
 	scanner.Stop()
 
+	// Write block list changes still waiting for block_save_delay_ms
+	commandHandler.GetBlocker().Flush()
+
+	// Stop answering agentctl
+	if controlServer != nil {
+		controlServer.Close()
+	}
+
+	// Stop the health endpoint
+	if healthServer != nil {
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.GetShutdownTimeoutDuration())
+		if err := healthServer.Shutdown(shutdownCtx); err != nil {
+			logging.Warn().Err(err).Msg("Health server did not shut down cleanly")
+		}
+		cancelShutdown()
+	}
+
 	// Cancel context to stop other goroutines
 	cancel()
 
@@ -249,6 +408,37 @@ func main() {
 	logging.Info().Msg("Agent shutdown complete")
 }
 
+// watchStartupSignals cancels startup when SIGINT or SIGTERM arrives before
+// done is closed, passing the signal on through received. SIGHUP is ignored
+// until the agent is running. received is closed when it returns.
+func watchStartupSignals(sigChan chan os.Signal, done chan struct{}, received chan os.Signal, cancel context.CancelFunc) {
+	defer close(received)
+	for {
+		select {
+		case <-done:
+			return
+		case s := <-sigChan:
+			if s == syscall.SIGHUP {
+				logging.Info().Msg("SIGHUP received during startup, ignoring")
+				continue
+			}
+			logging.Info().Str("signal", s.String()).Msg("Shutdown signal received during startup")
+			received <- s
+			cancel()
+			return
+		}
+	}
+}
+
+// exitIfCancelled ends the process quietly when startup failed because a
+// shutdown signal cancelled it
+func exitIfCancelled(ctx context.Context) {
+	if ctx.Err() != nil {
+		logging.Info().Msg("Startup cancelled, agent shutdown complete")
+		os.Exit(0)
+	}
+}
+
 // requestIOCUpdatesOnStartup sends a request to the server for IOC updates
 func requestIOCUpdatesOnStartup(ctx context.Context, edrClient *client.EDRClient, delay time.Duration) {
 	// Give time for the command stream to establish using configured delay
@@ -256,4 +446,41 @@ func requestIOCUpdatesOnStartup(ctx context.Context, edrClient *client.EDRClient
 
 	logging.Info().Msg("Requesting IOC updates from server...")
 	edrClient.RequestIOCUpdates(ctx)
-} 
\ No newline at end of file
+} 
+
+// reloadConfig re-reads the configuration file and applies the values that
+// can change on a running agent without dropping the command stream
+func reloadConfig(cfg *config.Config, configFile string, edrClient *client.EDRClient, scanner *ioc.Scanner) {
+	logging.Info().Str("config", configFile).Msg("Reloading configuration")
+
+	old := cfg.Current()
+
+	if err := cfg.Reload(configFile); err != nil {
+		logging.Error().Err(err).Msg("Configuration reload failed, keeping current configuration")
+		return
+	}
+
+	// The reloaded file is the intended configuration
+	edrClient.RecordConfigHash(configFile)
+	logging.ResetLevel()
+	current := cfg.Current()
+	logging.SetRedactedParams(current.LogRedact)
+	
+	if [3]int{current.ScanInterval, current.IPURLScanInterval, current.FileScanInterval} != [3]int{old.ScanInterval, old.IPURLScanInterval, old.FileScanInterval} {
+		scanner.UpdateIntervals()
+	}
+	if current.MetricsInterval != old.MetricsInterval {
+		edrClient.SetMetricsInterval(current.MetricsInterval)
+	}
+	if current.HeartbeatInterval != old.HeartbeatInterval {
+		edrClient.SetHeartbeatInterval(current.HeartbeatInterval)
+	}
+	if current.Mode != old.Mode {
+		logging.Warn().Str("from", old.Mode).Str("to", current.Mode).Msg("Agent mode changed")
+	}
+
+	logging.Info().
+		Int("scan_interval", current.ScanInterval).
+		Int("metrics_interval", current.MetricsInterval).
+		Msg("Configuration reloaded")
+}
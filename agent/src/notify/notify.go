@@ -0,0 +1,83 @@
+// Package notify tells the user logged on at the machine when the agent
+// has taken a remediation action there
+package notify
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"agent/config"
+	"agent/logging"
+)
+
+// Title is the caption of every notification
+const Title = "Security notice"
+
+// Actions substituted for {action} in notify_user_message. They say what
+// happened without naming files, addresses or detections.
+const (
+	FileQuarantined = "a malicious file was quarantined"
+	FileRemoved     = "a malicious file was removed"
+	ProcessStopped  = "a malicious program was stopped"
+	AddressBlocked  = "connections to a malicious network address were blocked"
+	WebsiteBlocked  = "a malicious website was blocked"
+	NetworkIsolated = "this computer was disconnected from the network"
+)
+
+// minInterval is the least time between two notifications, so a burst of
+// remediations shows one popup rather than a stack of them
+const minInterval = 30 * time.Second
+
+// errNoDesktop is returned when there is no interactive user to notify
+var errNoDesktop = errors.New("no interactive desktop session")
+
+// Notifier shows notify_user_message to the logged-on user when
+// notify_user is enabled
+type Notifier struct {
+	config *config.Config
+	mu     sync.Mutex
+	last   time.Time
+}
+
+// New creates a notifier that follows the notify_user options of cfg
+func New(cfg *config.Config) *Notifier {
+	return &Notifier{config: cfg}
+}
+
+// Notify tells the user that action was taken on their machine. It returns
+// at once; the message is shown in the background, and skipped when
+// notify_user is off, another was shown less than 30 seconds ago or no
+// user is logged on. A nil Notifier does nothing.
+func (n *Notifier) Notify(action string) {
+	if n == nil || !n.config.Current().NotifyUser {
+		return
+	}
+
+	n.mu.Lock()
+	now := time.Now()
+	if now.Sub(n.last) < minInterval {
+		n.mu.Unlock()
+		logging.Debug().Str("action", action).Msg("Skipping user notification, one was shown recently")
+		return
+	}
+	n.last = now
+	n.mu.Unlock()
+
+	message := Message(n.config.Current().NotifyUserMessage, action)
+	go func() {
+		if err := show(Title, message); err != nil {
+			logging.Debug().Err(err).Str("action", action).Msg("User notification not shown")
+		}
+	}()
+}
+
+// Message fills the {action} placeholder of template, starting the message
+// with a capital letter if the action opens it
+func Message(template, action string) string {
+	if action != "" && strings.HasPrefix(template, "{action}") {
+		action = strings.ToUpper(action[:1]) + action[1:]
+	}
+	return strings.ReplaceAll(template, "{action}", action)
+}
@@ -0,0 +1,9 @@
+// +build !windows
+
+package notify
+
+// show does nothing: agents on other systems run as daemons without a
+// desktop to show messages on
+func show(title, message string) error {
+	return errNoDesktop
+}
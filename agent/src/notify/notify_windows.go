@@ -0,0 +1,61 @@
+// +build windows
+
+package notify
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+var (
+	wtsapi32            = windows.NewLazySystemDLL("wtsapi32.dll")
+	procWTSSendMessageW = wtsapi32.NewProc("WTSSendMessageW")
+)
+
+const (
+	noConsoleSession = 0xFFFFFFFF // No user is attached to the console
+
+	mbIconWarning   = 0x00000030
+	mbSetForeground = 0x00010000
+	mbTopmost       = 0x00040000
+)
+
+// show displays a message box on the desktop of the user at the console.
+// WTSSendMessage works from the service session, where MessageBox would
+// show nothing, and with bWait false it returns without waiting for the
+// user to close the box.
+func show(title, message string) error {
+	session := windows.WTSGetActiveConsoleSessionId()
+	// Session 0 only runs services since Windows Vista
+	if session == noConsoleSession || session == 0 {
+		return errNoDesktop
+	}
+
+	titleUTF16, err := windows.UTF16FromString(title)
+	if err != nil {
+		return err
+	}
+	messageUTF16, err := windows.UTF16FromString(message)
+	if err != nil {
+		return err
+	}
+
+	var response uint32
+	ret, _, err := procWTSSendMessageW.Call(
+		0, // WTS_CURRENT_SERVER_HANDLE
+		uintptr(session),
+		uintptr(unsafe.Pointer(&titleUTF16[0])),
+		uintptr((len(titleUTF16)-1)*2),
+		uintptr(unsafe.Pointer(&messageUTF16[0])),
+		uintptr((len(messageUTF16)-1)*2),
+		mbIconWarning|mbSetForeground|mbTopmost,
+		0, // No timeout, the user closes the box
+		uintptr(unsafe.Pointer(&response)),
+		0, // bWait false
+	)
+	if ret == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package persist
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// BackupSuffix is appended to a data file's path to form its backup copy
+const BackupSuffix = ".bak"
+
+// ErrCorrupt is returned when a persisted file and its backup are both
+// unreadable or fail structural checks, so the agent starts without its data
+var ErrCorrupt = errors.New("persisted file is corrupt")
+
+// BackupPath returns the path of the backup copy of a data file
+func BackupPath(path string) string {
+	return path + BackupSuffix
+}
+
+// WriteFileAtomic replaces a file by writing a temporary sibling, syncing it
+// and renaming it over the target, so a crash mid-write never leaves a
+// truncated file behind. Each call uses its own temporary file, so
+// concurrent writes of the same path cannot mix their data; the last rename
+// wins. An existing file keeps its permissions; a new file is created with
+// perm.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// Clean up the temporary file on any failure
+	success := false
+	defer func() {
+		if !success {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return err
+	}
+	success = true
+
+	syncDir(filepath.Dir(path))
+	return nil
+}
+
+// syncDir flushes a directory entry so a rename survives power loss. Not all
+// platforms support syncing directories, so errors are ignored.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// SaveWithBackup atomically writes data and its checksum to path. The
+// previous contents are first rotated to the backup copy, provided they still
+// match their checksum, so a later corrupt file can be recovered with
+// ReadBackup.
+func (i *Integrity) SaveWithBackup(path string, data []byte, perm os.FileMode) error {
+	if current, err := os.ReadFile(path); err == nil {
+		if ok, err := i.VerifyChecksum(path, current); ok && err == nil {
+			if err := i.writeWithChecksum(BackupPath(path), current, perm); err != nil {
+				return fmt.Errorf("failed to write backup file: %v", err)
+			}
+		}
+	}
+
+	return i.writeWithChecksum(path, data, perm)
+}
+
+// writeWithChecksum atomically replaces path with data and then its checksum.
+// The new checksum is written to a pending file first, so if the agent stops
+// between the two renames VerifyChecksum recognises the data as its own
+// instead of reporting tampering.
+func (i *Integrity) writeWithChecksum(path string, data []byte, perm os.FileMode) error {
+	pending := pendingChecksumPath(path)
+	if err := WriteFileAtomic(pending, []byte(i.Checksum(data)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write checksum file: %v", err)
+	}
+	if err := WriteFileAtomic(path, data, perm); err != nil {
+		return err
+	}
+	if err := i.WriteChecksum(path, data); err != nil {
+		return err
+	}
+	os.Remove(pending)
+	return nil
+}
+
+// ReadBackup reads the backup copy of path and verifies it against its
+// checksum. Backups without a checksum are not trusted.
+func (i *Integrity) ReadBackup(path string) ([]byte, error) {
+	backup := BackupPath(path)
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup file: %v", err)
+	}
+
+	hasChecksum, err := i.VerifyChecksum(backup, data)
+	if err != nil {
+		return nil, err
+	}
+	if !hasChecksum {
+		return nil, fmt.Errorf("backup file %s has no checksum", backup)
+	}
+
+	return data, nil
+}
+
+// RestoreBackup replaces path with data read from its backup by ReadBackup,
+// along with its checksum. Unlike SaveWithBackup the backup itself is left as
+// it is, since the file being replaced is the corrupt one.
+func (i *Integrity) RestoreBackup(path string, data []byte, perm os.FileMode) error {
+	return i.writeWithChecksum(path, data, perm)
+}
@@ -0,0 +1,221 @@
+package persist
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestIntegrity returns an Integrity whose key already existed, as on
+// every run after the first
+func newTestIntegrity(t *testing.T) *Integrity {
+	t.Helper()
+	store := NewKeyStore(t.TempDir())
+	if _, _, err := store.Key(integrityKeyName); err != nil {
+		t.Fatal(err)
+	}
+	integrity, err := NewIntegrity(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return integrity
+}
+
+func TestSaveWithBackup(t *testing.T) {
+	integrity := newTestIntegrity(t)
+	path := filepath.Join(t.TempDir(), "items.json")
+	if err := integrity.SaveWithBackup(path, []byte("first"), 0600); err != nil {
+		t.Fatalf("first save: %v", err)
+	}
+	if err := integrity.SaveWithBackup(path, []byte("second"), 0600); err != nil {
+		t.Fatalf("second save: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := integrity.VerifyChecksum(path, data); !ok || err != nil {
+		t.Errorf("VerifyChecksum(%q) = %v, %v, want true, nil", data, ok, err)
+	}
+	backup, err := integrity.ReadBackup(path)
+	if err != nil {
+		t.Fatalf("ReadBackup: %v", err)
+	}
+	if string(backup) != "first" {
+		t.Errorf("backup = %q, want %q", backup, "first")
+	}
+	if _, err := os.Stat(pendingChecksumPath(path)); !os.IsNotExist(err) {
+		t.Errorf("pending checksum left behind after save: %v", err)
+	}
+}
+
+// TestVerifyChecksumPartialWrite replays a save that stopped at each step of
+// writeWithChecksum and checks that the file on disk still verifies
+func TestVerifyChecksumPartialWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		pending bool // The pending checksum was written
+		data    bool // The data file was replaced
+		want    string
+	}{
+		{name: "stopped before pending checksum", want: "old"},
+		{name: "stopped before data", pending: true, want: "old"},
+		{name: "stopped before checksum", pending: true, data: true, want: "new"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			integrity := newTestIntegrity(t)
+			path := filepath.Join(t.TempDir(), "items.json")
+			if err := integrity.SaveWithBackup(path, []byte("old"), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			newData := []byte("new")
+			if tt.pending {
+				if err := WriteFileAtomic(pendingChecksumPath(path), []byte(integrity.Checksum(newData)+"\n"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.data {
+				if err := WriteFileAtomic(path, newData, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Fatalf("data = %q, want %q", data, tt.want)
+			}
+			if ok, err := integrity.VerifyChecksum(path, data); !ok || err != nil {
+				t.Fatalf("VerifyChecksum = %v, %v, want true, nil", ok, err)
+			}
+
+			// The interrupted save is completed, so the next check does
+			// not depend on the pending checksum
+			if tt.data {
+				if _, err := os.Stat(pendingChecksumPath(path)); !os.IsNotExist(err) {
+					t.Errorf("pending checksum not removed: %v", err)
+				}
+				if ok, err := integrity.VerifyChecksum(path, data); !ok || err != nil {
+					t.Errorf("second VerifyChecksum = %v, %v, want true, nil", ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyChecksumTampered(t *testing.T) {
+	integrity := newTestIntegrity(t)
+	path := filepath.Join(t.TempDir(), "items.json")
+	if err := integrity.SaveWithBackup(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A pending checksum for other data does not cover the change
+	if err := WriteFileAtomic(pendingChecksumPath(path), []byte(integrity.Checksum([]byte("new"))+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("edited"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := integrity.VerifyChecksum(path, []byte("edited")); !errors.Is(err, ErrTampered) {
+		t.Errorf("VerifyChecksum = %v, want ErrTampered", err)
+	}
+}
+
+func TestVerifyChecksumForged(t *testing.T) {
+	integrity := newTestIntegrity(t)
+	path := filepath.Join(t.TempDir(), "items.json")
+	if err := integrity.SaveWithBackup(path, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Rewriting the data and its checksum file without the key is caught,
+	// whether the checksum is a plain SHA256 or is removed
+	edited := []byte("edited")
+	sum := sha256.Sum256(edited)
+	if err := os.WriteFile(path, edited, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ChecksumPath(path), []byte(hex.EncodeToString(sum[:])+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := integrity.VerifyChecksum(path, edited); !errors.Is(err, ErrTampered) {
+		t.Errorf("VerifyChecksum with a plain SHA256 = %v, want ErrTampered", err)
+	}
+
+	if err := os.Remove(ChecksumPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := integrity.VerifyChecksum(path, edited); !errors.Is(err, ErrTampered) {
+		t.Errorf("VerifyChecksum without a checksum = %v, want ErrTampered", err)
+	}
+}
+
+func TestIntegrityMigratesPlainChecksums(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain.json")
+	unsummed := filepath.Join(dir, "unsummed.json")
+	data := []byte("saved by an earlier agent")
+	sum := sha256.Sum256(data)
+	for path, contents := range map[string][]byte{
+		plain:               data,
+		ChecksumPath(plain): []byte(hex.EncodeToString(sum[:]) + "\n"),
+		unsummed:            data,
+	} {
+		if err := os.WriteFile(path, contents, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The run that generates the key accepts the old checksum files and
+	// replaces the plain checksum with a keyed one
+	store := NewKeyStore(filepath.Join(dir, "keys"))
+	first, err := NewIntegrity(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := first.VerifyChecksum(plain, data); !ok || err != nil {
+		t.Fatalf("first run VerifyChecksum(plain) = %v, %v, want true, nil", ok, err)
+	}
+	if ok, err := first.VerifyChecksum(unsummed, data); ok || err != nil {
+		t.Fatalf("first run VerifyChecksum(unsummed) = %v, %v, want false, nil", ok, err)
+	}
+
+	next, err := NewIntegrity(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := next.VerifyChecksum(plain, data); !ok || err != nil {
+		t.Errorf("next run VerifyChecksum(plain) = %v, %v, want true, nil", ok, err)
+	}
+	if _, err := next.VerifyChecksum(unsummed, data); !errors.Is(err, ErrTampered) {
+		t.Errorf("next run VerifyChecksum(unsummed) = %v, want ErrTampered", err)
+	}
+}
+
+func TestKeyStore(t *testing.T) {
+	store := NewKeyStore(filepath.Join(t.TempDir(), "keys"))
+	key, created, err := store.Key("test")
+	if err != nil || !created || len(key) != keySize {
+		t.Fatalf("first Key = %d bytes, %v, %v; want a new %d-byte key", len(key), created, err, keySize)
+	}
+
+	again, created, err := NewKeyStore(store.Dir()).Key("test")
+	if err != nil || created || !bytes.Equal(again, key) {
+		t.Errorf("second Key = %x, %v, %v; want the stored key %x", again, created, err, key)
+	}
+	if other, _, _ := store.Key("other"); bytes.Equal(other, key) {
+		t.Error("keys with different names are equal")
+	}
+}
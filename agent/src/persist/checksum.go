@@ -0,0 +1,154 @@
+package persist
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ChecksumSuffix is appended to a data file's path to form its checksum file
+const ChecksumSuffix = ".sha256"
+
+// pendingSuffix is appended to a checksum file's path to hold the checksum of
+// data that is being written, see writeWithChecksum
+const pendingSuffix = ".new"
+
+// integrityKeyName names the checksum key in the key store
+const integrityKeyName = "integrity"
+
+// ErrTampered is returned when a persisted file does not match its stored checksum,
+// or when the file is missing while its checksum is still present
+var ErrTampered = errors.New("persisted file failed integrity check")
+
+// Integrity writes and verifies the checksum files of persisted data. A
+// checksum is an HMAC-SHA256 keyed with a key from a KeyStore, so rewriting
+// a data file together with its checksum file is detected unless the key
+// can be read too.
+type Integrity struct {
+	key []byte
+
+	// The key was generated by this run: checksum files of earlier agents,
+	// plain SHA256 or missing, are accepted once and replaced
+	migrate bool
+}
+
+// NewIntegrity loads the checksum key from store, generating it on first use
+func NewIntegrity(store *KeyStore) (*Integrity, error) {
+	key, created, err := store.Key(integrityKeyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load integrity key: %v", err)
+	}
+	return &Integrity{key: key, migrate: created}, nil
+}
+
+// ChecksumPath returns the path of the checksum file for a data file
+func ChecksumPath(path string) string {
+	return path + ChecksumSuffix
+}
+
+// Checksum returns the hex-encoded HMAC-SHA256 of data
+func (i *Integrity) Checksum(data []byte) string {
+	mac := hmac.New(sha256.New, i.key)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// matches reports whether stored is the checksum of data. legacy is set
+// when it only matched as the plain SHA256 written by earlier agents.
+func (i *Integrity) matches(data []byte, stored string) (ok, legacy bool) {
+	if hmac.Equal([]byte(i.Checksum(data)), []byte(stored)) {
+		return true, false
+	}
+	if i.migrate {
+		sum := sha256.Sum256(data)
+		if hmac.Equal([]byte(hex.EncodeToString(sum[:])), []byte(stored)) {
+			return true, true
+		}
+	}
+	return false, false
+}
+
+// Matches reports whether stored is the checksum of data, accepting the
+// plain SHA256 of an earlier agent while the key is new
+func (i *Integrity) Matches(data []byte, stored string) bool {
+	ok, _ := i.matches(data, stored)
+	return ok
+}
+
+// pendingChecksumPath returns the path of the checksum written before the
+// data file at path is replaced
+func pendingChecksumPath(path string) string {
+	return ChecksumPath(path) + pendingSuffix
+}
+
+// WriteChecksum stores the checksum of data next to the data file at path
+func (i *Integrity) WriteChecksum(path string, data []byte) error {
+	if err := WriteFileAtomic(ChecksumPath(path), []byte(i.Checksum(data)+"\n"), 0600); err != nil {
+		return fmt.Errorf("failed to write checksum file: %v", err)
+	}
+	return nil
+}
+
+// VerifyChecksum checks data read from path against its stored checksum,
+// and returns ErrTampered on mismatch. A file without a checksum is only
+// accepted while the key is new, since it may have been written before
+// integrity protection existed; hasChecksum is false then and the caller
+// records one. Checksums of earlier agents are replaced with keyed ones.
+// Data matching the pending checksum of an interrupted save is accepted,
+// and the save is completed by storing that checksum.
+func (i *Integrity) VerifyChecksum(path string, data []byte) (hasChecksum bool, err error) {
+	stored, err := os.ReadFile(ChecksumPath(path))
+	if os.IsNotExist(err) {
+		if i.migrate {
+			return false, nil
+		}
+		return false, fmt.Errorf("%w: %s has no checksum", ErrTampered, path)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read checksum file: %v", err)
+	}
+
+	ok, legacy := i.matches(data, strings.TrimSpace(string(stored)))
+	if !ok {
+		if i.completePendingChecksum(path, data) {
+			return true, nil
+		}
+		return true, fmt.Errorf("%w: %s does not match its checksum", ErrTampered, path)
+	}
+	if legacy {
+		if err := i.WriteChecksum(path, data); err != nil {
+			return true, err
+		}
+	}
+
+	return true, nil
+}
+
+// completePendingChecksum finishes a save that stopped after the data file
+// at path was replaced but before its checksum was. It reports whether the
+// pending checksum is the one of data, the contents now in the file.
+func (i *Integrity) completePendingChecksum(path string, data []byte) bool {
+	pending, err := os.ReadFile(pendingChecksumPath(path))
+	if err != nil || !i.Matches(data, strings.TrimSpace(string(pending))) {
+		return false
+	}
+
+	if err := i.WriteChecksum(path, data); err != nil {
+		return false
+	}
+	os.Remove(pendingChecksumPath(path))
+	return true
+}
+
+// CheckMissing reports tampering when a data file is gone but its checksum
+// file remains, meaning the file was deleted outside of the agent
+func CheckMissing(path string) error {
+	if _, err := os.Stat(ChecksumPath(path)); err == nil {
+		return fmt.Errorf("%w: %s was deleted", ErrTampered, path)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package persist
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// keySize is the length of the keys generated by a KeyStore
+const keySize = 32
+
+// KeyStore holds the agent's secret keys outside its data directory, so that
+// whoever can rewrite the data files cannot also read the keys that sign
+// them. Keys are sealed for the agent's account where the OS supports it.
+type KeyStore struct {
+	dir string
+}
+
+// NewKeyStore returns a key store keeping its keys in dir
+func NewKeyStore(dir string) *KeyStore {
+	return &KeyStore{dir: dir}
+}
+
+// DefaultKeyStore returns the key store in the platform's key directory:
+// %ProgramData%\EDR Agent\keys on Windows, /etc/edr-agent/keys when running
+// as root elsewhere, and the user's configuration directory otherwise
+func DefaultKeyStore() *KeyStore {
+	return NewKeyStore(defaultKeyDir())
+}
+
+// Dir returns the directory the keys are kept in
+func (s *KeyStore) Dir() string {
+	return s.dir
+}
+
+// Key returns the key called name, generating and storing one if it does
+// not exist yet. created reports that the key was generated by this call.
+func (s *KeyStore) Key(name string) (key []byte, created bool, err error) {
+	key, err = s.Load(name)
+	if err == nil {
+		return key, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+
+	key = make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, false, fmt.Errorf("failed to generate key %s: %v", name, err)
+	}
+	if err := s.Store(name, key); err != nil {
+		return nil, false, err
+	}
+	return key, true, nil
+}
+
+// Store saves key under name, replacing any key of that name
+func (s *KeyStore) Store(name string, key []byte) error {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return fmt.Errorf("failed to create key directory: %v", err)
+	}
+	sealed, err := sealKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to seal key %s: %v", name, err)
+	}
+	if err := WriteFileAtomic(s.path(name), sealed, 0600); err != nil {
+		return fmt.Errorf("failed to write key %s: %v", name, err)
+	}
+	return nil
+}
+
+// Load returns the key called name. The error wraps os.ErrNotExist when
+// there is no such key.
+func (s *KeyStore) Load(name string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	key, err := unsealKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unseal key %s: %v", name, err)
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("key %s is empty", name)
+	}
+	return key, nil
+}
+
+func (s *KeyStore) path(name string) string {
+	return filepath.Join(s.dir, name+".key")
+}
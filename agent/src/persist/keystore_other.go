@@ -0,0 +1,31 @@
+// +build !windows
+
+package persist
+
+import (
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func defaultKeyDir() string {
+	if os.Geteuid() == 0 {
+		return "/etc/edr-agent/keys"
+	}
+	if dir, err := os.UserConfigDir(); err == nil {
+		return filepath.Join(dir, "edr-agent", "keys")
+	}
+	return "/etc/edr-agent/keys"
+}
+
+// sealKey encodes key as hex; the key directory and file are readable by
+// the agent's account only
+func sealKey(key []byte) ([]byte, error) {
+	return []byte(hex.EncodeToString(key) + "\n"), nil
+}
+
+// unsealKey decodes a key written by sealKey
+func unsealKey(data []byte) ([]byte, error) {
+	return hex.DecodeString(strings.TrimSpace(string(data)))
+}
@@ -0,0 +1,54 @@
+// +build windows
+
+package persist
+
+import (
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+func defaultKeyDir() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	return filepath.Join(programData, "EDR Agent", "keys")
+}
+
+// sealKey encrypts key with DPAPI for the agent's account, so only processes
+// running as that account, SYSTEM for the service, can read it
+func sealKey(key []byte) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptProtectData(dataBlob(key), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	return takeDataBlob(&out), nil
+}
+
+// unsealKey decrypts a key sealed by sealKey
+func unsealKey(data []byte) ([]byte, error) {
+	var out windows.DataBlob
+	if err := windows.CryptUnprotectData(dataBlob(data), nil, nil, 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out); err != nil {
+		return nil, err
+	}
+	return takeDataBlob(&out), nil
+}
+
+func dataBlob(data []byte) *windows.DataBlob {
+	if len(data) == 0 {
+		return &windows.DataBlob{}
+	}
+	return &windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+}
+
+// takeDataBlob copies the output of a DPAPI call and frees it
+func takeDataBlob(blob *windows.DataBlob) []byte {
+	if blob.Data == nil {
+		return nil
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(blob.Data)))
+	return append([]byte(nil), unsafe.Slice(blob.Data, blob.Size)...)
+}
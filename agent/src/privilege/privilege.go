@@ -0,0 +1,27 @@
+// Package privilege detects whether the agent runs with the rights it needs
+// to change the firewall and the hosts file
+package privilege
+
+import (
+	"sync"
+)
+
+var (
+	checkOnce sync.Once
+	elevated  bool
+)
+
+// IsElevated reports whether the agent process can modify the firewall and
+// the hosts file on this OS. Privileges do not change while the process
+// runs, so the check is made once.
+func IsElevated() bool {
+	checkOnce.Do(func() {
+		elevated = isElevated()
+	})
+	return elevated
+}
+
+// Requirement describes how to give the agent the rights IsElevated checks for
+func Requirement() string {
+	return requirement
+}
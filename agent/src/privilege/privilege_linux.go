@@ -0,0 +1,32 @@
+// +build linux
+
+package privilege
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const requirement = "run the agent as root or grant it CAP_NET_ADMIN and CAP_DAC_OVERRIDE"
+
+// isElevated reports whether the process runs as root or holds the
+// capabilities iptables and hosts file edits need, as when a systemd unit
+// grants them to a service account
+func isElevated() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return false
+	}
+	return hasCapability(data, unix.CAP_NET_ADMIN) && hasCapability(data, unix.CAP_DAC_OVERRIDE)
+}
+
+// hasCapability reports whether capability is in the effective set
+func hasCapability(data [2]unix.CapUserData, capability int) bool {
+	return data[capability/32].Effective&(1<<uint(capability%32)) != 0
+}
@@ -0,0 +1,14 @@
+// +build !windows,!linux
+
+package privilege
+
+import (
+	"os"
+)
+
+const requirement = "run the agent as root"
+
+// isElevated reports whether the process runs as root
+func isElevated() bool {
+	return os.Geteuid() == 0
+}
@@ -0,0 +1,15 @@
+// +build windows
+
+package privilege
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+const requirement = "run the agent as an elevated administrator or as the LocalSystem service"
+
+// isElevated reports whether the process token is elevated; LocalSystem and
+// administrators with UAC disabled always are
+func isElevated() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
@@ -66,6 +66,8 @@ class Config:
     
     # gRPC server configuration
     GRPC_PORT = int(os.environ.get('GRPC_PORT', '50051'))
+    # Gzip-compress messages to agents that accept it (IOC updates can be large)
+    GRPC_COMPRESSION = os.environ.get('GRPC_COMPRESSION', 'true').lower() == 'true'
     
     # Agent configuration
     AGENT_HEARTBEAT_INTERVAL = int(os.environ.get('AGENT_HEARTBEAT_INTERVAL', '60'))
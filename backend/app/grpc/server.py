@@ -52,6 +52,9 @@ class EDRServicer(agent_pb2_grpc.EDRServiceServicer):
         
         # Pending commands for offline agents
         self.pending_commands = defaultdict(list)
+        
+        # Heartbeats to send back so agents can measure round-trip latency
+        self.heartbeat_echoes = defaultdict(list)
     
     def load_command_results(self):
         """Load command results from file."""
@@ -92,6 +95,8 @@ class EDRServicer(agent_pb2_grpc.EDRServiceServicer):
         """Handle agent registration."""
         logger.info(f"New Agent Registration - ID: {request.agent_id}, Hostname: {request.hostname}")
         debug_logger.info(f"Registration details - OS: {request.os_version}, Agent version: {request.agent_version}")
+        if not request.elevated:
+            logger.warning(f"Agent on {request.hostname} is not running elevated, blocking and isolation commands will fail")
         
         agent_id = request.agent_id
         hostname = request.hostname
@@ -124,6 +129,7 @@ class EDRServicer(agent_pb2_grpc.EDRServiceServicer):
             'os_version': request.os_version,
             'agent_version': request.agent_version,
             'registration_time': request.registration_time,
+            'elevated': request.elevated,
             'last_seen': int(time.time()),
             'status': 'REGISTERED',
             'ioc_version': 0
@@ -417,6 +423,18 @@ class EDRServicer(agent_pb2_grpc.EDRServiceServicer):
                                 cmd for cmd in pending if cmd.command_id not in sent_command_ids
                             ]
                 
+                # Echo heartbeats back unchanged
+                with self.stream_lock:
+                    echoes = self.heartbeat_echoes.pop(agent_id, [])
+                for heartbeat in echoes:
+                    echo_msg = agent_pb2.CommandMessage(
+                        agent_id=agent_id,
+                        timestamp=int(time.time()),
+                        message_type=agent_pb2.MessageType.AGENT_HEARTBEAT
+                    )
+                    echo_msg.heartbeat.CopyFrom(heartbeat)
+                    yield echo_msg
+                
                 time.sleep(0.05)
                 
         except Exception as e:
@@ -502,6 +520,11 @@ class EDRServicer(agent_pb2_grpc.EDRServiceServicer):
                     else:
                         logger.warning(f"Received AGENT_RUNNING message with no running payload from agent {agent_id}")
                 
+                elif message.message_type == agent_pb2.MessageType.AGENT_HEARTBEAT:
+                    # Queue the heartbeat to be sent back by the stream loop
+                    with self.stream_lock:
+                        self.heartbeat_echoes[agent_id].append(message.heartbeat)
+                
                 elif message.message_type == agent_pb2.MessageType.AGENT_SHUTDOWN:
                     # Handle explicit shutdown signal
                     shutdown_signal = message.shutdown
@@ -761,6 +784,17 @@ class EDRServicer(agent_pb2_grpc.EDRServiceServicer):
             message="IOC match report received"
         )
 
+    def ReportIOCMatches(self, request_iterator, context):
+        """Handle a stream of IOC match reports found by one agent scan."""
+        acks = [self.ReportIOCMatch(request, context) for request in request_iterator]
+        received = sum(1 for ack in acks if ack.received)
+        
+        return agent_pb2.IOCMatchBatchAck(
+            received=received,
+            message=f"{received} IOC match reports received",
+            acks=acks
+        )
+
 def start_grpc_server(port=None, use_tls=None):
     """Start the gRPC server in a background thread.
     
@@ -777,7 +811,18 @@ def start_grpc_server(port=None, use_tls=None):
     if use_tls is None:
         use_tls = config.GRPC_USE_TLS
         
-    server = grpc.server(futures.ThreadPoolExecutor(max_workers=10))
+    # Accept the keepalive pings agents send to detect dead connections.
+    # The defaults only allow a ping every 5 minutes and drop the connection
+    # of clients that ping more often.
+    server = grpc.server(
+        futures.ThreadPoolExecutor(max_workers=10),
+        options=[
+            ('grpc.keepalive_permit_without_calls', 1),
+            ('grpc.http2.min_ping_interval_without_data_ms', 10000),
+            ('grpc.http2.max_pings_without_data', 0),
+        ],
+        compression=grpc.Compression.Gzip if config.GRPC_COMPRESSION else grpc.Compression.NoCompression,
+    )
     servicer = EDRServicer()
     agent_pb2_grpc.add_EDRServiceServicer_to_server(servicer, server)
     